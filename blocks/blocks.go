@@ -1,11 +1,17 @@
 package blocks
 
-import "github.com/overnest/strongsalt-common-go/tools"
+import (
+	"time"
+
+	"github.com/overnest/strongsalt-common-go/tools"
+)
 
 const (
 	_ = iota // Skip 0
 	// BlockListV1 is block list version 1
 	BlockListV1 = uint32(iota)
+	// BlockListV2 is block list version 2
+	BlockListV2 = uint32(iota)
 
 	// BlockListCurV is the current version of block list
 	BlockListCurV = BlockListV1
@@ -23,6 +29,7 @@ type Block interface {
 	GetID() uint32
 	GetSize() uint32
 	GetData() []byte
+	GetTimestamp() time.Time
 }
 
 // BlockDataComparator is a comparator function definition.
@@ -47,20 +54,20 @@ type InitEmptyBlockData func() interface{}
 
 // NewBlockListWriter creates a block list for writing only
 //
-func NewBlockListWriter(store interface{}, paddedBlockSize uint32, initOffset uint64) (BlockList, error) {
-	return NewBlockListWriterV1(store, paddedBlockSize, initOffset)
+func NewBlockListWriter(store interface{}, paddedBlockSize uint32, initOffset uint64,
+	opts ...BlockListOption) (BlockList, error) {
+	return NewBlockListWriterV1(store, paddedBlockSize, initOffset, opts...)
 }
 
 // NewBlockListReader creates a block list for reading only
 //
-func NewBlockListReader(store interface{}, initOffset, endOffset uint64, initBlockData InitEmptyBlockData) (BlockList, error) {
-	return NewBlockListReaderV1(store, initOffset, endOffset, initBlockData)
+func NewBlockListReader(store interface{}, initOffset, endOffset uint64, initBlockData InitEmptyBlockData,
+	opts ...BlockListOption) (BlockList, error) {
+	return NewBlockListReaderV1(store, initOffset, endOffset, initBlockData, opts...)
 }
 
+// GetPredictedJSONSize returns the size of the JSON serialized block data,
+// without serializing it into memory
 func GetPredictedJSONSize(data interface{}) (int, error) {
-	dataBytes, err := tools.Marshal(data)
-	if err != nil {
-		return 0, err
-	}
-	return len(dataBytes), nil
+	return tools.EstimateJSONSize(data)
 }
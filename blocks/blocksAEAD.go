@@ -0,0 +1,52 @@
+package blocks
+
+import (
+	"encoding/binary"
+
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// blockNonce derives the nonce of a block from its ID
+func (b *blockListV1) blockNonce(id uint32) []byte {
+	nonce := make([]byte, b.aead.NonceSize())
+	binary.BigEndian.PutUint32(nonce[len(nonce)-int(blockNumLen):], id)
+	return nonce
+}
+
+// sealBlock encrypts the data of a serialized block in place, and stores the
+// authentication tag in the block header
+func (b *blockListV1) sealBlock(format blockFormat, serial []byte) {
+	hdrLen := format.headerLen()
+	tagOffset := hdrLen - format.tagLen
+	id := binary.BigEndian.Uint32(serial)
+	size := binary.BigEndian.Uint32(serial[blockNumLen:])
+	data := serial[hdrLen : hdrLen+size]
+
+	sealed := b.aead.Seal(nil, b.blockNonce(id), data, serial[:tagOffset])
+	copy(data, sealed[:size])
+	copy(serial[tagOffset:hdrLen], sealed[size:])
+}
+
+// deserializeBlock deserializes a block of the list. The data of encrypted
+// blocks is authenticated and decrypted.
+func (b *blockListV1) deserializeBlock(blockBytes []byte) (*blockV1, error) {
+	format := b.blockFormat()
+	block, err := deserializeBlock(format, blockBytes)
+	if err != nil || format.tagLen == 0 {
+		return block, err
+	}
+
+	hdrLen := format.headerLen()
+	tagOffset := hdrLen - format.tagLen
+	sealed := make([]byte, 0, block.size+format.tagLen)
+	sealed = append(sealed, block.data...)
+	sealed = append(sealed, blockBytes[tagOffset:hdrLen]...)
+
+	data, err := b.aead.Open(sealed[:0], b.blockNonce(block.id), sealed, blockBytes[:tagOffset])
+	if err != nil {
+		b.warnf("Block %v fails authentication", block.id)
+		return nil, errs.WrapPrefix(err, errs.ErrCorrupt, "Can not authenticate block")
+	}
+	block.data = data
+	return block, nil
+}
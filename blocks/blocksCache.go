@@ -0,0 +1,178 @@
+package blocks
+
+import (
+	"io"
+	"sync"
+
+	"github.com/go-errors/errors"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// CacheStore is the local storage of a CachingReaderAt, such as an os.File
+type CacheStore interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// CachingReaderAt is an io.ReaderAt that fronts a slow remote io.ReaderAt,
+// such as an object store, with a local cache. The remote storage is cached
+// in pages of pageSize bytes starting at alignOffset, and the bytes in front
+// of alignOffset make up one more page. Each page is fetched from the remote
+// storage once, on its first read, and read from the cache afterwards. Pages
+// cut short by the end of the remote storage are never cached, so that a
+// list still being written can be cached. The cache starts out empty, and
+// is safe for concurrent use.
+type CachingReaderAt struct {
+	remote      io.ReaderAt
+	cache       CacheStore
+	pageSize    uint64
+	alignOffset uint64
+
+	lock   sync.Mutex
+	cached map[uint64]bool
+	hits   uint64
+	misses uint64
+}
+
+// NewCachingReaderAt returns a CachingReaderAt that caches remote in cache.
+// For a padded list, the page size is the padded block size and the align
+// offset is the offset of the first block, so that each page is one block.
+// WithReadCache sets them up for the list.
+func NewCachingReaderAt(remote io.ReaderAt, cache CacheStore, pageSize uint32,
+	alignOffset uint64) (*CachingReaderAt, error) {
+	if pageSize == 0 {
+		return nil, errors.New("The cache page size can not be 0")
+	}
+	return &CachingReaderAt{remote: remote, cache: cache, pageSize: uint64(pageSize),
+		alignOffset: alignOffset, cached: make(map[uint64]bool)}, nil
+}
+
+// ReadAt reads len(p) bytes at off, from the cache if they are cached, or
+// else from the remote storage, caching the pages read
+func (c *CachingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errs.Errorf(nil, "Invalid read offset(%v)", off)
+	}
+
+	read := 0
+	for read < len(p) {
+		pos := uint64(off) + uint64(read)
+		start, end := c.page(pos)
+		want := p[read:]
+		if uint64(len(want)) > end-pos {
+			want = want[:end-pos]
+		}
+
+		if c.isCached(start) {
+			n, err := c.cache.ReadAt(want, int64(pos))
+			read += n
+			if n < len(want) {
+				if err == nil {
+					err = io.ErrUnexpectedEOF
+				}
+				return read, errs.WrapPrefix(err, errs.ErrTruncated, "Can not read the cache")
+			}
+			continue
+		}
+
+		n, err := c.fetch(start, end, pos, want)
+		read += n
+		if err != nil {
+			return read, err
+		}
+	}
+	return read, nil
+}
+
+// page returns the range of the page that holds the byte at pos
+func (c *CachingReaderAt) page(pos uint64) (start, end uint64) {
+	if pos < c.alignOffset {
+		return 0, c.alignOffset
+	}
+	start = c.alignOffset + (pos-c.alignOffset)/c.pageSize*c.pageSize
+	return start, start + c.pageSize
+}
+
+func (c *CachingReaderAt) isCached(start uint64) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.cached[start] {
+		c.hits++
+		return true
+	}
+	c.misses++
+	return false
+}
+
+// fetch reads the page from start to end from the remote storage, caches
+// it, and copies the bytes from pos into want
+func (c *CachingReaderAt) fetch(start, end, pos uint64, want []byte) (int, error) {
+	page := make([]byte, end-start)
+	n, err := c.remote.ReadAt(page, int64(start))
+	if n == len(page) {
+		if _, werr := c.cache.WriteAt(page, int64(start)); werr != nil {
+			return 0, errs.WrapPrefix(werr, nil, "Can not write the cache")
+		}
+		c.lock.Lock()
+		c.cached[start] = true
+		c.lock.Unlock()
+		err = nil
+	}
+
+	if pos-start >= uint64(n) {
+		if err == nil {
+			err = io.EOF
+		}
+		return 0, err
+	}
+	copied := copy(want, page[pos-start:n])
+	if copied < len(want) {
+		if err == nil {
+			err = io.EOF
+		}
+		return copied, err
+	}
+	return copied, nil
+}
+
+// Stats returns the number of page reads served from the cache, and from
+// the remote storage
+func (c *CachingReaderAt) Stats() (hits, misses uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.hits, c.misses
+}
+
+// WithReadCache is a reader option for padded lists on slow storage that
+// caches the blocks in cache, so that each block is fetched from the storage
+// once. The random access reads of the list, such as ReadBlockDataAt,
+// SearchBinary and ScanInto, go through a CachingReaderAt with a page per
+// block.
+func WithReadCache(cache CacheStore) BlockListOption {
+	return func(b *blockListV1) error {
+		b.readCache = cache
+		return nil
+	}
+}
+
+// setupReadCache puts the cache of a list read WithReadCache in front of its
+// storage
+func (b *blockListV1) setupReadCache() error {
+	if b.readCache == nil {
+		return nil
+	}
+	if !b.IsBlockPadded() {
+		return errors.New("Only padded block lists can be read with a read cache")
+	}
+	if b.readerat == nil {
+		return errors.New("The underlying storage is not capable " +
+			"of performing random access reads")
+	}
+
+	cached, err := NewCachingReaderAt(b.readerat, b.readCache, b.GetPaddedBlockSize(), b.initOffset)
+	if err != nil {
+		return err
+	}
+	b.readerat = cached
+	return nil
+}
@@ -0,0 +1,293 @@
+package blocks
+
+import (
+	"encoding/binary"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/go-errors/errors"
+	"github.com/overnest/strongsalt-common-go/tools"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// A list can be stored compressed, through a CompressedWriter, and read back
+// through a CompressedReader. The compressed storage holds the list in
+// frames that are gzipped separately, followed by an index of the frames:
+// -------------------------------------------------------------------------
+// | frame | ... | frame | index(frames * 8) | frames(4) | crc(4) | magic(4) |
+// -------------------------------------------------------------------------
+// Each index entry is the uncompressed length(4) and the compressed
+// length(4) of a frame, and the crc is the CRC-32 (Castagnoli) of the index.
+// Every frame but the last holds frameSize bytes of the list, so that a read
+// at any offset of the list only uncompresses the frames it overlaps. Padded
+// lists compress well when their padding is not random, such as with
+// WithPaddingRand of a reader of zeros.
+const (
+	compressedMagic      = uint32(0x424c435a) // "BLCZ"
+	compressedEntryLen   = 8
+	compressedTrailerLen = 12
+)
+
+// DefaultCompressedFrameSize is the size of the frames of a compressed list,
+// when no frame size is given
+var DefaultCompressedFrameSize = uint32(256 * 1024)
+
+// compressedFrames finds the frames of the compressed storage that hold an
+// offset of the list
+type compressedFrames struct {
+	store io.ReaderAt
+	// starts are the offsets of the frames in the list, followed by the size
+	// of the list, and offsets are the offsets of the frames in the storage
+	starts  []uint64
+	offsets []uint64
+
+	lock        sync.Mutex
+	cachedFrame int
+	cached      []byte
+}
+
+func newCompressedFrames(store io.ReaderAt) *compressedFrames {
+	return &compressedFrames{store: store, starts: []uint64{0}, offsets: []uint64{0},
+		cachedFrame: -1}
+}
+
+// size returns the size of the list held by the frames
+func (f *compressedFrames) size() uint64 {
+	return f.starts[len(f.starts)-1]
+}
+
+func (f *compressedFrames) add(uncompressedLen, compressedLen uint64) {
+	f.starts = append(f.starts, f.size()+uncompressedLen)
+	f.offsets = append(f.offsets, f.offsets[len(f.offsets)-1]+compressedLen)
+}
+
+// frame returns the uncompressed data of the frame at index
+func (f *compressedFrames) frame(index int) ([]byte, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if index == f.cachedFrame {
+		return f.cached, nil
+	}
+
+	if f.store == nil {
+		return nil, errors.New("The compressed storage is not capable " +
+			"of performing random access reads")
+	}
+	zb := make([]byte, f.offsets[index+1]-f.offsets[index])
+	if err := readStoreAt(f.store, zb, f.offsets[index]); err != nil {
+		return nil, err
+	}
+	dataLen := f.starts[index+1] - f.starts[index]
+	data, err := tools.GunzipLimit(zb, int64(dataLen))
+	if err != nil {
+		return nil, errs.WrapPrefix(err, errs.ErrCorrupt, "Can not uncompress the list")
+	}
+	if uint64(len(data)) != dataLen {
+		return nil, errs.Errorf(errs.ErrCorrupt, "Compressed frame %v has %v bytes, but "+
+			"the index says %v", index, len(data), dataLen)
+	}
+
+	f.cachedFrame = index
+	f.cached = data
+	return data, nil
+}
+
+// readAt reads the list at off from the frames. It returns io.EOF at the
+// end of the frames.
+func (f *compressedFrames) readAt(p []byte, off uint64) (int, error) {
+	read := 0
+	for read < len(p) {
+		pos := off + uint64(read)
+		if pos >= f.size() {
+			return read, io.EOF
+		}
+		index := sort.Search(len(f.starts)-1, func(i int) bool { return f.starts[i+1] > pos })
+		data, err := f.frame(index)
+		if err != nil {
+			return read, err
+		}
+		read += copy(p[read:], data[pos-f.starts[index]:])
+	}
+	return read, nil
+}
+
+// CompressedWriter is the storage of a list written compressed. The list
+// writer writes to it, and Close writes the index of the frames to the
+// underlying storage once the list writer is closed.
+type CompressedWriter struct {
+	writer    io.Writer
+	frameSize uint32
+	frames    *compressedFrames
+	pending   []byte
+	closed    bool
+}
+
+// NewCompressedWriter returns the storage of a list written compressed in
+// frames of frameSize bytes of the list to w. A frame size of 0 is
+// DefaultCompressedFrameSize. For a padded list, a frame size that is a
+// multiple of the padded block size keeps the blocks from spanning frames.
+// The compressed storage starts where w is. Padded lists read back what
+// they wrote, which requires w to implement io.ReaderAt as well, from the
+// start of the compressed storage.
+func NewCompressedWriter(w io.Writer, frameSize uint32) *CompressedWriter {
+	if frameSize == 0 {
+		frameSize = DefaultCompressedFrameSize
+	}
+	store, _ := w.(io.ReaderAt)
+	return &CompressedWriter{writer: w, frameSize: frameSize, frames: newCompressedFrames(store)}
+}
+
+// Write adds p to the list, and writes the frames that are full
+func (c *CompressedWriter) Write(p []byte) (int, error) {
+	if c.closed {
+		return 0, errs.New(ErrSealed, "The compressed writer is closed")
+	}
+
+	written := 0
+	for len(p) > 0 {
+		n := tools.MinUint32(uint32(len(p)), c.frameSize-uint32(len(c.pending)))
+		c.pending = append(c.pending, p[:n]...)
+		p = p[n:]
+		written += int(n)
+
+		if uint32(len(c.pending)) == c.frameSize {
+			if err := c.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// flush compresses the pending bytes into a frame
+func (c *CompressedWriter) flush() error {
+	if len(c.pending) == 0 {
+		return nil
+	}
+	zb, err := tools.Gzip(c.pending)
+	if err != nil {
+		return errs.Wrap(err, nil)
+	}
+	if _, err = c.writer.Write(zb); err != nil {
+		return errs.Wrap(err, nil)
+	}
+	c.frames.add(uint64(len(c.pending)), uint64(len(zb)))
+	c.pending = c.pending[:0]
+	return nil
+}
+
+// ReadAt reads the list written so far
+func (c *CompressedWriter) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errs.Errorf(nil, "Invalid read offset(%v)", off)
+	}
+
+	n, err := c.frames.readAt(p, uint64(off))
+	if err != io.EOF {
+		return n, err
+	}
+
+	// The rest is in the frame that is not written yet
+	pos := uint64(off) + uint64(n) - c.frames.size()
+	if pos < uint64(len(c.pending)) {
+		n += copy(p[n:], c.pending[pos:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Close writes the last frame, and the index of the frames. It does not
+// close the underlying storage.
+func (c *CompressedWriter) Close() error {
+	if c.closed {
+		return nil
+	}
+	if err := c.flush(); err != nil {
+		return err
+	}
+
+	frames := len(c.frames.starts) - 1
+	index := make([]byte, frames*compressedEntryLen+compressedTrailerLen)
+	for i := 0; i < frames; i++ {
+		entry := index[i*compressedEntryLen:]
+		binary.BigEndian.PutUint32(entry, uint32(c.frames.starts[i+1]-c.frames.starts[i]))
+		binary.BigEndian.PutUint32(entry[4:], uint32(c.frames.offsets[i+1]-c.frames.offsets[i]))
+	}
+	trailer := index[frames*compressedEntryLen:]
+	binary.BigEndian.PutUint32(trailer, uint32(frames))
+	binary.BigEndian.PutUint32(trailer[4:], tools.CRC32C(index[:frames*compressedEntryLen]))
+	binary.BigEndian.PutUint32(trailer[8:], compressedMagic)
+	if _, err := c.writer.Write(index); err != nil {
+		return errs.Wrap(err, nil)
+	}
+	c.closed = true
+	return nil
+}
+
+// CompressedReader reads a list written through a CompressedWriter. A list
+// reader reads it through an io.SectionReader, for example:
+//
+//	compressed, err := NewCompressedReader(file, fileSize)
+//	store := io.NewSectionReader(compressed, 0, compressed.Size())
+//	reader, err := NewBlockListReaderV1(store, 0, uint64(compressed.Size()), initEmpty)
+type CompressedReader struct {
+	frames *compressedFrames
+}
+
+// NewCompressedReader reads the index of the frames of the compressed
+// storage, which is size bytes long
+func NewCompressedReader(r io.ReaderAt, size int64) (*CompressedReader, error) {
+	if size < compressedTrailerLen {
+		return nil, errs.Errorf(errs.ErrTruncated, "Compressed storage size(%v) is smaller "+
+			"than its trailer", size)
+	}
+	trailer := make([]byte, compressedTrailerLen)
+	if err := readStoreAt(r, trailer, uint64(size-compressedTrailerLen)); err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint32(trailer[8:]) != compressedMagic {
+		return nil, errs.New(errs.ErrCorrupt, "The storage does not hold a compressed list")
+	}
+
+	frames := uint64(binary.BigEndian.Uint32(trailer))
+	indexLen := frames * compressedEntryLen
+	if indexLen > uint64(size-compressedTrailerLen) {
+		return nil, errs.Errorf(errs.ErrCorrupt, "Compressed frame count(%v) is too large "+
+			"for the storage size(%v)", frames, size)
+	}
+	index := make([]byte, indexLen)
+	indexOffset := uint64(size-compressedTrailerLen) - indexLen
+	if err := readStoreAt(r, index, indexOffset); err != nil {
+		return nil, err
+	}
+	if tools.CRC32C(index) != binary.BigEndian.Uint32(trailer[4:]) {
+		return nil, errs.New(errs.ErrCorrupt, "The index of the compressed list is corrupted")
+	}
+
+	c := &CompressedReader{frames: newCompressedFrames(r)}
+	for i := uint64(0); i < frames; i++ {
+		entry := index[i*compressedEntryLen:]
+		c.frames.add(uint64(binary.BigEndian.Uint32(entry)), uint64(binary.BigEndian.Uint32(entry[4:])))
+	}
+	if c.frames.offsets[frames] != indexOffset {
+		return nil, errs.Errorf(errs.ErrCorrupt, "The compressed frames end at %v, but the "+
+			"index starts at %v", c.frames.offsets[frames], indexOffset)
+	}
+	return c, nil
+}
+
+// Size returns the size of the uncompressed list
+func (c *CompressedReader) Size() int64 {
+	return int64(c.frames.size())
+}
+
+// ReadAt reads the uncompressed list at off
+func (c *CompressedReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errs.Errorf(nil, "Invalid read offset(%v)", off)
+	}
+	return c.frames.readAt(p, uint64(off))
+}
@@ -0,0 +1,51 @@
+package blocks
+
+import (
+	"context"
+
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// NewBlockListWriterV1Ctx creates a block list version 1 writer, whose writes
+// fail once the context is done
+func NewBlockListWriterV1Ctx(ctx context.Context, store interface{}, paddedBlockSize uint32,
+	initOffset uint64, opts ...BlockListOption) (BlockListWriterV1, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, errs.Wrap(err, nil)
+	}
+
+	writer, err := NewBlockListWriterV1(store, paddedBlockSize, initOffset, opts...)
+	if err != nil {
+		return nil, err
+	}
+	writer.(*blockListV1).ctx = ctx
+	return writer, nil
+}
+
+// NewBlockListReaderV1Ctx creates a block list version 1 reader. Operations
+// that read many blocks, such as Iterate and the searches, stop with the
+// context error once the context is done.
+func NewBlockListReaderV1Ctx(ctx context.Context, store interface{}, initOffset, endOffset uint64,
+	initEmptyBlkData InitEmptyBlockData, opts ...BlockListOption) (BlockListReaderV1, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, errs.Wrap(err, nil)
+	}
+
+	reader, err := NewBlockListReaderV1(store, initOffset, endOffset, initEmptyBlkData, opts...)
+	if err != nil {
+		return nil, err
+	}
+	reader.(*blockListV1).ctx = ctx
+	return reader, nil
+}
+
+// checkContext returns the context error once the context of the list is done
+func (b *blockListV1) checkContext() error {
+	if b.ctx == nil {
+		return nil
+	}
+	if err := b.ctx.Err(); err != nil {
+		return errs.Wrap(err, nil)
+	}
+	return nil
+}
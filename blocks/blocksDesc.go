@@ -0,0 +1,110 @@
+package blocks
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+
+	"github.com/overnest/strongsalt-common-go/tools"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// DescriptionFormat is the format name of the block list descriptions
+const DescriptionFormat = "strongsalt-blocklist"
+
+// maxDescriptionLen is the biggest serialized description readers accept
+const maxDescriptionLen = 64 * 1024
+
+// ListDescription describes what produced a block list, and how its block
+// data is encoded, so that operators can tell without knowing the
+// application. It is written by WithDescription.
+type ListDescription struct {
+	// Format is DescriptionFormat
+	Format string `json:"format"`
+	// Codec is the serialization of the block data, "json" by default
+	Codec string `json:"codec"`
+	// Compression is "gzip" for lists without padding, or else "none"
+	Compression string `json:"compression"`
+	// Flags are the version 2 flags of the list
+	Flags uint32 `json:"flags"`
+	// Created is the time the list was created
+	Created time.Time `json:"created"`
+	// AppTag is set by the application that wrote the list
+	AppTag string `json:"appTag"`
+}
+
+// WithDescription is a writer option that records a description of the
+// list after the list header. The writer fills in the format, compression
+// and flags, and the codec and creation time if they are not set. Readers
+// return it from GetDescription. The list is written as version 2.
+func WithDescription(desc ListDescription) BlockListOption {
+	return func(b *blockListV1) error {
+		b.flags |= flagDescription
+		b.description = &desc
+		return nil
+	}
+}
+
+// GetDescription returns the description of the list, or nil if it was not
+// written WithDescription
+func (b *blockListV1) GetDescription() *ListDescription {
+	if b.flags&flagDescription == 0 || b.description == nil {
+		return nil
+	}
+	desc := *b.description
+	return &desc
+}
+
+// serializeDescription fills in the description of a list being written,
+// and serializes it for the list header
+func (b *blockListV1) serializeDescription() error {
+	desc := b.description
+	desc.Format = DescriptionFormat
+	desc.Compression = "none"
+	if !b.IsBlockPadded() {
+		desc.Compression = "gzip"
+	}
+	desc.Flags = b.flags
+	if desc.Codec == "" {
+		desc.Codec = "json"
+	}
+	if desc.Created.IsZero() {
+		desc.Created = time.Now().UTC()
+	}
+
+	serial, err := tools.Marshal(desc)
+	if err != nil {
+		return errs.Wrap(err, nil)
+	}
+	if len(serial) > maxDescriptionLen {
+		return errs.Errorf(errs.ErrTooLarge, "Block list description length(%v) is "+
+			"bigger than %v", len(serial), maxDescriptionLen)
+	}
+	b.descriptionBytes = serial
+	return nil
+}
+
+// readDescription reads the description of the list from the list header
+func (b *blockListV1) readDescription() error {
+	descLen := make([]byte, descriptionLenLen)
+	if _, err := io.ReadFull(b.reader, descLen); err != nil {
+		return errs.WrapPrefix(err, errs.ErrTruncated, "Can not read block list description length")
+	}
+	n := binary.BigEndian.Uint32(descLen)
+	if n > maxDescriptionLen {
+		return errs.Errorf(errs.ErrCorrupt, "Block list description length(%v) is "+
+			"bigger than %v", n, maxDescriptionLen)
+	}
+
+	serial := make([]byte, n)
+	if _, err := io.ReadFull(b.reader, serial); err != nil {
+		return errs.WrapPrefix(err, errs.ErrTruncated, "Can not read block list description")
+	}
+	desc := &ListDescription{}
+	if err := tools.Unmarshal(serial, desc); err != nil {
+		return errs.Wrap(err, errs.ErrCorrupt)
+	}
+	b.description = desc
+	b.descriptionBytes = serial
+	return nil
+}
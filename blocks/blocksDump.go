@@ -0,0 +1,133 @@
+package blocks
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/overnest/strongsalt-common-go/tools"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// dumpRecord is one line of a block list dump. Block data that can be
+// decoded into JSON is in Payload. Otherwise the data is kept as stored in
+// Raw, and Error tells why it could not be decoded.
+type dumpRecord struct {
+	ID        uint32          `json:"id"`
+	Size      uint32          `json:"size"`
+	Timestamp int64           `json:"timestamp,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	Raw       []byte          `json:"raw,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// Dump writes every block of the list to w as JSON Lines, one JSON object
+// per block with its ID, size and decoded payload. Blocks whose data can not
+// be decoded are dumped as raw bytes, so a corrupted list can be inspected up
+// to the first block that can not be read.
+func Dump(reader BlockListReaderV1, w io.Writer) error {
+	if err := reader.Reset(); err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	for {
+		if err := reader.checkContext(); err != nil {
+			return err
+		}
+
+		blk, err := reader.readNextBlock()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		record := dumpRecord{ID: blk.GetID(), Size: blk.GetSize()}
+		if ts := blk.GetTimestamp(); !ts.IsZero() {
+			record.Timestamp = ts.UnixNano()
+		}
+
+		payload := blk.GetData()
+		if !reader.IsBlockPadded() {
+			payload, err = gunzipBlockData(payload)
+		}
+		if err == nil && !json.Valid(payload) {
+			err = errs.New(errs.ErrCorrupt, "The block data is not valid JSON")
+		}
+		if err == nil {
+			record.Payload = payload
+		} else {
+			record.Raw = blk.GetData()
+			record.Error = err.Error()
+		}
+
+		if err = encoder.Encode(&record); err != nil {
+			return errs.Wrap(err, nil)
+		}
+	}
+}
+
+// Load reads a dump written by Dump, and writes its blocks to the writer.
+// Block IDs are kept if the writer was created with WithExplicitIDs.
+// Otherwise the blocks are numbered by the writer. Raw blocks are written
+// unchanged, and timestamps are set by the writer. Load returns the number
+// of blocks written. It does not close the writer.
+func Load(r io.Reader, writer BlockListWriterV1) (uint32, error) {
+	explicitIDs := false
+	maxBlockSize := DefaultMaxBlockSize
+	if b, ok := writer.(*blockListV1); ok {
+		explicitIDs = b.explicitIDs
+		maxBlockSize = b.maxReadSize()
+	}
+
+	// A line holds a block, which is bigger once encoded
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, int(maxBlockSize)*2)
+	written := uint32(0)
+	for line := 1; scanner.Scan(); line++ {
+		if err := writer.checkContext(); err != nil {
+			return written, err
+		}
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var record dumpRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return written, errs.WrapPrefix(err, errs.ErrCorrupt, fmt.Sprintf(
+				"Can not parse line %v of the dump", line))
+		}
+
+		data := record.Raw
+		if record.Payload != nil {
+			data = record.Payload
+			if !writer.IsBlockPadded() {
+				var err error
+				if data, err = tools.Gzip(data); err != nil {
+					return written, err
+				}
+			}
+		}
+
+		var err error
+		if explicitIDs {
+			err = writer.writeBlock(newBlock(record.ID, uint32(len(data)), data))
+		} else {
+			_, err = writer.writeBlockDataBytes(data)
+		}
+		if err != nil {
+			return written, errs.WrapPrefix(err, nil, fmt.Sprintf(
+				"Can not write block %v from line %v of the dump", record.ID, line))
+		}
+		written++
+	}
+
+	if err := scanner.Err(); err != nil {
+		return written, errs.Wrap(err, nil)
+	}
+	return written, nil
+}
@@ -1,12 +1,25 @@
 package blocks
 
 import (
+	stderrors "errors"
 	"fmt"
 
 	"github.com/go-errors/errors"
 	"github.com/overnest/strongsalt-common-go/tools"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
 )
 
+// ErrReadAfterEOF means a reader created with WithStrictReads was read again
+// after reaching the end of the list
+var ErrReadAfterEOF = stderrors.New("read after the end of the block list")
+
+// ErrListFull means a block was not written because the list would have
+// gone over the limit of WithMaxTotalBytes or WithMaxTotalBlocks
+var ErrListFull = stderrors.New("block list is full")
+
+// ErrSealed means a block was written after the writer was closed or sealed
+var ErrSealed = stderrors.New("block list is sealed")
+
 // BlockPaddingError represents an error while doing block padding
 type BlockPaddingError struct {
 	PaddedBlockSize uint32
@@ -27,13 +40,8 @@ func NewBlockPaddingError(msg string, paddedSize, blockSize, maxDataSize uint32)
 
 // IsBlockPaddingError tests error to see if it's a block padding error
 func IsBlockPaddingError(err error) (*BlockPaddingError, bool) {
-	if e, ok := err.(*errors.Error); ok {
-		if e, ok := e.Err.(*BlockPaddingError); ok {
-			return e, true
-		}
-	}
-
-	if e, ok := err.(*BlockPaddingError); ok {
+	var e *BlockPaddingError
+	if errs.As(err, &e) {
 		return e, true
 	}
 	return nil, false
@@ -48,3 +56,43 @@ func (e *BlockPaddingError) Stacktrace() string {
 func (e *BlockPaddingError) Error() string {
 	return e.Err.Error()
 }
+
+// Is reports whether the target is errs.ErrTooLarge
+func (e *BlockPaddingError) Is(target error) bool {
+	return target == errs.ErrTooLarge
+}
+
+// PaddedBlockSizeError represents a padded block size that can not hold a block
+type PaddedBlockSizeError struct {
+	PaddedBlockSize    uint32
+	MinPaddedBlockSize uint32
+	Err                *errors.Error
+}
+
+// NewPaddedBlockSizeError creates a padded block size error
+func NewPaddedBlockSizeError(msg string, paddedSize, minPaddedSize uint32) tools.ErrorStack {
+	return &PaddedBlockSizeError{
+		paddedSize,
+		minPaddedSize,
+		errors.Wrap(fmt.Sprintf("%v : PaddedSize=%v MinPaddedSize=%v",
+			msg, paddedSize, minPaddedSize), 1)}
+}
+
+// IsPaddedBlockSizeError tests error to see if it's a padded block size error
+func IsPaddedBlockSizeError(err error) (*PaddedBlockSizeError, bool) {
+	var e *PaddedBlockSizeError
+	if errs.As(err, &e) {
+		return e, true
+	}
+	return nil, false
+}
+
+// Stacktrace shows the stack trace
+func (e *PaddedBlockSizeError) Stacktrace() string {
+	return e.Err.ErrorStack()
+}
+
+// Error shows the error message
+func (e *PaddedBlockSizeError) Error() string {
+	return e.Err.Error()
+}
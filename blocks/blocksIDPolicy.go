@@ -0,0 +1,111 @@
+package blocks
+
+import (
+	"github.com/go-errors/errors"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// IDPolicy is how a reader checks the IDs of the blocks it reads
+type IDPolicy int
+
+const (
+	// IDStrict expects the ID of each block to be the ID of the block before
+	// it plus 1, so that the ID of a block is its index. Lists that record
+	// their block IDs, such as lists written WithExplicitIDs, are checked
+	// against the recorded IDs instead.
+	IDStrict = IDPolicy(iota)
+	// IDMonotonic expects the ID of each block to be bigger than the ID of
+	// the block before it, which accepts the gaps that sparse writers leave
+	IDMonotonic
+	// IDNone does not check the block IDs at all, so that recovery tools can
+	// read lists whose blocks are out of order
+	IDNone
+)
+
+// WithIDPolicy is a reader option that checks the block IDs with the
+// policy, instead of IDStrict. Without IDStrict, blocks can only be found by
+// ID in lists that record their block IDs.
+func WithIDPolicy(policy IDPolicy) BlockListOption {
+	return func(b *blockListV1) error {
+		switch policy {
+		case IDStrict, IDMonotonic, IDNone:
+			b.idPolicy = policy
+		default:
+			return errors.Errorf("Invalid ID policy(%v)", policy)
+		}
+		return nil
+	}
+}
+
+// follows shows whether a block with the ID can follow the block with
+// prevID under the policy. A prevID of -1 is the start of the list.
+func (p IDPolicy) follows(prevID, id int64) bool {
+	switch p {
+	case IDStrict:
+		return id == prevID+1
+	case IDMonotonic:
+		return id > prevID
+	}
+	return true
+}
+
+// orderPolicy returns the policy that consecutive blocks are checked with.
+// Lists that record their block IDs can have gaps between them.
+func (b *blockListV1) orderPolicy() IDPolicy {
+	if b.idPolicy == IDStrict && (b.explicitIDs || b.blockIDs != nil) {
+		return IDMonotonic
+	}
+	return b.idPolicy
+}
+
+// scanIDPolicy returns the policy that ScanLists checks the blocks with.
+// The block IDs of a list with a footer are not known until the footer is
+// read, so they can have gaps between them.
+func (b *blockListV1) scanIDPolicy() IDPolicy {
+	if b.idPolicy == IDStrict && b.hasFooter() {
+		return IDMonotonic
+	}
+	return b.idPolicy
+}
+
+// checkIndexID checks that the block read at index has the ID the list
+// expects there
+func (b *blockListV1) checkIndexID(index, id uint32) error {
+	if b.idPolicy == IDNone {
+		return nil
+	}
+	expectedID := index
+	if b.blockIDs != nil {
+		if index >= uint32(len(b.blockIDs)) {
+			return errs.Errorf(errs.ErrCorrupt, "Block index(%v) is not in the "+
+				"footer, which has %v blocks", index, len(b.blockIDs))
+		}
+		expectedID = b.blockIDs[index]
+	} else if b.idPolicy == IDMonotonic {
+		return nil
+	}
+
+	if id != expectedID {
+		b.warnf("Block ID(%v) does not match the retrieval index(%v)", id, index)
+		return errs.Errorf(errs.ErrCorrupt, "Block ID(%v) does not match the retrieval index(%v)",
+			id, index)
+	}
+	return nil
+}
+
+// checkIDOrder checks that the ID of a block follows the ID of the block
+// before it
+func (b *blockListV1) checkIDOrder(prevID, nextID uint32) error {
+	policy := b.orderPolicy()
+	if policy.follows(int64(prevID), int64(nextID)) {
+		return nil
+	}
+	if policy == IDMonotonic {
+		b.warnf("Block ID(%v) is not bigger than the previous block ID(%v)", nextID, prevID)
+		return errs.Errorf(errs.ErrCorrupt, "The next block ID(%v) is not bigger than "+
+			"the previous block ID(%v)", nextID, prevID)
+	}
+	b.warnf("Block ID(%v) does not immediately follow the previous block ID(%v)", nextID, prevID)
+	return errs.Errorf(errs.ErrCorrupt, "The next block ID(%v) does not immediately follow "+
+		"the previous block ID(%v)", nextID, prevID)
+}
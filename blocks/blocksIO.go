@@ -0,0 +1,175 @@
+package blocks
+
+import (
+	"context"
+	"io"
+
+	"github.com/overnest/strongsalt-common-go/tools"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// dataReader reads the data of the blocks of a list, one after the other
+type dataReader struct {
+	reader  BlockListReaderV1
+	started bool
+	data    []byte
+}
+
+// NewDataReader returns an io.Reader over the data of every block of the
+// list in order, as written with the raw block data. The read position of the
+// list is reset by the first Read, and must not be moved while reading.
+func NewDataReader(reader BlockListReaderV1) io.Reader {
+	return &dataReader{reader: reader}
+}
+
+func (r *dataReader) Read(p []byte) (int, error) {
+	if !r.started {
+		if err := r.reader.Reset(); err != nil {
+			return 0, err
+		}
+		r.started = true
+	}
+
+	for len(r.data) == 0 && len(p) > 0 {
+		if err := r.reader.checkContext(); err != nil {
+			return 0, err
+		}
+
+		blk, err := r.reader.readNextBlock()
+		if err != nil {
+			return 0, err
+		}
+		r.data = blk.GetData()
+	}
+
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// DefaultDataChunkSize is the size of the blocks written by a data writer on
+// a list without padding, when no chunk size is given
+var DefaultDataChunkSize = uint32(64 * 1024)
+
+// dataWriter splits the data written to it into blocks
+type dataWriter struct {
+	writer    BlockListWriterV1
+	chunkSize uint32
+	buf       []byte
+}
+
+// NewDataWriter returns an io.WriteCloser that splits the data written to it
+// into blocks of chunkSize bytes, or less for the last one. The chunk size is
+// limited to the maximum data size of a padded list. A chunk size of 0 fills
+// padded blocks, or uses DefaultDataChunkSize for lists without padding.
+// Close writes the last block, and closes the writer.
+func NewDataWriter(writer BlockListWriterV1, chunkSize uint32) io.WriteCloser {
+	if chunkSize == 0 {
+		chunkSize = DefaultDataChunkSize
+		if writer.IsBlockPadded() {
+			chunkSize = writer.GetMaxDataSize()
+		}
+	}
+	if chunkSize > writer.GetMaxDataSize() {
+		chunkSize = writer.GetMaxDataSize()
+	}
+
+	return &dataWriter{
+		writer:    writer,
+		chunkSize: chunkSize,
+		buf:       make([]byte, 0, chunkSize),
+	}
+}
+
+func (w *dataWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := tools.MinUint32(uint32(len(p)), w.chunkSize-uint32(len(w.buf)))
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		written += int(n)
+
+		if uint32(len(w.buf)) == w.chunkSize {
+			if err := w.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (w *dataWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	if _, err := w.writer.writeBlockDataBytes(w.buf); err != nil {
+		return err
+	}
+	// The writer keeps the last block written
+	w.buf = make([]byte, 0, w.chunkSize)
+	return nil
+}
+
+func (w *dataWriter) Close() error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+	return w.writer.Close()
+}
+
+// retryReaderAt retries the failed reads of the storage of a list, as the
+// retry policy of the list says
+type retryReaderAt struct {
+	list     *blockListV1
+	readerat io.ReaderAt
+}
+
+func (r *retryReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	ctx := r.list.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	var eof error
+	attempt := 0
+	err = tools.Retry(ctx, *r.list.retry, func() error {
+		attempt++
+		var rerr error
+		n, rerr = r.readerat.ReadAt(p, off)
+		if rerr == io.EOF {
+			eof = rerr
+			return nil
+		}
+		if rerr != nil {
+			r.list.debugf("Read of %v bytes at offset %v failed, attempt %v: %v",
+				len(p), off, attempt, rerr)
+		}
+		return rerr
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, eof
+}
+
+// checkSlowWrite calls the slow write hook if the write of a block, timed by
+// the stopwatch, took longer than the threshold
+func (b *blockListV1) checkSlowWrite(blockID uint32, sw *tools.Stopwatch) {
+	if b.slowWrite == nil {
+		return
+	}
+	if d := sw.Elapsed(); d > b.slowWriteThreshold {
+		b.slowWrite(blockID, d)
+	}
+}
+
+// syncStore flushes the writes to the storage to stable storage, if the
+// storage can be synced, such as an os.File
+func (b *blockListV1) syncStore() error {
+	for _, store := range []interface{}{b.store, b.writerat} {
+		if syncer, ok := store.(interface{ Sync() error }); ok {
+			return errs.Wrap(syncer.Sync(), nil)
+		}
+	}
+	return nil
+}
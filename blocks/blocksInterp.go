@@ -0,0 +1,140 @@
+package blocks
+
+import (
+	"math"
+
+	"github.com/go-errors/errors"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// NumericKeyRange returns the smallest and biggest numeric keys of the block
+// data
+type NumericKeyRange func(blockData interface{}) (minKey, maxKey float64, err error)
+
+// WithInterpolationSearch is a reader option that makes SearchBinary and
+// SearchBinaryIndex use interpolation search, for lists of numeric keys that
+// are about uniformly distributed. Instead of the middle block, the search
+// probes the block where the value would be if the keys between the blocks
+// around it were evenly spread, which needs far fewer probes on very large
+// lists. The value searched for must be a number. A probe that does not
+// halve the blocks left to search is followed by a bisection, so that badly
+// distributed keys take at most twice the probes of a binary search.
+func WithInterpolationSearch(keyRange NumericKeyRange) BlockListOption {
+	return func(b *blockListV1) error {
+		if keyRange == nil {
+			return errors.New("Interpolation search needs the key range of the blocks")
+		}
+		b.keyRange = keyRange
+		return nil
+	}
+}
+
+// numericValue converts the value searched for to a float64
+func numericValue(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), nil
+	case int8:
+		return float64(v), nil
+	case int16:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint:
+		return float64(v), nil
+	case uint8:
+		return float64(v), nil
+	case uint16:
+		return float64(v), nil
+	case uint32:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case float32:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	}
+	return 0, errors.Errorf("The value %T is not a number, which interpolation search needs", value)
+}
+
+// searchInterpolationIndex performs the interpolation search of
+// SearchBinaryIndex. It also returns the data of the block the comparator
+// returned 1 for, and its JSON size.
+func (b *blockListV1) searchInterpolationIndex(value interface{},
+	comparator BlockDataComparator) (uint32, bool, interface{}, int, error) {
+	if b.readerat == nil {
+		return 0, false, nil, 0, errors.New("The underlying storage is not capable " +
+			"of performing random reads")
+	}
+
+	key, err := numericValue(value)
+	if err != nil {
+		return 0, false, nil, 0, err
+	}
+
+	totalBlocks, err := b.GetTotalBlocks()
+	if err != nil {
+		return 0, false, nil, 0, err
+	}
+
+	// The blocks left to search are [left, right). lowKey is the biggest key
+	// of the block before left, and highKey the smallest key of the block at
+	// right, once they have been probed.
+	left, right := uint32(0), totalBlocks
+	var lowKey, highKey float64
+	lowKnown, highKnown, bisect := false, false, false
+	for left < right {
+		if err := b.checkContext(); err != nil {
+			return 0, false, nil, 0, err
+		}
+
+		mid := left + (right-left)/2
+		switch {
+		case !lowKnown:
+			mid = left
+		case !highKnown:
+			mid = right - 1
+		case !bisect && highKey > lowKey:
+			pos := (key - lowKey) / (highKey - lowKey) * float64(right-left)
+			// A key outside of the known keys must not overflow mid
+			mid = left + uint32(math.Max(0, math.Min(pos, float64(right-1-left))))
+		}
+
+		blockData, jsonSize, err := b.ReadBlockDataAt(mid)
+		if err != nil {
+			return 0, false, nil, 0, errs.Wrap(err, nil)
+		}
+		comp, err := comparator(value, blockData)
+		if err != nil {
+			return 0, false, nil, 0, errs.Wrap(err, nil)
+		}
+		if comp == 1 {
+			return mid, true, blockData, jsonSize, nil
+		}
+		if comp == 0 {
+			return mid, false, nil, 0, nil
+		}
+
+		minKey, maxKey, err := b.keyRange(blockData)
+		if err != nil {
+			return 0, false, nil, 0, errs.Wrap(err, nil)
+		}
+		if minKey > maxKey {
+			return 0, false, nil, 0, errs.Errorf(errs.ErrCorrupt, "The smallest block key(%v) "+
+				"is bigger than the biggest block key(%v)", minKey, maxKey)
+		}
+
+		remaining := right - left
+		if comp < 0 {
+			right, highKey, highKnown = mid, minKey, true
+		} else {
+			left, lowKey, lowKnown = mid+1, maxKey, true
+		}
+		bisect = lowKnown && highKnown && !bisect && (right-left) > remaining/2
+	}
+
+	return left, false, nil, 0, nil
+}
@@ -0,0 +1,241 @@
+package blocks
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/go-errors/errors"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// IterateDirection is the order in which Iterate visits the blocks
+type IterateDirection int
+
+const (
+	// IterateForward visits the blocks from the first to the last
+	IterateForward = IterateDirection(iota)
+	// IterateBackward visits the blocks from the last to the first
+	IterateBackward
+)
+
+// BlockDataIterFunc is called by Iterate with the deserialized data of each
+// block. Returning more = false stops the iteration without an error.
+type BlockDataIterFunc func(blockData interface{}, jsonSize int) (more bool, err error)
+
+// The reader keeps a read position between two blocks. ReadNextBlockData
+// returns the block after the position and moves past it, while
+// ReadPrevBlockData returns the block before the position and moves in front
+// of it. Changing direction returns the same block again.
+
+func (b *blockListV1) readPrevBlock() (Block, error) {
+	if b.reader == nil {
+		return nil, errors.New("The underlying storage is not capable " +
+			"of performing reads")
+	}
+
+	if b.curOffset <= b.initOffset {
+		return nil, io.EOF
+	}
+
+	var blockLen uint32
+	if b.IsBlockPadded() {
+		blockLen = b.GetPaddedBlockSize()
+	} else {
+		if !b.hasBackPointers() {
+			return nil, errors.New("The block list does not have padded fixed sized blocks " +
+				"or back pointers. Can not read backwards")
+		}
+
+		backPointer := make([]byte, backPointerLen)
+		if err := b.readAt(backPointer, b.curOffset-uint64(backPointerLen)); err != nil {
+			return nil, err
+		}
+		blockLen = binary.BigEndian.Uint32(backPointer)
+		if uint64(blockLen) > b.curOffset-b.initOffset {
+			return nil, errs.Errorf(errs.ErrCorrupt, "Block back pointer(%v) is bigger "+
+				"than the bytes(%v) in front of the read position", blockLen, b.curOffset-b.initOffset)
+		}
+		format := b.blockFormat()
+		if blockLen < format.minHeaderLen()+backPointerLen {
+			return nil, errs.Errorf(errs.ErrCorrupt, "Block back pointer(%v) is "+
+				"smaller than the block overhead", blockLen)
+		}
+		// The data size of a compact block is only known from its header
+		if !format.compact {
+			if err := b.checkDataSize(blockLen - format.headerLen() - backPointerLen); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if uint64(blockLen) > b.curOffset-b.initOffset {
+		return nil, errs.Errorf(errs.ErrCorrupt, "Block size(%v) is bigger than the "+
+			"bytes(%v) in front of the read position", blockLen, b.curOffset-b.initOffset)
+	}
+
+	blockOffset := b.curOffset - uint64(blockLen)
+	blockBytes := make([]byte, blockLen-b.blockTrailerLen())
+	if err := b.readAt(blockBytes, blockOffset); err != nil {
+		return nil, err
+	}
+
+	block, err := b.deserializeBlock(blockBytes)
+	if err != nil {
+		return nil, err
+	}
+	if !b.IsBlockPadded() && block.GetSize()+b.blockFormat().blockHeaderLen(block.GetID(),
+		block.GetSize()) != uint32(len(blockBytes)) {
+		return nil, errs.Errorf(errs.ErrCorrupt, "Block size(%v) does not match "+
+			"the block back pointer(%v)", block.GetSize(), blockLen)
+	}
+
+	// After a forward read, the previous block is the one that was just read
+	if b.cursorNext != nil {
+		if err = b.checkIDOrder(block.GetID(), b.cursorNext.GetID()); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = b.seek(blockOffset); err != nil {
+		return nil, err
+	}
+
+	b.curOffset = blockOffset
+	b.curBlock = block
+	b.cursorNext = block
+	b.eof = false
+	return block, nil
+}
+
+// ReadPrevBlockData reads the block before the read position, and
+// deserializes its data. It returns io.EOF at the beginning of the list.
+func (b *blockListV1) ReadPrevBlockData() (interface{}, int, error) {
+	blk, err := b.readPrevBlock()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if blk == nil || len(blk.GetData()) == 0 {
+		return nil, 0, errors.New("invalid blockData")
+	}
+	return b.deserializeBlockData(blk.GetData())
+}
+
+// Iterate calls fn with the data of every block in the list, in the given
+// direction. The read position is reset before the iteration starts.
+func (b *blockListV1) Iterate(direction IterateDirection, fn BlockDataIterFunc) error {
+	readBlockData := b.ReadNextBlockData
+	reset := b.Reset
+	if direction == IterateBackward {
+		readBlockData = b.ReadPrevBlockData
+		reset = b.ResetToEnd
+	}
+
+	if err := reset(); err != nil {
+		return err
+	}
+
+	for {
+		if err := b.checkContext(); err != nil {
+			return err
+		}
+
+		blockData, jsonSize, err := readBlockData()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		more, err := fn(blockData, jsonSize)
+		if err != nil {
+			return err
+		}
+		if !more {
+			return nil
+		}
+	}
+}
+
+// Cursor reads the data of the blocks of a list one at a time, in the style
+// of database/sql Rows. Next returns false at the end of the list and after
+// an error, which Err then returns, so that callers do not need to tell
+// io.EOF apart from other errors.
+//
+//	cursor := reader.Cursor(IterateForward)
+//	for cursor.Next() {
+//		blockData := cursor.Value()
+//	}
+//	if err := cursor.Err(); err != nil {
+//		return err
+//	}
+type Cursor struct {
+	b             *blockListV1
+	readBlockData func() (interface{}, int, error)
+	value         interface{}
+	jsonSize      int
+	err           error
+	done          bool
+}
+
+// Cursor returns a cursor over the data of every block in the list, in the
+// given direction. Like Iterate, it resets the read position first.
+func (b *blockListV1) Cursor(direction IterateDirection) *Cursor {
+	c := &Cursor{b: b, readBlockData: b.ReadNextBlockData}
+	reset := b.Reset
+	if direction == IterateBackward {
+		c.readBlockData = b.ReadPrevBlockData
+		reset = b.ResetToEnd
+	}
+
+	if err := reset(); err != nil {
+		c.err = err
+		c.done = true
+	}
+	return c
+}
+
+// Next reads the next block. It returns false once there are no more blocks
+// or the read failed.
+func (c *Cursor) Next() bool {
+	if c.done {
+		return false
+	}
+	c.value, c.jsonSize = nil, 0
+
+	if err := c.b.checkContext(); err != nil {
+		c.err = err
+		c.done = true
+		return false
+	}
+
+	blockData, jsonSize, err := c.readBlockData()
+	if err != nil {
+		if !errs.Is(err, io.EOF) {
+			c.err = err
+		}
+		c.done = true
+		return false
+	}
+
+	c.value, c.jsonSize = blockData, jsonSize
+	return true
+}
+
+// Value returns the deserialized data of the block read by the last call to
+// Next
+func (c *Cursor) Value() interface{} {
+	return c.value
+}
+
+// JSONSize returns the serialized size of the block data returned by Value
+func (c *Cursor) JSONSize() int {
+	return c.jsonSize
+}
+
+// Err returns the error that stopped the cursor. It is nil if the cursor
+// reached the end of the list.
+func (c *Cursor) Err() error {
+	return c.err
+}
@@ -0,0 +1,230 @@
+package blocks
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+
+	"github.com/go-errors/errors"
+	"github.com/overnest/strongsalt-common-go/tools"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// A write journal tells a block that was being written when the writer
+// crashed from a corrupted one. The writer of a padded list records its
+// intent before each block write, and the commit once the block is synced:
+//
+//  1. JournalIntent with the offset of the block about to be written
+//  2. The block is written, and the storage is synced
+//  3. JournalCommitted with the end of the block
+//  4. Close writes the footer, syncs the storage, and records JournalClosed
+//     with the end of the list
+//
+// A reader of a list that was not closed only reads up to the offset of the
+// last entry, dropping a block that was only partly written, and reads the
+// list as if it had no footer. Writing to it again with OpenBlockListRW
+// overwrites the dropped block, and Close then writes the footer.
+
+// JournalState is the state of the block write recorded in a JournalEntry
+type JournalState uint32
+
+const (
+	_ = iota // Skip 0
+	// JournalIntent means a block is being written at the offset
+	JournalIntent = JournalState(iota)
+	// JournalCommitted means the blocks up to the offset are written
+	JournalCommitted
+	// JournalClosed means the list was closed, and ends at the offset
+	JournalClosed
+)
+
+// JournalEntry is the last block write of a list recorded in a WriteJournal
+type JournalEntry struct {
+	State JournalState
+	// Offset is the end of the committed part of the list, which is also
+	// where the block of an intent is written
+	Offset uint64
+	// BlockID is the ID of the block written or being written
+	BlockID uint32
+}
+
+// WriteJournal holds the last JournalEntry of a padded list being written
+type WriteJournal interface {
+	// Store replaces the entry, and returns once it is on stable storage
+	Store(entry JournalEntry) error
+	// Load returns the last stored entry, or an errs.ErrNotFound error if
+	// there is none
+	Load() (JournalEntry, error)
+}
+
+// The file write journal has two slots, which are written in turn, so that
+// a crash while writing one leaves the other intact. Each slot has the
+// following format:
+// ------------------------------------------------------------------------
+// | magic(4) | seq(8) | state(4) | offset(8) | blockID(4) | crc(4) |
+// ------------------------------------------------------------------------
+// The entry with the highest seq whose crc matches is the last entry.
+const (
+	journalMagic   = uint32(0x424c4a4e) // "BLJN"
+	journalSlotLen = 32
+)
+
+// FileWriteJournal is a WriteJournal kept in a file
+type FileWriteJournal struct {
+	file *os.File
+	seq  uint64
+}
+
+// OpenFileWriteJournal opens the write journal in the file at path, which is
+// created if it does not exist
+func OpenFileWriteJournal(path string) (*FileWriteJournal, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errs.Wrap(err, nil)
+	}
+
+	j := &FileWriteJournal{file: file}
+	if _, seq, err := j.load(); err != nil && !errs.Is(err, errs.ErrNotFound) {
+		file.Close()
+		return nil, err
+	} else if err == nil {
+		j.seq = seq
+	}
+	return j, nil
+}
+
+// Store writes the entry over the older of the two slots, and syncs it
+func (j *FileWriteJournal) Store(entry JournalEntry) error {
+	slot := make([]byte, journalSlotLen)
+	binary.BigEndian.PutUint32(slot, journalMagic)
+	binary.BigEndian.PutUint64(slot[4:], j.seq+1)
+	binary.BigEndian.PutUint32(slot[12:], uint32(entry.State))
+	binary.BigEndian.PutUint64(slot[16:], entry.Offset)
+	binary.BigEndian.PutUint32(slot[24:], entry.BlockID)
+	binary.BigEndian.PutUint32(slot[28:], tools.CRC32C(slot[:28]))
+
+	if _, err := j.file.WriteAt(slot, int64((j.seq+1)%2)*journalSlotLen); err != nil {
+		return errs.Wrap(err, nil)
+	}
+	if err := j.file.Sync(); err != nil {
+		return errs.Wrap(err, nil)
+	}
+	j.seq++
+	return nil
+}
+
+// Load returns the last stored entry
+func (j *FileWriteJournal) Load() (JournalEntry, error) {
+	entry, _, err := j.load()
+	return entry, err
+}
+
+func (j *FileWriteJournal) load() (JournalEntry, uint64, error) {
+	slots := make([]byte, 2*journalSlotLen)
+	n, err := j.file.ReadAt(slots, 0)
+	if err != nil && err != io.EOF {
+		return JournalEntry{}, 0, errs.Wrap(err, nil)
+	}
+
+	var last JournalEntry
+	var lastSeq uint64
+	for i := 0; i+journalSlotLen <= n; i += journalSlotLen {
+		slot := slots[i : i+journalSlotLen]
+		if binary.BigEndian.Uint32(slot) != journalMagic ||
+			binary.BigEndian.Uint32(slot[28:]) != tools.CRC32C(slot[:28]) {
+			continue
+		}
+		if seq := binary.BigEndian.Uint64(slot[4:]); seq > lastSeq {
+			lastSeq = seq
+			last = JournalEntry{
+				State:   JournalState(binary.BigEndian.Uint32(slot[12:])),
+				Offset:  binary.BigEndian.Uint64(slot[16:]),
+				BlockID: binary.BigEndian.Uint32(slot[24:]),
+			}
+		}
+	}
+	if lastSeq == 0 {
+		return JournalEntry{}, 0, errs.New(errs.ErrNotFound, "The write journal has no entry")
+	}
+	return last, lastSeq, nil
+}
+
+// Close closes the journal file
+func (j *FileWriteJournal) Close() error {
+	return errs.Wrap(j.file.Close(), nil)
+}
+
+// WithWriteJournal is a writer and reader option for padded lists that
+// records each block write in the journal, so that a reader can drop a block
+// that was only partly written when the writer crashed. The list can not be
+// preallocated, or written WithAEAD: the block written over a dropped block
+// has the same ID, and so the same nonce, as the dropped bytes left on the
+// storage.
+func WithWriteJournal(journal WriteJournal) BlockListOption {
+	return func(b *blockListV1) error {
+		b.journal = journal
+		return nil
+	}
+}
+
+// journalStore stores the entry in the journal, if the list has one. A
+// committed entry is stored once the storage is synced.
+func (b *blockListV1) journalStore(state JournalState, offset uint64, blockID uint32) error {
+	if b.journal == nil {
+		return nil
+	}
+	if state != JournalIntent {
+		if err := b.syncStore(); err != nil {
+			return err
+		}
+	}
+	return b.journal.Store(JournalEntry{State: state, Offset: offset, BlockID: blockID})
+}
+
+// recoverJournal drops what follows the last journal entry of a list that
+// was not closed
+func (b *blockListV1) recoverJournal() error {
+	entry, err := b.journal.Load()
+	if errs.Is(err, errs.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if entry.State == JournalClosed {
+		return nil
+	}
+
+	if entry.Offset < b.initOffset {
+		return errs.Errorf(errs.ErrCorrupt, "The write journal offset(%v) is in front of "+
+			"the first block", entry.Offset)
+	}
+	if entry.Offset < b.endOffset {
+		b.warnf("Dropping the %v bytes after the last write journal entry at offset %v, "+
+			"block %v", b.endOffset-entry.Offset, entry.Offset, entry.BlockID)
+		b.endOffset = entry.Offset
+	}
+	if b.hasFooter() {
+		b.debugf("Reading the block list without its footer, which was never written")
+		// The footer was never written
+		b.flags &^= flagFooter
+		b.pendingFooter = true
+	}
+	return nil
+}
+
+// checkJournal makes sure a list written with a journal can use one
+func (b *blockListV1) checkJournal() error {
+	if b.journal == nil {
+		return nil
+	}
+	if !b.IsBlockPadded() || b.preallocated {
+		return errors.New("Only padded block lists that are not preallocated can be " +
+			"written with a journal")
+	}
+	if b.aead != nil {
+		return errors.New("Block lists written WithAEAD can not be written with a journal, " +
+			"which would reuse the nonce of a dropped block")
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package blocks
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+
+	"github.com/go-errors/errors"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// WriteBlockDataWithKeys serializes blockData, writes it as the next block,
+// and records the index of the block under each of the keys. The list must
+// have been created with WithKeyIndex.
+func (b *blockListV1) WriteBlockDataWithKeys(blockData interface{}, keys [][]byte) error {
+	if b.keys == nil {
+		return errors.New("The block list writer was not created with WithKeyIndex")
+	}
+
+	index := b.blocks
+	if err := b.WriteBlockData(blockData); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		indexes := b.keys[string(key)]
+		if len(indexes) > 0 && indexes[len(indexes)-1] == index {
+			continue
+		}
+		b.keys[string(key)] = append(indexes, index)
+	}
+	return nil
+}
+
+// LookupKey returns the indexes of the blocks written with the key, in block
+// order. A block is only a candidate, since the key index does not know how
+// the keys relate to the block data. It returns no indexes if the key is not
+// in the index.
+func (b *blockListV1) LookupKey(key []byte) ([]uint32, error) {
+	if b.keys == nil {
+		return nil, errors.New("The block list does not have a key index")
+	}
+	return b.keys[string(key)], nil
+}
+
+// blockKeyRange is the smallest and biggest keys a block was written with
+type blockKeyRange struct {
+	index  uint32
+	minKey []byte
+	maxKey []byte
+}
+
+// SearchKey is SearchBinaryIndex for lists whose blocks were written with
+// sorted keys by WriteBlockDataWithKeys. It compares the key with the
+// smallest and biggest keys of each block in the key index, as bytes.Compare
+// does, so it needs no comparator and reads no block. If no block has the
+// key in its range, found is false and the index is that of the first block
+// after the key, or the index after the last block written with keys if the
+// key is after all of them. Blocks written without keys are skipped.
+func (b *blockListV1) SearchKey(key []byte) (uint32, bool, error) {
+	if b.keys == nil {
+		return 0, false, errors.New("The block list does not have a key index")
+	}
+
+	ranges := b.keyRanges
+	if ranges == nil {
+		var err error
+		if ranges, err = b.blockKeyRanges(); err != nil {
+			return 0, false, err
+		}
+		if b.writer == nil {
+			// The key index of a reader does not change
+			b.keyRanges = ranges
+		}
+	}
+
+	i := sort.Search(len(ranges), func(i int) bool {
+		return bytes.Compare(ranges[i].maxKey, key) >= 0
+	})
+	if i == len(ranges) {
+		if i == 0 {
+			return 0, false, nil
+		}
+		return ranges[i-1].index + 1, false, nil
+	}
+	return ranges[i].index, bytes.Compare(ranges[i].minKey, key) <= 0, nil
+}
+
+// blockKeyRanges returns the key ranges of the blocks in the key index, in
+// block order. The ranges must not overlap, except for a key shared by
+// consecutive blocks.
+func (b *blockListV1) blockKeyRanges() ([]blockKeyRange, error) {
+	byIndex := make(map[uint32]*blockKeyRange)
+	for key, indexes := range b.keys {
+		keyBytes := []byte(key)
+		for _, index := range indexes {
+			r := byIndex[index]
+			if r == nil {
+				byIndex[index] = &blockKeyRange{index: index, minKey: keyBytes, maxKey: keyBytes}
+				continue
+			}
+			if bytes.Compare(keyBytes, r.minKey) < 0 {
+				r.minKey = keyBytes
+			}
+			if bytes.Compare(keyBytes, r.maxKey) > 0 {
+				r.maxKey = keyBytes
+			}
+		}
+	}
+
+	ranges := make([]blockKeyRange, 0, len(byIndex))
+	for _, r := range byIndex {
+		ranges = append(ranges, *r)
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].index < ranges[j].index })
+	for i := 1; i < len(ranges); i++ {
+		if bytes.Compare(ranges[i].minKey, ranges[i-1].maxKey) < 0 {
+			return nil, errors.Errorf("The keys of block %v are not after the keys of "+
+				"block %v. Use LookupKey instead", ranges[i].index, ranges[i-1].index)
+		}
+	}
+	return ranges, nil
+}
+
+func serializeKeyIndex(keys map[string][]uint32) []byte {
+	sortedKeys := make([]string, 0, len(keys))
+	size, count := 4, 0
+	for key, indexes := range keys {
+		sortedKeys = append(sortedKeys, key)
+		size += (8 + len(key)) * len(indexes)
+		count += len(indexes)
+	}
+	sort.Strings(sortedKeys)
+
+	section := make([]byte, 4, size)
+	binary.BigEndian.PutUint32(section, uint32(count))
+	entry := make([]byte, 4)
+	for _, key := range sortedKeys {
+		for _, index := range keys[key] {
+			binary.BigEndian.PutUint32(entry, uint32(len(key)))
+			section = append(section, entry...)
+			section = append(section, key...)
+			binary.BigEndian.PutUint32(entry, index)
+			section = append(section, entry...)
+		}
+	}
+	return section
+}
+
+func deserializeKeyIndex(section []byte) (map[string][]uint32, error) {
+	if len(section) < 4 {
+		return nil, errs.New(errs.ErrCorrupt, "Footer key section is too small")
+	}
+
+	count := binary.BigEndian.Uint32(section)
+	section = section[4:]
+	// Every entry takes at least 8 bytes
+	if uint64(count)*8 > uint64(len(section)) {
+		return nil, errs.Errorf(errs.ErrCorrupt, "Footer key section has %v bytes "+
+			"for %v keys", len(section), count)
+	}
+
+	keys := make(map[string][]uint32)
+	var prevKey []byte
+	for i := uint32(0); i < count; i++ {
+		if len(section) < 4 {
+			return nil, errs.New(errs.ErrCorrupt, "Footer key section is truncated")
+		}
+		keyLen := binary.BigEndian.Uint32(section)
+		section = section[4:]
+		if uint64(keyLen)+4 > uint64(len(section)) {
+			return nil, errs.Errorf(errs.ErrCorrupt, "Footer key length(%v) is bigger "+
+				"than the remaining key section size(%v)", keyLen, len(section))
+		}
+		key := section[:keyLen]
+		index := binary.BigEndian.Uint32(section[keyLen:])
+		section = section[keyLen+4:]
+
+		if prevKey != nil && bytes.Compare(key, prevKey) < 0 {
+			return nil, errs.New(errs.ErrCorrupt, "Footer keys are not sorted")
+		}
+		prevKey = key
+		keys[string(key)] = append(keys[string(key)], index)
+	}
+
+	if len(section) != 0 {
+		return nil, errs.Errorf(errs.ErrCorrupt, "Footer key section has %v extra bytes",
+			len(section))
+	}
+	return keys, nil
+}
@@ -0,0 +1,201 @@
+package blocks
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/go-errors/errors"
+	"github.com/overnest/strongsalt-common-go/tools"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// One writer can append to a list while readers of the same storage read
+// it, through a small side record that the writer replaces atomically:
+//
+//  1. The writer appends blocks. Readers ignore the bytes after the end
+//     offset in the record, so they never read a block that is only partly
+//     written.
+//  2. Commit syncs the storage, and then publishes the end of the last
+//     block in the record.
+//  3. Close writes the footer, syncs the storage, and then publishes the end
+//     of the footer, with Closed set. Until then, readers read a list with a
+//     footer as if it had none, so the footer is never read half written.
+//  4. Refresh reloads the record, so that the reader sees the blocks
+//     committed since it was created or last refreshed.
+
+// ListCommit is the state of a list published through a CommitRecord
+type ListCommit struct {
+	// EndOffset is the end of the committed part of the list, which is the
+	// end of the footer once the list is closed
+	EndOffset uint64
+	Blocks    uint32
+	Closed    bool
+}
+
+// CommitRecord holds the ListCommit of a list being written
+type CommitRecord interface {
+	// Store replaces the record. Readers must see either the old or the new
+	// commit, never a mix of both.
+	Store(commit ListCommit) error
+	// Load returns the last stored commit
+	Load() (ListCommit, error)
+}
+
+// The file commit record has the following format:
+// -----------------------------------------------------------
+// | magic(4) | endOffset(8) | blocks(4) | flags(4) | crc(4) |
+// -----------------------------------------------------------
+// The crc is the CRC-32 (Castagnoli) of the bytes before it.
+const (
+	commitRecordMagic  = uint32(0x424c4352) // "BLCR"
+	commitRecordLen    = 24
+	commitRecordClosed = uint32(1 << 0)
+)
+
+type fileCommitRecord struct {
+	path string
+}
+
+// NewFileCommitRecord returns a CommitRecord kept in the file at path. The
+// file is replaced by renaming a new file over it, which is atomic on POSIX
+// file systems.
+func NewFileCommitRecord(path string) CommitRecord {
+	return &fileCommitRecord{path}
+}
+
+func (r *fileCommitRecord) Store(commit ListCommit) error {
+	record := make([]byte, commitRecordLen)
+	binary.BigEndian.PutUint32(record, commitRecordMagic)
+	binary.BigEndian.PutUint64(record[4:], commit.EndOffset)
+	binary.BigEndian.PutUint32(record[12:], commit.Blocks)
+	if commit.Closed {
+		binary.BigEndian.PutUint32(record[16:], commitRecordClosed)
+	}
+	binary.BigEndian.PutUint32(record[20:], tools.CRC32C(record[:20]))
+
+	tmp, err := ioutil.TempFile(filepath.Dir(r.path), filepath.Base(r.path)+".tmp")
+	if err != nil {
+		return errs.Wrap(err, nil)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(record); err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return errs.Wrap(err, nil)
+	}
+	return errs.Wrap(os.Rename(tmp.Name(), r.path), nil)
+}
+
+func (r *fileCommitRecord) Load() (ListCommit, error) {
+	record, err := ioutil.ReadFile(r.path)
+	if os.IsNotExist(err) {
+		return ListCommit{}, errs.Errorf(errs.ErrNotFound, "The commit record %v does not exist", r.path)
+	}
+	if err != nil {
+		return ListCommit{}, errs.Wrap(err, nil)
+	}
+
+	if len(record) != commitRecordLen || binary.BigEndian.Uint32(record) != commitRecordMagic ||
+		binary.BigEndian.Uint32(record[20:]) != tools.CRC32C(record[:20]) {
+		return ListCommit{}, errs.Errorf(errs.ErrCorrupt, "The commit record %v is corrupted", r.path)
+	}
+	return ListCommit{
+		EndOffset: binary.BigEndian.Uint64(record[4:]),
+		Blocks:    binary.BigEndian.Uint32(record[12:]),
+		Closed:    binary.BigEndian.Uint32(record[16:])&commitRecordClosed != 0,
+	}, nil
+}
+
+// WithCommitRecord is a writer and reader option for lists that are read
+// while they are written. The writer publishes the committed part of the
+// list in the record when it is created, and on Commit and Close. The
+// reader reads the end offset from the record instead of its endOffset
+// argument, and Refresh picks up the blocks committed since. The list can
+// not be preallocated.
+func WithCommitRecord(record CommitRecord) BlockListOption {
+	return func(b *blockListV1) error {
+		b.commitRecord = record
+		return nil
+	}
+}
+
+// Commit makes the blocks written so far visible to the readers of the list
+// created WithCommitRecord
+func (b *blockListV1) Commit() error {
+	if b.writer == nil {
+		return errors.New("This is not a block list writer")
+	}
+	if b.commitRecord == nil {
+		return errors.New("The block list writer was not created WithCommitRecord")
+	}
+	if b.closed {
+		return errs.New(ErrSealed, "The block list writer is closed")
+	}
+	return b.publishCommit(false)
+}
+
+// publishCommit syncs the storage, and then stores the end of the written
+// part of the list in the commit record
+func (b *blockListV1) publishCommit(closed bool) error {
+	if err := b.syncStore(); err != nil {
+		return err
+	}
+	return b.commitRecord.Store(ListCommit{EndOffset: b.endOffset + uint64(b.footerLen),
+		Blocks: b.blocks, Closed: closed})
+}
+
+// loadCommit sets the end offset of a reader from the commit record
+func (b *blockListV1) loadCommit() error {
+	commit, err := b.commitRecord.Load()
+	if err != nil {
+		return err
+	}
+	if commit.EndOffset < b.initOffset || commit.EndOffset < b.committed.EndOffset {
+		return errs.Errorf(errs.ErrCorrupt, "The committed end offset(%v) is in front of "+
+			"the committed blocks", commit.EndOffset)
+	}
+
+	b.committed = commit
+	b.endOffset = commit.EndOffset
+	if !commit.Closed && b.hasFooter() {
+		// Read the list as if it had no footer until the footer is committed
+		b.flags &^= flagFooter
+		b.pendingFooter = true
+	}
+	if commit.Closed && b.pendingFooter {
+		b.flags |= flagFooter
+		b.pendingFooter = false
+		return b.readFooter()
+	}
+	return nil
+}
+
+// Refresh reloads the commit record of a list read WithCommitRecord, so that
+// the blocks committed since the reader was created can be read. Reads that
+// returned io.EOF at the end of the list can then continue.
+func (b *blockListV1) Refresh() error {
+	if b.commitRecord == nil {
+		return errors.New("The block list reader was not created WithCommitRecord")
+	}
+	if b.committed.Closed {
+		return nil
+	}
+
+	if err := b.loadCommit(); err != nil {
+		return err
+	}
+
+	// Drop what was read ahead, which may have been only partly written
+	if err := b.seek(b.curOffset); err != nil {
+		return err
+	}
+	b.eof = false
+	return nil
+}
@@ -0,0 +1,36 @@
+package blocks
+
+// Logger receives the diagnostics of a block list: the problems found in the
+// blocks it reads, and what it did about them. Without one, a list fails or
+// recovers silently.
+type Logger interface {
+	// Debugf logs what the list does to work around a problem, such as
+	// retrying a read
+	Debugf(format string, args ...interface{})
+	// Warnf logs a problem with the list, such as a block with the wrong ID,
+	// a block that fails authentication, or the part of a list that is
+	// dropped to recover it
+	Warnf(format string, args ...interface{})
+}
+
+// WithLogger is a writer and reader option that logs to logger the block ID
+// mismatches, failed block authentications, read retries and recovery
+// actions of the list
+func WithLogger(logger Logger) BlockListOption {
+	return func(b *blockListV1) error {
+		b.logger = logger
+		return nil
+	}
+}
+
+func (b *blockListV1) debugf(format string, args ...interface{}) {
+	if b.logger != nil {
+		b.logger.Debugf(format, args...)
+	}
+}
+
+func (b *blockListV1) warnf(format string, args ...interface{}) {
+	if b.logger != nil {
+		b.logger.Warnf(format, args...)
+	}
+}
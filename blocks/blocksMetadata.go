@@ -0,0 +1,77 @@
+package blocks
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// MaxMetadataLen is the biggest user metadata a list header can hold
+const MaxMetadataLen = 64 * 1024
+
+// WithMetadata is a writer option that stores a blob of application
+// metadata in the list header, such as the schema of the sort keys and the
+// codec of the block data, so that it is kept with the list instead of in a
+// separate file. Readers return it from GetMetadata. The list is written as
+// version 2.
+func WithMetadata(metadata []byte) BlockListOption {
+	return func(b *blockListV1) error {
+		if len(metadata) > MaxMetadataLen {
+			return errs.Errorf(errs.ErrTooLarge, "Block list metadata length(%v) is "+
+				"bigger than %v", len(metadata), MaxMetadataLen)
+		}
+		b.flags |= flagMetadata
+		b.metadata = append([]byte{}, metadata...)
+		return nil
+	}
+}
+
+// GetMetadata returns the user metadata of the list, or nil if it was not
+// written WithMetadata
+func (b *blockListV1) GetMetadata() []byte {
+	if b.flags&flagMetadata == 0 {
+		return nil
+	}
+	return append([]byte{}, b.metadata...)
+}
+
+// readMetadata reads the user metadata of the list from the list header
+func (b *blockListV1) readMetadata() error {
+	metadataLen := make([]byte, metadataLenLen)
+	if _, err := io.ReadFull(b.reader, metadataLen); err != nil {
+		return errs.WrapPrefix(err, errs.ErrTruncated, "Can not read block list metadata length")
+	}
+	n := binary.BigEndian.Uint32(metadataLen)
+	if n > MaxMetadataLen {
+		return errs.Errorf(errs.ErrCorrupt, "Block list metadata length(%v) is "+
+			"bigger than %v", n, MaxMetadataLen)
+	}
+
+	b.metadata = make([]byte, n)
+	if _, err := io.ReadFull(b.reader, b.metadata); err != nil {
+		return errs.WrapPrefix(err, errs.ErrTruncated, "Can not read block list metadata")
+	}
+	return nil
+}
+
+// WithListID is a writer option that stores the 16 byte ID of the list, such
+// as a UUID, in the list header. Readers return it from GetListID. The list
+// ID and a block ID identify a block across lists, for deriving per-block
+// nonces or for references between files. The list is written as version 2.
+func WithListID(id [16]byte) BlockListOption {
+	return func(b *blockListV1) error {
+		b.flags |= flagListID
+		b.listID = id
+		return nil
+	}
+}
+
+// GetListID returns the ID of the list, and whether it was written
+// WithListID
+func (b *blockListV1) GetListID() (id [16]byte, ok bool) {
+	if b.flags&flagListID == 0 {
+		return id, false
+	}
+	return b.listID, true
+}
@@ -0,0 +1,311 @@
+package blocks
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"io"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/overnest/strongsalt-common-go/tools"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// BlockListOption configures a block list reader or writer. Readers take the
+// format of the list from its header, so the writer options that set the
+// format, such as WithTimestamps or WithBackPointers, are ignored by readers.
+// Readers reject WithPreallocatedBlocks and WithExplicitIDs, which would
+// change how the blocks are read. Reader options are ignored by writers.
+type BlockListOption func(b *blockListV1) error
+
+// WithExplicitIDs is a writer option that allows WriteBlockDataWithID to
+// assign block IDs with gaps between them. The IDs must be increasing. The
+// list is written as version 2, with the ID of every block recorded in the
+// footer written by Close.
+func WithExplicitIDs() BlockListOption {
+	return func(b *blockListV1) error {
+		b.flags |= flagFooter
+		b.explicitIDs = true
+		return nil
+	}
+}
+
+// WithKeyIndex is a writer option that allows WriteBlockDataWithKeys to
+// record keys for each block. The key index is written to the footer by
+// Close, and LookupKey finds the blocks of a key. The list is written as
+// version 2.
+func WithKeyIndex() BlockListOption {
+	return func(b *blockListV1) error {
+		b.flags |= flagFooter
+		if b.keys == nil {
+			b.keys = make(map[string][]uint32)
+		}
+		return nil
+	}
+}
+
+// WithAEAD is a writer and reader option that encrypts and authenticates the
+// data of every block with the AEAD cipher, such as AES-GCM or
+// ChaCha20-Poly1305. Blocks stay randomly accessible, and a block is
+// authenticated before its data is returned. The nonce of a block is derived
+// from its ID, so a key must never be used for more than one list, and each
+// block of a preallocated list can only be written once. The list is written
+// as version 2.
+func WithAEAD(aead cipher.AEAD) BlockListOption {
+	return func(b *blockListV1) error {
+		if aead.NonceSize() < int(blockNumLen) {
+			return errors.Errorf("The AEAD nonce size(%v) can not hold a block ID",
+				aead.NonceSize())
+		}
+		b.flags |= flagAEAD
+		b.aead = aead
+		return nil
+	}
+}
+
+// WithAESGCM is WithAEAD using AES-GCM with the key, which must be 16, 24 or
+// 32 bytes long.
+func WithAESGCM(key []byte) BlockListOption {
+	return func(b *blockListV1) error {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return errs.Wrap(err, nil)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return errs.Wrap(err, nil)
+		}
+		return WithAEAD(aead)(b)
+	}
+}
+
+// WithPreallocatedBlocks is a writer option for padded lists that reserves
+// room for a number of blocks, which are written in any order with
+// WriteBlockDataAt, possibly by several goroutines. The storage must
+// implement io.WriterAt. Close records which blocks were written in the
+// footer, and readers refuse to read the blocks that were not. The list is
+// written as version 2.
+func WithPreallocatedBlocks(blocks uint32) BlockListOption {
+	return func(b *blockListV1) error {
+		b.flags |= flagFooter
+		b.preallocated = true
+		b.slots = blocks
+		b.filled = make([]byte, tools.CeilDiv(uint64(blocks), 8))
+		b.sealedSlots = make([]byte, len(b.filled))
+		return nil
+	}
+}
+
+// WithMaxBlockDataSize is a writer option for lists without padding that
+// limits the data of every block to size bytes. The limit is recorded in
+// the list header, and readers reject blocks that claim to be bigger, which
+// bounds the memory used to read a block. GetMaxDataSize returns the limit.
+// The list is written as version 2.
+func WithMaxBlockDataSize(size uint32) BlockListOption {
+	return func(b *blockListV1) error {
+		if size < MinBlockDataSize {
+			return errors.Errorf("Invalid maximum block data size(%v)", size)
+		}
+		b.flags |= flagMaxDataSize
+		b.maxDataSize = size
+		return nil
+	}
+}
+
+// WithMaxBlockSize is a writer and reader option that limits the size of a
+// block, padded block size included. Writers reject bigger blocks, and
+// accept blocks of any size without it. Readers only apply the limit to the
+// block sizes read from the storage that can not be checked against the end
+// of the list, and use DefaultMaxBlockSize without it.
+func WithMaxBlockSize(size uint32) BlockListOption {
+	return func(b *blockListV1) error {
+		if size == 0 {
+			return errors.New("The maximum block size must be bigger than 0")
+		}
+		b.maxBlockSize = size
+		return nil
+	}
+}
+
+// WithMaxTotalBytes is a writer option that limits the size of the list,
+// from the list header to the end of the last block. Writing a block that
+// would make the list bigger fails with ErrListFull, and writes nothing, so
+// that the caller can go on with a new list. The footer written by Close is
+// not counted.
+func WithMaxTotalBytes(size uint64) BlockListOption {
+	return func(b *blockListV1) error {
+		if size == 0 {
+			return errors.New("The maximum total bytes must be bigger than 0")
+		}
+		b.maxTotalBytes = size
+		return nil
+	}
+}
+
+// WithMaxTotalBlocks is a writer option that limits the number of blocks in
+// the list. Writing more blocks fails with ErrListFull.
+func WithMaxTotalBlocks(blocks uint32) BlockListOption {
+	return func(b *blockListV1) error {
+		if blocks == 0 {
+			return errors.New("The maximum total blocks must be bigger than 0")
+		}
+		b.maxTotalBlocks = blocks
+		return nil
+	}
+}
+
+// WithPageAlignment is a writer option for padded lists that aligns every
+// block to the storage pages, for direct I/O and memory mapped access. The
+// padded block size is rounded up to a multiple of the page size, and the
+// list header is padded so that the first block starts one or more pages
+// after the start of the list. The page size must be a power of 2. The list
+// is written as version 2.
+func WithPageAlignment(pageSize uint32) BlockListOption {
+	return func(b *blockListV1) error {
+		if !validPageSize(pageSize) {
+			return errors.Errorf("Invalid page size(%v)", pageSize)
+		}
+		b.flags |= flagPageAligned
+		b.pageSize = pageSize
+		return nil
+	}
+}
+
+// validPageSize shows whether a page size is a power of 2
+func validPageSize(pageSize uint32) bool {
+	return pageSize > 0 && pageSize&(pageSize-1) == 0
+}
+
+// WithFooter is a writer option that ends the list with a footer when the
+// writer is closed. The footer marks the end of the list, so a reader using
+// WithEndDiscovery can tell a complete list from a truncated one, or from one
+// followed by other data. The list is written as version 2.
+func WithFooter() BlockListOption {
+	return func(b *blockListV1) error {
+		b.flags |= flagFooter
+		return nil
+	}
+}
+
+// WithEndDiscovery is a reader option that finds the end of the list by
+// seeking to the end of the storage, instead of using the endOffset passed
+// to the reader. The list must be the last thing in the storage. If the list
+// has a footer, the reader fails unless the footer is found at the end.
+func WithEndDiscovery() BlockListOption {
+	return func(b *blockListV1) error {
+		b.discoverEnd = true
+		return nil
+	}
+}
+
+// WithMissingFooter is a reader option for recovering a list whose writer
+// never closed it, such as a preallocated list after a crash. If the list
+// should have a footer but it can not be read, the blocks up to the end
+// offset are read as if the list had no footer. MissingBlocks then finds the
+// blocks that were never written.
+func WithMissingFooter() BlockListOption {
+	return func(b *blockListV1) error {
+		b.missingFooter = true
+		return nil
+	}
+}
+
+// WithReadAhead is a reader option that reads the storage size bytes at a
+// time when reading blocks in order, as SearchLinear and ReadNextBlockData
+// do. This saves many small reads when the storage is slow to access, such
+// as network storage. Random access reads are not affected.
+func WithReadAhead(size int) BlockListOption {
+	return func(b *blockListV1) error {
+		if size < 0 {
+			return errors.Errorf("Invalid read ahead size(%v)", size)
+		}
+		b.readAhead = size
+		return nil
+	}
+}
+
+// WithRetry is a reader option that retries the random access reads of
+// blocks that fail, as the policy says. Remote storage such as object stores
+// often fails with transient errors. Reaching the end of the storage is not
+// retried.
+func WithRetry(policy tools.RetryPolicy) BlockListOption {
+	return func(b *blockListV1) error {
+		if policy.MaxAttempts < 1 {
+			return errors.Errorf("Invalid maximum retry attempts(%v)", policy.MaxAttempts)
+		}
+		b.retry = &policy
+		return nil
+	}
+}
+
+// WithStrictReads is a reader option that fails reads past the end of the
+// list. Once a forward read has returned io.EOF, the next forward read
+// returns an ErrReadAfterEOF error, until the read position is moved by
+// Reset, ResetToEnd or a backward read.
+func WithStrictReads() BlockListOption {
+	return func(b *blockListV1) error {
+		b.strictReads = true
+		return nil
+	}
+}
+
+// WithIDGaps is a reader option that accepts gaps between the IDs of
+// consecutive blocks, as long as the IDs are increasing. Lists written with
+// WithExplicitIDs are accepted without this option. It is the same as
+// WithIDPolicy(IDMonotonic).
+func WithIDGaps() BlockListOption {
+	return WithIDPolicy(IDMonotonic)
+}
+
+// WithBackPointers is a writer option that allows a list without padding to
+// be read backwards, by following each block with its size. The list is
+// written as version 2. Padded lists can always be read backwards, so the
+// option has no effect on them.
+func WithBackPointers() BlockListOption {
+	return func(b *blockListV1) error {
+		if !b.IsBlockPadded() {
+			b.flags |= flagBackPointers
+		}
+		return nil
+	}
+}
+
+// WithTimestamps is a writer option that records the creation time of each
+// block in its header, available from Block.GetTimestamp. The list is
+// written as version 2.
+func WithTimestamps() BlockListOption {
+	return func(b *blockListV1) error {
+		b.flags |= flagTimestamps
+		if b.now == nil {
+			b.now = time.Now
+		}
+		return nil
+	}
+}
+
+// WithSlowWriteThreshold is a writer option that calls fn with the ID of
+// each block whose write to the storage takes longer than threshold, and
+// how long the write took. It finds misbehaving storage without wrapping
+// the storage.
+func WithSlowWriteThreshold(threshold time.Duration, fn func(blockID uint32, d time.Duration)) BlockListOption {
+	return func(b *blockListV1) error {
+		b.slowWriteThreshold = threshold
+		b.slowWrite = fn
+		return nil
+	}
+}
+
+// WithPaddingRand is a writer option that reads the random padding of the
+// blocks of a padded list from r instead of crypto/rand, such as a fast
+// CSPRNG stream for writers of many blocks, or a deterministic source in
+// tests. r must be safe for concurrent use if the blocks of a preallocated
+// list are written concurrently.
+func WithPaddingRand(r io.Reader) BlockListOption {
+	return func(b *blockListV1) error {
+		if r == nil {
+			return errors.New("The padding random source can not be nil")
+		}
+		b.paddingRand = r
+		return nil
+	}
+}
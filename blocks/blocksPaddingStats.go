@@ -0,0 +1,62 @@
+package blocks
+
+// PaddingStats shows how much of what a writer wrote is block data, for
+// sizing the padded block size of a list. Every block write is counted,
+// including the rewrites of the blocks of a preallocated list.
+type PaddingStats struct {
+	// Blocks is the number of blocks written
+	Blocks uint64
+	// PayloadBytes is the size of the data of the blocks written
+	PayloadBytes uint64
+	// BlockBytes is the number of bytes written for the blocks, with their
+	// headers, padding and trailers
+	BlockBytes uint64
+	// BytesWritten is the number of bytes of the list, as BytesWritten
+	// returns them, which also counts the list header and footer
+	BytesWritten uint64
+	// MaxPayload is the size of the biggest block data written
+	MaxPayload uint32
+	// WasteHistogram counts the blocks by the share of their bytes that is
+	// not data. Bucket i counts the blocks that waste at least i*10% and
+	// less than (i+1)*10% of their bytes.
+	WasteHistogram [10]uint64
+}
+
+// Amplification returns the number of bytes of the list written per byte
+// of block data, or 0 if no data was written
+func (s PaddingStats) Amplification() float64 {
+	if s.PayloadBytes == 0 {
+		return 0
+	}
+	return float64(s.BytesWritten) / float64(s.PayloadBytes)
+}
+
+// PaddingStats returns the statistics of the block data and the padding
+// written so far
+func (b *blockListV1) PaddingStats() PaddingStats {
+	b.paddingStatsLock.Lock()
+	defer b.paddingStatsLock.Unlock()
+	stats := b.paddingStats
+	stats.BytesWritten = b.BytesWritten()
+	return stats
+}
+
+// recordPadding adds a block with payload bytes of data, written in
+// blockLen bytes, to the padding statistics
+func (b *blockListV1) recordPadding(payload, blockLen uint32) {
+	b.paddingStatsLock.Lock()
+	defer b.paddingStatsLock.Unlock()
+	stats := &b.paddingStats
+	stats.Blocks++
+	stats.PayloadBytes += uint64(payload)
+	stats.BlockBytes += uint64(blockLen)
+	if payload > stats.MaxPayload {
+		stats.MaxPayload = payload
+	}
+
+	bucket := 0
+	if blockLen > 0 && payload < blockLen {
+		bucket = int(uint64(blockLen-payload) * uint64(len(stats.WasteHistogram)) / uint64(blockLen))
+	}
+	stats.WasteHistogram[bucket]++
+}
@@ -0,0 +1,139 @@
+package blocks
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/go-errors/errors"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// FormatProfile is how the block headers of a list without padding encode
+// the ID and the size of each block
+type FormatProfile int
+
+const (
+	// BigEndianFixed encodes the block ID and size as 4 byte big endian
+	// integers, as every version of the format does
+	BigEndianFixed = FormatProfile(iota)
+	// VarintCompact encodes the block ID and size as unsigned varints, which
+	// saves up to 6 bytes per block on lists of many small blocks
+	VarintCompact
+)
+
+// WithFormatProfile is a writer option that encodes the block headers with
+// the profile. The VarintCompact profile is recorded in the list header,
+// and readers detect it from there. It can only be used by lists without
+// padding that are not encrypted WithAEAD, and the list is written as
+// version 2.
+func WithFormatProfile(profile FormatProfile) BlockListOption {
+	return func(b *blockListV1) error {
+		switch profile {
+		case BigEndianFixed:
+			b.flags &^= flagCompact
+		case VarintCompact:
+			b.flags |= flagCompact
+		default:
+			return errors.Errorf("Invalid format profile(%v)", profile)
+		}
+		return nil
+	}
+}
+
+// GetFormatProfile returns the profile of the block headers of the list
+func (b *blockListV1) GetFormatProfile() FormatProfile {
+	if b.flags&flagCompact != 0 {
+		return VarintCompact
+	}
+	return BigEndianFixed
+}
+
+// checkFormatProfile makes sure the list can use its format profile
+func (b *blockListV1) checkFormatProfile() error {
+	if b.flags&flagCompact != 0 && (b.IsBlockPadded() || b.flags&flagAEAD != 0) {
+		return errors.New("Only block lists without padding that are not encrypted " +
+			"can use the VarintCompact format profile")
+	}
+	return nil
+}
+
+// compactHeaderLen returns the size of the compact header of a block
+func (f blockFormat) compactHeaderLen(id, size uint32) uint32 {
+	var buf [binary.MaxVarintLen32]byte
+	hdrLen := uint32(binary.PutUvarint(buf[:], uint64(id)) + binary.PutUvarint(buf[:], uint64(size)))
+	if f.timestamps {
+		hdrLen += timestampLen
+	}
+	return hdrLen
+}
+
+// putCompactHeader serializes the compact header of a block into a buffer of
+// compactHeaderLen bytes
+func (f blockFormat) putCompactHeader(hdr []byte, id, size uint32, timestamp int64) {
+	n := binary.PutUvarint(hdr, uint64(id))
+	n += binary.PutUvarint(hdr[n:], uint64(size))
+	if f.timestamps {
+		binary.BigEndian.PutUint64(hdr[n:], uint64(timestamp))
+	}
+}
+
+// parseCompactHeader parses the compact header at the start of the bytes
+func (f blockFormat) parseCompactHeader(hdr []byte) (id, size uint32, timestamp int64,
+	hdrLen uint32, err error) {
+	var values [2]uint32
+	for i := range values {
+		value, n := binary.Uvarint(hdr[hdrLen:])
+		if n == 0 {
+			return 0, 0, 0, 0, errs.Errorf(errs.ErrTruncated, "Insufficient data size of %v",
+				len(hdr))
+		}
+		if n < 0 || value > math.MaxUint32 {
+			return 0, 0, 0, 0, errs.New(errs.ErrCorrupt, "Invalid compact block header")
+		}
+		values[i] = uint32(value)
+		hdrLen += uint32(n)
+	}
+
+	if f.timestamps {
+		if uint32(len(hdr)) < hdrLen+timestampLen {
+			return 0, 0, 0, 0, errs.Errorf(errs.ErrTruncated, "Insufficient data size of %v",
+				len(hdr))
+		}
+		timestamp = int64(binary.BigEndian.Uint64(hdr[hdrLen:]))
+		hdrLen += timestampLen
+	}
+	return values[0], values[1], timestamp, hdrLen, nil
+}
+
+// readCompactHeader reads the compact header of the next block, one byte at
+// a time, since its size is only known once it is read
+func (b *blockListV1) readCompactHeader(format blockFormat) ([]byte, error) {
+	hdr := make([]byte, 0, format.headerLen())
+	one := make([]byte, 1)
+	for varints, varintLen := 0, 0; varints < 2; {
+		if _, err := b.readFull(one); err != nil {
+			if err == io.EOF && len(hdr) > 0 {
+				return nil, errs.New(errs.ErrTruncated, "Can not read block header")
+			}
+			return nil, err
+		}
+		hdr = append(hdr, one[0])
+		varintLen++
+		if one[0] < 0x80 {
+			varints++
+			varintLen = 0
+		} else if varintLen >= binary.MaxVarintLen32 {
+			return nil, errs.New(errs.ErrCorrupt, "Invalid compact block header")
+		}
+	}
+
+	if format.timestamps {
+		timestamp := make([]byte, timestampLen)
+		if _, err := io.ReadFull(b.reader, timestamp); err != nil {
+			return nil, errs.WrapPrefix(err, errs.ErrTruncated, "Can not read block timestamp")
+		}
+		hdr = append(hdr, timestamp...)
+	}
+	return hdr, nil
+}
@@ -0,0 +1,128 @@
+package blocks
+
+import (
+	"io"
+	"math"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// BlockListReadWriterV1 is the interface of a padded block list that can be
+// read and appended to through the same handle
+type BlockListReadWriterV1 interface {
+	BlockListReaderV1
+	BlockListWriterV1
+}
+
+// offsetWriter turns an io.WriterAt into an io.Writer that writes from an offset
+type offsetWriter struct {
+	writerat io.WriterAt
+	offset   int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.writerat.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	return n, err
+}
+
+// rwStore is the storage seen by a read-write block list. Reads and writes
+// have their own positions.
+type rwStore struct {
+	*io.SectionReader
+	*offsetWriter
+}
+
+// OpenBlockListRW opens a padded block list for both reading and appending,
+// so that it can be searched and extended without reopening it. The storage
+// must implement io.ReaderAt and io.WriterAt, and nothing may follow the list
+// in the storage.
+//
+// If endOffset is not bigger than initOffset, a new list is created at
+// initOffset. Otherwise the existing list between the offsets is opened, and
+// paddedBlockSize must either be 0 or match the list.
+func OpenBlockListRW(store interface{}, paddedBlockSize uint32, initOffset, endOffset uint64,
+	initBlockData InitEmptyBlockData, opts ...BlockListOption) (BlockListReadWriterV1, error) {
+	readerat, ok := store.(io.ReaderAt)
+	if !ok {
+		return nil, errors.New("The storage must implement io.ReaderAt")
+	}
+	writerat, ok := store.(io.WriterAt)
+	if !ok {
+		return nil, errors.New("The storage must implement io.WriterAt")
+	}
+
+	rw := rwStore{io.NewSectionReader(readerat, 0, math.MaxInt64), nil}
+	if _, err := rw.Seek(int64(initOffset), io.SeekStart); err != nil {
+		return nil, errs.Wrap(err, nil)
+	}
+
+	// Create a new list
+	if endOffset <= initOffset {
+		if paddedBlockSize == 0 {
+			return nil, errors.New("A read-write block list must be padded")
+		}
+
+		rw.offsetWriter = &offsetWriter{writerat, int64(initOffset)}
+		w, err := NewBlockListWriterV1(rw, paddedBlockSize, initOffset, opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		b := w.(*blockListV1)
+		b.reader, b.seeker, b.readerat = rw, rw, rw
+		b.curOffset = b.initOffset
+		b.initDeserializedBlockData = initBlockData
+		if _, err = rw.Seek(int64(b.initOffset), io.SeekStart); err != nil {
+			return nil, errs.Wrap(err, nil)
+		}
+		return b, nil
+	}
+
+	// Open an existing list
+	r, err := NewBlockListReaderV1(rw, initOffset, endOffset, initBlockData, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	b := r.(*blockListV1)
+	if !b.IsBlockPadded() {
+		return nil, errors.New("A read-write block list must be padded")
+	}
+	if paddedBlockSize != 0 && paddedBlockSize != b.GetPaddedBlockSize() {
+		return nil, errors.Errorf("The padded block size(%v) does not match the "+
+			"padded block size(%v) of the block list", paddedBlockSize, b.GetPaddedBlockSize())
+	}
+	if err = b.checkJournal(); err != nil {
+		return nil, err
+	}
+
+	totalBlocks, err := b.GetTotalBlocks()
+	if err != nil {
+		return nil, err
+	}
+	b.blocks = totalBlocks
+	if totalBlocks > 0 {
+		if b.lastWritten, err = b.readBlockAt(totalBlocks - 1); err != nil {
+			return nil, err
+		}
+	}
+
+	// New blocks are written over the footer, which is rewritten by Close
+	rw.offsetWriter = &offsetWriter{writerat, int64(b.endOffset)}
+	b.writer = rw
+	b.writerat = writerat
+	b.store = rw
+	b.explicitIDs = b.blockIDs != nil
+	if b.pendingFooter {
+		// The list was not closed, so Close still has to write the footer
+		b.flags |= flagFooter
+		b.pendingFooter = false
+	}
+	if b.blockFormat().timestamps {
+		b.now = time.Now
+	}
+	return b, nil
+}
@@ -0,0 +1,58 @@
+package blocks
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// RepairFunc returns a good copy of the serialized padded block at index,
+// such as one fetched from a replica in another region, after the block read
+// from the storage failed with err
+type RepairFunc func(index uint32, err error) ([]byte, error)
+
+// WithRepair is a reader option for padded lists that calls repair when a
+// block read from the storage can not be deserialized, fails
+// authentication, or is not the block expected at its index. The read then
+// continues with the block repair returns, which must pass the same checks.
+// If writeBack is not nil, the repaired block is also written over the bad
+// one in the storage.
+func WithRepair(repair RepairFunc, writeBack io.WriterAt) BlockListOption {
+	return func(b *blockListV1) error {
+		b.repair = repair
+		b.repairWriter = writeBack
+		return nil
+	}
+}
+
+// repairBlock replaces the block at index, at offset in the storage, that
+// failed with cause by the copy from the repair function
+func (b *blockListV1) repairBlock(index uint32, offset uint64, cause error) (*blockV1, error) {
+	b.warnf("Repairing block %v: %v", index, cause)
+	blockBytes, err := b.repair(index, cause)
+	if err != nil {
+		return nil, errs.WrapPrefix(err, nil, fmt.Sprintf("Can not repair block %v, which "+
+			"failed with: %v", index, cause))
+	}
+	if uint32(len(blockBytes)) != b.GetPaddedBlockSize() {
+		return nil, errs.Errorf(errs.ErrCorrupt, "The repaired block size(%v) does not match "+
+			"the padded block size(%v)", len(blockBytes), b.GetPaddedBlockSize())
+	}
+
+	block, err := b.deserializeBlock(blockBytes)
+	if err != nil {
+		return nil, errs.WrapPrefix(err, nil, "The repaired block is not valid")
+	}
+	if err = b.checkIndexID(index, block.GetID()); err != nil {
+		return nil, errs.WrapPrefix(err, nil, "The repaired block is not valid")
+	}
+
+	if b.repairWriter != nil {
+		if _, err = b.repairWriter.WriteAt(blockBytes, int64(offset)); err != nil {
+			return nil, errs.WrapPrefix(err, nil, "Can not write the repaired block back")
+		}
+		b.debugf("Wrote the repaired block %v back at offset %v", index, offset)
+	}
+	return block, nil
+}
@@ -0,0 +1,93 @@
+package blocks
+
+import (
+	"math/rand"
+	"sort"
+
+	"github.com/go-errors/errors"
+)
+
+// Sample reads the data of n blocks of a padded list, chosen uniformly at
+// random without replacement, for approximate statistics that do not need
+// a full scan. The blocks are read in index order, and all of them are read
+// if the list has n blocks or fewer. The unwritten blocks of a preallocated
+// list are never chosen.
+func (b *blockListV1) Sample(n uint32, rng *rand.Rand) ([]interface{}, error) {
+	if rng == nil {
+		return nil, errors.New("Sampling needs a random number generator")
+	}
+
+	indexes, err := b.sampleCandidates()
+	if err != nil {
+		return nil, err
+	}
+
+	if n < uint32(len(indexes)) {
+		indexes = sampleIndexes(indexes, n, rng)
+	}
+
+	samples := make([]interface{}, 0, len(indexes))
+	for _, index := range indexes {
+		if err := b.checkContext(); err != nil {
+			return nil, err
+		}
+
+		blockData, _, err := b.ReadBlockDataAt(index)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, blockData)
+	}
+	return samples, nil
+}
+
+// sampleCandidates returns the indexes of the blocks Sample can choose from
+func (b *blockListV1) sampleCandidates() ([]uint32, error) {
+	if !b.IsBlockPadded() {
+		return nil, errors.New("The block list does not have padded fixed sized blocks. " +
+			"Can not sample blocks")
+	}
+
+	if b.preallocated {
+		b.filledLock.Lock()
+		defer b.filledLock.Unlock()
+		var indexes []uint32
+		for index := uint32(0); index < b.slots; index++ {
+			if b.filled[index/8]&(1<<(index%8)) != 0 {
+				indexes = append(indexes, index)
+			}
+		}
+		return indexes, nil
+	}
+
+	totalBlocks, err := b.GetTotalBlocks()
+	if err != nil {
+		return nil, err
+	}
+	indexes := make([]uint32, totalBlocks)
+	for i := range indexes {
+		indexes[i] = uint32(i)
+	}
+	return indexes, nil
+}
+
+// sampleIndexes chooses n of the indexes with Floyd's algorithm, and returns
+// them sorted
+func sampleIndexes(indexes []uint32, n uint32, rng *rand.Rand) []uint32 {
+	total := len(indexes)
+	chosen := make(map[int]bool, n)
+	for j := total - int(n); j < total; j++ {
+		if i := rng.Intn(j + 1); !chosen[i] {
+			chosen[i] = true
+		} else {
+			chosen[j] = true
+		}
+	}
+
+	sampled := make([]uint32, 0, n)
+	for i := range chosen {
+		sampled = append(sampled, indexes[i])
+	}
+	sort.Slice(sampled, func(i, j int) bool { return sampled[i] < sampled[j] })
+	return sampled
+}
@@ -0,0 +1,199 @@
+package blocks
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// ListExtent is where a block list is in storage that holds several lists
+// back to back
+type ListExtent struct {
+	// Offset is the offset of the list header, the initOffset of a reader
+	Offset uint64
+	// Length is the size of the whole list, footer included, so that
+	// Offset+Length is the endOffset of a reader
+	Length          uint64
+	Version         uint32
+	PaddedBlockSize uint32
+	// Blocks is the number of blocks, the unwritten blocks of a preallocated
+	// list included
+	Blocks uint32
+	Footer bool
+}
+
+// ScanLists finds the block lists stored back to back in the first size
+// bytes of the storage, so that each can be opened without keeping track of
+// their offsets elsewhere. Since a list header does not hold the length of
+// the list, the blocks of each list are followed until the bytes no longer
+// hold the next block, and then the footer is skipped if the list has one.
+// The options are passed to the list readers, such as WithAEAD for encrypted
+// lists.
+func ScanLists(ra io.ReaderAt, size int64, opts ...BlockListOption) ([]ListExtent, error) {
+	store := io.NewSectionReader(ra, 0, size)
+
+	var extents []ListExtent
+	for offset := uint64(0); offset < uint64(size); {
+		extent, err := scanList(store, offset, uint64(size), opts)
+		if err != nil {
+			return extents, errs.WrapPrefix(err, nil,
+				fmt.Sprintf("Can not scan the block list at offset %v", offset))
+		}
+		extents = append(extents, extent)
+		offset += extent.Length
+	}
+	return extents, nil
+}
+
+// scanList finds the extent of the list at the offset
+func scanList(store *io.SectionReader, offset, size uint64, opts []BlockListOption) (ListExtent, error) {
+	if _, err := store.Seek(int64(offset), io.SeekStart); err != nil {
+		return ListExtent{}, errs.Wrap(err, nil)
+	}
+
+	b := &blockListV1{version: BlockListV1, startOffset: offset, initOffset: offset,
+		curOffset: offset, store: store, reader: store, seeker: store}
+	if err := b.readListHeader(opts); err != nil {
+		return ListExtent{}, err
+	}
+
+	extent := ListExtent{Offset: offset, Version: b.GetVersion(),
+		PaddedBlockSize: b.GetPaddedBlockSize(), Footer: b.hasFooter()}
+	end := b.initOffset
+	prevID := int64(-1)
+	for {
+		blockLen, id, ok, err := b.scanBlock(end, size, prevID)
+		if err != nil {
+			return ListExtent{}, err
+		}
+		if !ok {
+			break
+		}
+		end += blockLen
+		extent.Blocks++
+		if id >= 0 {
+			prevID = id
+		}
+	}
+
+	if b.hasFooter() {
+		footerLen, err := scanFooter(store, end, size)
+		if err != nil {
+			return ListExtent{}, err
+		}
+		end += footerLen
+	}
+
+	extent.Length = end - offset
+	return extent, nil
+}
+
+// scanBlock shows whether the bytes at the offset hold the block after the
+// block with prevID. It returns the size of the block, and its ID, which is
+// -1 for the unwritten blocks of a preallocated list.
+func (b *blockListV1) scanBlock(offset, size uint64, prevID int64) (uint64, int64, bool, error) {
+	format := b.blockFormat()
+	hdrLen := uint64(format.headerLen())
+
+	var blockLen uint64
+	var blockBytes []byte
+	if b.IsBlockPadded() {
+		blockLen = uint64(b.GetPaddedBlockSize())
+		if offset+blockLen > size {
+			return 0, 0, false, nil
+		}
+		blockBytes = make([]byte, blockLen)
+		if err := b.readAt(blockBytes, offset); err != nil {
+			return 0, 0, false, err
+		}
+		// Preallocated lists leave zeros where blocks were not written
+		if b.hasFooter() && allZeros(blockBytes) {
+			return blockLen, -1, true, nil
+		}
+	} else {
+		readLen := hdrLen
+		if format.compact && offset+readLen > size {
+			// Compact block headers are shorter at the end of the storage
+			readLen = size - offset
+		}
+		if readLen == 0 || offset+readLen > size {
+			return 0, 0, false, nil
+		}
+		blockBytes = make([]byte, readLen)
+		if err := b.readAt(blockBytes, offset); err != nil {
+			return 0, 0, false, err
+		}
+	}
+
+	blockID, blockSize, _, blockHdrLen, err := format.parseHeader(blockBytes)
+	if err != nil {
+		return 0, 0, false, nil
+	}
+	id, dataLen, hdrLen := int64(blockID), uint64(blockSize), uint64(blockHdrLen)
+	if dataLen == 0 || !b.scanIDPolicy().follows(prevID, id) {
+		return 0, 0, false, nil
+	}
+
+	if b.IsBlockPadded() {
+		if hdrLen+dataLen > blockLen {
+			return 0, 0, false, nil
+		}
+		data := blockBytes[hdrLen : hdrLen+dataLen]
+		if format.tagLen == 0 && !json.Valid(data) {
+			return 0, 0, false, nil
+		}
+		return blockLen, id, true, nil
+	}
+
+	blockLen = hdrLen + dataLen + uint64(b.blockTrailerLen())
+	if offset+blockLen > size {
+		return 0, 0, false, nil
+	}
+
+	// The data of lists without padding is gzipped, unless it is encrypted
+	if format.tagLen == 0 {
+		magic := make([]byte, 2)
+		if err := b.readAt(magic, offset+hdrLen); err != nil {
+			return 0, 0, false, err
+		}
+		if magic[0] != 0x1f || magic[1] != 0x8b {
+			return 0, 0, false, nil
+		}
+	}
+	if b.hasBackPointers() {
+		backPointer := make([]byte, backPointerLen)
+		if err := b.readAt(backPointer, offset+blockLen-uint64(backPointerLen)); err != nil {
+			return 0, 0, false, err
+		}
+		if uint64(binary.BigEndian.Uint32(backPointer)) != blockLen {
+			return 0, 0, false, nil
+		}
+	}
+	return blockLen, id, true, nil
+}
+
+// scanFooter finds the length of the footer at the offset, by skipping its
+// sections until the trailer
+func scanFooter(store io.ReaderAt, offset, size uint64) (uint64, error) {
+	buf := make([]byte, footerTrailerLen)
+	for cur := offset; cur+uint64(footerTrailerLen) <= size; {
+		if err := readStoreAt(store, buf, cur); err != nil {
+			return 0, err
+		}
+
+		footerLen := uint64(binary.BigEndian.Uint32(buf))
+		if binary.BigEndian.Uint32(buf[footerLenLen:]) == footerMagic &&
+			footerLen == cur+uint64(footerTrailerLen)-offset {
+			return footerLen, nil
+		}
+
+		// Skip the section
+		cur += uint64(footerSectionHdrLen) + uint64(binary.BigEndian.Uint32(buf[4:]))
+	}
+
+	return 0, errs.Errorf(errs.ErrCorrupt, "The block list footer at offset %v is "+
+		"missing or corrupted", offset)
+}
@@ -0,0 +1,76 @@
+package blocks
+
+import (
+	"github.com/go-errors/errors"
+	"github.com/overnest/strongsalt-common-go/tools"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// ScanInto reads all the blocks of a padded list in order, and calls fn with
+// the data of each block. The blocks are read into buf, as many whole blocks
+// as fit at a time, and the data passed to fn is a slice of buf, so that a
+// full scan allocates nothing per block. The data is only valid until fn
+// returns. buf must hold at least one padded block. The blocks of lists
+// written WithAEAD are decrypted out of place, so they can not be scanned.
+// The blocks of a preallocated list that were never written are skipped.
+// An error returned by fn stops the scan, and is returned as it is.
+func (b *blockListV1) ScanInto(buf []byte, fn func(data []byte) error) error {
+	if !b.IsBlockPadded() {
+		return errors.New("The block list does not have padded fixed sized blocks. " +
+			"Can not scan into a buffer")
+	}
+	if b.readerat == nil {
+		return errors.New("The underlying storage is not capable " +
+			"of performing random access reads")
+	}
+	if b.aead != nil {
+		return errors.New("The blocks of a list written WithAEAD can not be scanned into a buffer")
+	}
+
+	blockSize := uint64(b.GetPaddedBlockSize())
+	perRead := uint64(len(buf)) / blockSize
+	if perRead == 0 {
+		return errs.Errorf(nil, "The buffer size(%v) is smaller than the padded block size(%v)",
+			len(buf), blockSize)
+	}
+
+	format := b.blockFormat()
+	index := uint32(0)
+	for offset := b.initOffset; !b.pastEnd(offset); {
+		n := tools.MinUint64(perRead, (b.endOffset-offset)/blockSize)
+		chunk := buf[:n*blockSize]
+		read, err := b.readerat.ReadAt(chunk, int64(offset))
+		if read != len(chunk) {
+			if err != nil {
+				return errs.Wrap(err, errs.ErrTruncated)
+			}
+			return errs.Errorf(errs.ErrTruncated, "Expecting %v bytes but only read %v",
+				len(chunk), read)
+		}
+
+		for blockBytes := chunk; len(blockBytes) > 0; blockBytes = blockBytes[blockSize:] {
+			blockIndex := index
+			index++
+			if b.preallocated && b.checkFilled(blockIndex) != nil {
+				continue
+			}
+
+			id, size, _, hdrLen, err := format.parseHeader(blockBytes[:blockSize])
+			if err != nil {
+				return err
+			}
+			if uint64(size)+uint64(hdrLen) > blockSize {
+				return errs.Errorf(errs.ErrCorrupt, "Block size(%v) is bigger than the data size(%v)",
+					uint64(size)+uint64(hdrLen), blockSize)
+			}
+			if err = b.checkIndexID(blockIndex, id); err != nil {
+				return err
+			}
+			if err = fn(blockBytes[hdrLen : hdrLen+size]); err != nil {
+				return err
+			}
+		}
+		offset += n * blockSize
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package blocks
+
+import (
+	"bytes"
+	"io"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/overnest/strongsalt-common-go/tools"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// KeyRangeExtractor returns the smallest and biggest keys of the block data
+type KeyRangeExtractor func(blockData interface{}) (minKey, maxKey []byte, err error)
+
+// KeyComparator creates a comparator for blocks of sorted keys, for
+// SearchLinear and SearchBinary. Keys are compared byte by byte, as
+// bytes.Compare does, and the value must be a []byte or a string. A value
+// between the smallest and biggest keys of a block, both included, is taken
+// to be in the block, so the caller still has to look for the key in the
+// returned block data.
+func KeyComparator(extract KeyRangeExtractor) BlockDataComparator {
+	return func(value interface{}, blockData interface{}) (int, error) {
+		var key []byte
+		switch v := value.(type) {
+		case []byte:
+			key = v
+		case string:
+			key = []byte(v)
+		default:
+			return 0, errors.Errorf("The value %T is not a []byte or string key", value)
+		}
+
+		minKey, maxKey, err := extract(blockData)
+		if err != nil {
+			return 0, err
+		}
+		if bytes.Compare(minKey, maxKey) > 0 {
+			return 0, errs.Errorf(errs.ErrCorrupt, "The smallest block key(%x) is bigger "+
+				"than the biggest block key(%x)", minKey, maxKey)
+		}
+
+		if bytes.Compare(key, minKey) < 0 {
+			return -1, nil
+		}
+		if bytes.Compare(key, maxKey) > 0 {
+			return 2, nil
+		}
+		return 1, nil
+	}
+}
+
+// SearchBinaryIndex is SearchBinary returning the index of the block that
+// has the value, instead of its data. If no block has the value, found is
+// false and the index is that of the block that would have it: the block
+// the comparator returned 0 for, or else the first block after the value,
+// which is the number of blocks if the value is after all of them.
+func (b *blockListV1) SearchBinaryIndex(value interface{}, comparator BlockDataComparator) (uint32, bool, error) {
+	if b.keyRange != nil {
+		index, found, _, _, err := b.searchInterpolationIndex(value, comparator)
+		return index, found, err
+	}
+
+	return b.searchBinaryIndex(func(index uint32) (int, error) {
+		blockData, _, err := b.ReadBlockDataAt(index)
+		if err != nil {
+			return 0, err
+		}
+		return comparator(value, blockData)
+	})
+}
+
+// searchBinaryIndex performs the binary search of SearchBinaryIndex, with
+// compare comparing the value with the block at an index
+func (b *blockListV1) searchBinaryIndex(compare func(index uint32) (int, error)) (uint32, bool, error) {
+	if b.readerat == nil {
+		return 0, false, errors.New("The underlying storage is not capable " +
+			"of performing random reads")
+	}
+
+	totalBlocks, err := b.GetTotalBlocks()
+	if err != nil {
+		return 0, false, err
+	}
+
+	left, right := uint32(0), totalBlocks
+	for left < right {
+		if err := b.checkContext(); err != nil {
+			return 0, false, err
+		}
+
+		mid := left + (right-left)/2
+		comp, err := compare(mid)
+		if err != nil {
+			return 0, false, errs.Wrap(err, nil)
+		}
+		switch {
+		case comp == 1:
+			return mid, true, nil
+		case comp == 0:
+			return mid, false, nil
+		case comp < 0:
+			right = mid
+		default:
+			left = mid + 1
+		}
+	}
+
+	return left, false, nil
+}
+
+// BlockHandle is a block found by SearchLinearHandle or SearchBinaryHandle.
+// The block data is only decompressed and deserialized when asked for, so a
+// caller that only needs to know that the block exists, or needs one field
+// of it, does not pay for deserializing the whole block.
+type BlockHandle interface {
+	// BlockIndex returns the index of the block in the block list
+	BlockIndex() uint32
+	// RawBytes returns the serialized block data, decompressed if the block
+	// list is not padded
+	RawBytes() ([]byte, error)
+	// Decode deserializes the block data into dst
+	Decode(dst interface{}) error
+}
+
+// BlockHandleComparator is a BlockDataComparator that gets a handle of the
+// block instead of the deserialized block data
+type BlockHandleComparator func(value interface{}, handle BlockHandle) (int, error)
+
+type blockHandle struct {
+	list  *blockListV1
+	index uint32
+	data  []byte
+	raw   []byte
+}
+
+func (b *blockListV1) newBlockHandle(index uint32, blk Block) (*blockHandle, error) {
+	if blk == nil || len(blk.GetData()) == 0 {
+		return nil, errors.New("invalid blockData")
+	}
+	return &blockHandle{list: b, index: index, data: blk.GetData()}, nil
+}
+
+func (h *blockHandle) BlockIndex() uint32 {
+	return h.index
+}
+
+func (h *blockHandle) RawBytes() ([]byte, error) {
+	if h.raw != nil {
+		return h.raw, nil
+	}
+	if h.list.IsBlockPadded() {
+		h.raw = h.data
+		return h.raw, nil
+	}
+
+	raw, err := gunzipBlockData(h.data)
+	if err != nil {
+		return nil, err
+	}
+	h.raw = raw
+	return h.raw, nil
+}
+
+func (h *blockHandle) Decode(dst interface{}) error {
+	raw, err := h.RawBytes()
+	if err != nil {
+		return err
+	}
+	return tools.Unmarshal(raw, dst)
+}
+
+// SearchLinearHandle is SearchLinear returning a handle of the found block.
+// The handle is nil if no block has the value.
+func (b *blockListV1) SearchLinearHandle(value interface{}, comparator BlockHandleComparator) (BlockHandle, error) {
+	if b.reader == nil {
+		return nil, errors.New("The underlying storage is not capable " +
+			"of performing reads")
+	}
+
+	if err := b.Reset(); err != nil {
+		return nil, err
+	}
+
+	for index := uint32(0); ; index++ {
+		if err := b.checkContext(); err != nil {
+			return nil, err
+		}
+
+		blk, err := b.readNextBlock()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, errs.Wrap(err, nil)
+		}
+
+		handle, err := b.newBlockHandle(index, blk)
+		if err != nil {
+			return nil, err
+		}
+		comp, err := comparator(value, handle)
+		if err != nil {
+			return nil, errs.Wrap(err, nil)
+		}
+		if comp == 1 {
+			return handle, nil
+		}
+	}
+}
+
+// SearchBinaryHandle is SearchBinary returning a handle of the found block.
+// The handle is nil if no block has the value.
+func (b *blockListV1) SearchBinaryHandle(value interface{}, comparator BlockHandleComparator) (BlockHandle, error) {
+	var found *blockHandle
+	_, ok, err := b.searchBinaryIndex(func(index uint32) (int, error) {
+		blk, err := b.readBlockAt(index)
+		if err != nil {
+			return 0, err
+		}
+		handle, err := b.newBlockHandle(index, blk)
+		if err != nil {
+			return 0, err
+		}
+		found = handle
+		return comparator(value, handle)
+	})
+	if err != nil || !ok {
+		return nil, err
+	}
+	return found, nil
+}
+
+// SearchByTime finds the first block of a padded list, whose blocks are in
+// creation time order, that was created at or after t. found is false if
+// every block was created before t.
+func (b *blockListV1) SearchByTime(t time.Time) (uint32, bool, error) {
+	if !b.blockFormat().timestamps {
+		return 0, false, errors.New("The block list does not have block timestamps")
+	}
+
+	totalBlocks, err := b.GetTotalBlocks()
+	if err != nil {
+		return 0, false, err
+	}
+
+	left, right := uint32(0), totalBlocks
+	for left < right {
+		if err := b.checkContext(); err != nil {
+			return 0, false, err
+		}
+
+		mid := left + (right-left)/2
+		block, err := b.readBlockAt(mid)
+		if err != nil {
+			return 0, false, err
+		}
+
+		if block.GetTimestamp().Before(t) {
+			left = mid + 1
+		} else {
+			right = mid
+		}
+	}
+
+	return left, left < totalBlocks, nil
+}
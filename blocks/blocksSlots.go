@@ -0,0 +1,163 @@
+package blocks
+
+import (
+	"github.com/go-errors/errors"
+	"github.com/overnest/strongsalt-common-go/tools"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// WriteBlockDataAt serializes blockData and writes it as the block at the
+// index of a list created with WithPreallocatedBlocks. The ID of the block
+// is its index. Blocks can be written in any order, and concurrently. The
+// blocks of a list written WithAEAD can only be written once, since their
+// nonce is derived from their ID.
+func (b *blockListV1) WriteBlockDataAt(index uint32, blockData interface{}) error {
+	if !b.preallocated || b.writerat == nil {
+		return errors.New("The block list writer was not created with WithPreallocatedBlocks")
+	}
+
+	if index >= b.slots {
+		return errors.Errorf("Block index(%v) is not in the preallocated block list, "+
+			"which has %v blocks", index, b.slots)
+	}
+
+	if err := b.checkContext(); err != nil {
+		return err
+	}
+
+	data, err := b.SerializeBlockData(blockData)
+	if err != nil {
+		return err
+	}
+
+	format := b.blockFormat()
+	blockv1 := newBlock(index, uint32(len(data)), data)
+	if format.timestamps {
+		blockv1.timestamp = b.now().UnixNano()
+	}
+	serialSize, err := blockv1.serializedSize(format)
+	if err != nil {
+		return errs.Wrap(err, nil)
+	}
+
+	serial := tools.DefaultBufferPool.Get(int(serialSize))
+	defer tools.DefaultBufferPool.Put(serial)
+	if err = blockv1.serializeTo(format, serial); err != nil {
+		return err
+	}
+
+	// Close records the written blocks, so it waits for the writes in
+	// progress, and the writes after it fail
+	b.slotWrites.RLock()
+	defer b.slotWrites.RUnlock()
+	if b.closed {
+		return errs.New(ErrSealed, "The block list writer is closed")
+	}
+	if format.tagLen > 0 {
+		if err = b.claimSealedSlot(index); err != nil {
+			return err
+		}
+		b.sealBlock(format, serial)
+	}
+
+	offset := b.initOffset + uint64(index)*uint64(b.GetPaddedBlockSize())
+	sw := tools.StartStopwatch()
+	n, err := b.writerat.WriteAt(serial, int64(offset))
+	b.checkSlowWrite(index, sw)
+	if err != nil {
+		return errs.Wrap(err, nil)
+	}
+	if n != len(serial) {
+		return errors.New("Can not write complete block to storage")
+	}
+
+	b.filledLock.Lock()
+	b.filled[index/8] |= 1 << (index % 8)
+	b.filledLock.Unlock()
+	b.recordPadding(blockv1.GetSize(), uint32(n))
+	return b.syncAfterWrite()
+}
+
+// claimSealedSlot marks the nonce of the block at index as used. It fails if
+// the block was already written, which would reuse the nonce.
+func (b *blockListV1) claimSealedSlot(index uint32) error {
+	b.filledLock.Lock()
+	defer b.filledLock.Unlock()
+	mask := byte(1 << (index % 8))
+	if b.sealedSlots[index/8]&mask != 0 {
+		return errors.Errorf("Block %v of the preallocated block list was already written, "+
+			"and can not be written again with the same AEAD nonce", index)
+	}
+	b.sealedSlots[index/8] |= mask
+	return nil
+}
+
+// checkFilled returns an error if the block at the index of a preallocated
+// list was never written
+func (b *blockListV1) checkFilled(index uint32) error {
+	if index >= b.slots {
+		return errs.Errorf(errs.ErrNotFound, "Block index(%v) is not in the preallocated "+
+			"block list, which has %v blocks", index, b.slots)
+	}
+
+	b.filledLock.Lock()
+	defer b.filledLock.Unlock()
+	if b.filled[index/8]&(1<<(index%8)) == 0 {
+		return errs.Errorf(errs.ErrNotFound, "Block %v of the preallocated block list "+
+			"was never written", index)
+	}
+	return nil
+}
+
+// MissingBlocks returns the indexes of the blocks of a padded list that were
+// never written. Those of a closed preallocated list come from its footer.
+// Otherwise, as for a list read WithMissingFooter, they are the blocks that
+// are all zeros, which is what storage returns for the gaps left by WriteAt.
+// Blocks after the end offset are not reported.
+func (b *blockListV1) MissingBlocks() ([]uint32, error) {
+	if !b.IsBlockPadded() {
+		return nil, errors.New("The block list does not have padded fixed sized blocks")
+	}
+
+	var missing []uint32
+	if b.preallocated {
+		b.filledLock.Lock()
+		defer b.filledLock.Unlock()
+		for index := uint32(0); index < b.slots; index++ {
+			if b.filled[index/8]&(1<<(index%8)) == 0 {
+				missing = append(missing, index)
+			}
+		}
+		return missing, nil
+	}
+
+	totalBlocks, err := b.GetTotalBlocks()
+	if err != nil {
+		return nil, err
+	}
+
+	blockBytes := make([]byte, b.GetPaddedBlockSize())
+	for index := uint32(0); index < totalBlocks; index++ {
+		if err := b.checkContext(); err != nil {
+			return nil, err
+		}
+
+		offset := b.initOffset + uint64(index)*uint64(b.GetPaddedBlockSize())
+		if err := b.readAt(blockBytes, offset); err != nil {
+			return nil, err
+		}
+		if allZeros(blockBytes) {
+			missing = append(missing, index)
+		}
+	}
+	return missing, nil
+}
+
+func allZeros(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,73 @@
+package blocks
+
+import (
+	"time"
+
+	"github.com/go-errors/errors"
+)
+
+// Without a sync policy, the writes of a list stay in the page cache until
+// the OS flushes them. The sync options sync the storage, when it has a Sync
+// method like os.File, so that a crash loses at most the blocks written
+// since the last sync. They can be combined: the storage is synced when any
+// of them says so.
+
+// WithSyncEveryNBlocks is a writer option that syncs the storage after every
+// n blocks written
+func WithSyncEveryNBlocks(n uint32) BlockListOption {
+	return func(b *blockListV1) error {
+		if n == 0 {
+			return errors.New("The number of blocks between syncs must be at least 1")
+		}
+		b.syncBlocks = n
+		return nil
+	}
+}
+
+// WithSyncEvery is a writer option that syncs the storage after a block
+// write, if the storage was last synced more than d ago. Writes are not
+// synced in the background, so the blocks written last are only synced by
+// the next write, or by Close WithSyncOnClose.
+func WithSyncEvery(d time.Duration) BlockListOption {
+	return func(b *blockListV1) error {
+		if d <= 0 {
+			return errors.Errorf("Invalid sync interval(%v)", d)
+		}
+		b.syncInterval = d
+		return nil
+	}
+}
+
+// WithSyncOnClose is a writer option that syncs the storage when the list is
+// closed, after the footer is written
+func WithSyncOnClose() BlockListOption {
+	return func(b *blockListV1) error {
+		b.syncOnClose = true
+		return nil
+	}
+}
+
+// syncAfterWrite syncs the storage after a block write, if the sync policy
+// of the list says so
+func (b *blockListV1) syncAfterWrite() error {
+	if b.syncBlocks == 0 && b.syncInterval == 0 {
+		return nil
+	}
+
+	// The blocks of a preallocated list are written concurrently
+	b.syncLock.Lock()
+	defer b.syncLock.Unlock()
+	b.unsynced++
+	if b.lastSync.IsZero() {
+		b.lastSync = time.Now()
+	}
+	if (b.syncBlocks > 0 && b.unsynced >= b.syncBlocks) ||
+		(b.syncInterval > 0 && time.Since(b.lastSync) >= b.syncInterval) {
+		if err := b.syncStore(); err != nil {
+			return err
+		}
+		b.unsynced = 0
+		b.lastSync = time.Now()
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package blocks
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/go-errors/errors"
+)
+
+// InitBlockDataOf returns an InitEmptyBlockData that creates a pointer to a
+// new value of the type of example, which can be a value or a pointer to
+// one. A reflect.Type is used as the type itself.
+func InitBlockDataOf(example interface{}) (InitEmptyBlockData, error) {
+	t, ok := example.(reflect.Type)
+	if !ok {
+		t = reflect.TypeOf(example)
+	}
+	if t == nil {
+		return nil, errors.New("The block data type can not be nil")
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return func() interface{} { return reflect.New(t).Interface() }, nil
+}
+
+// NewBlockListReaderV1Of creates a block list version 1 reader whose block
+// data is deserialized into new values of the type of example, as given to
+// InitBlockDataOf
+func NewBlockListReaderV1Of(store interface{}, initOffset, endOffset uint64, example interface{},
+	opts ...BlockListOption) (BlockListReaderV1, error) {
+	initEmptyBlkData, err := InitBlockDataOf(example)
+	if err != nil {
+		return nil, err
+	}
+	return NewBlockListReaderV1(store, initOffset, endOffset, initEmptyBlkData, opts...)
+}
+
+// NewRawBlockListReaderV1 creates a block list version 1 reader that does not
+// deserialize the block data. The data read is a json.RawMessage of the block
+// data as it was serialized, which can be written to another list with
+// WriteBlockData as it is.
+func NewRawBlockListReaderV1(store interface{}, initOffset, endOffset uint64,
+	opts ...BlockListOption) (BlockListReaderV1, error) {
+	reader, err := NewBlockListReaderV1(store, initOffset, endOffset, nil, opts...)
+	if err != nil {
+		return nil, err
+	}
+	reader.(*blockListV1).rawBlockData = true
+	return reader, nil
+}
+
+// copyRawBlockData returns a copy of the serialized block data
+func copyRawBlockData(data []byte) json.RawMessage {
+	raw := make(json.RawMessage, len(data))
+	copy(raw, data)
+	return raw
+}
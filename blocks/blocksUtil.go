@@ -0,0 +1,325 @@
+package blocks
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/go-errors/errors"
+	"github.com/overnest/strongsalt-common-go/tools"
+
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// DeriveFunc transforms the data of a source block for Derive. It returns
+// the data to write to the destination list, and whether to keep the block.
+type DeriveFunc func(blockData interface{}) (newBlockData interface{}, keep bool)
+
+// Derive streams every block of the source list through mapFn and writes the
+// kept blocks to the destination list, in the same order. The data is
+// re-serialized for the destination, so the two lists can have different
+// padded block sizes. Derive returns the number of blocks written. It does
+// not close the destination list.
+func Derive(src BlockListReaderV1, dst BlockListWriterV1, mapFn DeriveFunc) (uint32, error) {
+	if err := src.Reset(); err != nil {
+		return 0, err
+	}
+
+	written := uint32(0)
+	for {
+		if err := src.checkContext(); err != nil {
+			return written, err
+		}
+
+		blockData, _, err := src.ReadNextBlockData()
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+
+		newBlockData, keep := mapFn(blockData)
+		if !keep {
+			continue
+		}
+
+		if err = dst.WriteBlockData(newBlockData); err != nil {
+			return written, errs.WrapPrefix(err, nil, fmt.Sprintf(
+				"Can not write the block derived from source block %v", src.GetCurBlock().GetID()))
+		}
+		written++
+	}
+}
+
+// Concat appends the blocks of the source lists to the destination list, one
+// list after the other. The blocks are renumbered by the destination list,
+// and their data is copied without being deserialized. It is only gzipped
+// or gunzipped when the source and destination lists differ in padding, and
+// a block that does not fit the padded destination fails with a block
+// padding error. Concat returns the number of blocks written. It does not
+// close the destination list.
+func Concat(dst BlockListWriterV1, srcs ...BlockListReaderV1) (uint32, error) {
+	written := uint32(0)
+	for i, src := range srcs {
+		if err := src.Reset(); err != nil {
+			return written, err
+		}
+
+		for {
+			if err := src.checkContext(); err != nil {
+				return written, err
+			}
+
+			blk, err := src.readNextBlock()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return written, err
+			}
+
+			if err = copyRawBlock(src, dst, blk); err != nil {
+				return written, errs.WrapPrefix(err, nil, fmt.Sprintf("Source list %v", i))
+			}
+			written++
+		}
+	}
+	return written, nil
+}
+
+// CopyRaw copies the blocks of the source list from index from up to, but
+// not including, index to, to the end of the destination list. The data is
+// copied as in Concat, without being deserialized, and the blocks are
+// renumbered by the destination list. The blocks of a padded source are read
+// at random, which needs a storage with random access reads, and the blocks
+// of other sources are read from the first one. CopyRaw stops at the end of
+// the source, and returns the number of blocks written. It does not close
+// the destination list.
+func CopyRaw(src BlockListReaderV1, dst BlockListWriterV1, from, to uint32) (uint32, error) {
+	if from > to {
+		return 0, errors.Errorf("Invalid block range from %v to %v", from, to)
+	}
+
+	var next func() (Block, error)
+	if src.IsBlockPadded() {
+		index := from
+		next = func() (Block, error) {
+			blk, err := src.readBlockAt(index)
+			index++
+			return blk, err
+		}
+	} else {
+		if err := src.Reset(); err != nil {
+			return 0, err
+		}
+		for skipped := uint32(0); skipped < from; skipped++ {
+			if _, err := src.readNextBlock(); err == io.EOF {
+				return 0, nil
+			} else if err != nil {
+				return 0, err
+			}
+		}
+		next = src.readNextBlock
+	}
+
+	written := uint32(0)
+	for index := from; index < to; index++ {
+		if err := src.checkContext(); err != nil {
+			return written, err
+		}
+
+		blk, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return written, err
+		}
+
+		if err = copyRawBlock(src, dst, blk); err != nil {
+			return written, err
+		}
+		written++
+	}
+	return written, nil
+}
+
+// copyRawBlock writes the data of the source block to the destination list,
+// gzipping or gunzipping it when the lists differ in padding
+func copyRawBlock(src BlockListReaderV1, dst BlockListWriterV1, blk Block) error {
+	data := blk.GetData()
+	var err error
+	if src.IsBlockPadded() && !dst.IsBlockPadded() {
+		data, err = tools.Gzip(data)
+	} else if !src.IsBlockPadded() && dst.IsBlockPadded() {
+		data, err = gunzipBlockData(data)
+	}
+	if err != nil {
+		return errs.Wrap(err, errs.ErrCorrupt)
+	}
+
+	if _, err = dst.writeBlockDataBytes(data); err != nil {
+		return errs.WrapPrefix(err, nil, fmt.Sprintf("Can not write source block %v", blk.GetID()))
+	}
+	return nil
+}
+
+// Upgrade writes the blocks of the source list as a version 2 list into the
+// storage at initOffset, one block at a time, and closes it. The new list
+// has the padded block size of the source, a footer, and the features of the
+// options, such as WithTimestamps or WithAEAD. The block data is copied as
+// in Concat, without being deserialized. Upgrade returns the number of
+// blocks written.
+func Upgrade(src BlockListReaderV1, store interface{}, initOffset uint64,
+	opts ...BlockListOption) (uint32, error) {
+	dst, err := NewBlockListWriterV1(store, src.GetPaddedBlockSize(), initOffset,
+		append([]BlockListOption{WithFooter()}, opts...)...)
+	if err != nil {
+		return 0, err
+	}
+
+	written, err := Concat(dst, src)
+	if err != nil {
+		return written, err
+	}
+	return written, dst.Close()
+}
+
+// WriteAtomic creates a block list at path with the list built by build, so
+// that a crash leaves either the old file or the complete new one. The list
+// is written to a temporary file in the same directory, which is synced,
+// closed and renamed to path after build returns and the writer is closed.
+// A new file gets mode 0644, and a replaced file keeps its mode.
+func WriteAtomic(path string, paddedBlockSize uint32, build func(writer BlockListWriterV1) error,
+	opts ...BlockListOption) (err error) {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+
+	mode := os.FileMode(0644)
+	if stat, err := os.Stat(path); err == nil {
+		mode = stat.Mode().Perm()
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+base+".tmp")
+	if err != nil {
+		return errs.Wrap(err, nil)
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	writer, err := NewBlockListWriterV1(tmp, paddedBlockSize, 0, opts...)
+	if err != nil {
+		return err
+	}
+	if err = build(writer); err != nil {
+		return err
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+
+	if err = tmp.Chmod(mode); err != nil {
+		return errs.Wrap(err, nil)
+	}
+	if err = tmp.Sync(); err != nil {
+		return errs.Wrap(err, nil)
+	}
+	if err = tmp.Close(); err != nil {
+		return errs.Wrap(err, nil)
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return errs.Wrap(err, nil)
+	}
+
+	// Sync the directory so the rename survives a crash. Not every platform
+	// can sync a directory, so failing to do so is not an error.
+	if d, derr := os.Open(dir); derr == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// BlockDataSerializer serializes block data the way a padded list stores it
+type BlockDataSerializer func(blockData interface{}) ([]byte, error)
+
+// SuggestPadSize recommends a padded block size for blocks like the samples.
+// The samples are serialized with serialize, or as JSON like the padded list
+// writer does if serialize is nil. Options that change the block header,
+// such as WithTimestamps and WithAEAD, are taken into account.
+//
+// Every sample fits in the suggested size. Of the sizes that do, the
+// roundest one whose padding wastes at most targetWastePct percent of the
+// list is suggested: a power of 2 first, then a multiple of 64, then of 8,
+// and then the size of the biggest sample.
+// The waste of the suggested size is returned with it. If no size meets the
+// target, the smallest size that fits every sample is returned with an error.
+func SuggestPadSize(samples []interface{}, serialize BlockDataSerializer, targetWastePct float64,
+	opts ...BlockListOption) (uint32, float64, error) {
+	if len(samples) == 0 {
+		return 0, 0, errors.New("Can not suggest a padded block size without samples")
+	}
+	serializedSize := GetPredictedJSONSize
+	if serialize != nil {
+		serializedSize = func(sample interface{}) (int, error) {
+			data, err := serialize(sample)
+			return len(data), err
+		}
+	}
+
+	b := &blockListV1{}
+	if err := b.applyOptions(opts); err != nil {
+		return 0, 0, err
+	}
+	hdrLen := uint64(b.blockFormat().headerLen())
+
+	sizes := make([]uint64, len(samples))
+	maxSize := hdrLen + uint64(MinBlockDataSize)
+	for i, sample := range samples {
+		size, err := serializedSize(sample)
+		if err != nil {
+			return 0, 0, err
+		}
+		sizes[i] = hdrLen + uint64(size)
+		maxSize = tools.MaxUint64(maxSize, sizes[i])
+	}
+	if maxSize > uint64(b.maxWriteSize()) {
+		return 0, 0, errs.Errorf(errs.ErrTooLarge, "A sample block size(%v) is bigger than "+
+			"the maximum block size(%v)", maxSize, b.maxWriteSize())
+	}
+
+	waste := func(padSize uint64) float64 {
+		wasted := uint64(0)
+		for _, size := range sizes {
+			wasted += padSize - size
+		}
+		return float64(wasted) * 100 / float64(padSize*uint64(len(sizes)))
+	}
+
+	pow2 := uint64(1)
+	for pow2 < maxSize {
+		pow2 <<= 1
+	}
+	candidates := []uint64{pow2, tools.AlignUp(maxSize, 64), tools.AlignUp(maxSize, 8), maxSize}
+	for _, padSize := range candidates {
+		if padSize > uint64(b.maxWriteSize()) {
+			continue
+		}
+		if w := waste(padSize); w <= targetWastePct {
+			return uint32(padSize), w, nil
+		}
+	}
+
+	w := waste(maxSize)
+	return uint32(maxSize), w, errors.Errorf("The samples waste %.2f%% of a padded list "+
+		"even without rounding, which is more than the target of %.2f%%", w, targetWastePct)
+}
@@ -1,12 +1,20 @@
 package blocks
 
 import (
-	"crypto/rand"
+	"bufio"
+	"context"
+	"crypto/cipher"
 	"encoding/binary"
 	"io"
 	"math"
+	mrand "math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/overnest/strongsalt-common-go/tools"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
 
 	"github.com/go-errors/errors"
 )
@@ -29,8 +37,19 @@ type BlockListWriterV1 interface {
 	GetTotalBlocks() (uint32, error)
 	writeBlock(block Block) error
 	WriteBlockData(blockData interface{}) error
+	AppendBlockData(blockData interface{}) (Block, uint64, error)
+	WriteBlockDataWithID(id uint32, blockData interface{}) error
+	WriteBlockDataWithKeys(blockData interface{}, keys [][]byte) error
+	WriteBlockDataAt(index uint32, blockData interface{}) error
 	writeBlockDataBytes(data []byte) (Block, error)
 	SerializeBlockData(blockData interface{}) ([]byte, error)
+	BytesWritten() uint64
+	PaddingStats() PaddingStats
+	Close() error
+	Seal() error
+	IsSealed() bool
+	Commit() error
+	checkContext() error
 }
 
 // BlockListReaderV1 is the block list reader interface for version 1
@@ -42,32 +61,159 @@ type BlockListReaderV1 interface {
 	GetCurBlock() Block
 	readNextBlock() (Block, error)
 	ReadNextBlockData() (blockData interface{}, jsonSize int, err error)
+	EOF() bool
+	readPrevBlock() (Block, error)
+	ReadPrevBlockData() (blockData interface{}, jsonSize int, err error)
 	readBlockAt(index uint32) (Block, error)
 	ReadBlockDataAt(index uint32) (interface{}, int, error)
+	BlockExtent(index uint32) (offset, length uint64, err error)
+	DeserializeBlock(blockBytes []byte) (Block, error)
+	ScanInto(buf []byte, fn func(data []byte) error) error
+	GetBlockIndex(id uint32) (uint32, error)
+	ReadBlockDataByID(id uint32) (interface{}, int, error)
+	LookupKey(key []byte) ([]uint32, error)
+	SearchKey(key []byte) (blockIndex uint32, found bool, err error)
 	Reset() error
+	ResetToEnd() error
+	Iterate(direction IterateDirection, fn BlockDataIterFunc) error
+	Cursor(direction IterateDirection) *Cursor
+	BytesRemaining() uint64
 	SearchLinear(value interface{}, comparator BlockDataComparator) (interface{}, int, error)
 	SearchBinary(value interface{}, comparator BlockDataComparator) (interface{}, int, error)
+	SearchBinaryIndex(value interface{}, comparator BlockDataComparator) (blockIndex uint32, found bool, err error)
+	SearchLinearHandle(value interface{}, comparator BlockHandleComparator) (BlockHandle, error)
+	SearchBinaryHandle(value interface{}, comparator BlockHandleComparator) (BlockHandle, error)
+	SearchByTime(t time.Time) (index uint32, found bool, err error)
+	GetDescription() *ListDescription
+	GetMetadata() []byte
+	GetListID() (id [16]byte, ok bool)
+	GetFormatProfile() FormatProfile
+	MissingBlocks() ([]uint32, error)
+	Sample(n uint32, rng *mrand.Rand) ([]interface{}, error)
+	IsSealed() bool
+	Refresh() error
 	deserializeBlockData(data []byte) (interface{}, int, error)
+	checkContext() error
 }
 
 type blockListV1 struct {
 	version                   uint32
 	paddedBlockSize           uint32
 	curBlock                  Block
+	cursorNext                Block
+	lastWritten               Block
+	store                     interface{}
 	writer                    io.Writer
 	reader                    io.Reader
 	readerat                  io.ReaderAt
 	seeker                    io.Seeker
+	startOffset               uint64
 	initOffset                uint64
 	curOffset                 uint64
 	endOffset                 uint64
 	initDeserializedBlockData InitEmptyBlockData
+	rawBlockData              bool
+
+	// Version 2 features
+	flags       uint32
+	maxDataSize uint32
+	pageSize    uint32
+	explicitIDs bool
+	idPolicy    IDPolicy
+	discoverEnd bool
+	blockIDs    []uint32
+	keys        map[string][]uint32
+	blocks      uint32
+	footerLen   uint32
+	closed      bool
+	now         func() time.Time
+	aead        cipher.AEAD
+
+	description      *ListDescription
+	descriptionBytes []byte
+	metadata         []byte
+	listID           [listIDLen]byte
+
+	// Interpolation search of numeric keys
+	keyRange NumericKeyRange
+
+	// Key ranges of the blocks, built from the key index by SearchKey
+	keyRanges []blockKeyRange
+
+	readAhead    int
+	readAheadBuf *bufio.Reader
+	retry        *tools.RetryPolicy
+
+	// Caches the blocks read from slow storage
+	readCache CacheStore
+
+	// The read position is at the end after a forward read returned io.EOF
+	eof         bool
+	strictReads bool
+
+	// Read the list as if it had no footer when the footer can not be read
+	missingFooter bool
+
+	// Largest block accepted, or 0 for the defaults
+	maxBlockSize uint32
+
+	// Limits of the size of the list being written
+	maxTotalBytes  uint64
+	maxTotalBlocks uint32
+
+	// When the storage is synced
+	syncBlocks   uint32
+	syncInterval time.Duration
+	syncOnClose  bool
+	unsynced     uint32
+	lastSync     time.Time
+	syncLock     sync.Mutex
+
+	// Source of the random padding of blocks
+	paddingRand io.Reader
+
+	// Called when a block takes longer than the threshold to write
+	slowWriteThreshold time.Duration
+	slowWrite          func(blockID uint32, d time.Duration)
+
+	// Lists read while they are written
+	commitRecord  CommitRecord
+	committed     ListCommit
+	pendingFooter bool
+
+	// Records each block write of a padded list
+	journal WriteJournal
+
+	// Diagnostics of the list
+	logger Logger
+
+	// Replaces the padded blocks that fail to read
+	repair       RepairFunc
+	repairWriter io.WriterAt
+
+	// Block data and padding of the blocks written
+	paddingStats     PaddingStats
+	paddingStatsLock sync.Mutex
+
+	ctx context.Context
+
+	// Preallocated padded lists written in any order
+	writerat     io.WriterAt
+	preallocated bool
+	slots        uint32
+	filled       []byte
+	filledLock   sync.Mutex
+	// sealedSlots are the blocks whose nonce was used WithAEAD
+	sealedSlots []byte
+	// Held for reading by each block write, and for writing by Close
+	slotWrites sync.RWMutex
 }
 
 type blockV1 struct {
-	id   uint32
-	size uint32
-	data []byte
+	id        uint32
+	size      uint32
+	data      []byte
+	timestamp int64
 }
 
 const (
@@ -80,11 +226,29 @@ const (
 	blockHeaderLen = blockNumLen + blockSizeLen
 )
 
+// DefaultMaxBlockSize is the largest block a reader accepts when the size
+// read from the storage can not be checked against the end of the list. It
+// keeps a corrupted size field from causing a huge allocation.
+// WithMaxBlockSize changes it.
+const DefaultMaxBlockSize = uint32(64 * 1024 * 1024)
+
+// MinBlockDataSize is the least data a block of a padded list must be able
+// to hold. Writers reject padded block sizes that leave less room than this.
+var MinBlockDataSize = uint32(1)
+
+// MinPaddedBlockSize returns the smallest padded block size a writer accepts
+// for a list without version 2 features. Features such as timestamps make
+// the block header bigger, which raises the minimum.
+func MinPaddedBlockSize() uint32 {
+	return blockHeaderLen + MinBlockDataSize
+}
+
 // NewBlockListWriterV1 creates a block list version 1 writer
-func NewBlockListWriterV1(store interface{}, paddedBlockSize uint32, initOffset uint64) (BlockListWriterV1, error) {
+func NewBlockListWriterV1(store interface{}, paddedBlockSize uint32, initOffset uint64,
+	opts ...BlockListOption) (BlockListWriterV1, error) {
 	var ok bool
-	b := &blockListV1{BlockListV1, paddedBlockSize,
-		nil, nil, nil, nil, nil, initOffset, 0, 0, nil}
+	b := &blockListV1{version: BlockListV1, paddedBlockSize: paddedBlockSize,
+		startOffset: initOffset, initOffset: initOffset, store: store}
 
 	if b.writer, ok = store.(io.Writer); !ok {
 		return nil, errors.New("The storage must implement io.Writer")
@@ -97,42 +261,106 @@ func NewBlockListWriterV1(store interface{}, paddedBlockSize uint32, initOffset
 		}
 	}
 
-	version := make([]byte, versionLen)
-	binary.BigEndian.PutUint32(version, b.GetVersion())
-	padSize := make([]byte, padSizeLen)
-	binary.BigEndian.PutUint32(padSize, b.GetPaddedBlockSize())
+	if err := b.applyOptions(opts); err != nil {
+		return nil, err
+	}
 
-	n, err := b.writer.Write(version)
-	if err != nil {
-		return nil, errors.New(err)
+	if paddedBlockSize > b.maxWriteSize() {
+		return nil, errs.Errorf(errs.ErrTooLarge, "Padded block size(%v) is bigger than "+
+			"the maximum block size(%v)", paddedBlockSize, b.maxWriteSize())
 	}
-	if n != len(version) {
-		return nil, errors.New("Can not write version data to storage")
+
+	if b.pageSize > 0 {
+		if !b.IsBlockPadded() {
+			return nil, errors.New("Only padded block lists can be page aligned")
+		}
+		padSize := tools.AlignUp(uint64(paddedBlockSize), uint64(b.pageSize))
+		if padSize > uint64(b.maxWriteSize()) {
+			return nil, errs.Errorf(errs.ErrTooLarge, "Page aligned padded block size(%v) is "+
+				"bigger than the maximum block size(%v)", padSize, b.maxWriteSize())
+		}
+		b.paddedBlockSize = uint32(padSize)
+		paddedBlockSize = b.paddedBlockSize
+	}
+
+	if b.maxDataSize > 0 && b.IsBlockPadded() {
+		return nil, errors.New("The maximum block data size of a padded block list " +
+			"comes from its padded block size")
+	}
+
+	if b.preallocated {
+		if !b.IsBlockPadded() {
+			return nil, errors.New("Only padded block lists can be preallocated")
+		}
+		if b.commitRecord != nil {
+			return nil, errors.New("Preallocated block lists can not be read while " +
+				"they are written")
+		}
+		if b.writerat, ok = store.(io.WriterAt); !ok {
+			return nil, errors.New("A preallocated block list allows writes in any order, " +
+				"which requires the storage to implement io.WriterAt")
+		}
+	}
+
+	if err := b.checkJournal(); err != nil {
+		return nil, err
+	}
+
+	if err := b.checkFormatProfile(); err != nil {
+		return nil, err
+	}
+
+	if b.IsBlockPadded() {
+		format := b.blockFormat()
+		if minSize := format.headerLen() + MinBlockDataSize; paddedBlockSize < minSize {
+			return nil, NewPaddedBlockSizeError("The padded block size is too small "+
+				"for the block header and the minimum block data", paddedBlockSize, minSize)
+		}
+	}
+
+	if b.flags&flagDescription != 0 {
+		if err := b.serializeDescription(); err != nil {
+			return nil, err
+		}
 	}
 
-	n, err = b.writer.Write(padSize)
+	hdr := b.serializeListHeader()
+	n, err := b.writer.Write(hdr)
 	if err != nil {
-		return nil, errors.New(err)
+		return nil, errs.Wrap(err, nil)
 	}
-	if n != len(version) {
-		return nil, errors.New("Can not write padded block size data to storage")
+	if n != len(hdr) {
+		return nil, errors.New("Can not write block list header to storage")
 	}
 
-	b.initOffset += uint64((len(version) + len(padSize)))
+	b.initOffset += uint64(len(hdr))
 	b.curOffset = b.initOffset
 	b.endOffset = b.curOffset
+	if b.preallocated {
+		b.endOffset += uint64(b.slots) * uint64(paddedBlockSize)
+	}
+
+	if b.commitRecord != nil {
+		if err := b.publishCommit(false); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := b.journalStore(JournalCommitted, b.endOffset, 0); err != nil {
+		return nil, err
+	}
 
 	return b, nil
 }
 
-// NewBlockListReaderV1 creates a block list version 1 reader
-func NewBlockListReaderV1(store interface{}, initOffset, endOffset uint64, initEmptyBlkData InitEmptyBlockData) (BlockListReaderV1, error) {
+// NewBlockListReaderV1 creates a block list version 1 reader. It also reads
+// version 2 block lists.
+func NewBlockListReaderV1(store interface{}, initOffset, endOffset uint64,
+	initEmptyBlkData InitEmptyBlockData, opts ...BlockListOption) (BlockListReaderV1, error) {
 	var ok bool
-	b := &blockListV1{BlockListV1, 0, nil,
-		nil, nil, nil, nil,
-		initOffset, initOffset, endOffset,
-		initEmptyBlkData,
-	}
+	b := &blockListV1{version: BlockListV1, startOffset: initOffset, initOffset: initOffset,
+		curOffset: initOffset, endOffset: endOffset, store: store,
+		initDeserializedBlockData: initEmptyBlkData}
 
 	if b.reader, ok = store.(io.Reader); !ok {
 		return nil, errors.New("The storage must implement io.Reader")
@@ -142,42 +370,368 @@ func NewBlockListReaderV1(store interface{}, initOffset, endOffset uint64, initE
 		return nil, errors.New("The storage must implement io.Seeker")
 	}
 
+	if err := b.readListHeader(opts); err != nil {
+		return nil, err
+	}
+
+	if b.discoverEnd {
+		if err := b.discoverEndOffset(); err != nil {
+			return nil, err
+		}
+	}
+
+	if b.commitRecord != nil {
+		if err := b.loadCommit(); err != nil {
+			return nil, err
+		}
+	}
+
+	if b.journal != nil {
+		if err := b.recoverJournal(); err != nil {
+			return nil, err
+		}
+	}
+
+	if b.IsBlockPadded() && b.endOffset < 1 {
+		return nil, errors.New(`A padded block list allows random access, 
+			which requires the code to have and endOffset > 0`)
+	}
+
+	if b.hasFooter() {
+		if err := b.readFooter(); err != nil {
+			if !b.missingFooter {
+				return nil, err
+			}
+			b.warnf("Reading the block list without its footer, which can not be read: %v", err)
+			b.flags &^= flagFooter
+		}
+	}
+
+	if b.readAhead > 0 {
+		b.readAheadBuf = bufio.NewReaderSize(b.reader, b.readAhead)
+		b.reader = b.readAheadBuf
+	}
+
+	if b.retry != nil && b.readerat != nil {
+		b.readerat = &retryReaderAt{b, b.readerat}
+	}
+
+	if err := b.setupReadCache(); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// readListHeader reads the list header at the read position, applies the
+// reader options, and moves the read position to the first block
+func (b *blockListV1) readListHeader(opts []BlockListOption) error {
+	var ok bool
 	version := make([]byte, versionLen)
 	n, err := b.reader.Read(version)
 	if err != nil {
-		return nil, errors.New(err)
+		return errs.Wrap(err, nil)
 	}
 	if n != len(version) {
-		return nil, errors.New("Can not read version data from storage")
+		return errs.New(errs.ErrTruncated, "Can not read version data from storage")
 	}
 	b.version = binary.BigEndian.Uint32(version)
+	if b.version != BlockListV1 && b.version != BlockListV2 {
+		return errs.Errorf(errs.ErrUnsupportedVersion,
+			"Block list version %v is not supported", b.version)
+	}
 
 	paddedBlockSize := make([]byte, padSizeLen)
 	n, err = b.reader.Read(paddedBlockSize)
 	if err != nil {
-		return nil, errors.New(err)
+		return errs.Wrap(err, nil)
 	}
 	if n != len(paddedBlockSize) {
-		return nil, errors.New("Can not read padded block size data from storage")
+		return errs.New(errs.ErrTruncated, "Can not read padded block size data from storage")
 	}
 
 	b.paddedBlockSize = binary.BigEndian.Uint32(paddedBlockSize)
 	if b.IsBlockPadded() {
-		if b.readerat, ok = store.(io.ReaderAt); !ok {
-			return nil, errors.New(`A padded block list allows random access, 
+		if b.readerat, ok = b.store.(io.ReaderAt); !ok {
+			return errors.New(`A padded block list allows random access, 
 				which requires the storage to implement io.ReaderAt`)
 		}
+	}
+
+	if b.version >= BlockListV2 {
+		flags := make([]byte, flagsLen)
+		if _, err = io.ReadFull(b.reader, flags); err != nil {
+			return errs.WrapPrefix(err, errs.ErrTruncated, "Can not read block list flags")
+		}
+		b.flags = binary.BigEndian.Uint32(flags)
+	}
 
-		if endOffset < 1 {
-			return nil, errors.New(`A padded block list allows random access, 
-				which requires the code to have and endOffset > 0`)
+	if b.flags&flagMaxDataSize != 0 {
+		maxDataSize := make([]byte, maxDataSizeLen)
+		if _, err = io.ReadFull(b.reader, maxDataSize); err != nil {
+			return errs.WrapPrefix(err, errs.ErrTruncated, "Can not read maximum block data size")
+		}
+		b.maxDataSize = binary.BigEndian.Uint32(maxDataSize)
+		if b.maxDataSize < MinBlockDataSize {
+			return errs.Errorf(errs.ErrCorrupt, "Invalid maximum block data size(%v)", b.maxDataSize)
+		}
+	}
+
+	if b.flags&flagPageAligned != 0 {
+		pageSize := make([]byte, pageSizeLen)
+		if _, err = io.ReadFull(b.reader, pageSize); err != nil {
+			return errs.WrapPrefix(err, errs.ErrTruncated, "Can not read page size")
+		}
+		b.pageSize = binary.BigEndian.Uint32(pageSize)
+		if !validPageSize(b.pageSize) || !b.IsBlockPadded() || b.paddedBlockSize%b.pageSize != 0 {
+			return errs.Errorf(errs.ErrCorrupt, "Invalid page size(%v) for padded "+
+				"block size(%v)", b.pageSize, b.paddedBlockSize)
+		}
+	}
+
+	if b.flags&flagDescription != 0 {
+		if err = b.readDescription(); err != nil {
+			return err
+		}
+	}
+
+	if b.flags&flagMetadata != 0 {
+		if err = b.readMetadata(); err != nil {
+			return err
+		}
+	}
+
+	if b.flags&flagListID != 0 {
+		if _, err = io.ReadFull(b.reader, b.listID[:]); err != nil {
+			return errs.WrapPrefix(err, errs.ErrTruncated, "Can not read block list ID")
 		}
 	}
 
-	b.initOffset += uint64((len(version) + len(paddedBlockSize)))
+	// The format of an existing list comes from its header, not the options
+	flags, maxDataSize, pageSize, description := b.flags, b.maxDataSize, b.pageSize, b.description
+	metadata, listID, keys := b.metadata, b.listID, b.keys
+	if err := b.applyOptions(opts); err != nil {
+		return err
+	}
+	b.flags, b.maxDataSize, b.pageSize, b.description = flags, maxDataSize, pageSize, description
+	b.metadata, b.listID, b.keys = metadata, listID, keys
+	// The block slots and IDs of an existing list come from its footer
+	if b.preallocated || b.explicitIDs {
+		return errors.New("WithPreallocatedBlocks and WithExplicitIDs are writer options, " +
+			"which readers do not accept")
+	}
+	if b.flags&flagAEAD != 0 && b.aead == nil {
+		return errors.New("The block list is encrypted, which requires the WithAEAD option")
+	}
+	if err := b.checkFormatProfile(); err != nil {
+		return errs.Wrap(err, errs.ErrCorrupt)
+	}
+
+	b.initOffset += uint64(b.listHeaderLen())
 	b.curOffset = b.initOffset
 
-	return b, nil
+	// Skip the zeros that align the first block
+	if b.pageSize > 0 {
+		if err := b.seek(b.initOffset); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *blockListV1) applyOptions(opts []BlockListOption) error {
+	for _, opt := range opts {
+		if err := opt(b); err != nil {
+			return err
+		}
+	}
+
+	// Writers only use version 2 when a version 2 feature is turned on
+	if b.writer != nil && b.flags != 0 {
+		b.version = BlockListV2
+	}
+	return nil
+}
+
+// listHeaderLen returns the size of the list header for the list version
+func (b *blockListV1) listHeaderLen() uint32 {
+	if b.GetVersion() < BlockListV2 {
+		return blockListHeaderLen
+	}
+
+	hdrLen := blockListHeaderLen + flagsLen
+	if b.flags&flagMaxDataSize != 0 {
+		hdrLen += maxDataSizeLen
+	}
+	if b.flags&flagPageAligned != 0 {
+		hdrLen += pageSizeLen
+	}
+	if b.flags&flagDescription != 0 {
+		hdrLen += descriptionLenLen + uint32(len(b.descriptionBytes))
+	}
+	if b.flags&flagMetadata != 0 {
+		hdrLen += metadataLenLen + uint32(len(b.metadata))
+	}
+	if b.flags&flagListID != 0 {
+		hdrLen += listIDLen
+	}
+	if b.flags&flagPageAligned != 0 {
+		hdrLen = uint32(tools.AlignUp(uint64(hdrLen), uint64(b.pageSize)))
+	}
+	return hdrLen
+}
+
+func (b *blockListV1) serializeListHeader() []byte {
+	hdr := make([]byte, b.listHeaderLen())
+	binary.BigEndian.PutUint32(hdr, b.GetVersion())
+	binary.BigEndian.PutUint32(hdr[versionLen:], b.GetPaddedBlockSize())
+	if b.GetVersion() < BlockListV2 {
+		return hdr
+	}
+
+	offset := blockListHeaderLen
+	binary.BigEndian.PutUint32(hdr[offset:], b.flags)
+	offset += flagsLen
+	if b.flags&flagMaxDataSize != 0 {
+		binary.BigEndian.PutUint32(hdr[offset:], b.maxDataSize)
+		offset += maxDataSizeLen
+	}
+	if b.flags&flagPageAligned != 0 {
+		binary.BigEndian.PutUint32(hdr[offset:], b.pageSize)
+		offset += pageSizeLen
+	}
+	if b.flags&flagDescription != 0 {
+		binary.BigEndian.PutUint32(hdr[offset:], uint32(len(b.descriptionBytes)))
+		copy(hdr[offset+descriptionLenLen:], b.descriptionBytes)
+		offset += descriptionLenLen + uint32(len(b.descriptionBytes))
+	}
+	if b.flags&flagMetadata != 0 {
+		binary.BigEndian.PutUint32(hdr[offset:], uint32(len(b.metadata)))
+		copy(hdr[offset+metadataLenLen:], b.metadata)
+		offset += metadataLenLen + uint32(len(b.metadata))
+	}
+	if b.flags&flagListID != 0 {
+		copy(hdr[offset:], b.listID[:])
+	}
+	return hdr
+}
+
+func (b *blockListV1) hasFooter() bool {
+	return b.flags&flagFooter != 0
+}
+
+func (b *blockListV1) hasBackPointers() bool {
+	return b.flags&flagBackPointers != 0
+}
+
+// blockTrailerLen returns the number of bytes that follow each block
+func (b *blockListV1) blockTrailerLen() uint32 {
+	if b.hasBackPointers() {
+		return backPointerLen
+	}
+	return 0
+}
+
+// discoverEndOffset sets the end offset to the end of the storage, and puts
+// the storage back at its current position
+func (b *blockListV1) discoverEndOffset() error {
+	cur, err := b.seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return errs.Wrap(err, nil)
+	}
+	end, err := b.seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return errs.Wrap(err, nil)
+	}
+	if _, err = b.seeker.Seek(cur, io.SeekStart); err != nil {
+		return errs.Wrap(err, nil)
+	}
+
+	b.endOffset = uint64(end)
+	return nil
+}
+
+// readFooter reads the footer at the end of the list, and moves the end
+// offset to the end of the last block
+func (b *blockListV1) readFooter() error {
+	if b.endOffset < b.initOffset+uint64(footerTrailerLen) {
+		return errs.Errorf(errs.ErrTruncated, "The block list needs a footer, "+
+			"but the end offset(%v) leaves no room for it", b.endOffset)
+	}
+
+	trailer := make([]byte, footerTrailerLen)
+	if err := b.readAt(trailer, b.endOffset-uint64(footerTrailerLen)); err != nil {
+		return err
+	}
+	footerLen, err := parseFooterTrailer(trailer)
+	if err != nil {
+		return err
+	}
+	if uint64(footerLen) > b.endOffset-b.initOffset {
+		return errs.Errorf(errs.ErrCorrupt, "The footer length(%v) is bigger than "+
+			"the block list", footerLen)
+	}
+
+	footerBytes := make([]byte, footerLen)
+	if err := b.readAt(footerBytes, b.endOffset-uint64(footerLen)); err != nil {
+		return err
+	}
+	footer, err := deserializeFooter(footerBytes)
+	if err != nil {
+		return err
+	}
+
+	b.blockIDs = footer.blockIDs
+	b.keys = footer.keys
+	b.footerLen = footerLen
+	b.endOffset -= uint64(footerLen)
+
+	if footer.filled != nil {
+		if !b.IsBlockPadded() || b.endOffset-b.initOffset !=
+			uint64(footer.slots)*uint64(b.GetPaddedBlockSize()) {
+			return errs.Errorf(errs.ErrCorrupt, "The footer has %v block slots, "+
+				"which do not match the block list", footer.slots)
+		}
+		b.preallocated = true
+		b.slots = footer.slots
+		b.filled = footer.filled
+	}
+	return nil
+}
+
+// readAt reads len(p) bytes at an offset of the storage. Storage that
+// does not implement io.ReaderAt is put back at its current position.
+func (b *blockListV1) readAt(p []byte, offset uint64) error {
+	return readStoreAt(b.store, p, offset)
+}
+
+func readStoreAt(store interface{}, p []byte, offset uint64) error {
+	if readerat, ok := store.(io.ReaderAt); ok {
+		if _, err := readerat.ReadAt(p, int64(offset)); err != nil {
+			return errs.Wrap(err, errs.ErrTruncated)
+		}
+		return nil
+	}
+
+	seeker, ok := store.(io.ReadSeeker)
+	if !ok {
+		return errors.New("The storage must implement io.ReaderAt or io.ReadSeeker")
+	}
+	cur, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return errs.Wrap(err, nil)
+	}
+	if _, err = seeker.Seek(int64(offset), io.SeekStart); err != nil {
+		return errs.Wrap(err, nil)
+	}
+	if _, err = io.ReadFull(seeker, p); err != nil {
+		return errs.Wrap(err, errs.ErrTruncated)
+	}
+	if _, err = seeker.Seek(cur, io.SeekStart); err != nil {
+		return errs.Wrap(err, nil)
+	}
+	return nil
 }
 
 func (b *blockListV1) GetVersion() uint32 {
@@ -194,22 +748,25 @@ func (b *blockListV1) GetPaddedBlockSize() uint32 {
 
 func (b *blockListV1) GetMaxDataSize() uint32 {
 	if b.IsBlockPadded() {
-		return b.GetPaddedBlockSize() - 8
+		return b.blockFormat().maxDataSize()
 	}
 
+	if b.maxDataSize > 0 {
+		return b.maxDataSize
+	}
 	return math.MaxUint32
 }
 
 func (b *blockListV1) checkListValid() error {
 	if b.endOffset < b.initOffset {
-		return errors.Errorf("The initial offset(%v) of the block list is "+
+		return errs.Errorf(errs.ErrCorrupt, "The initial offset(%v) of the block list is "+
 			"bigger than the end offset(%v)", b.initOffset, b.endOffset)
 	}
 
 	if b.IsBlockPadded() {
 		blockBytes := b.endOffset - b.initOffset
 		if blockBytes%uint64(b.GetPaddedBlockSize()) > 0 {
-			return errors.Errorf("The number of block bytes(%v) does "+
+			return errs.Errorf(errs.ErrCorrupt, "The number of block bytes(%v) does "+
 				"not divide evenly by padded block size(%v).", blockBytes,
 				b.GetPaddedBlockSize())
 		}
@@ -229,14 +786,46 @@ func (b *blockListV1) GetTotalBlocks() (uint32, error) {
 	}
 
 	blockBytes := b.endOffset - b.initOffset
-	return uint32(blockBytes / uint64(b.GetPaddedBlockSize())), nil
+	blocks, err := tools.U64ToU32(blockBytes / uint64(b.GetPaddedBlockSize()))
+	if err != nil {
+		return 0, errs.WrapPrefix(err, nil, "The block list has too many blocks")
+	}
+	return blocks, nil
+}
+
+// blockIndexAt returns the index of the block at an offset of a padded list
+func (b *blockListV1) blockIndexAt(offset uint64) (uint32, error) {
+	index, err := tools.U64ToU32((offset - b.initOffset) / uint64(b.GetPaddedBlockSize()))
+	if err != nil {
+		return 0, errs.WrapPrefix(err, nil, "Block index is too large")
+	}
+	return index, nil
 }
 
 func (b *blockListV1) GetCurBlock() Block {
 	return b.curBlock
 }
 
+// EOF shows whether the last forward read returned io.EOF, and the read
+// position has not been moved since
+func (b *blockListV1) EOF() bool {
+	return b.eof
+}
+
 func (b *blockListV1) readNextBlock() (Block, error) {
+	if b.eof && b.strictReads {
+		return nil, errs.New(ErrReadAfterEOF, "The block list was read to the end. "+
+			"Reset the read position before reading again")
+	}
+
+	blk, err := b.nextBlock()
+	if err == io.EOF {
+		b.eof = true
+	}
+	return blk, err
+}
+
+func (b *blockListV1) nextBlock() (Block, error) {
 	if b.reader == nil {
 		return nil, errors.New("The underlying storage is not capable " +
 			"of performing reads")
@@ -245,62 +834,192 @@ func (b *blockListV1) readNextBlock() (Block, error) {
 	var err error
 	var blockBytes []byte
 
+	// Do not read the footer, or what follows the list in the storage, as a
+	// block. Lists without a footer may be read without an end offset.
+	if (b.hasFooter() || b.endOffset > 0) && b.pastEnd(b.curOffset) {
+		return nil, io.EOF
+	}
+
+	if b.preallocated {
+		var index uint32
+		if index, err = b.blockIndexAt(b.curOffset); err != nil {
+			return nil, err
+		}
+		if err = b.checkFilled(index); err != nil {
+			return nil, err
+		}
+	}
+
 	if b.IsBlockPadded() {
 		blockBytes = make([]byte, b.GetPaddedBlockSize())
-		if n, err = b.reader.Read(blockBytes); err != nil {
-			if err == io.EOF {
-				return nil, err
-			}
-			return nil, errors.New(err)
-		}
-		if n != len(blockBytes) {
-			return nil, errors.Errorf("Expecting %v bytes but read %v", len(blockBytes), n)
+		if n, err = b.readFull(blockBytes); err != nil {
+			return nil, err
 		}
 	} else {
-		hdr := make([]byte, blockHeaderLen)
-		if n, err = b.reader.Read(hdr); err != nil {
-			if err == io.EOF {
+		format := b.blockFormat()
+		var hdr []byte
+		if format.compact {
+			if hdr, err = b.readCompactHeader(format); err != nil {
+				return nil, err
+			}
+		} else {
+			hdr = make([]byte, format.headerLen())
+			if n, err = b.readFull(hdr); err != nil {
 				return nil, err
 			}
-			return nil, errors.New(err)
 		}
-		if n != len(hdr) {
-			return nil, errors.Errorf("Expecting %v bytes but read %v", len(hdr), n)
+
+		var blockSize uint32
+		if _, blockSize, _, _, err = format.parseHeader(hdr); err != nil {
+			return nil, err
+		}
+		if err = b.checkDataSize(blockSize); err != nil {
+			return nil, err
+		}
+		if err = b.checkBlockSize(uint64(blockSize) + uint64(len(hdr))); err != nil {
+			return nil, err
 		}
 
-		blockNum := binary.BigEndian.Uint32(hdr[:blockNumLen])
-		_ = blockNum // Not used
-		blockSize := binary.BigEndian.Uint32(hdr[blockNumLen:])
 		blockData := make([]byte, blockSize)
-		if n, err = b.reader.Read(blockData); err != nil {
-			if err == io.EOF {
-				return nil, err
-			}
-			return nil, errors.New(err)
-		}
-		if n != len(blockData) {
-			return nil, errors.Errorf("Expecting %v bytes but read %v", len(blockData), n)
+		if _, err = io.ReadFull(b.reader, blockData); err != nil {
+			return nil, errs.WrapPrefix(err, errs.ErrTruncated, "Can not read block data")
 		}
 
 		blockBytes = append(hdr, blockData...)
 		n = len(blockBytes)
+
+		if b.hasBackPointers() {
+			backPointer := make([]byte, backPointerLen)
+			if _, err = io.ReadFull(b.reader, backPointer); err != nil {
+				return nil, errs.WrapPrefix(err, errs.ErrTruncated, "Can not read block back pointer")
+			}
+			n += len(backPointer)
+			if binary.BigEndian.Uint32(backPointer) != uint32(n) {
+				return nil, errs.Errorf(errs.ErrCorrupt, "Block back pointer(%v) does not "+
+					"match the block size(%v)", binary.BigEndian.Uint32(backPointer), n)
+			}
+		}
 	}
 
-	blockv1, err := DeserializeBlockV1(b.GetPaddedBlockSize(), blockBytes)
+	blockv1, err := b.deserializeBlock(blockBytes)
+	if err == nil {
+		err = b.checkNextID(blockv1)
+	}
+	if err != nil && b.repair != nil && b.IsBlockPadded() {
+		index, ierr := b.blockIndexAt(b.curOffset)
+		if ierr != nil {
+			return nil, ierr
+		}
+		if blockv1, err = b.repairBlock(index, b.curOffset, err); err == nil {
+			err = b.checkNextID(blockv1)
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	if b.GetCurBlock() != nil {
-		if blockv1.GetID() != b.GetCurBlock().GetID()+1 {
-			return nil, errors.Errorf("The next block ID(%v) does not immediately follow "+
-				"the previous block ID(%v)", blockv1.GetID(), b.GetCurBlock().GetID())
+	b.curOffset += uint64(n)
+	b.curBlock = blockv1
+	b.cursorNext = nil
+	return blockv1, nil
+}
+
+// checkNextID checks that the ID of the block read after the current block
+// follows it
+func (b *blockListV1) checkNextID(next Block) error {
+	// After a reverse read, the next block is the one that was just read
+	if b.cursorNext == nil && b.GetCurBlock() != nil {
+		return b.checkIDOrder(b.GetCurBlock().GetID(), next.GetID())
+	}
+	return nil
+}
+
+// readFull reads len(p) bytes from the read position. It returns io.EOF if
+// the read position is at the end of the storage.
+func (b *blockListV1) readFull(p []byte) (int, error) {
+	n, err := io.ReadFull(b.reader, p)
+	if err == io.EOF {
+		return n, err
+	}
+	if err == io.ErrUnexpectedEOF {
+		return n, errs.Errorf(errs.ErrTruncated, "Expecting %v bytes but read %v", len(p), n)
+	}
+	if err != nil {
+		return n, errs.Wrap(err, nil)
+	}
+	return n, nil
+}
+
+// seek moves the read position to an offset of the storage, and drops any
+// data read ahead
+func (b *blockListV1) seek(offset uint64) error {
+	if _, err := b.seeker.Seek(int64(offset), io.SeekStart); err != nil {
+		return errs.Wrap(err, nil)
+	}
+	if b.readAheadBuf != nil {
+		b.readAheadBuf.Reset(b.store.(io.Reader))
+	}
+	return nil
+}
+
+// checkBlockSize makes sure a block of a list without padding, whose size
+// comes from the storage, fits in the rest of the list before it is read.
+// When the end of the list is unknown, the size is checked against the
+// maximum block size instead.
+func (b *blockListV1) checkBlockSize(size uint64) error {
+	// An end offset of 0 means the end of the list is unknown
+	if b.endOffset > 0 {
+		if b.curOffset+size > b.endOffset {
+			return errs.Errorf(errs.ErrCorrupt, "Block size(%v) is bigger than the "+
+				"remaining list size(%v)", size, b.BytesRemaining())
 		}
+		return nil
 	}
 
-	b.curOffset += uint64(len(blockBytes))
-	b.curBlock = blockv1
-	return blockv1, nil
+	if size > uint64(b.maxReadSize()) {
+		return errs.Errorf(errs.ErrCorrupt, "Block size(%v) is bigger than the "+
+			"maximum block size(%v)", size, b.maxReadSize())
+	}
+	return nil
+}
+
+// maxWriteSize returns the biggest block a writer accepts, which is the
+// size set WithMaxBlockSize, or else the biggest size a list can hold
+func (b *blockListV1) maxWriteSize() uint32 {
+	if b.maxBlockSize > 0 {
+		return b.maxBlockSize
+	}
+	return math.MaxUint32
+}
+
+// maxReadSize returns the biggest block a reader accepts when the size can
+// not be checked against the end of the list
+func (b *blockListV1) maxReadSize() uint32 {
+	if b.maxBlockSize > 0 {
+		return b.maxBlockSize
+	}
+	return DefaultMaxBlockSize
+}
+
+// pastEnd shows whether the block at the offset is after the end of the
+// list. A padded block that would end after the end offset is not part of
+// the list either, so that the data following the list in the storage is
+// never read as a block.
+func (b *blockListV1) pastEnd(offset uint64) bool {
+	if b.IsBlockPadded() {
+		return offset+uint64(b.GetPaddedBlockSize()) > b.endOffset
+	}
+	return offset >= b.endOffset
+}
+
+// checkDataSize makes sure the data of a block of a list without padding,
+// whose size comes from the storage, is not bigger than the list allows
+func (b *blockListV1) checkDataSize(size uint32) error {
+	if b.maxDataSize > 0 && size > b.maxDataSize {
+		return errs.Errorf(errs.ErrCorrupt, "Block data size(%v) is bigger than the "+
+			"maximum block data size(%v) of the list", size, b.maxDataSize)
+	}
+	return nil
 }
 
 // read next block, deserialize block data
@@ -320,6 +1039,43 @@ func (b *blockListV1) ReadNextBlockData() (interface{}, int, error) {
 	return deserialized, jsonSize, nil
 }
 
+// BlockExtent returns the byte range of the block at index in the storage of
+// a padded list, so that callers with their own mmap or HTTP range requests
+// can fetch the block themselves, and parse it with DeserializeBlock. It
+// returns io.EOF if the block is past the end of the list.
+func (b *blockListV1) BlockExtent(index uint32) (offset, length uint64, err error) {
+	if !b.IsBlockPadded() {
+		return 0, 0, errors.New("The block list does not have padded fixed sized blocks. " +
+			"Can not perform random access reads")
+	}
+
+	if b.preallocated {
+		if err := b.checkFilled(index); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	length = uint64(b.GetPaddedBlockSize())
+	offset = b.initOffset + length*uint64(index)
+	if b.pastEnd(offset) {
+		return 0, 0, io.EOF
+	}
+	return offset, length, nil
+}
+
+// DeserializeBlock parses the bytes of a block of the list, such as those
+// fetched from the range returned by BlockExtent. Unlike DeserializeBlockV1,
+// it parses the block formats of version 2 lists, such as the timestamps of
+// lists written WithTimestamps, and authenticates the blocks of lists
+// written WithAEAD.
+func (b *blockListV1) DeserializeBlock(blockBytes []byte) (Block, error) {
+	block, err := b.deserializeBlock(blockBytes)
+	if err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
 func (b *blockListV1) readBlockAt(index uint32) (Block, error) {
 	if !b.IsBlockPadded() {
 		return nil, errors.New("The block list does not have padded fixed sized blocks. " +
@@ -331,30 +1087,61 @@ func (b *blockListV1) readBlockAt(index uint32) (Block, error) {
 			"of performing random access reads")
 	}
 
-	blockBytes := make([]byte, b.GetPaddedBlockSize())
-	offset := b.initOffset + (uint64(b.GetPaddedBlockSize()) * uint64(index))
+	offset, length, err := b.BlockExtent(index)
+	if err != nil {
+		return nil, err
+	}
 
+	blockBytes := make([]byte, length)
 	n, err := b.readerat.ReadAt(blockBytes, int64(offset))
 	if err != nil {
 		if err == io.EOF {
 			return nil, err
 		}
-		return nil, errors.New(err)
+		return nil, errs.Wrap(err, nil)
 	}
 	if n != len(blockBytes) {
-		return nil, errors.Errorf("Expecting %v bytes but only read %v", len(blockBytes), n)
+		return nil, errs.Errorf(errs.ErrTruncated, "Expecting %v bytes but only read %v", len(blockBytes), n)
 	}
 
-	block, err := DeserializeBlockV1(b.GetPaddedBlockSize(), blockBytes)
+	block, err := b.deserializeBlock(blockBytes)
+	if err == nil {
+		err = b.checkIndexID(index, block.GetID())
+	}
+	if err != nil && b.repair != nil {
+		block, err = b.repairBlock(index, offset, err)
+	}
 	if err != nil {
 		return nil, err
 	}
-	if block.GetID() != index {
-		return nil, errors.Errorf("Block ID(%v) does not match the retrieval index(%v)",
-			block.GetID(), index)
+	return block, nil
+}
+
+// GetBlockIndex finds the index of the block with the given ID. Without
+// gaps between IDs, the index of a block is its ID.
+func (b *blockListV1) GetBlockIndex(id uint32) (uint32, error) {
+	if b.blockIDs == nil {
+		if b.idPolicy != IDStrict {
+			return 0, errors.New("The block list has gaps between block IDs, " +
+				"but no block ID index")
+		}
+		return id, nil
+	}
+
+	i := sort.Search(len(b.blockIDs), func(i int) bool { return b.blockIDs[i] >= id })
+	if i == len(b.blockIDs) || b.blockIDs[i] != id {
+		return 0, errors.Errorf("Block ID(%v) is not in the block list", id)
 	}
+	return uint32(i), nil
+}
 
-	return block, nil
+// ReadBlockDataByID reads and deserializes the block with the given ID
+func (b *blockListV1) ReadBlockDataByID(id uint32) (interface{}, int, error) {
+	index, err := b.GetBlockIndex(id)
+	if err != nil {
+		return nil, 0, err
+	}
+	return b.ReadBlockDataAt(index)
 }
 
 func (b *blockListV1) ReadBlockDataAt(index uint32) (interface{}, int, error) {
@@ -383,18 +1170,61 @@ func (b *blockListV1) WriteBlockData(blockData interface{}) error {
 	return err
 }
 
+// AppendBlockData is WriteBlockData returning the written block, and the
+// storage offset it was written at, so that callers can build their own
+// index of the block offsets without reading the list again
+func (b *blockListV1) AppendBlockData(blockData interface{}) (Block, uint64, error) {
+	dataBytes, err := b.SerializeBlockData(blockData)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	offset := b.endOffset
+	block, err := b.writeBlockDataBytes(dataBytes)
+	if err != nil {
+		return nil, 0, err
+	}
+	return block, offset, nil
+}
+
+// WriteBlockDataWithID serializes blockData and writes it as a block with
+// the given ID. The list must have been created with WithExplicitIDs, and
+// the ID must be bigger than the ID of the previous block.
+func (b *blockListV1) WriteBlockDataWithID(id uint32, blockData interface{}) error {
+	if !b.explicitIDs {
+		return errors.New("The block list writer was not created with WithExplicitIDs")
+	}
+
+	if last := b.lastWritten; last != nil && id <= last.GetID() {
+		return errors.Errorf("The block ID(%v) must be bigger than "+
+			"the previous block ID(%v)", id, last.GetID())
+	}
+
+	dataBytes, err := b.SerializeBlockData(blockData)
+	if err != nil {
+		return err
+	}
+	return b.writeBlock(newBlock(id, uint32(len(dataBytes)), dataBytes))
+}
+
 // write serialized blockData bytes
 func (b *blockListV1) writeBlockDataBytes(data []byte) (Block, error) {
-	block := &blockV1{0, uint32(len(data)), data}
+	block := newBlock(0, uint32(len(data)), data)
 
-	if b.GetCurBlock() != nil {
-		block.id = b.GetCurBlock().GetID() + 1
+	if b.lastWritten != nil {
+		block.id = b.lastWritten.GetID() + 1
 	}
 
 	err := b.writeBlock(block)
 	return block, err
 }
 
+// vectoredWriteMinSize is the smallest block data writeBlock writes without
+// copying it into the serialized block. net.Buffers writes it along with the
+// block header and the padding in one writev call on network connections,
+// and in three writes on other storage.
+const vectoredWriteMinSize = 64 * 1024
+
 func (b *blockListV1) writeBlock(block Block) error {
 	var blockv1 *blockV1
 	var ok bool
@@ -403,48 +1233,234 @@ func (b *blockListV1) writeBlock(block Block) error {
 		return errors.New("This is not a block list writer")
 	}
 
+	if b.closed {
+		return errs.New(ErrSealed, "The block list writer is closed")
+	}
+
+	if b.preallocated {
+		return errors.New("Blocks of a preallocated block list are written with WriteBlockDataAt")
+	}
+
+	if err := b.checkContext(); err != nil {
+		return err
+	}
+
 	if blockv1, ok = block.(*blockV1); !ok {
 		return errors.New("Version 1 block list can only accept version 1 blocks")
 	}
 
-	if b.GetCurBlock() != nil {
-		blockv1.id = b.GetCurBlock().GetID() + 1
+	if b.maxDataSize > 0 && blockv1.GetSize() > b.maxDataSize {
+		return errs.Errorf(errs.ErrTooLarge, "Block data size(%v) is bigger than the "+
+			"maximum block data size(%v)", blockv1.GetSize(), b.maxDataSize)
 	}
 
-	serial, err := blockv1.Serialize(b.GetPaddedBlockSize())
+	format := b.blockFormat()
+	if format.timestamps {
+		blockv1.timestamp = b.now().UnixNano()
+	}
+	serialSize, err := blockv1.serializedSize(format)
 	if err != nil {
-		return errors.New(err)
+		return errs.Wrap(err, nil)
+	}
+	if uint64(serialSize)+uint64(b.blockTrailerLen()) > uint64(b.maxWriteSize()) {
+		return errs.Errorf(errs.ErrTooLarge, "Block size(%v) is bigger than the "+
+			"maximum block size(%v)", serialSize, b.maxWriteSize())
+	}
+	if b.maxTotalBlocks > 0 && b.blocks >= b.maxTotalBlocks {
+		return errs.Errorf(ErrListFull, "The block list already has the maximum "+
+			"number of blocks(%v)", b.maxTotalBlocks)
+	}
+	if blockLen := uint64(serialSize) + uint64(b.blockTrailerLen()); b.maxTotalBytes > 0 &&
+		b.BytesWritten()+blockLen > b.maxTotalBytes {
+		return errs.Errorf(ErrListFull, "Block size(%v) would make the block list bigger "+
+			"than the maximum total bytes(%v)", blockLen, b.maxTotalBytes)
 	}
 
-	n, err := b.writer.Write(serial)
+	blockLen := int(serialSize + b.blockTrailerLen())
+	var parts net.Buffers
+	if format.tagLen > 0 || len(blockv1.GetData()) < vectoredWriteMinSize {
+		// The data is encrypted in place, or small enough that copying it is
+		// cheaper than more writes to the storage
+		serial := tools.DefaultBufferPool.Get(blockLen)
+		defer tools.DefaultBufferPool.Put(serial)
+		if err = blockv1.serializeTo(format, serial); err != nil {
+			return err
+		}
+		if format.tagLen > 0 {
+			b.sealBlock(format, serial[:serialSize])
+		}
+		parts = net.Buffers{serial}
+	} else {
+		// The data is written from the block between the block header and the
+		// padding, instead of being copied next to them
+		hdrLen := format.blockHeaderLen(blockv1.GetID(), uint32(len(blockv1.GetData())))
+		rest := tools.DefaultBufferPool.Get(blockLen - len(blockv1.GetData()))
+		defer tools.DefaultBufferPool.Put(rest)
+		blockv1.serializeHeader(format, rest[:hdrLen])
+		if err = format.pad(rest[hdrLen:]); err != nil {
+			return err
+		}
+		parts = net.Buffers{rest[:hdrLen], blockv1.GetData(), rest[hdrLen:]}
+	}
+	if b.hasBackPointers() {
+		last := parts[len(parts)-1]
+		binary.BigEndian.PutUint32(last[len(last)-int(backPointerLen):], uint32(blockLen))
+	}
+
+	if err := b.journalStore(JournalIntent, b.endOffset, blockv1.GetID()); err != nil {
+		return err
+	}
+
+	sw := tools.StartStopwatch()
+	n, err := parts.WriteTo(b.writer)
+	b.checkSlowWrite(blockv1.GetID(), sw)
 	if err != nil {
-		return errors.New(err)
+		return errs.Wrap(err, nil)
 	}
-	if n != len(serial) {
+	if n != int64(blockLen) {
 		return errors.New("Can not write complete block to storage")
 	}
+	if err := b.journalStore(JournalCommitted, b.endOffset+uint64(n), blockv1.GetID()); err != nil {
+		return err
+	}
 
-	b.curOffset += uint64(n)
-	b.endOffset = b.curOffset
-	b.curBlock = blockv1
+	b.endOffset += uint64(n)
+	b.lastWritten = blockv1
+	b.blocks++
+	b.recordPadding(blockv1.GetSize(), uint32(n))
+	if b.explicitIDs {
+		b.blockIDs = append(b.blockIDs, blockv1.GetID())
+	}
+
+	return b.syncAfterWrite()
+}
+
+// BytesWritten returns the number of bytes written to the storage, from the
+// block list header to the end of the last block, or to the end of the
+// footer once the list is closed
+func (b *blockListV1) BytesWritten() uint64 {
+	return b.endOffset - b.startOffset + uint64(b.footerLen)
+}
+
+// BytesRemaining returns the number of bytes from the read position to the
+// end of the block list, footer included
+func (b *blockListV1) BytesRemaining() uint64 {
+	listEndOffset := b.endOffset + uint64(b.footerLen)
+	if b.curOffset >= listEndOffset {
+		return 0
+	}
+	return listEndOffset - b.curOffset
+}
+
+// Close finishes writing the block list, writing the footer if the list
+// has one. It does not close the underlying storage.
+func (b *blockListV1) Close() error {
+	if b.writer == nil {
+		return errors.New("This is not a block list writer")
+	}
+
+	b.slotWrites.Lock()
+	defer b.slotWrites.Unlock()
+	if b.closed {
+		return nil
+	}
+
+	if b.hasFooter() {
+		footer := &blockListFooter{blockIDs: b.blockIDs, keys: b.keys}
+		if footer.blockIDs == nil && b.explicitIDs {
+			footer.blockIDs = []uint32{}
+		}
+		if b.preallocated {
+			footer.slots = b.slots
+			footer.filled = b.filled
+		}
+
+		serial := footer.serialize()
+		var n int
+		var err error
+		if b.preallocated {
+			n, err = b.writerat.WriteAt(serial, int64(b.endOffset))
+		} else {
+			n, err = b.writer.Write(serial)
+		}
+		if err != nil {
+			return errs.Wrap(err, nil)
+		}
+		if n != len(serial) {
+			return errors.New("Can not write block list footer to storage")
+		}
+		b.footerLen = uint32(n)
+	}
+
+	if b.syncOnClose {
+		if err := b.syncStore(); err != nil {
+			return err
+		}
+	}
+
+	if b.commitRecord != nil {
+		if err := b.publishCommit(true); err != nil {
+			return err
+		}
+	}
+
+	if err := b.journalStore(JournalClosed, b.endOffset+uint64(b.footerLen), 0); err != nil {
+		return err
+	}
 
+	b.closed = true
 	return nil
 }
 
+// Seal is Close for lists that must be known to be complete. The list must
+// have been created WithFooter, whose footer marks the end of the list.
+// Blocks written after the list is sealed fail with ErrSealed.
+func (b *blockListV1) Seal() error {
+	if b.writer == nil {
+		return errors.New("This is not a block list writer")
+	}
+	if !b.hasFooter() {
+		return errors.New("Only block lists created WithFooter can be sealed")
+	}
+	return b.Close()
+}
+
+// IsSealed shows whether the list ends with its footer. A list with a
+// footer that was not closed, such as one truncated by a crash, can only be
+// read WithMissingFooter, and is not sealed.
+func (b *blockListV1) IsSealed() bool {
+	return b.hasFooter() && b.footerLen > 0
+}
+
 func (b *blockListV1) Reset() error {
 	if b.seeker != nil {
-		_, err := b.seeker.Seek(int64(b.initOffset), io.SeekStart)
-		if err != nil {
-			return errors.New(err)
+		if err := b.seek(b.initOffset); err != nil {
+			return err
 		}
 		b.curBlock = nil
+		b.cursorNext = nil
 		b.curOffset = b.initOffset
+		b.eof = false
 		return nil
 	}
 
 	return errors.Errorf("Seeker interface not implemented. Can not reset")
 }
 
+// ResetToEnd moves the read position to the end of the list, so that the
+// list can be read backwards with ReadPrevBlockData
+func (b *blockListV1) ResetToEnd() error {
+	if err := b.Reset(); err != nil {
+		return err
+	}
+
+	if err := b.seek(b.endOffset); err != nil {
+		return err
+	}
+	b.curOffset = b.endOffset
+	return nil
+}
+
 func (b *blockListV1) SearchLinear(value interface{}, comparator BlockDataComparator) (interface{}, int, error) {
 	if b.reader == nil {
 		return nil, 0, errors.New("The underlying storage is not capable " +
@@ -457,18 +1473,22 @@ func (b *blockListV1) SearchLinear(value interface{}, comparator BlockDataCompar
 	}
 
 	for true {
+		if err := b.checkContext(); err != nil {
+			return nil, 0, err
+		}
+
 		blockData, jsonSize, err := b.ReadNextBlockData()
 		if err == io.EOF {
 			break
 		}
 
 		if err != nil {
-			return nil, 0, errors.New(err)
+			return nil, 0, errs.Wrap(err, nil)
 		}
 
 		comp, err := comparator(value, blockData)
 		if err != nil {
-			return nil, 0, errors.New(err)
+			return nil, 0, errs.Wrap(err, nil)
 		}
 		// Found
 		if comp == 1 {
@@ -481,6 +1501,14 @@ func (b *blockListV1) SearchLinear(value interface{}, comparator BlockDataCompar
 }
 
 func (b *blockListV1) SearchBinary(value interface{}, comparator BlockDataComparator) (interface{}, int, error) {
+	if b.keyRange != nil {
+		_, found, blockData, jsonSize, err := b.searchInterpolationIndex(value, comparator)
+		if err != nil || !found {
+			return nil, 0, err
+		}
+		return blockData, jsonSize, nil
+	}
+
 	if b.readerat == nil {
 		return nil, 0, errors.New("The underlying storage is not capable " +
 			"of performing random reads")
@@ -489,21 +1517,25 @@ func (b *blockListV1) SearchBinary(value interface{}, comparator BlockDataCompar
 	left := uint32(0)
 	right, err := b.GetTotalBlocks()
 	if err != nil {
-		return nil, 0, errors.New(err)
+		return nil, 0, errs.Wrap(err, nil)
 	}
 	right--
 
 	for true {
+		if err := b.checkContext(); err != nil {
+			return nil, 0, err
+		}
+
 		mid := (left + right) / 2
 
 		blockData, jsonSize, err := b.ReadBlockDataAt(mid)
 		if err != nil {
-			return nil, 0, errors.New(err)
+			return nil, 0, errs.Wrap(err, nil)
 		}
 
 		comp, err := comparator(value, blockData)
 		if err != nil {
-			return nil, 0, errors.New(err)
+			return nil, 0, errs.Wrap(err, nil)
 		}
 		// Found
 		if comp == 1 {
@@ -548,17 +1580,33 @@ func (b *blockListV1) SerializeBlockData(blockData interface{}) ([]byte, error)
 	return marshalledBytes, nil
 }
 
+// MaxGunzippedBlockDataSize is the biggest data a block of a list without
+// padding is uncompressed to. Bigger data is rejected with an
+// errs.ErrTooLarge error, so that a small malicious block can not exhaust the
+// memory.
+var MaxGunzippedBlockDataSize = int64(256 * 1024 * 1024)
+
+// gunzipBlockData uncompresses the data of a block of a list without
+// padding, up to MaxGunzippedBlockDataSize
+func gunzipBlockData(data []byte) ([]byte, error) {
+	return tools.GunzipLimit(data, MaxGunzippedBlockDataSize)
+}
+
 func (b *blockListV1) deserializeBlockData(data []byte) (interface{}, int, error) {
-	deserialized := b.initDeserializedBlockData()
 	uncompressedBytes := data
 	if !b.IsBlockPadded() {
 		var err error
-		uncompressedBytes, err = tools.Gunzip(data)
+		uncompressedBytes, err = gunzipBlockData(data)
 		if err != nil {
 			return nil, 0, err
 		}
 	}
 
+	if b.rawBlockData {
+		return copyRawBlockData(uncompressedBytes), len(uncompressedBytes), nil
+	}
+
+	deserialized := b.initDeserializedBlockData()
 	err := tools.Unmarshal(uncompressedBytes, deserialized)
 	if err != nil {
 		return nil, 0, err
@@ -567,7 +1615,7 @@ func (b *blockListV1) deserializeBlockData(data []byte) (interface{}, int, error
 }
 
 func newBlock(id, size uint32, data []byte) *blockV1 {
-	return &blockV1{id, size, data}
+	return &blockV1{id: id, size: size, data: data}
 }
 
 func (b *blockV1) GetID() uint32 {
@@ -582,66 +1630,103 @@ func (b *blockV1) GetData() []byte {
 	return b.data
 }
 
+// GetTimestamp returns the block creation time, or the zero time if the
+// block list does not have timestamps
+func (b *blockV1) GetTimestamp() time.Time {
+	if b.timestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, b.timestamp)
+}
+
 //	blockID(4bytes) + blockSize(4bytes) + blockData(blockSize bytes) + padding(optional)
 func (b *blockV1) Serialize(paddedBlockSize uint32) ([]byte, error) {
-	blockSize := uint32(len(b.GetData()))
-	totalSize := blockHeaderLen + blockSize
-	arrayBytes := totalSize
+	format := blockFormat{paddedBlockSize: paddedBlockSize}
+	serialSize, err := b.serializedSize(format)
+	if err != nil {
+		return nil, err
+	}
 
-	// Padding turned on
-	if paddedBlockSize > 0 {
-		arrayBytes = paddedBlockSize
+	serial := make([]byte, serialSize)
+	if err := b.serializeTo(format, serial); err != nil {
+		return nil, err
+	}
+	return serial, nil
+}
 
+// serializedSize returns the number of bytes the serialized block takes
+func (b *blockV1) serializedSize(format blockFormat) (uint32, error) {
+	hdrLen := format.blockHeaderLen(b.GetID(), uint32(len(b.GetData())))
+	totalSize := hdrLen + uint32(len(b.GetData()))
+
+	// Padding turned on
+	if paddedBlockSize := format.paddedBlockSize; paddedBlockSize > 0 {
 		// Each block can be at most "paddedBlockSize"
 		if totalSize > paddedBlockSize {
-			return nil, NewBlockPaddingError(
+			return 0, NewBlockPaddingError(
 				"Block too large to pad to a fixed size",
-				paddedBlockSize, totalSize, paddedBlockSize-8)
+				paddedBlockSize, totalSize, format.maxDataSize())
 		}
+		return paddedBlockSize, nil
 	}
 
-	serial := make([]byte, arrayBytes)
-	binary.BigEndian.PutUint32(serial[0:], b.GetID())
-	binary.BigEndian.PutUint32(serial[blockNumLen:], blockSize)
-	copy(serial[blockHeaderLen:], b.GetData())
+	return totalSize, nil
+}
 
-	// Padding turned on
-	if paddedBlockSize > 0 {
-		if _, err := rand.Read(serial[totalSize:]); err != nil {
-			return nil, errors.New(err)
-		}
-	}
+// serializeTo serializes the block into a buffer of serializedSize bytes
+func (b *blockV1) serializeTo(format blockFormat, serial []byte) error {
+	hdrLen := format.blockHeaderLen(b.GetID(), uint32(len(b.GetData())))
+	b.serializeHeader(format, serial[:hdrLen])
+	copy(serial[hdrLen:], b.GetData())
+	return format.pad(serial[hdrLen+uint32(len(b.GetData())):])
+}
 
-	return serial, nil
+// serializeHeader serializes the block header into a buffer of
+// blockHeaderLen bytes. The authentication tag of an encrypted block is set
+// by sealBlock.
+func (b *blockV1) serializeHeader(format blockFormat, hdr []byte) {
+	if format.compact {
+		format.putCompactHeader(hdr, b.GetID(), uint32(len(b.GetData())), b.timestamp)
+		return
+	}
+	binary.BigEndian.PutUint32(hdr[0:], b.GetID())
+	binary.BigEndian.PutUint32(hdr[blockNumLen:], uint32(len(b.GetData())))
+	if format.timestamps {
+		binary.BigEndian.PutUint64(hdr[blockHeaderLen:], uint64(b.timestamp))
+	}
 }
 
-func (b *blockV1) deserialize(paddedBlockSize uint32, dataBytes []byte) (*blockV1, error) {
+func (b *blockV1) deserialize(format blockFormat, dataBytes []byte) (*blockV1, error) {
 	totalSize := uint32(len(dataBytes))
-
-	if totalSize < blockHeaderLen {
-		return nil, errors.Errorf("Insufficient data size of %v", totalSize)
+	var hdrLen uint32
+	var err error
+	if b.id, b.size, b.timestamp, hdrLen, err = format.parseHeader(dataBytes); err != nil {
+		return nil, err
 	}
 
 	// Padding turned on
-	if paddedBlockSize > 0 && totalSize != paddedBlockSize {
-		return nil, errors.Errorf("Data size(%v) does not match padded block size(%v)",
+	if paddedBlockSize := format.paddedBlockSize; paddedBlockSize > 0 && totalSize != paddedBlockSize {
+		return nil, errs.Errorf(errs.ErrCorrupt, "Data size(%v) does not match padded block size(%v)",
 			totalSize, paddedBlockSize)
 	}
 
-	b.id = binary.BigEndian.Uint32(dataBytes[0:])
-	b.size = binary.BigEndian.Uint32(dataBytes[blockNumLen:])
-
-	if b.size+blockHeaderLen > totalSize {
-		return nil, errors.Errorf("Block size(%v) is bigger than the data size(%v)",
-			b.size+8, totalSize)
+	if uint64(b.size)+uint64(hdrLen) > uint64(totalSize) {
+		return nil, errs.Errorf(errs.ErrCorrupt, "Block size(%v) is bigger than the data size(%v)",
+			uint64(b.size)+uint64(hdrLen), totalSize)
 	}
 
-	b.data = dataBytes[blockHeaderLen : blockHeaderLen+b.size]
+	b.data = dataBytes[hdrLen : hdrLen+b.size]
 	return b, nil
 }
 
-// DeserializeBlockV1 deserializes V1 block
+// DeserializeBlockV1 deserializes V1 block. Blocks of lists with version 2
+// block formats, such as lists written WithTimestamps, are parsed with the
+// DeserializeBlock of the list reader instead.
 func DeserializeBlockV1(paddedBlockSize uint32, dataBytes []byte) (Block, error) {
+	return deserializeBlock(blockFormat{paddedBlockSize: paddedBlockSize}, dataBytes)
+}
+
+func deserializeBlock(format blockFormat, dataBytes []byte) (*blockV1, error) {
 	block := &blockV1{}
-	return block.deserialize(paddedBlockSize, dataBytes)
+	return block.deserialize(format, dataBytes)
 }
@@ -0,0 +1,385 @@
+package blocks
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+
+	"github.com/overnest/strongsalt-common-go/tools"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+//
+// Block list version 2 keeps the version 1 layout and adds a flags field to
+// the list header. Each flag turns on an optional feature of the list:
+// ---------------------------------------------------------------------
+// | version(4) | padSize(4) | flags(4) | blocks ... | footer(optional) |
+// ---------------------------------------------------------------------
+// A writer only produces version 2 when one of its options needs a version 2
+// feature. Otherwise it keeps writing version 1, which older readers parse.
+//
+// When flagBackPointers is set, every block of a list without padding is
+// followed by a back pointer holding the size of the whole block, back
+// pointer included. This allows the list to be read backwards:
+// ---------------------------------------------------------------------
+// | blockID(4) | blockSize(4) | blockData(blockSize) | backPointer(4) |
+// ---------------------------------------------------------------------
+// Padded lists do not need back pointers, since every block has the same size.
+//
+// When flagTimestamps is set, every block header holds the block creation
+// time, in nanoseconds since the Unix epoch:
+// ---------------------------------------------------------------------
+// | blockID(4) | blockSize(4) | timestamp(8) | blockData(blockSize) ... |
+// ---------------------------------------------------------------------
+//
+// When flagAEAD is set, the data of every block is encrypted with an AEAD
+// cipher, and the authentication tag is kept in the block header. The nonce
+// is derived from the block ID, and the rest of the block header is
+// authenticated along with the data:
+// ---------------------------------------------------------------------
+// | blockID(4) | blockSize(4) | timestamp(8, optional) | tag | data ... |
+// ---------------------------------------------------------------------
+// blockSize is the size of the encrypted data, which is the size of the
+// plaintext data.
+//
+// When flagMaxDataSize is set, the list header is followed by the most data
+// a block of a list without padding can hold. Readers reject blocks that
+// claim to be bigger before reading them:
+// ---------------------------------------------------------------------
+// | version(4) | padSize(4) | flags(4) | maxDataSize(4) | blocks ... |
+// ---------------------------------------------------------------------
+//
+// When flagPageAligned is set, the padded block size is a multiple of the
+// storage page size, which follows the other list header fields. The list
+// header is padded with zeros to a multiple of the page size, so that every
+// block starts on a page boundary relative to the start of the list:
+// ---------------------------------------------------------------------
+// | version(4) | padSize(4) | flags(4) | pageSize(4) | zeros | blocks ... |
+// ---------------------------------------------------------------------
+//
+// When flagDescription is set, a description of the list follows the other
+// list header fields, before the zeros of a page aligned list. It is the
+// JSON serialized ListDescription:
+// ---------------------------------------------------------------------
+// | version(4) | padSize(4) | flags(4) | descLen(4) | desc(descLen) | blocks ... |
+// ---------------------------------------------------------------------
+//
+// When flagMetadata is set, a blob of user metadata follows the description,
+// before the zeros of a page aligned list:
+// ---------------------------------------------------------------------
+// | version(4) | padSize(4) | flags(4) | metaLen(4) | meta(metaLen) | blocks ... |
+// ---------------------------------------------------------------------
+//
+// When flagListID is set, the 16 byte ID of the list, such as a UUID,
+// follows the metadata, before the zeros of a page aligned list:
+// ---------------------------------------------------------------------
+// | version(4) | padSize(4) | flags(4) | listID(16) | blocks ... |
+// ---------------------------------------------------------------------
+//
+// When flagCompact is set, the block ID and size of each block header are
+// unsigned varints instead of 4 byte integers. Only lists without padding
+// that are not encrypted can be compact:
+// ---------------------------------------------------------------------
+// | blockID(1-5) | blockSize(1-5) | timestamp(8, optional) | blockData ... |
+// ---------------------------------------------------------------------
+//
+// When flagFooter is set, the writer appends a footer when it is closed.
+// The footer is a list of sections followed by a fixed size trailer, so it
+// can be found by reading backwards from the end of the list:
+// ------------------------------------------------------------------
+// | section ... | footerLen(4) | footerMagic(4) |
+// ------------------------------------------------------------------
+// section: | tag(4) | length(4) | data(length) |
+// footerLen is the length of the whole footer, including the trailer.
+// Readers skip sections with unknown tags.
+//
+
+const (
+	flagsLen = uint32(4)
+
+	// flagFooter means the list ends with a footer
+	flagFooter = uint32(1 << 0)
+	// flagBackPointers means each block is followed by a back pointer
+	flagBackPointers = uint32(1 << 1)
+	// flagTimestamps means each block header has a creation timestamp
+	flagTimestamps = uint32(1 << 2)
+	// flagAEAD means each block is encrypted and authenticated
+	flagAEAD = uint32(1 << 3)
+	// flagMaxDataSize means the list header has the maximum block data size
+	flagMaxDataSize = uint32(1 << 4)
+	// flagPageAligned means the blocks are aligned to the storage pages
+	flagPageAligned = uint32(1 << 5)
+	// flagDescription means the list header has a description of the list
+	flagDescription = uint32(1 << 6)
+	// flagMetadata means the list header has user metadata
+	flagMetadata = uint32(1 << 7)
+	// flagListID means the list header has the ID of the list
+	flagListID = uint32(1 << 8)
+	// flagCompact means the block headers use the VarintCompact profile
+	flagCompact = uint32(1 << 9)
+
+	maxDataSizeLen    = uint32(4)
+	pageSizeLen       = uint32(4)
+	descriptionLenLen = uint32(4)
+	metadataLenLen    = uint32(4)
+	listIDLen         = uint32(16)
+
+	backPointerLen = uint32(4)
+	timestampLen   = uint32(8)
+)
+
+// blockFormat describes how the blocks of a list are serialized
+type blockFormat struct {
+	paddedBlockSize uint32
+	timestamps      bool
+	tagLen          uint32
+	// compact block headers have varint IDs and sizes
+	compact bool
+	// paddingRand is the source of the random padding, crypto/rand if nil
+	paddingRand io.Reader
+}
+
+// headerLen returns the size of the block header, which is the biggest size
+// of a compact block header
+func (f blockFormat) headerLen() uint32 {
+	hdrLen := blockHeaderLen
+	if f.compact {
+		hdrLen = 2 * binary.MaxVarintLen32
+	}
+	if f.timestamps {
+		hdrLen += timestampLen
+	}
+	return hdrLen + f.tagLen
+}
+
+// blockHeaderLen returns the size of the header of a block
+func (f blockFormat) blockHeaderLen(id, size uint32) uint32 {
+	if f.compact {
+		return f.compactHeaderLen(id, size)
+	}
+	return f.headerLen()
+}
+
+// minHeaderLen returns the smallest size of a block header
+func (f blockFormat) minHeaderLen() uint32 {
+	if f.compact {
+		return f.compactHeaderLen(0, 0)
+	}
+	return f.headerLen()
+}
+
+// parseHeader parses the block header at the start of the bytes, and
+// returns its size
+func (f blockFormat) parseHeader(hdr []byte) (id, size uint32, timestamp int64, hdrLen uint32,
+	err error) {
+	if f.compact {
+		return f.parseCompactHeader(hdr)
+	}
+
+	hdrLen = f.headerLen()
+	if uint32(len(hdr)) < hdrLen {
+		return 0, 0, 0, 0, errs.Errorf(errs.ErrTruncated, "Insufficient data size of %v", len(hdr))
+	}
+	id = binary.BigEndian.Uint32(hdr[0:])
+	size = binary.BigEndian.Uint32(hdr[blockNumLen:])
+	if f.timestamps {
+		timestamp = int64(binary.BigEndian.Uint64(hdr[blockHeaderLen:]))
+	}
+	return id, size, timestamp, hdrLen, nil
+}
+
+// pad fills the padding after the data of a padded block with random bytes.
+// Blocks of lists without padding have no padding.
+func (f blockFormat) pad(padding []byte) error {
+	if f.paddedBlockSize == 0 {
+		return nil
+	}
+	paddingRand := f.paddingRand
+	if paddingRand == nil {
+		paddingRand = rand.Reader
+	}
+	if _, err := io.ReadFull(paddingRand, padding); err != nil {
+		return errs.Wrap(err, nil)
+	}
+	return nil
+}
+
+// maxDataSize returns the most data a padded block can hold
+func (f blockFormat) maxDataSize() uint32 {
+	if f.paddedBlockSize < f.headerLen() {
+		return 0
+	}
+	return f.paddedBlockSize - f.headerLen()
+}
+
+// blockFormat returns the format of the blocks in the list
+func (b *blockListV1) blockFormat() blockFormat {
+	format := blockFormat{
+		paddedBlockSize: b.GetPaddedBlockSize(),
+		timestamps:      b.flags&flagTimestamps != 0,
+		paddingRand:     b.paddingRand,
+		compact:         b.flags&flagCompact != 0,
+	}
+	if b.flags&flagAEAD != 0 && b.aead != nil {
+		format.tagLen = uint32(b.aead.Overhead())
+	}
+	return format
+}
+
+const (
+	footerLenLen     = uint32(4)
+	footerMagicLen   = uint32(4)
+	footerTrailerLen = footerLenLen + footerMagicLen
+	footerMagic      = uint32(0x53534246) // "SSBF"
+
+	footerSectionHdrLen = uint32(8)
+
+	// footerSectionIDs holds the ID of every block, in block order:
+	// | count(4) | id(4) ... |
+	footerSectionIDs = uint32(1)
+	// footerSectionKeys holds the key index, sorted by key:
+	// | count(4) | keyLen(4) key(keyLen) blockIndex(4) ... |
+	footerSectionKeys = uint32(2)
+	// footerSectionFilled holds a bitmap of the written slots of a
+	// preallocated list, where bit i%8 of byte i/8 is slot i:
+	// | slots(4) | bitmap((slots+7)/8) |
+	footerSectionFilled = uint32(3)
+)
+
+// blockListFooter is the deserialized block list footer
+type blockListFooter struct {
+	blockIDs []uint32
+	keys     map[string][]uint32
+	slots    uint32
+	filled   []byte
+}
+
+func (f *blockListFooter) serialize() []byte {
+	var sections []byte
+
+	if f.blockIDs != nil {
+		section := make([]byte, 4+4*len(f.blockIDs))
+		binary.BigEndian.PutUint32(section, uint32(len(f.blockIDs)))
+		for i, id := range f.blockIDs {
+			binary.BigEndian.PutUint32(section[4+4*i:], id)
+		}
+		sections = appendFooterSection(sections, footerSectionIDs, section)
+	}
+
+	if f.keys != nil {
+		sections = appendFooterSection(sections, footerSectionKeys, serializeKeyIndex(f.keys))
+	}
+
+	if f.filled != nil {
+		section := make([]byte, 4, 4+len(f.filled))
+		binary.BigEndian.PutUint32(section, f.slots)
+		sections = appendFooterSection(sections, footerSectionFilled, append(section, f.filled...))
+	}
+
+	footerLen := uint32(len(sections)) + footerTrailerLen
+	trailer := make([]byte, footerTrailerLen)
+	binary.BigEndian.PutUint32(trailer, footerLen)
+	binary.BigEndian.PutUint32(trailer[footerLenLen:], footerMagic)
+	return append(sections, trailer...)
+}
+
+func appendFooterSection(sections []byte, tag uint32, section []byte) []byte {
+	hdr := make([]byte, footerSectionHdrLen)
+	binary.BigEndian.PutUint32(hdr, tag)
+	binary.BigEndian.PutUint32(hdr[4:], uint32(len(section)))
+	sections = append(sections, hdr...)
+	return append(sections, section...)
+}
+
+// parseFooterTrailer parses the footer trailer and returns the footer length
+func parseFooterTrailer(trailer []byte) (uint32, error) {
+	if uint32(len(trailer)) != footerTrailerLen {
+		return 0, errs.Errorf(errs.ErrTruncated, "Insufficient footer trailer size of %v", len(trailer))
+	}
+
+	if binary.BigEndian.Uint32(trailer[footerLenLen:]) != footerMagic {
+		return 0, errs.New(errs.ErrCorrupt, "The block list footer is missing or corrupted")
+	}
+
+	footerLen := binary.BigEndian.Uint32(trailer)
+	if footerLen < footerTrailerLen {
+		return 0, errs.Errorf(errs.ErrCorrupt, "Invalid block list footer length(%v)", footerLen)
+	}
+	return footerLen, nil
+}
+
+// deserializeFooter deserializes the whole footer, including the trailer
+func deserializeFooter(footer []byte) (*blockListFooter, error) {
+	footerLen, err := parseFooterTrailer(footer[uint32(len(footer))-footerTrailerLen:])
+	if err != nil {
+		return nil, err
+	}
+	if footerLen != uint32(len(footer)) {
+		return nil, errs.Errorf(errs.ErrCorrupt, "Footer length(%v) does not match "+
+			"the footer data size(%v)", footerLen, len(footer))
+	}
+
+	f := &blockListFooter{}
+	sections := footer[:footerLen-footerTrailerLen]
+	for len(sections) > 0 {
+		if uint32(len(sections)) < footerSectionHdrLen {
+			return nil, errs.Errorf(errs.ErrCorrupt, "Insufficient footer section size of %v",
+				len(sections))
+		}
+
+		tag := binary.BigEndian.Uint32(sections)
+		sectionLen := binary.BigEndian.Uint32(sections[4:])
+		sections = sections[footerSectionHdrLen:]
+		if uint64(sectionLen) > uint64(len(sections)) {
+			return nil, errs.Errorf(errs.ErrCorrupt, "Footer section size(%v) is bigger "+
+				"than the remaining footer size(%v)", sectionLen, len(sections))
+		}
+		section := sections[:sectionLen]
+		sections = sections[sectionLen:]
+
+		switch tag {
+		case footerSectionIDs:
+			if f.blockIDs, err = deserializeFooterIDs(section); err != nil {
+				return nil, err
+			}
+		case footerSectionKeys:
+			if f.keys, err = deserializeKeyIndex(section); err != nil {
+				return nil, err
+			}
+		case footerSectionFilled:
+			if len(section) < 4 {
+				return nil, errs.New(errs.ErrCorrupt, "Footer slot section is too small")
+			}
+			f.slots = binary.BigEndian.Uint32(section)
+			f.filled = section[4:]
+			if uint64(len(f.filled)) != tools.CeilDiv(uint64(f.slots), 8) {
+				return nil, errs.Errorf(errs.ErrCorrupt, "Footer slot section has %v bytes "+
+					"for %v slots", len(f.filled), f.slots)
+			}
+		}
+	}
+
+	return f, nil
+}
+
+func deserializeFooterIDs(section []byte) ([]uint32, error) {
+	if len(section) < 4 {
+		return nil, errs.New(errs.ErrCorrupt, "Footer ID section is too small")
+	}
+
+	count := binary.BigEndian.Uint32(section)
+	if uint64(len(section)-4) != uint64(count)*4 {
+		return nil, errs.Errorf(errs.ErrCorrupt, "Footer ID section has %v bytes "+
+			"for %v IDs", len(section)-4, count)
+	}
+
+	ids := make([]uint32, count)
+	for i := range ids {
+		ids[i] = binary.BigEndian.Uint32(section[4+4*i:])
+		if i > 0 && ids[i] <= ids[i-1] {
+			return nil, errs.Errorf(errs.ErrCorrupt, "Footer block ID(%v) does not follow "+
+				"the previous block ID(%v)", ids[i], ids[i-1])
+		}
+	}
+	return ids, nil
+}
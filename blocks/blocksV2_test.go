@@ -0,0 +1,2214 @@
+package blocks
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/overnest/strongsalt-common-go/tools"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+	"gotest.tools/assert"
+)
+
+func TestBlockListExplicitIDs(t *testing.T) {
+	testBlockListExplicitIDs(t, 0)
+	testBlockListExplicitIDs(t, 64)
+}
+
+func testBlockListExplicitIDs(t *testing.T, paddedBlockSize uint32) {
+	fileName := "/tmp/blocklistexplicitids_test"
+	ids := []uint32{3, 4, 10, 11, 100, 1000}
+
+	file, err := os.Create(fileName)
+	assert.NilError(t, err)
+	defer os.Remove(fileName)
+	defer file.Close()
+
+	// Explicit IDs are only available with the option
+	blWriter, err := NewBlockListWriterV1(file, paddedBlockSize, 0)
+	assert.NilError(t, err)
+	assert.Assert(t, blWriter.WriteBlockDataWithID(1, &testBlockV1{List: []uint64{1}}) != nil)
+
+	_, err = file.Seek(0, io.SeekStart)
+	assert.NilError(t, err)
+	assert.NilError(t, file.Truncate(0))
+
+	blWriter, err = NewBlockListWriterV1(file, paddedBlockSize, 0, WithExplicitIDs())
+	assert.NilError(t, err)
+	assert.Equal(t, blWriter.GetVersion(), BlockListV2)
+
+	for _, id := range ids {
+		err = blWriter.WriteBlockDataWithID(id, &testBlockV1{List: []uint64{uint64(id)}})
+		assert.NilError(t, err)
+	}
+	// IDs must increase
+	err = blWriter.WriteBlockDataWithID(ids[0], &testBlockV1{List: []uint64{0}})
+	assert.Assert(t, err != nil)
+	// Implicit IDs continue from the last explicit ID
+	assert.NilError(t, blWriter.WriteBlockData(&testBlockV1{List: []uint64{1001}}))
+	ids = append(ids, 1001)
+
+	assert.NilError(t, blWriter.Close())
+	assert.Assert(t, blWriter.WriteBlockData(&testBlockV1{List: []uint64{0}}) != nil)
+	file.Close()
+
+	file, blReader := openTestBlockList(t, fileName)
+	defer file.Close()
+	assert.Equal(t, blReader.GetVersion(), BlockListV2)
+	_, err = file.Seek(0, io.SeekStart)
+	assert.NilError(t, err)
+	_, err = NewBlockListReaderV1(file, 0, 0, initEmptyBlockData, WithExplicitIDs())
+	assert.ErrorContains(t, err, "writer options")
+
+	for _, id := range ids {
+		blockData, _, err := blReader.ReadNextBlockData()
+		assert.NilError(t, err)
+		assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(id))
+		assert.Equal(t, blReader.GetCurBlock().GetID(), id)
+	}
+	_, _, err = blReader.ReadNextBlockData()
+	assert.Equal(t, err, io.EOF)
+
+	for i, id := range ids {
+		index, err := blReader.GetBlockIndex(id)
+		assert.NilError(t, err)
+		assert.Equal(t, index, uint32(i))
+	}
+	_, err = blReader.GetBlockIndex(5)
+	assert.Assert(t, err != nil)
+
+	if blReader.IsBlockPadded() {
+		totalBlocks, err := blReader.GetTotalBlocks()
+		assert.NilError(t, err)
+		assert.Equal(t, totalBlocks, uint32(len(ids)))
+
+		for _, id := range ids {
+			blockData, _, err := blReader.ReadBlockDataByID(id)
+			assert.NilError(t, err)
+			assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(id))
+		}
+	}
+}
+
+func TestBlockListIDGaps(t *testing.T) {
+	fileName := "/tmp/blocklistidgaps_test"
+	paddedBlockSize := uint32(32)
+
+	file, err := os.Create(fileName)
+	assert.NilError(t, err)
+	defer os.Remove(fileName)
+	defer file.Close()
+
+	// A version 1 list whose block IDs have gaps
+	blWriter, err := NewBlockListWriterV1(file, paddedBlockSize, 0)
+	assert.NilError(t, err)
+	for _, id := range []uint32{0, 2, 7} {
+		data, err := blWriter.SerializeBlockData(&testBlockV1{List: []uint64{uint64(id)}})
+		assert.NilError(t, err)
+		serial, err := newBlock(id, uint32(len(data)), data).Serialize(paddedBlockSize)
+		assert.NilError(t, err)
+		_, err = file.Write(serial)
+		assert.NilError(t, err)
+	}
+	file.Close()
+
+	file, blReader := openTestBlockList(t, fileName)
+	_, _, err = blReader.ReadNextBlockData()
+	assert.NilError(t, err)
+	_, _, err = blReader.ReadNextBlockData()
+	assert.Assert(t, errs.Is(err, errs.ErrCorrupt))
+	_, _, err = blReader.ReadBlockDataAt(1)
+	assert.Assert(t, errs.Is(err, errs.ErrCorrupt))
+	file.Close()
+
+	file, blReader = openTestBlockList(t, fileName, WithIDGaps())
+	defer file.Close()
+	for _, id := range []uint32{0, 2, 7} {
+		blockData, _, err := blReader.ReadNextBlockData()
+		assert.NilError(t, err)
+		assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(id))
+	}
+	blockData, _, err := blReader.ReadBlockDataAt(2)
+	assert.NilError(t, err)
+	assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(7))
+}
+
+func TestBlockListIDPolicy(t *testing.T) {
+	fileName := "/tmp/blocklistidpolicy_test"
+	paddedBlockSize := uint32(32)
+	ids := []uint32{0, 5, 3, 3, 9}
+
+	file, err := os.Create(fileName)
+	assert.NilError(t, err)
+	defer os.Remove(fileName)
+	defer file.Close()
+
+	// A list recovered out of order
+	blWriter, err := NewBlockListWriterV1(file, paddedBlockSize, 0)
+	assert.NilError(t, err)
+	for _, id := range ids {
+		data, err := blWriter.SerializeBlockData(&testBlockV1{List: []uint64{uint64(id)}})
+		assert.NilError(t, err)
+		serial, err := newBlock(id, uint32(len(data)), data).Serialize(paddedBlockSize)
+		assert.NilError(t, err)
+		_, err = file.Write(serial)
+		assert.NilError(t, err)
+	}
+	stat, err := file.Stat()
+	assert.NilError(t, err)
+	file.Close()
+
+	// Each policy reads up to the first block it does not accept
+	for policy, readable := range map[IDPolicy]int{IDStrict: 1, IDMonotonic: 2, IDNone: len(ids)} {
+		file, blReader := openTestBlockList(t, fileName, WithIDPolicy(policy))
+		for i := 0; i < readable; i++ {
+			blockData, _, err := blReader.ReadNextBlockData()
+			assert.NilError(t, err)
+			assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(ids[i]))
+		}
+		_, _, err = blReader.ReadNextBlockData()
+		if readable < len(ids) {
+			assert.Assert(t, errs.Is(err, errs.ErrCorrupt), "policy %v", policy)
+		} else {
+			assert.Equal(t, err, io.EOF)
+		}
+
+		_, _, err = blReader.ReadBlockDataAt(2)
+		if policy == IDStrict {
+			assert.Assert(t, errs.Is(err, errs.ErrCorrupt))
+		} else {
+			assert.NilError(t, err)
+		}
+		file.Close()
+	}
+
+	// Backward reads are checked as well
+	file, blReader := openTestBlockList(t, fileName, WithIDPolicy(IDNone))
+	assert.NilError(t, blReader.ResetToEnd())
+	for i := len(ids) - 1; i >= 0; i-- {
+		blockData, _, err := blReader.ReadPrevBlockData()
+		assert.NilError(t, err)
+		assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(ids[i]))
+	}
+	_, _, err = blReader.ReadBlockDataByID(9)
+	assert.Assert(t, err != nil)
+	file.Close()
+
+	file, err = os.Open(fileName)
+	assert.NilError(t, err)
+	defer file.Close()
+	extents, err := ScanLists(file, stat.Size(), WithIDPolicy(IDNone))
+	assert.NilError(t, err)
+	assert.Equal(t, len(extents), 1)
+	assert.Equal(t, extents[0].Blocks, uint32(len(ids)))
+
+	_, err = NewBlockListReaderV1(file, 0, uint64(stat.Size()), initEmptyBlockData,
+		WithIDPolicy(IDPolicy(7)))
+	assert.Assert(t, err != nil)
+}
+
+func TestBlockListTimestamps(t *testing.T) {
+	fileName := "/tmp/blocklisttimestamps_test"
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	totalBlocks := 50
+
+	for _, paddedBlockSize := range []uint32{0, 64} {
+		file, err := os.Create(fileName)
+		assert.NilError(t, err)
+		defer os.Remove(fileName)
+		defer file.Close()
+
+		blWriter, err := NewBlockListWriterV1(file, paddedBlockSize, 0, WithTimestamps())
+		assert.NilError(t, err)
+		assert.Equal(t, blWriter.GetVersion(), BlockListV2)
+		if paddedBlockSize > 0 {
+			assert.Equal(t, blWriter.GetMaxDataSize(), paddedBlockSize-16)
+		}
+
+		// Blocks are created a minute apart
+		now := start
+		blWriter.(*blockListV1).now = func() time.Time {
+			now = now.Add(time.Minute)
+			return now
+		}
+		for i := 0; i < totalBlocks; i++ {
+			err = blWriter.WriteBlockData(&testBlockV1{List: []uint64{uint64(i)}})
+			assert.NilError(t, err)
+		}
+		assert.NilError(t, blWriter.Close())
+		file.Close()
+
+		file, blReader := openTestBlockList(t, fileName)
+		defer file.Close()
+		for i := 0; i < totalBlocks; i++ {
+			_, _, err := blReader.ReadNextBlockData()
+			assert.NilError(t, err)
+			assert.Assert(t, blReader.GetCurBlock().GetTimestamp().Equal(
+				start.Add(time.Duration(i+1)*time.Minute)))
+		}
+
+		if paddedBlockSize == 0 {
+			_, _, err = blReader.SearchByTime(start)
+			assert.Assert(t, err != nil)
+			continue
+		}
+
+		index, found, err := blReader.SearchByTime(start)
+		assert.NilError(t, err)
+		assert.Assert(t, found)
+		assert.Equal(t, index, uint32(0))
+
+		index, found, err = blReader.SearchByTime(start.Add(10 * time.Minute))
+		assert.NilError(t, err)
+		assert.Assert(t, found)
+		assert.Equal(t, index, uint32(9))
+
+		index, found, err = blReader.SearchByTime(start.Add(10*time.Minute + time.Second))
+		assert.NilError(t, err)
+		assert.Assert(t, found)
+		assert.Equal(t, index, uint32(10))
+
+		_, found, err = blReader.SearchByTime(start.Add(time.Hour * 24))
+		assert.NilError(t, err)
+		assert.Assert(t, !found)
+	}
+
+	// Lists without timestamps
+	writeTestBlockList(t, fileName, 64, 5)
+	file, blReader := openTestBlockList(t, fileName)
+	defer file.Close()
+	_, _, err := blReader.ReadNextBlockData()
+	assert.NilError(t, err)
+	assert.Assert(t, blReader.GetCurBlock().GetTimestamp().IsZero())
+	_, _, err = blReader.SearchByTime(start)
+	assert.Assert(t, err != nil)
+}
+
+func TestBlockListEndDiscovery(t *testing.T) {
+	testBlockListEndDiscovery(t, 0)
+	testBlockListEndDiscovery(t, 64)
+}
+
+func testBlockListEndDiscovery(t *testing.T, paddedBlockSize uint32) {
+	fileName := "/tmp/blocklistenddiscovery_test"
+	defer os.Remove(fileName)
+
+	// Without a footer, the end of the storage is the end of the list
+	writeTestBlockList(t, fileName, paddedBlockSize, 10)
+	file, err := os.Open(fileName)
+	assert.NilError(t, err)
+	blReader, err := NewBlockListReaderV1(file, 0, 0, initEmptyBlockData, WithEndDiscovery())
+	assert.NilError(t, err)
+	testReadAllBlocks(t, blReader, 10)
+	file.Close()
+
+	writeTestBlockList(t, fileName, paddedBlockSize, 10, WithFooter())
+	file, err = os.Open(fileName)
+	assert.NilError(t, err)
+	blReader, err = NewBlockListReaderV1(file, 0, 0, initEmptyBlockData, WithEndDiscovery())
+	assert.NilError(t, err)
+	assert.Equal(t, blReader.GetVersion(), BlockListV2)
+	if paddedBlockSize > 0 {
+		totalBlocks, err := blReader.GetTotalBlocks()
+		assert.NilError(t, err)
+		assert.Equal(t, totalBlocks, uint32(10))
+	}
+	testReadAllBlocks(t, blReader, 10)
+	file.Close()
+
+	// Data after the footer hides it
+	file, err = os.OpenFile(fileName, os.O_RDWR|os.O_APPEND, 0)
+	assert.NilError(t, err)
+	_, err = file.Write([]byte("trailing"))
+	assert.NilError(t, err)
+	_, err = file.Seek(0, io.SeekStart)
+	assert.NilError(t, err)
+	_, err = NewBlockListReaderV1(file, 0, 0, initEmptyBlockData, WithEndDiscovery())
+	assert.Assert(t, errs.Is(err, errs.ErrCorrupt))
+	file.Close()
+}
+
+func testReadAllBlocks(t *testing.T, blReader BlockListReaderV1, blocks int) {
+	for i := 0; i < blocks; i++ {
+		blockData, _, err := blReader.ReadNextBlockData()
+		assert.NilError(t, err)
+		assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(i))
+	}
+	_, _, err := blReader.ReadNextBlockData()
+	assert.Equal(t, err, io.EOF)
+}
+
+func TestBlockListKeyIndex(t *testing.T) {
+	testBlockListKeyIndex(t, 0)
+	testBlockListKeyIndex(t, 64)
+}
+
+func testBlockListKeyIndex(t *testing.T, paddedBlockSize uint32) {
+	fileName := "/tmp/blocklistkeyindex_test"
+
+	file, err := os.Create(fileName)
+	assert.NilError(t, err)
+	defer os.Remove(fileName)
+	defer file.Close()
+
+	blWriter, err := NewBlockListWriterV1(file, paddedBlockSize, 0)
+	assert.NilError(t, err)
+	err = blWriter.WriteBlockDataWithKeys(&testBlockV1{List: []uint64{0}}, [][]byte{[]byte("even")})
+	assert.Assert(t, err != nil)
+
+	_, err = file.Seek(0, io.SeekStart)
+	assert.NilError(t, err)
+	assert.NilError(t, file.Truncate(0))
+
+	blWriter, err = NewBlockListWriterV1(file, paddedBlockSize, 0, WithKeyIndex())
+	assert.NilError(t, err)
+	assert.Equal(t, blWriter.GetVersion(), BlockListV2)
+	for i := 0; i < 10; i++ {
+		keys := [][]byte{[]byte("odd"), []byte("odd")}
+		if i%2 == 0 {
+			keys = [][]byte{[]byte("even")}
+		}
+		if i == 7 {
+			keys = append(keys, []byte("seven"))
+		}
+		err = blWriter.WriteBlockDataWithKeys(&testBlockV1{List: []uint64{uint64(i)}}, keys)
+		assert.NilError(t, err)
+	}
+	assert.NilError(t, blWriter.WriteBlockData(&testBlockV1{List: []uint64{10}}))
+	assert.NilError(t, blWriter.Close())
+	file.Close()
+
+	file, blReader := openTestBlockList(t, fileName)
+	defer file.Close()
+
+	indexes, err := blReader.LookupKey([]byte("even"))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, indexes, []uint32{0, 2, 4, 6, 8})
+	indexes, err = blReader.LookupKey([]byte("odd"))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, indexes, []uint32{1, 3, 5, 7, 9})
+	indexes, err = blReader.LookupKey([]byte("seven"))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, indexes, []uint32{7})
+	indexes, err = blReader.LookupKey([]byte("none"))
+	assert.NilError(t, err)
+	assert.Equal(t, len(indexes), 0)
+
+	// The key ranges of the blocks overlap
+	_, _, err = blReader.SearchKey([]byte("odd"))
+	assert.Assert(t, err != nil)
+
+	if paddedBlockSize > 0 {
+		blockData, _, err := blReader.ReadBlockDataAt(7)
+		assert.NilError(t, err)
+		assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(7))
+	}
+	testReadAllBlocks(t, blReader, 11)
+
+	// Lists without a key index can not be looked up
+	file.Close()
+	writeTestBlockList(t, fileName, paddedBlockSize, 3)
+	file, blReader = openTestBlockList(t, fileName)
+	defer file.Close()
+	_, err = blReader.LookupKey([]byte("even"))
+	assert.Assert(t, err != nil)
+}
+
+func TestFooterKeyIndexCorrupt(t *testing.T) {
+	section := serializeKeyIndex(map[string][]uint32{"a": {1}, "b": {2, 3}})
+	keys, err := deserializeKeyIndex(section)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, keys, map[string][]uint32{"a": {1}, "b": {2, 3}})
+
+	_, err = deserializeKeyIndex(section[:len(section)-1])
+	assert.Assert(t, errs.Is(err, errs.ErrCorrupt))
+	binary.BigEndian.PutUint32(section[4:], 0xFFFFFFFF)
+	_, err = deserializeKeyIndex(section)
+	assert.Assert(t, errs.Is(err, errs.ErrCorrupt))
+}
+
+func TestBlockListAEAD(t *testing.T) {
+	testBlockListAEAD(t, 0, WithBackPointers())
+	testBlockListAEAD(t, 64)
+	testBlockListAEAD(t, 64, WithTimestamps())
+}
+
+func testBlockListAEAD(t *testing.T, paddedBlockSize uint32, opts ...BlockListOption) {
+	fileName := "/tmp/blocklistaead_test"
+	defer os.Remove(fileName)
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	_, err := NewBlockListWriterV1(os.Stdout, 0, 0, WithAESGCM(key[:5]))
+	assert.Assert(t, err != nil)
+
+	writeTestBlockList(t, fileName, paddedBlockSize, 10, append(opts, WithAESGCM(key))...)
+
+	// The block data is not stored in the clear
+	plain, err := tools.Marshal(&testBlockV1{List: []uint64{7}})
+	assert.NilError(t, err)
+	stored, err := ioutil.ReadFile(fileName)
+	assert.NilError(t, err)
+	if paddedBlockSize > 0 {
+		assert.Assert(t, !bytes.Contains(stored, plain))
+	}
+
+	// Encrypted lists can not be read without the key
+	file, err := os.Open(fileName)
+	assert.NilError(t, err)
+	_, err = NewBlockListReaderV1(file, 0, uint64(len(stored)), initEmptyBlockData)
+	assert.Assert(t, err != nil)
+	file.Close()
+
+	file, blReader := openTestBlockList(t, fileName, WithAESGCM(key))
+	testReadAllBlocks(t, blReader, 10)
+	blockData, _, err := blReader.ReadPrevBlockData()
+	assert.NilError(t, err)
+	assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(9))
+	if paddedBlockSize > 0 {
+		blockData, _, err := blReader.ReadBlockDataAt(7)
+		assert.NilError(t, err)
+		assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(7))
+	}
+	file.Close()
+
+	// A different key fails authentication
+	otherKey := append([]byte{}, key...)
+	otherKey[0] ^= 1
+	file, blReader = openTestBlockList(t, fileName, WithAESGCM(otherKey))
+	_, _, err = blReader.ReadNextBlockData()
+	assert.Assert(t, errs.Is(err, errs.ErrCorrupt))
+	file.Close()
+
+	// So does a modified block header
+	stored[blockListHeaderLen+flagsLen+blockHeaderLen] ^= 1
+	assert.NilError(t, ioutil.WriteFile(fileName, stored, 0600))
+	file, blReader = openTestBlockList(t, fileName, WithAESGCM(key))
+	defer file.Close()
+	_, _, err = blReader.ReadNextBlockData()
+	assert.Assert(t, errs.Is(err, errs.ErrCorrupt))
+}
+
+func TestBlockListPreallocated(t *testing.T) {
+	fileName := "/tmp/blocklistpreallocated_test"
+	totalBlocks := uint32(100)
+
+	file, err := os.Create(fileName)
+	assert.NilError(t, err)
+	defer os.Remove(fileName)
+	defer file.Close()
+
+	_, err = NewBlockListWriterV1(file, 0, 0, WithPreallocatedBlocks(totalBlocks))
+	assert.Assert(t, err != nil)
+
+	blWriter, err := NewBlockListWriterV1(file, 64, 0, WithPreallocatedBlocks(totalBlocks))
+	assert.NilError(t, err)
+	assert.Assert(t, blWriter.WriteBlockData(&testBlockV1{List: []uint64{0}}) != nil)
+	assert.Assert(t, blWriter.WriteBlockDataAt(totalBlocks, &testBlockV1{List: []uint64{0}}) != nil)
+
+	// Several producers write every block but the multiples of 10, backwards
+	var wg sync.WaitGroup
+	producers := uint32(4)
+	for p := uint32(0); p < producers; p++ {
+		wg.Add(1)
+		go func(p uint32) {
+			defer wg.Done()
+			for i := p; i < totalBlocks; i += producers {
+				index := totalBlocks - 1 - i
+				if index%10 != 0 {
+					assert.Check(t, blWriter.WriteBlockDataAt(index,
+						&testBlockV1{List: []uint64{uint64(index)}}))
+				}
+			}
+		}(p)
+	}
+	wg.Wait()
+	assert.NilError(t, blWriter.Close())
+	assert.Assert(t, blWriter.WriteBlockDataAt(10, &testBlockV1{List: []uint64{10}}) != nil)
+	file.Close()
+
+	file, blReader := openTestBlockList(t, fileName)
+	defer file.Close()
+	blocks, err := blReader.GetTotalBlocks()
+	assert.NilError(t, err)
+	assert.Equal(t, blocks, totalBlocks)
+
+	for i := uint32(0); i < totalBlocks; i++ {
+		blockData, _, err := blReader.ReadBlockDataAt(i)
+		if i%10 == 0 {
+			assert.Assert(t, errs.Is(err, errs.ErrNotFound))
+			continue
+		}
+		assert.NilError(t, err)
+		assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(i))
+	}
+
+	_, _, err = blReader.ReadNextBlockData()
+	assert.Assert(t, errs.Is(err, errs.ErrNotFound))
+	blockData, _, err := blReader.SearchBinary(uint64(55), BlockTestComparator)
+	assert.NilError(t, err)
+	assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(55))
+}
+
+// gatedWriterAt holds back the writes of the data that contains gate until
+// release is closed
+type gatedWriterAt struct {
+	*os.File
+	gate    []byte
+	held    chan struct{}
+	release chan struct{}
+}
+
+func (w *gatedWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	if bytes.Contains(p, w.gate) {
+		close(w.held)
+		<-w.release
+	}
+	return w.File.WriteAt(p, off)
+}
+
+func TestBlockListPreallocatedConcurrent(t *testing.T) {
+	fileName := "/tmp/blocklistpreallocatedconcurrent_test"
+	file, err := os.Create(fileName)
+	assert.NilError(t, err)
+	defer os.Remove(fileName)
+	defer file.Close()
+
+	gated := &gatedWriterAt{File: file, gate: []byte("[7]"), held: make(chan struct{}),
+		release: make(chan struct{})}
+	blWriter, err := NewBlockListWriterV1(gated, 64, 0, WithPreallocatedBlocks(2),
+		WithPaddingRand(zeroReader{}))
+	assert.NilError(t, err)
+
+	// A slow write does not hold back the other writes, but it does hold
+	// back Close
+	slow := make(chan error)
+	go func() { slow <- blWriter.WriteBlockDataAt(0, &testBlockV1{List: []uint64{7}}) }()
+	<-gated.held
+	fast := make(chan error)
+	go func() { fast <- blWriter.WriteBlockDataAt(1, &testBlockV1{List: []uint64{8}}) }()
+	select {
+	case err = <-fast:
+		assert.NilError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("The write of block 1 waited for the write of block 0")
+	}
+	closed := make(chan error)
+	go func() { closed <- blWriter.Close() }()
+	select {
+	case <-closed:
+		t.Fatal("Close did not wait for the write of block 0")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(gated.release)
+	assert.NilError(t, <-slow)
+	assert.NilError(t, <-closed)
+	file.Close()
+
+	file, blReader := openTestBlockList(t, fileName)
+	defer file.Close()
+	missing, err := blReader.MissingBlocks()
+	assert.NilError(t, err)
+	assert.Equal(t, len(missing), 0)
+}
+
+func TestBlockListPreallocatedAEAD(t *testing.T) {
+	fileName := "/tmp/blocklistpreallocatedaead_test"
+	key := make([]byte, 32)
+
+	file, err := os.Create(fileName)
+	assert.NilError(t, err)
+	defer os.Remove(fileName)
+	defer file.Close()
+
+	blWriter, err := NewBlockListWriterV1(file, 64, 0, WithPreallocatedBlocks(2), WithAESGCM(key))
+	assert.NilError(t, err)
+	assert.NilError(t, blWriter.WriteBlockDataAt(0, &testBlockV1{List: []uint64{0}}))
+
+	// Rewriting a block would reuse its nonce with other data
+	assert.Assert(t, blWriter.WriteBlockDataAt(0, &testBlockV1{List: []uint64{1}}) != nil)
+	assert.NilError(t, blWriter.WriteBlockDataAt(1, &testBlockV1{List: []uint64{1}}))
+	assert.NilError(t, blWriter.Close())
+	file.Close()
+
+	file, blReader := openTestBlockList(t, fileName, WithAESGCM(key))
+	defer file.Close()
+	for i := uint32(0); i < 2; i++ {
+		blockData, _, err := blReader.ReadBlockDataAt(i)
+		assert.NilError(t, err)
+		assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(i))
+	}
+}
+
+func TestBlockListMaxDataSize(t *testing.T) {
+	fileName := "/tmp/blocklistmaxdatasize_test"
+	defer os.Remove(fileName)
+
+	file, err := os.Create(fileName)
+	assert.NilError(t, err)
+	_, err = NewBlockListWriterV1(file, 64, 0, WithMaxBlockDataSize(32))
+	assert.Assert(t, err != nil)
+	_, err = NewBlockListWriterV1(file, 0, 0, WithMaxBlockDataSize(0))
+	assert.Assert(t, err != nil)
+
+	file, err = os.Create(fileName)
+	assert.NilError(t, err)
+	blWriter, err := NewBlockListWriterV1(file, 0, 0, WithMaxBlockDataSize(40), WithBackPointers())
+	assert.NilError(t, err)
+	assert.Equal(t, blWriter.GetVersion(), BlockListV2)
+	assert.Equal(t, blWriter.GetMaxDataSize(), uint32(40))
+	for i := 0; i < 3; i++ {
+		assert.NilError(t, blWriter.WriteBlockData(&testBlockV1{List: []uint64{uint64(i)}}))
+	}
+	_, err = blWriter.writeBlockDataBytes(make([]byte, 41))
+	assert.Assert(t, errs.Is(err, errs.ErrTooLarge))
+	assert.NilError(t, blWriter.Close())
+	file.Close()
+
+	file, blReader := openTestBlockList(t, fileName)
+	assert.Equal(t, blReader.(*blockListV1).GetMaxDataSize(), uint32(40))
+	testReadAllBlocks(t, blReader, 3)
+	_, _, err = blReader.ReadPrevBlockData()
+	assert.NilError(t, err)
+	file.Close()
+
+	// A block that claims to be bigger than the maximum is rejected, even
+	// though the list has room for it
+	stored, err := ioutil.ReadFile(fileName)
+	assert.NilError(t, err)
+	hdrLen := blockListHeaderLen + flagsLen + maxDataSizeLen
+	binary.BigEndian.PutUint32(stored[hdrLen+blockNumLen:], 41)
+	assert.NilError(t, ioutil.WriteFile(fileName, stored, 0644))
+	file, blReader = openTestBlockList(t, fileName)
+	defer file.Close()
+	_, _, err = blReader.ReadNextBlockData()
+	assert.Assert(t, errs.Is(err, errs.ErrCorrupt))
+}
+
+func TestBlockListPageAlignment(t *testing.T) {
+	fileName := "/tmp/blocklistpagealigned_test"
+	defer os.Remove(fileName)
+	initOffset := uint64(100)
+	pageSize := uint32(512)
+
+	file, err := os.Create(fileName)
+	assert.NilError(t, err)
+	_, err = NewBlockListWriterV1(file, 0, 0, WithPageAlignment(pageSize))
+	assert.Assert(t, err != nil)
+	_, err = NewBlockListWriterV1(file, 100, 0, WithPageAlignment(500))
+	assert.Assert(t, err != nil)
+
+	file, err = os.Create(fileName)
+	assert.NilError(t, err)
+	_, err = file.Write(make([]byte, initOffset))
+	assert.NilError(t, err)
+	blWriter, err := NewBlockListWriterV1(file, 100, initOffset, WithPageAlignment(pageSize))
+	assert.NilError(t, err)
+	assert.Equal(t, blWriter.GetPaddedBlockSize(), pageSize)
+	assert.Equal(t, blWriter.BytesWritten(), uint64(pageSize))
+	for i := 0; i < 5; i++ {
+		assert.NilError(t, blWriter.WriteBlockData(&testBlockV1{List: []uint64{uint64(i)}}))
+	}
+	assert.NilError(t, blWriter.Close())
+	file.Close()
+
+	// Every block starts on a page boundary relative to the start of the list
+	stored, err := ioutil.ReadFile(fileName)
+	assert.NilError(t, err)
+	assert.Equal(t, len(stored), int(initOffset)+6*int(pageSize))
+	for i := uint32(0); i < 5; i++ {
+		offset := initOffset + uint64(pageSize)*uint64(i+1)
+		assert.Equal(t, binary.BigEndian.Uint32(stored[offset:]), i)
+	}
+
+	file, err = os.Open(fileName)
+	assert.NilError(t, err)
+	defer file.Close()
+	_, err = file.Seek(int64(initOffset), io.SeekStart)
+	assert.NilError(t, err)
+	blReader, err := NewBlockListReaderV1(file, initOffset, uint64(len(stored)), initEmptyBlockData)
+	assert.NilError(t, err)
+	totalBlocks, err := blReader.GetTotalBlocks()
+	assert.NilError(t, err)
+	assert.Equal(t, totalBlocks, uint32(5))
+	testReadAllBlocks(t, blReader, 5)
+	blockData, _, err := blReader.ReadBlockDataAt(3)
+	assert.NilError(t, err)
+	assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(3))
+	blockData, _, err = blReader.ReadPrevBlockData()
+	assert.NilError(t, err)
+	assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(4))
+}
+
+func TestTestVectors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blockvectors")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.NilError(t, GenerateTestVectors(dir))
+	assert.NilError(t, VerifyTestVectors(dir))
+
+	// A changed padded list does not verify
+	fileName := filepath.Join(dir, "padded_v2_footer.bin")
+	list, err := ioutil.ReadFile(fileName)
+	assert.NilError(t, err)
+	list[len(list)-1]++
+	assert.NilError(t, ioutil.WriteFile(fileName, list, 0644))
+	assert.Assert(t, VerifyTestVectors(dir) != nil)
+}
+
+func TestMissingBlocks(t *testing.T) {
+	fileName := "/tmp/blocklistmissing_test"
+	defer os.Remove(fileName)
+
+	file, err := os.Create(fileName)
+	assert.NilError(t, err)
+	blWriter, err := NewBlockListWriterV1(file, 64, 0, WithPreallocatedBlocks(10))
+	assert.NilError(t, err)
+	for _, i := range []uint32{0, 2, 3, 7} {
+		assert.NilError(t, blWriter.WriteBlockDataAt(i, &testBlockV1{List: []uint64{uint64(i)}}))
+	}
+	file.Close()
+
+	// The writer crashed before writing the footer
+	file, err = os.Open(fileName)
+	assert.NilError(t, err)
+	_, err = NewBlockListReaderV1(file, 0, 0, initEmptyBlockData, WithEndDiscovery())
+	assert.Assert(t, err != nil)
+	_, err = file.Seek(0, io.SeekStart)
+	assert.NilError(t, err)
+	blReader, err := NewBlockListReaderV1(file, 0, 0, initEmptyBlockData,
+		WithEndDiscovery(), WithMissingFooter())
+	assert.NilError(t, err)
+	missing, err := blReader.MissingBlocks()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, missing, []uint32{1, 4, 5, 6})
+	blockData, _, err := blReader.ReadBlockDataAt(7)
+	assert.NilError(t, err)
+	assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(7))
+	file.Close()
+
+	// The footer of a closed list records the missing blocks
+	file, err = os.Create(fileName)
+	assert.NilError(t, err)
+	blWriter, err = NewBlockListWriterV1(file, 64, 0, WithPreallocatedBlocks(10))
+	assert.NilError(t, err)
+	for _, i := range []uint32{0, 2, 3, 7} {
+		assert.NilError(t, blWriter.WriteBlockDataAt(i, &testBlockV1{List: []uint64{uint64(i)}}))
+	}
+	assert.NilError(t, blWriter.Close())
+	file.Close()
+
+	file, blReader = openTestBlockList(t, fileName, WithMissingFooter())
+	defer file.Close()
+	missing, err = blReader.MissingBlocks()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, missing, []uint32{1, 4, 5, 6, 8, 9})
+}
+
+// flakyFile fails every other ReadAt
+type flakyFile struct {
+	*os.File
+	reads int
+}
+
+func (f *flakyFile) ReadAt(p []byte, off int64) (int, error) {
+	f.reads++
+	if f.reads%2 == 1 {
+		return 0, errors.New("transient")
+	}
+	return f.File.ReadAt(p, off)
+}
+
+func TestBlockListRetry(t *testing.T) {
+	fileName := "/tmp/blocklistretry_test"
+	defer os.Remove(fileName)
+	writeTestBlockList(t, fileName, 64, 10)
+
+	file, err := os.Open(fileName)
+	assert.NilError(t, err)
+	defer file.Close()
+	stat, err := file.Stat()
+	assert.NilError(t, err)
+
+	flaky := &flakyFile{File: file}
+	blReader, err := NewBlockListReaderV1(flaky, 0, uint64(stat.Size()), initEmptyBlockData)
+	assert.NilError(t, err)
+	_, _, err = blReader.ReadBlockDataAt(3)
+	assert.Assert(t, err != nil)
+
+	_, err = file.Seek(0, io.SeekStart)
+	assert.NilError(t, err)
+	policy := tools.RetryPolicy{MaxAttempts: 2, InitialDelay: time.Millisecond}
+	blReader, err = NewBlockListReaderV1(flaky, 0, uint64(stat.Size()), initEmptyBlockData,
+		WithRetry(policy))
+	assert.NilError(t, err)
+	for i := uint32(0); i < 10; i++ {
+		blockData, _, err := blReader.ReadBlockDataAt(i)
+		assert.NilError(t, err)
+		assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(i))
+	}
+
+	_, err = NewBlockListReaderV1(flaky, 0, uint64(stat.Size()), initEmptyBlockData,
+		WithRetry(tools.RetryPolicy{}))
+	assert.Assert(t, err != nil)
+}
+
+func TestBlockListDescription(t *testing.T) {
+	fileName := "/tmp/blocklistdescription_test"
+	defer os.Remove(fileName)
+
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, blockSize := range []uint32{0, 4096} {
+		opts := []BlockListOption{WithDescription(ListDescription{AppTag: "app 1.0", Created: created}),
+			WithFooter()}
+		if blockSize > 0 {
+			opts = append(opts, WithPageAlignment(4096))
+		}
+		writeTestBlockList(t, fileName, blockSize, 10, opts...)
+
+		file, blReader := openTestBlockList(t, fileName)
+		desc := blReader.GetDescription()
+		assert.Assert(t, desc != nil)
+		compression := "gzip"
+		if blockSize > 0 {
+			compression = "none"
+		}
+		flags := flagDescription | flagFooter
+		if blockSize > 0 {
+			flags |= flagPageAligned
+		}
+		assert.DeepEqual(t, *desc, ListDescription{Format: DescriptionFormat, Codec: "json",
+			Compression: compression, Flags: flags, Created: created, AppTag: "app 1.0"})
+		testReadAllBlocks(t, blReader, 10)
+		file.Close()
+	}
+
+	writeTestBlockList(t, fileName, 64, 1)
+	file, blReader := openTestBlockList(t, fileName, WithDescription(ListDescription{}))
+	defer file.Close()
+	assert.Assert(t, blReader.GetDescription() == nil)
+}
+
+func TestBlockListMetadata(t *testing.T) {
+	fileName := "/tmp/blocklistmetadata_test"
+	defer os.Remove(fileName)
+
+	metadata := []byte(`{"sortKey":"name","codec":"json"}`)
+	for _, blockSize := range []uint32{0, 4096} {
+		opts := []BlockListOption{WithMetadata(metadata),
+			WithDescription(ListDescription{AppTag: "app 1.0"})}
+		if blockSize > 0 {
+			opts = append(opts, WithPageAlignment(4096))
+		}
+		writeTestBlockList(t, fileName, blockSize, 10, opts...)
+
+		file, blReader := openTestBlockList(t, fileName, WithMetadata([]byte("ignored")))
+		assert.DeepEqual(t, blReader.GetMetadata(), metadata)
+		assert.Equal(t, blReader.GetDescription().AppTag, "app 1.0")
+		testReadAllBlocks(t, blReader, 10)
+		file.Close()
+	}
+
+	writeTestBlockList(t, fileName, 64, 1)
+	file, blReader := openTestBlockList(t, fileName)
+	defer file.Close()
+	assert.Assert(t, blReader.GetMetadata() == nil)
+
+	_, err := NewBlockListWriterV1(&bytes.Buffer{}, 0, 0,
+		WithMetadata(make([]byte, MaxMetadataLen+1)))
+	assert.Assert(t, errs.Is(err, errs.ErrTooLarge))
+}
+
+func TestBlockListID(t *testing.T) {
+	fileName := "/tmp/blocklistid_test"
+	defer os.Remove(fileName)
+
+	id := [16]byte{0x6b, 0xa7, 0xb8, 0x10, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0,
+		0x4f, 0xd4, 0x30, 0xc8}
+	for _, blockSize := range []uint32{0, 4096} {
+		opts := []BlockListOption{WithListID(id), WithMetadata([]byte("meta"))}
+		if blockSize > 0 {
+			opts = append(opts, WithPageAlignment(4096))
+		}
+		writeTestBlockList(t, fileName, blockSize, 10, opts...)
+
+		file, blReader := openTestBlockList(t, fileName, WithListID([16]byte{1}))
+		listID, ok := blReader.GetListID()
+		assert.Assert(t, ok)
+		assert.Equal(t, listID, id)
+		assert.DeepEqual(t, blReader.GetMetadata(), []byte("meta"))
+		testReadAllBlocks(t, blReader, 10)
+		file.Close()
+	}
+
+	writeTestBlockList(t, fileName, 64, 1)
+	file, blReader := openTestBlockList(t, fileName)
+	defer file.Close()
+	_, ok := blReader.GetListID()
+	assert.Assert(t, !ok)
+}
+
+func TestBlockListSeal(t *testing.T) {
+	fileName := "/tmp/blocklistseal_test"
+	defer os.Remove(fileName)
+
+	file, err := os.Create(fileName)
+	assert.NilError(t, err)
+	blWriter, err := NewBlockListWriterV1(file, 0, 0)
+	assert.NilError(t, err)
+	assert.Assert(t, blWriter.Seal() != nil)
+
+	_, err = file.Seek(0, io.SeekStart)
+	assert.NilError(t, err)
+	blWriter, err = NewBlockListWriterV1(file, 0, 0, WithFooter())
+	assert.NilError(t, err)
+	assert.NilError(t, blWriter.WriteBlockData(&testBlockV1{List: []uint64{0}}))
+	assert.Assert(t, !blWriter.IsSealed())
+	assert.NilError(t, blWriter.Seal())
+	assert.Assert(t, blWriter.IsSealed())
+	err = blWriter.WriteBlockData(&testBlockV1{List: []uint64{1}})
+	assert.Assert(t, errs.Is(err, ErrSealed))
+	file.Close()
+
+	file, blReader := openTestBlockList(t, fileName)
+	assert.Assert(t, blReader.IsSealed())
+	file.Close()
+
+	// A list truncated before its footer is not sealed
+	stored, err := ioutil.ReadFile(fileName)
+	assert.NilError(t, err)
+	assert.NilError(t, ioutil.WriteFile(fileName, stored[:len(stored)-1], 0600))
+	file, blReader = openTestBlockList(t, fileName, WithMissingFooter())
+	defer file.Close()
+	assert.Assert(t, !blReader.IsSealed())
+}
+
+func TestScanLists(t *testing.T) {
+	fileName := "/tmp/blocklistscan_test"
+	defer os.Remove(fileName)
+
+	file, err := os.Create(fileName)
+	assert.NilError(t, err)
+	defer file.Close()
+
+	type testList struct {
+		paddedBlockSize uint32
+		blocks          int
+		opts            []BlockListOption
+	}
+	lists := []testList{
+		{64, 3, nil},
+		{0, 4, []BlockListOption{WithFooter(), WithBackPointers()}},
+		{0, 0, nil},
+		{128, 2, []BlockListOption{WithExplicitIDs()}},
+		{0, 2, nil},
+		{64, 5, []BlockListOption{WithPreallocatedBlocks(8)}},
+		{64, 1, []BlockListOption{WithTimestamps()}},
+		{0, 3, []BlockListOption{WithFormatProfile(VarintCompact), WithBackPointers()}},
+	}
+
+	var offsets []uint64
+	offset := uint64(0)
+	for _, list := range lists {
+		offsets = append(offsets, offset)
+		blWriter, err := NewBlockListWriterV1(file, list.paddedBlockSize, offset, list.opts...)
+		assert.NilError(t, err)
+		for i := 0; i < list.blocks; i++ {
+			blockData := &testBlockV1{List: []uint64{uint64(i)}}
+			switch {
+			case list.opts == nil || list.paddedBlockSize == 0 || list.blocks == 1:
+				assert.NilError(t, blWriter.WriteBlockData(blockData))
+			case list.paddedBlockSize == 128:
+				assert.NilError(t, blWriter.WriteBlockDataWithID(uint32(10*i+5), blockData))
+			default:
+				assert.NilError(t, blWriter.WriteBlockDataAt(uint32(i+i/2), blockData))
+			}
+		}
+		assert.NilError(t, blWriter.Close())
+		offset += blWriter.BytesWritten()
+		_, err = file.Seek(int64(offset), io.SeekStart)
+		assert.NilError(t, err)
+	}
+
+	extents, err := ScanLists(file, int64(offset))
+	assert.NilError(t, err)
+	assert.Equal(t, len(extents), len(lists))
+	for i, extent := range extents {
+		assert.Equal(t, extent.Offset, offsets[i])
+		assert.Equal(t, extent.PaddedBlockSize, lists[i].paddedBlockSize)
+
+		_, err = file.Seek(int64(extent.Offset), io.SeekStart)
+		assert.NilError(t, err)
+		blReader, err := NewBlockListReaderV1(file, extent.Offset, extent.Offset+extent.Length,
+			initEmptyBlockData)
+		assert.NilError(t, err)
+		assert.Equal(t, blReader.IsSealed(), extent.Footer)
+
+		if i == 5 {
+			assert.Equal(t, extent.Blocks, uint32(8))
+			missing, err := blReader.MissingBlocks()
+			assert.NilError(t, err)
+			assert.DeepEqual(t, missing, []uint32{2, 5, 7})
+			continue
+		}
+		assert.Equal(t, extent.Blocks, uint32(lists[i].blocks))
+		testReadAllBlocks(t, blReader, lists[i].blocks)
+	}
+
+	// Bytes that are not a list
+	_, err = file.WriteAt([]byte("garbage"), int64(offset))
+	assert.NilError(t, err)
+	extents, err = ScanLists(file, int64(offset)+7)
+	assert.Assert(t, err != nil)
+	assert.Equal(t, len(extents), len(lists))
+}
+
+func TestBlockListFull(t *testing.T) {
+	fileName := "/tmp/blocklistfull_test"
+	defer os.Remove(fileName)
+
+	file, err := os.Create(fileName)
+	assert.NilError(t, err)
+	defer file.Close()
+
+	_, err = NewBlockListWriterV1(file, 64, 0, WithMaxTotalBlocks(0))
+	assert.Assert(t, err != nil)
+
+	blWriter, err := NewBlockListWriterV1(file, 64, 0, WithMaxTotalBlocks(3))
+	assert.NilError(t, err)
+	for i := 0; i < 3; i++ {
+		assert.NilError(t, blWriter.WriteBlockData(&testBlockV1{List: []uint64{uint64(i)}}))
+	}
+	err = blWriter.WriteBlockData(&testBlockV1{List: []uint64{3}})
+	assert.Assert(t, errs.Is(err, ErrListFull))
+
+	// The header and two padded blocks fit
+	assert.NilError(t, file.Truncate(0))
+	_, err = file.Seek(0, io.SeekStart)
+	assert.NilError(t, err)
+	blWriter, err = NewBlockListWriterV1(file, 64, 0, WithMaxTotalBytes(uint64(blockListHeaderLen)+150))
+	assert.NilError(t, err)
+	assert.NilError(t, blWriter.WriteBlockData(&testBlockV1{List: []uint64{0}}))
+	assert.NilError(t, blWriter.WriteBlockData(&testBlockV1{List: []uint64{1}}))
+	written := blWriter.BytesWritten()
+	err = blWriter.WriteBlockData(&testBlockV1{List: []uint64{2}})
+	assert.Assert(t, errs.Is(err, ErrListFull))
+	assert.Equal(t, blWriter.BytesWritten(), written)
+	assert.NilError(t, blWriter.Close())
+	file.Close()
+
+	file, blReader := openTestBlockList(t, fileName)
+	defer file.Close()
+	total, err := blReader.GetTotalBlocks()
+	assert.NilError(t, err)
+	assert.Equal(t, total, uint32(2))
+}
+
+func TestSample(t *testing.T) {
+	fileName := "/tmp/blocklistsample_test"
+	defer os.Remove(fileName)
+	writeTestBlockList(t, fileName, 64, 20)
+
+	file, blReader := openTestBlockList(t, fileName)
+	defer file.Close()
+	rng := rand.New(rand.NewSource(1))
+	samples, err := blReader.Sample(5, rng)
+	assert.NilError(t, err)
+	assert.Equal(t, len(samples), 5)
+	for i := 1; i < len(samples); i++ {
+		assert.Assert(t, samples[i-1].(*testBlockV1).List[0] < samples[i].(*testBlockV1).List[0])
+	}
+
+	// Every block is sampled if there are not enough of them
+	samples, err = blReader.Sample(50, rng)
+	assert.NilError(t, err)
+	assert.Equal(t, len(samples), 20)
+	_, err = blReader.Sample(5, nil)
+	assert.Assert(t, err != nil)
+	file.Close()
+
+	// Unwritten blocks of a preallocated list are not sampled
+	file, err = os.Create(fileName)
+	assert.NilError(t, err)
+	blWriter, err := NewBlockListWriterV1(file, 64, 0, WithPreallocatedBlocks(10))
+	assert.NilError(t, err)
+	for _, i := range []uint32{1, 4, 8} {
+		assert.NilError(t, blWriter.WriteBlockDataAt(i, &testBlockV1{List: []uint64{uint64(i)}}))
+	}
+	assert.NilError(t, blWriter.Close())
+	file.Close()
+
+	file, blReader = openTestBlockList(t, fileName)
+	defer file.Close()
+	samples, err = blReader.Sample(10, rng)
+	assert.NilError(t, err)
+	assert.Equal(t, len(samples), 3)
+	samples, err = blReader.Sample(2, rng)
+	assert.NilError(t, err)
+	assert.Equal(t, len(samples), 2)
+	for _, sample := range samples {
+		v := sample.(*testBlockV1).List[0]
+		assert.Assert(t, v == 1 || v == 4 || v == 8)
+	}
+
+	// Lists without padding can not be sampled
+	writeTestBlockList(t, fileName, 0, 5)
+	file, blReader = openTestBlockList(t, fileName)
+	defer file.Close()
+	_, err = blReader.Sample(2, rng)
+	assert.Assert(t, err != nil)
+}
+
+func TestCommitRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blockcommit")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	readBlocks := func(reader BlockListReaderV1) []uint64 {
+		var values []uint64
+		for {
+			blockData, _, err := reader.ReadNextBlockData()
+			if err == io.EOF {
+				return values
+			}
+			assert.NilError(t, err)
+			values = append(values, blockData.(*testBlockV1).List[0])
+		}
+	}
+	writeBlocks := func(writer BlockListWriterV1, from, to uint64) {
+		for i := from; i < to; i++ {
+			assert.NilError(t, writer.WriteBlockData(&testBlockV1{List: []uint64{i}}))
+		}
+	}
+
+	for _, padded := range []uint32{64, 0} {
+		fileName := filepath.Join(dir, "list")
+		record := NewFileCommitRecord(filepath.Join(dir, "list.commit"))
+
+		wfile, err := os.Create(fileName)
+		assert.NilError(t, err)
+		blWriter, err := NewBlockListWriterV1(wfile, padded, 0, WithFooter(),
+			WithBackPointers(), WithCommitRecord(record))
+		assert.NilError(t, err)
+
+		rfile, err := os.Open(fileName)
+		assert.NilError(t, err)
+		blReader, err := NewBlockListReaderV1(rfile, 0, 0, initEmptyBlockData,
+			WithReadAhead(4096), WithCommitRecord(record))
+		assert.NilError(t, err)
+		assert.Equal(t, len(readBlocks(blReader)), 0)
+
+		// Blocks are not read until they are committed
+		writeBlocks(blWriter, 0, 3)
+		assert.NilError(t, blReader.Refresh())
+		assert.Equal(t, len(readBlocks(blReader)), 0)
+		assert.NilError(t, blWriter.Commit())
+		assert.NilError(t, blReader.Refresh())
+		assert.DeepEqual(t, readBlocks(blReader), []uint64{0, 1, 2})
+
+		writeBlocks(blWriter, 3, 5)
+		assert.NilError(t, blWriter.Commit())
+		writeBlocks(blWriter, 5, 6)
+		assert.NilError(t, blReader.Refresh())
+		assert.DeepEqual(t, readBlocks(blReader), []uint64{3, 4})
+		if padded > 0 {
+			total, err := blReader.GetTotalBlocks()
+			assert.NilError(t, err)
+			assert.Equal(t, total, uint32(5))
+		}
+
+		// The footer is read once the list is closed
+		assert.Assert(t, !blReader.IsSealed())
+		assert.NilError(t, blWriter.Seal())
+		assert.Assert(t, errs.Is(blWriter.Commit(), ErrSealed))
+		assert.NilError(t, blReader.Refresh())
+		assert.Assert(t, blReader.IsSealed())
+		assert.DeepEqual(t, readBlocks(blReader), []uint64{5})
+		_, _, err = blReader.ReadPrevBlockData()
+		assert.NilError(t, err)
+		assert.NilError(t, blReader.Reset())
+		assert.Equal(t, len(readBlocks(blReader)), 6)
+
+		commit, err := record.Load()
+		assert.NilError(t, err)
+		assert.Equal(t, commit.Blocks, uint32(6))
+		assert.Assert(t, commit.Closed)
+		assert.Equal(t, commit.EndOffset, blWriter.BytesWritten())
+
+		wfile.Close()
+		rfile.Close()
+	}
+
+	// Preallocated lists are written out of order
+	file, err := os.Create(filepath.Join(dir, "prealloc"))
+	assert.NilError(t, err)
+	defer file.Close()
+	_, err = NewBlockListWriterV1(file, 64, 0, WithPreallocatedBlocks(10),
+		WithCommitRecord(NewFileCommitRecord(filepath.Join(dir, "prealloc.commit"))))
+	assert.Assert(t, err != nil)
+
+	_, err = NewFileCommitRecord(filepath.Join(dir, "missing")).Load()
+	assert.Assert(t, errs.Is(err, errs.ErrNotFound))
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "corrupt"), make([]byte, 24), 0644))
+	_, err = NewFileCommitRecord(filepath.Join(dir, "corrupt")).Load()
+	assert.Assert(t, errs.Is(err, errs.ErrCorrupt))
+}
+
+// slowFile takes a while to write the block after the list header and
+// slowBlocks blocks
+type slowFile struct {
+	*os.File
+	writes     int
+	slowBlocks int
+}
+
+func (f *slowFile) Write(p []byte) (int, error) {
+	if f.writes == f.slowBlocks+1 {
+		time.Sleep(20 * time.Millisecond)
+	}
+	f.writes++
+	return f.File.Write(p)
+}
+
+func TestSlowWriteThreshold(t *testing.T) {
+	fileName := "/tmp/blocklistslowwrite_test"
+	defer os.Remove(fileName)
+	file, err := os.Create(fileName)
+	assert.NilError(t, err)
+	defer file.Close()
+
+	var slowIDs []uint32
+	blWriter, err := NewBlockListWriterV1(&slowFile{File: file, slowBlocks: 2}, 0, 0,
+		WithSlowWriteThreshold(10*time.Millisecond, func(blockID uint32, d time.Duration) {
+			assert.Assert(t, d > 10*time.Millisecond)
+			slowIDs = append(slowIDs, blockID)
+		}))
+	assert.NilError(t, err)
+	for i := uint64(0); i < 5; i++ {
+		assert.NilError(t, blWriter.WriteBlockData(&testBlockV1{List: []uint64{i}}))
+	}
+	assert.NilError(t, blWriter.Close())
+	assert.DeepEqual(t, slowIDs, []uint32{2})
+}
+
+func TestUpgrade(t *testing.T) {
+	srcName := "/tmp/blocklistupgrade_src_test"
+	dstName := "/tmp/blocklistupgrade_dst_test"
+	defer os.Remove(srcName)
+	defer os.Remove(dstName)
+
+	for _, padded := range []uint32{64, 0} {
+		writeTestBlockList(t, srcName, padded, 20)
+		srcFile, src := openTestBlockList(t, srcName)
+		assert.Equal(t, src.GetVersion(), BlockListV1)
+
+		dstFile, err := os.Create(dstName)
+		assert.NilError(t, err)
+		written, err := Upgrade(src, dstFile, 0, WithBackPointers(), WithTimestamps())
+		assert.NilError(t, err)
+		assert.Equal(t, written, uint32(20))
+		srcFile.Close()
+		dstFile.Close()
+
+		dstFile, dst := openTestBlockList(t, dstName)
+		assert.Equal(t, dst.GetVersion(), BlockListV2)
+		assert.Equal(t, dst.GetPaddedBlockSize(), padded)
+		assert.Assert(t, dst.IsSealed())
+		testReadAllBlocks(t, dst, 20)
+
+		// The upgraded list can be read backwards
+		assert.NilError(t, dst.ResetToEnd())
+		blockData, _, err := dst.ReadPrevBlockData()
+		assert.NilError(t, err)
+		assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(19))
+		assert.Assert(t, !dst.GetCurBlock().GetTimestamp().IsZero())
+		dstFile.Close()
+	}
+}
+
+func TestTypedReaders(t *testing.T) {
+	fileName := "/tmp/blocklisttyped_test"
+	copyName := "/tmp/blocklisttyped_copy_test"
+	defer os.Remove(fileName)
+	defer os.Remove(copyName)
+	writeTestBlockList(t, fileName, 64, 5)
+
+	file, err := os.Open(fileName)
+	assert.NilError(t, err)
+	defer file.Close()
+	stat, err := file.Stat()
+	assert.NilError(t, err)
+
+	for _, example := range []interface{}{testBlockV1{}, &testBlockV1{}, reflect.TypeOf(testBlockV1{})} {
+		_, err = file.Seek(0, io.SeekStart)
+		assert.NilError(t, err)
+		blReader, err := NewBlockListReaderV1Of(file, 0, uint64(stat.Size()), example)
+		assert.NilError(t, err)
+		testReadAllBlocks(t, blReader, 5)
+	}
+	_, err = InitBlockDataOf(nil)
+	assert.Assert(t, err != nil)
+
+	// Raw block data is forwarded to another list as it is
+	_, err = file.Seek(0, io.SeekStart)
+	assert.NilError(t, err)
+	rawReader, err := NewRawBlockListReaderV1(file, 0, uint64(stat.Size()))
+	assert.NilError(t, err)
+	copyFile, err := os.Create(copyName)
+	assert.NilError(t, err)
+	defer copyFile.Close()
+	blWriter, err := NewBlockListWriterV1(copyFile, 0, 0)
+	assert.NilError(t, err)
+	for i := 0; ; i++ {
+		blockData, jsonSize, err := rawReader.ReadNextBlockData()
+		if err == io.EOF {
+			break
+		}
+		assert.NilError(t, err)
+		raw, ok := blockData.(json.RawMessage)
+		assert.Assert(t, ok)
+		assert.Equal(t, string(raw), fmt.Sprintf(`{"List":[%v]}`, i))
+		assert.Equal(t, jsonSize, len(raw))
+		assert.NilError(t, blWriter.WriteBlockData(raw))
+	}
+	assert.NilError(t, blWriter.Close())
+	copyFile.Close()
+
+	copyFile, blReader := openTestBlockList(t, copyName)
+	defer copyFile.Close()
+	testReadAllBlocks(t, blReader, 5)
+}
+
+func TestWriteJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blockjournal")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "list")
+	journal, err := OpenFileWriteJournal(filepath.Join(dir, "list.journal"))
+	assert.NilError(t, err)
+	defer journal.Close()
+
+	// Only padded lists can be written with a journal
+	wfile, err := os.Create(fileName)
+	assert.NilError(t, err)
+	_, err = NewBlockListWriterV1(wfile, 0, 0, WithWriteJournal(journal))
+	assert.Assert(t, err != nil)
+	wfile.Close()
+
+	// Nor can encrypted lists, whose dropped blocks would be rewritten with
+	// the same nonce
+	key := make([]byte, 32)
+	wfile, err = os.Create(fileName)
+	assert.NilError(t, err)
+	_, err = NewBlockListWriterV1(wfile, 64, 0, WithAESGCM(key), WithWriteJournal(journal))
+	assert.ErrorContains(t, err, "WithAEAD")
+	wfile.Close()
+	writeTestBlockList(t, fileName, 64, 3, WithAESGCM(key))
+	stat, err := os.Stat(fileName)
+	assert.NilError(t, err)
+	rwfile, err := os.OpenFile(fileName, os.O_RDWR, 0644)
+	assert.NilError(t, err)
+	_, err = OpenBlockListRW(rwfile, 64, 0, uint64(stat.Size()), initEmptyBlockData,
+		WithAESGCM(key), WithWriteJournal(journal))
+	assert.ErrorContains(t, err, "WithAEAD")
+	rwfile.Close()
+
+	wfile, err = os.Create(fileName)
+	assert.NilError(t, err)
+	blWriter, err := NewBlockListWriterV1(wfile, 64, 0, WithFooter(), WithWriteJournal(journal))
+	assert.NilError(t, err)
+	for i := uint64(0); i < 3; i++ {
+		assert.NilError(t, blWriter.WriteBlockData(&testBlockV1{List: []uint64{i}}))
+	}
+
+	// Crash while writing the fourth block
+	entry, err := journal.Load()
+	assert.NilError(t, err)
+	assert.Equal(t, entry.State, JournalCommitted)
+	assert.Equal(t, entry.BlockID, uint32(2))
+	assert.NilError(t, journal.Store(JournalEntry{State: JournalIntent, Offset: entry.Offset,
+		BlockID: 3}))
+	_, err = wfile.Write(bytes.Repeat([]byte{0xff}, 32))
+	assert.NilError(t, err)
+	wfile.Close()
+
+	// The journal is read back after it is reopened
+	journal.Close()
+	journal, err = OpenFileWriteJournal(filepath.Join(dir, "list.journal"))
+	assert.NilError(t, err)
+
+	stat, err = os.Stat(fileName)
+	assert.NilError(t, err)
+	rfile, err := os.Open(fileName)
+	assert.NilError(t, err)
+	blReader, err := NewBlockListReaderV1(rfile, 0, uint64(stat.Size()), initEmptyBlockData,
+		WithWriteJournal(journal))
+	assert.NilError(t, err)
+	total, err := blReader.GetTotalBlocks()
+	assert.NilError(t, err)
+	assert.Equal(t, total, uint32(3))
+	testReadAllBlocks(t, blReader, 3)
+	rfile.Close()
+
+	// The dropped block is overwritten, and Close writes the footer
+	rwfile, err = os.OpenFile(fileName, os.O_RDWR, 0644)
+	assert.NilError(t, err)
+	blRW, err := OpenBlockListRW(rwfile, 64, 0, uint64(stat.Size()), initEmptyBlockData,
+		WithWriteJournal(journal))
+	assert.NilError(t, err)
+	for i := uint64(3); i < 5; i++ {
+		assert.NilError(t, blRW.WriteBlockData(&testBlockV1{List: []uint64{i}}))
+	}
+	assert.NilError(t, blRW.Close())
+	rwfile.Close()
+
+	entry, err = journal.Load()
+	assert.NilError(t, err)
+	assert.Equal(t, entry.State, JournalClosed)
+
+	rfile, blReader = openTestBlockList(t, fileName, WithWriteJournal(journal))
+	defer rfile.Close()
+	assert.Assert(t, blReader.IsSealed())
+	testReadAllBlocks(t, blReader, 5)
+}
+
+func TestInterpolationSearch(t *testing.T) {
+	fileName := "/tmp/blocklistinterpolation_test"
+	defer os.Remove(fileName)
+
+	keyRange := func(blockData interface{}) (float64, float64, error) {
+		list := blockData.(*testBlockV1).List
+		return float64(list[0]), float64(list[len(list)-1]), nil
+	}
+	probes := 0
+	comparator := func(value interface{}, blockData interface{}) (int, error) {
+		probes++
+		return BlockTestComparator(value, blockData)
+	}
+
+	// Block i holds the values key(i) and key(i)+2
+	for _, key := range []func(i uint64) uint64{
+		func(i uint64) uint64 { return 10 * i },
+		func(i uint64) uint64 { return i * i * i },
+	} {
+		const blocks = 1000
+		file, err := os.Create(fileName)
+		assert.NilError(t, err)
+		blWriter, err := NewBlockListWriterV1(file, 64, 0)
+		assert.NilError(t, err)
+		for i := uint64(0); i < blocks; i++ {
+			assert.NilError(t, blWriter.WriteBlockData(&testBlockV1{List: []uint64{3 * key(i), 3*key(i) + 2}}))
+		}
+		assert.NilError(t, blWriter.Close())
+		file.Close()
+
+		file, blReader := openTestBlockList(t, fileName, WithInterpolationSearch(keyRange))
+		binFile, binReader := openTestBlockList(t, fileName)
+		interpolationProbes, binaryProbes := 0, 0
+		for i := uint64(0); i < blocks; i += 7 {
+			for _, value := range []uint64{3 * key(i), 3*key(i) + 1, 3*key(i) + 4} {
+				probes = 0
+				index, found, err := blReader.SearchBinaryIndex(value, comparator)
+				assert.NilError(t, err)
+				interpolationProbes += probes
+
+				probes = 0
+				expectedIndex, expectedFound, err := binReader.SearchBinaryIndex(value, comparator)
+				assert.NilError(t, err)
+				binaryProbes += probes
+
+				assert.Equal(t, index, expectedIndex)
+				assert.Equal(t, found, expectedFound)
+			}
+		}
+
+		blockData, _, err := blReader.SearchBinary(3*key(500)+2, BlockTestComparator)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, blockData.(*testBlockV1).List, []uint64{3 * key(500), 3*key(500) + 2})
+		blockData, _, err = blReader.SearchBinary(3*key(500)+3, BlockTestComparator)
+		assert.NilError(t, err)
+		assert.Assert(t, blockData == nil)
+
+		// Uniform keys take fewer probes, and others at most twice as many
+		if key(2) == 20 {
+			assert.Assert(t, interpolationProbes*3 < binaryProbes*2,
+				"%v interpolation probes, %v binary probes", interpolationProbes, binaryProbes)
+		} else {
+			assert.Assert(t, interpolationProbes <= 2*binaryProbes,
+				"%v interpolation probes, %v binary probes", interpolationProbes, binaryProbes)
+		}
+
+		_, _, err = blReader.SearchBinaryIndex("key", comparator)
+		assert.Assert(t, err != nil)
+		file.Close()
+		binFile.Close()
+	}
+}
+
+func TestPaddingRand(t *testing.T) {
+	fileName := "/tmp/blocklistpaddingrand_test"
+	defer os.Remove(fileName)
+
+	writeList := func(opts ...BlockListOption) []byte {
+		writeTestBlockList(t, fileName, 64, 5, opts...)
+		list, err := ioutil.ReadFile(fileName)
+		assert.NilError(t, err)
+		return list
+	}
+
+	// The same source gives the same list
+	list := writeList(WithPaddingRand(rand.New(rand.NewSource(1))))
+	assert.DeepEqual(t, writeList(WithPaddingRand(rand.New(rand.NewSource(1)))), list)
+	assert.Assert(t, !bytes.Equal(writeList(), writeList()))
+
+	// The padding comes from the source
+	zeros := writeList(WithPaddingRand(bytes.NewReader(make([]byte, 5*64))))
+	blockLen := blockHeaderLen + uint32(len(`{"List":[0]}`))
+	assert.Assert(t, allZeros(zeros[blockListHeaderLen+blockLen:blockListHeaderLen+64]))
+
+	// A source that runs out fails the write
+	file, err := os.Create(fileName)
+	assert.NilError(t, err)
+	defer file.Close()
+	blWriter, err := NewBlockListWriterV1(file, 64, 0, WithPaddingRand(bytes.NewReader(make([]byte, 10))))
+	assert.NilError(t, err)
+	assert.Assert(t, blWriter.WriteBlockData(&testBlockV1{List: []uint64{0}}) != nil)
+
+	_, err = NewBlockListWriterV1(file, 64, 0, WithPaddingRand(nil))
+	assert.Assert(t, err != nil)
+}
+
+func TestVectoredWrite(t *testing.T) {
+	fileName := "/tmp/blocklistvectored_test"
+	defer os.Remove(fileName)
+
+	type bigBlock struct {
+		ID   int
+		Data []byte
+	}
+	rng := rand.New(rand.NewSource(1))
+	blocks := make([]*bigBlock, 4)
+	for i := range blocks {
+		// Random data does not gzip, so big blocks stay big
+		data := make([]byte, vectoredWriteMinSize+rng.Intn(vectoredWriteMinSize))
+		rng.Read(data)
+		blocks[i] = &bigBlock{ID: i, Data: data}
+	}
+
+	for _, padded := range []uint32{0, 256 * 1024} {
+		file, err := os.Create(fileName)
+		assert.NilError(t, err)
+		blWriter, err := NewBlockListWriterV1(file, padded, 0, WithBackPointers())
+		assert.NilError(t, err)
+		// Small blocks are copied between the big ones
+		for i, block := range blocks {
+			assert.NilError(t, blWriter.WriteBlockData(block))
+			assert.NilError(t, blWriter.WriteBlockData(&bigBlock{ID: i}))
+		}
+		assert.NilError(t, blWriter.Close())
+		file.Close()
+
+		file, err = os.Open(fileName)
+		assert.NilError(t, err)
+		stat, err := file.Stat()
+		assert.NilError(t, err)
+		assert.Equal(t, uint64(stat.Size()), blWriter.BytesWritten())
+		blReader, err := NewBlockListReaderV1(file, 0, uint64(stat.Size()),
+			func() interface{} { return &bigBlock{} })
+		assert.NilError(t, err)
+
+		assert.NilError(t, blReader.ResetToEnd())
+		for i := len(blocks) - 1; i >= 0; i-- {
+			blockData, _, err := blReader.ReadPrevBlockData()
+			assert.NilError(t, err)
+			assert.DeepEqual(t, blockData, &bigBlock{ID: i})
+			blockData, _, err = blReader.ReadPrevBlockData()
+			assert.NilError(t, err)
+			assert.DeepEqual(t, blockData, blocks[i])
+		}
+		_, _, err = blReader.ReadPrevBlockData()
+		assert.Equal(t, err, io.EOF)
+		file.Close()
+	}
+}
+
+func TestGunzippedBlockDataLimit(t *testing.T) {
+	fileName := "/tmp/blocklistgunziplimit_test"
+	defer os.Remove(fileName)
+	defer func(maxSize int64) { MaxGunzippedBlockDataSize = maxSize }(MaxGunzippedBlockDataSize)
+
+	writeTestBlockList(t, fileName, 0, 3)
+	blockLen := int64(len(`{"List":[0]}`))
+
+	MaxGunzippedBlockDataSize = blockLen
+	file, blReader := openTestBlockList(t, fileName)
+	testReadAllBlocks(t, blReader, 3)
+	file.Close()
+
+	MaxGunzippedBlockDataSize = blockLen - 1
+	file, blReader = openTestBlockList(t, fileName)
+	defer file.Close()
+	_, _, err := blReader.ReadNextBlockData()
+	assert.Assert(t, errs.Is(err, errs.ErrTooLarge))
+}
+
+type syncFile struct {
+	*os.File
+	syncs int
+}
+
+func (f *syncFile) Sync() error {
+	f.syncs++
+	return f.File.Sync()
+}
+
+func TestSyncPolicy(t *testing.T) {
+	fileName := "/tmp/blocklistsync_test"
+	defer os.Remove(fileName)
+
+	writeBlocks := func(blocks int, opts ...BlockListOption) int {
+		file, err := os.Create(fileName)
+		assert.NilError(t, err)
+		defer file.Close()
+		store := &syncFile{File: file}
+		blWriter, err := NewBlockListWriterV1(store, 64, 0, opts...)
+		assert.NilError(t, err)
+		for i := 0; i < blocks; i++ {
+			assert.NilError(t, blWriter.WriteBlockData(&testBlockV1{List: []uint64{uint64(i)}}))
+		}
+		assert.NilError(t, blWriter.Close())
+		return store.syncs
+	}
+
+	assert.Equal(t, writeBlocks(10), 0)
+	assert.Equal(t, writeBlocks(10, WithSyncEveryNBlocks(1)), 10)
+	assert.Equal(t, writeBlocks(10, WithSyncEveryNBlocks(3)), 3)
+	assert.Equal(t, writeBlocks(10, WithSyncEveryNBlocks(3), WithSyncOnClose()), 4)
+	assert.Equal(t, writeBlocks(10, WithSyncOnClose(), WithFooter()), 1)
+	assert.Equal(t, writeBlocks(10, WithSyncEvery(time.Hour)), 0)
+
+	file, err := os.Create(fileName)
+	assert.NilError(t, err)
+	defer file.Close()
+	store := &syncFile{File: file}
+	blWriter, err := NewBlockListWriterV1(store, 64, 0, WithSyncEvery(10*time.Millisecond))
+	assert.NilError(t, err)
+	for i := 0; i < 3; i++ {
+		assert.NilError(t, blWriter.WriteBlockData(&testBlockV1{List: []uint64{uint64(i)}}))
+		time.Sleep(15 * time.Millisecond)
+	}
+	// The first write starts the interval
+	assert.Equal(t, store.syncs, 2)
+
+	_, err = NewBlockListWriterV1(file, 64, 0, WithSyncEveryNBlocks(0))
+	assert.Assert(t, err != nil)
+	_, err = NewBlockListWriterV1(file, 64, 0, WithSyncEvery(0))
+	assert.Assert(t, err != nil)
+}
+
+// testLogger records the messages logged by a block list
+type testLogger struct {
+	debug []string
+	warn  []string
+}
+
+func (l *testLogger) Debugf(format string, args ...interface{}) {
+	l.debug = append(l.debug, fmt.Sprintf(format, args...))
+}
+
+func (l *testLogger) Warnf(format string, args ...interface{}) {
+	l.warn = append(l.warn, fmt.Sprintf(format, args...))
+}
+
+func TestLogger(t *testing.T) {
+	fileName := "/tmp/blocklistlogger_test"
+	defer os.Remove(fileName)
+	writeTestBlockList(t, fileName, 64, 10)
+
+	// Copy block 4 over block 3
+	stored, err := ioutil.ReadFile(fileName)
+	assert.NilError(t, err)
+	first := len(stored) - 10*64
+	copy(stored[first+3*64:], stored[first+4*64:first+5*64])
+	assert.NilError(t, ioutil.WriteFile(fileName, stored, 0600))
+
+	logger := &testLogger{}
+	file, blReader := openTestBlockList(t, fileName, WithLogger(logger))
+	_, _, err = blReader.ReadBlockDataAt(3)
+	assert.Assert(t, errs.Is(err, errs.ErrCorrupt))
+	assert.Equal(t, len(logger.warn), 1)
+	assert.Assert(t, strings.Contains(logger.warn[0], "Block ID(4)"))
+	file.Close()
+
+	// Retries are logged
+	writeTestBlockList(t, fileName, 64, 10)
+	file, err = os.Open(fileName)
+	assert.NilError(t, err)
+	stat, err := file.Stat()
+	assert.NilError(t, err)
+	logger = &testLogger{}
+	blReader, err = NewBlockListReaderV1(&flakyFile{File: file}, 0, uint64(stat.Size()),
+		initEmptyBlockData, WithRetry(tools.RetryPolicy{MaxAttempts: 2}), WithLogger(logger))
+	assert.NilError(t, err)
+	_, _, err = blReader.ReadBlockDataAt(3)
+	assert.NilError(t, err)
+	assert.Equal(t, len(logger.debug), 1)
+	assert.Assert(t, strings.Contains(logger.debug[0], "attempt 1"))
+	assert.Equal(t, len(logger.warn), 0)
+	file.Close()
+
+	// So is reading a list without its footer
+	writeTestBlockList(t, fileName, 64, 10, WithFooter())
+	stored, err = ioutil.ReadFile(fileName)
+	assert.NilError(t, err)
+	assert.NilError(t, ioutil.WriteFile(fileName, stored[:len(stored)-1], 0600))
+	logger = &testLogger{}
+	file, _ = openTestBlockList(t, fileName, WithMissingFooter(), WithLogger(logger))
+	defer file.Close()
+	assert.Equal(t, len(logger.warn), 1)
+	assert.Assert(t, strings.Contains(logger.warn[0], "without its footer"))
+}
+
+func TestSearchKey(t *testing.T) {
+	fileName := "/tmp/blocklistsearchkey_test"
+	defer os.Remove(fileName)
+
+	file, err := os.Create(fileName)
+	assert.NilError(t, err)
+	blWriter, err := NewBlockListWriterV1(file, 0, 0, WithKeyIndex())
+	assert.NilError(t, err)
+	// Block i has the keys k<2i>0 to k<2i>9, block 5 shares its first key
+	// with block 4, and block 6 has no keys
+	for i := 0; i < 10; i++ {
+		var keys [][]byte
+		for j := 0; j < 10 && i != 6; j++ {
+			keys = append(keys, []byte(fmt.Sprintf("k%02d%d", 2*i, j)))
+		}
+		if i == 5 {
+			keys = append(keys, []byte("k089"))
+		}
+		err = blWriter.WriteBlockDataWithKeys(&testBlockV1{List: []uint64{uint64(i)}}, keys)
+		assert.NilError(t, err)
+	}
+	assert.NilError(t, blWriter.Close())
+	file.Close()
+
+	file, blReader := openTestBlockList(t, fileName)
+	defer file.Close()
+	for _, test := range []struct {
+		key   string
+		index uint32
+		found bool
+	}{
+		{"a", 0, false},
+		{"k000", 0, true},
+		{"k025", 1, true},
+		{"k029", 1, true},
+		{"k030", 2, false},
+		{"k089", 4, true},
+		{"k100", 5, true},
+		{"k125", 7, false},
+		{"k140", 7, true},
+		{"k189", 9, true},
+		{"z", 10, false},
+	} {
+		index, found, err := blReader.SearchKey([]byte(test.key))
+		assert.NilError(t, err)
+		assert.Equal(t, index, test.index, test.key)
+		assert.Equal(t, found, test.found, test.key)
+	}
+
+	// Lists without a key index can not be searched
+	file.Close()
+	writeTestBlockList(t, fileName, 0, 3)
+	file, blReader = openTestBlockList(t, fileName)
+	defer file.Close()
+	_, _, err = blReader.SearchKey([]byte("k000"))
+	assert.Assert(t, err != nil)
+}
+
+func TestFormatProfile(t *testing.T) {
+	fileName := "/tmp/blocklistprofile_test"
+	defer os.Remove(fileName)
+
+	var fixedSize uint64
+	for _, opts := range [][]BlockListOption{
+		{WithFormatProfile(BigEndianFixed)},
+		{WithFormatProfile(VarintCompact)},
+		{WithFormatProfile(VarintCompact), WithBackPointers(), WithTimestamps(), WithFooter()},
+	} {
+		file, err := os.Create(fileName)
+		assert.NilError(t, err)
+		blWriter, err := NewBlockListWriterV1(file, 0, 0, opts...)
+		assert.NilError(t, err)
+		for i := 0; i < 300; i++ {
+			assert.NilError(t, blWriter.WriteBlockData(&testBlockV1{List: []uint64{uint64(i)}}))
+		}
+		assert.NilError(t, blWriter.Close())
+		written := blWriter.BytesWritten()
+		file.Close()
+
+		file, blReader := openTestBlockList(t, fileName)
+		if len(opts) == 1 && blReader.GetFormatProfile() == BigEndianFixed {
+			fixedSize = written
+		} else if len(opts) == 1 {
+			assert.Equal(t, blReader.GetFormatProfile(), VarintCompact)
+			// IDs below 128 take 1 byte, and the others 2, and each size 1
+			assert.Equal(t, fixedSize-written, uint64(128*6+172*5-4))
+		}
+		testReadAllBlocks(t, blReader, 300)
+		if len(opts) > 1 {
+			assert.NilError(t, blReader.ResetToEnd())
+			for i := 299; i >= 0; i-- {
+				blockData, _, err := blReader.ReadPrevBlockData()
+				assert.NilError(t, err)
+				assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(i))
+			}
+		}
+		file.Close()
+	}
+
+	// Padded and encrypted lists can not be compact
+	_, err := NewBlockListWriterV1(&bytes.Buffer{}, 64, 0, WithFormatProfile(VarintCompact))
+	assert.Assert(t, err != nil)
+	_, err = NewBlockListWriterV1(&bytes.Buffer{}, 0, 0, WithFormatProfile(VarintCompact),
+		WithAESGCM(make([]byte, 32)))
+	assert.Assert(t, err != nil)
+	_, err = NewBlockListWriterV1(&bytes.Buffer{}, 0, 0, WithFormatProfile(FormatProfile(5)))
+	assert.Assert(t, err != nil)
+}
+
+func TestRepair(t *testing.T) {
+	fileName := "/tmp/blocklistrepair_test"
+	replicaName := "/tmp/blocklistrepair_replica_test"
+	defer os.Remove(fileName)
+	defer os.Remove(replicaName)
+	writeTestBlockList(t, replicaName, 64, 10)
+	replica, err := ioutil.ReadFile(replicaName)
+	assert.NilError(t, err)
+
+	// Block 3 has a corrupted size, and block 5 the ID of another block
+	corrupt := func() {
+		serial := append([]byte{}, replica...)
+		file, reader := openTestBlockList(t, replicaName)
+		defer file.Close()
+		offset, _, err := reader.BlockExtent(3)
+		assert.NilError(t, err)
+		binary.BigEndian.PutUint32(serial[offset+4:], 1000)
+		offset, _, err = reader.BlockExtent(5)
+		assert.NilError(t, err)
+		binary.BigEndian.PutUint32(serial[offset:], 6)
+		assert.NilError(t, ioutil.WriteFile(fileName, serial, 0644))
+	}
+	corrupt()
+
+	var repaired []uint32
+	repair := func(index uint32, err error) ([]byte, error) {
+		assert.Assert(t, errs.Is(err, errs.ErrCorrupt))
+		repaired = append(repaired, index)
+		file, reader := openTestBlockList(t, replicaName)
+		defer file.Close()
+		offset, length, err := reader.BlockExtent(index)
+		if err != nil {
+			return nil, err
+		}
+		blockBytes := make([]byte, length)
+		_, err = file.ReadAt(blockBytes, int64(offset))
+		return blockBytes, err
+	}
+
+	file, blReader := openTestBlockList(t, fileName, WithRepair(repair, nil))
+	defer file.Close()
+	for _, i := range []uint32{3, 5} {
+		blockData, _, err := blReader.ReadBlockDataAt(i)
+		assert.NilError(t, err)
+		assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(i))
+	}
+	assert.DeepEqual(t, repaired, []uint32{3, 5})
+
+	// Sequential reads repair the blocks too
+	repaired = nil
+	assert.NilError(t, blReader.Reset())
+	testReadAllBlocks(t, blReader, 10)
+	assert.DeepEqual(t, repaired, []uint32{3, 5})
+
+	// Without the option, the blocks fail
+	file2, blReader := openTestBlockList(t, fileName)
+	defer file2.Close()
+	_, _, err = blReader.ReadBlockDataAt(3)
+	assert.Assert(t, errs.Is(err, errs.ErrCorrupt))
+
+	// Errors of the repair function are returned
+	unavailable := errors.New("replica unavailable")
+	file3, blReader := openTestBlockList(t, fileName, WithRepair(func(uint32, error) ([]byte, error) {
+		return nil, unavailable
+	}, nil))
+	defer file3.Close()
+	_, _, err = blReader.ReadBlockDataAt(3)
+	assert.Assert(t, errors.Is(err, unavailable))
+
+	// The repaired blocks can be written back
+	writeBack, err := os.OpenFile(fileName, os.O_RDWR, 0644)
+	assert.NilError(t, err)
+	defer writeBack.Close()
+	file4, blReader := openTestBlockList(t, fileName, WithRepair(repair, writeBack))
+	defer file4.Close()
+	_, _, err = blReader.ReadBlockDataAt(3)
+	assert.NilError(t, err)
+	_, _, err = blReader.ReadBlockDataAt(5)
+	assert.NilError(t, err)
+	serial, err := ioutil.ReadFile(fileName)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, serial, replica)
+}
+
+// countingReaderAt counts the reads of the storage
+type countingReaderAt struct {
+	readerat io.ReaderAt
+	reads    int
+}
+
+func (r *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.reads++
+	return r.readerat.ReadAt(p, off)
+}
+
+func TestCachingReaderAt(t *testing.T) {
+	remote := make([]byte, 1000)
+	rand.New(rand.NewSource(1)).Read(remote)
+	cacheFile, err := ioutil.TempFile("", "blockcache")
+	assert.NilError(t, err)
+	defer os.Remove(cacheFile.Name())
+	defer cacheFile.Close()
+
+	counting := &countingReaderAt{readerat: bytes.NewReader(remote)}
+	cached, err := NewCachingReaderAt(counting, cacheFile, 64, 10)
+	assert.NilError(t, err)
+
+	// Reads that span pages, and reads at the end of the storage
+	for _, r := range []struct{ off, n int }{{0, 5}, {5, 100}, {70, 64}, {300, 700}, {990, 10}} {
+		p := make([]byte, r.n)
+		n, err := cached.ReadAt(p, int64(r.off))
+		assert.NilError(t, err)
+		assert.Equal(t, n, r.n)
+		assert.DeepEqual(t, p, remote[r.off:r.off+r.n])
+	}
+	p := make([]byte, 20)
+	n, err := cached.ReadAt(p, 990)
+	assert.Equal(t, err, io.EOF)
+	assert.Equal(t, n, 10)
+	assert.DeepEqual(t, p[:n], remote[990:])
+	_, err = cached.ReadAt(p, 1000)
+	assert.Equal(t, err, io.EOF)
+
+	// Only the two pages never read, and the last page, which is short and
+	// never cached, are read again
+	reads := counting.reads
+	p = make([]byte, 990)
+	_, err = cached.ReadAt(p, 0)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, p, remote[:990])
+	assert.Equal(t, counting.reads, reads+3)
+
+	_, err = NewCachingReaderAt(counting, cacheFile, 0, 0)
+	assert.Assert(t, err != nil)
+}
+
+func TestReadCache(t *testing.T) {
+	fileName := "/tmp/blocklistreadcache_test"
+	defer os.Remove(fileName)
+	writeTestBlockList(t, fileName, 64, 10, WithMetadata([]byte("list metadata")))
+	serial, err := ioutil.ReadFile(fileName)
+	assert.NilError(t, err)
+
+	cacheFile, err := ioutil.TempFile("", "blockcache")
+	assert.NilError(t, err)
+	defer os.Remove(cacheFile.Name())
+	defer cacheFile.Close()
+
+	counting := &countingReaderAt{readerat: bytes.NewReader(serial)}
+	store := io.NewSectionReader(counting, 0, int64(len(serial)))
+	blReader, err := NewBlockListReaderV1(store, 0, uint64(len(serial)), initEmptyBlockData,
+		WithReadCache(cacheFile))
+	assert.NilError(t, err)
+
+	// Cold blocks are fetched once, one read per block
+	for pass := 0; pass < 2; pass++ {
+		reads := counting.reads
+		for i := uint32(0); i < 10; i++ {
+			blockData, _, err := blReader.ReadBlockDataAt(i)
+			assert.NilError(t, err)
+			assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(i))
+		}
+		if pass == 0 {
+			assert.Equal(t, counting.reads, reads+10)
+		} else {
+			assert.Equal(t, counting.reads, reads)
+		}
+	}
+	hits, misses := blReader.(*blockListV1).readerat.(*CachingReaderAt).Stats()
+	assert.Equal(t, hits, uint64(10))
+	assert.Equal(t, misses, uint64(10))
+
+	// Lists without padding can not be cached
+	writeTestBlockList(t, fileName, 0, 10)
+	file, err := os.Open(fileName)
+	assert.NilError(t, err)
+	defer file.Close()
+	stat, err := file.Stat()
+	assert.NilError(t, err)
+	_, err = NewBlockListReaderV1(file, 0, uint64(stat.Size()), initEmptyBlockData, WithReadCache(cacheFile))
+	assert.Assert(t, err != nil)
+}
+
+// zeroReader reads zeros
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestCompressedList(t *testing.T) {
+	fileName := "/tmp/blocklistcompressed_test"
+	defer os.Remove(fileName)
+	file, err := os.Create(fileName)
+	assert.NilError(t, err)
+	defer file.Close()
+
+	// Frames of 4 padded blocks, and a last frame of 2 blocks and the footer
+	compressed := NewCompressedWriter(file, 4*256)
+	blWriter, err := NewBlockListWriterV1(compressed, 256, 0, WithFooter(),
+		WithPaddingRand(zeroReader{}))
+	assert.NilError(t, err)
+	for i := 0; i < 50; i++ {
+		assert.NilError(t, blWriter.WriteBlockData(&testBlockV1{List: []uint64{uint64(i)}}))
+	}
+	assert.NilError(t, blWriter.Close())
+	assert.NilError(t, compressed.Close())
+	_, err = compressed.Write([]byte{1})
+	assert.Assert(t, errs.Is(err, ErrSealed))
+
+	stat, err := file.Stat()
+	assert.NilError(t, err)
+	assert.Assert(t, stat.Size() < 50*256/4, "%v bytes", stat.Size())
+
+	reader, err := NewCompressedReader(file, stat.Size())
+	assert.NilError(t, err)
+	assert.Assert(t, reader.Size() > 50*256)
+	store := io.NewSectionReader(reader, 0, reader.Size())
+	blReader, err := NewBlockListReaderV1(store, 0, uint64(reader.Size()), initEmptyBlockData)
+	assert.NilError(t, err)
+	testReadAllBlocks(t, blReader, 50)
+	for _, i := range []uint32{49, 0, 17, 18, 3} {
+		blockData, _, err := blReader.ReadBlockDataAt(i)
+		assert.NilError(t, err)
+		assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(i))
+	}
+
+	// The index is checked
+	serial, err := ioutil.ReadFile(fileName)
+	assert.NilError(t, err)
+	serial[len(serial)-compressedTrailerLen-1] ^= 1
+	_, err = NewCompressedReader(bytes.NewReader(serial), int64(len(serial)))
+	assert.Assert(t, errs.Is(err, errs.ErrCorrupt))
+	_, err = NewCompressedReader(bytes.NewReader(serial[:8]), 8)
+	assert.Assert(t, errs.Is(err, errs.ErrTruncated))
+
+	// Frames that do not match the index are corrupted
+	serial, err = ioutil.ReadFile(fileName)
+	assert.NilError(t, err)
+	serial[20] ^= 0xff
+	reader, err = NewCompressedReader(bytes.NewReader(serial), int64(len(serial)))
+	assert.NilError(t, err)
+	_, err = reader.ReadAt(make([]byte, 10), 0)
+	assert.Assert(t, errs.Is(err, errs.ErrCorrupt))
+}
+
+func TestCompressedWriterReadAt(t *testing.T) {
+	var buf bytes.Buffer
+	compressed := NewCompressedWriter(&buf, 10)
+	data := []byte("the quick brown fox jumps over the lazy dog")
+	_, err := compressed.Write(data)
+	assert.NilError(t, err)
+
+	// Without io.ReaderAt, only the pending frame can be read back
+	p := make([]byte, 3)
+	n, err := compressed.ReadAt(p, 40)
+	assert.NilError(t, err)
+	assert.Equal(t, string(p[:n]), "dog")
+	_, err = compressed.ReadAt(p, 0)
+	assert.Assert(t, err != nil)
+	assert.NilError(t, compressed.Close())
+
+	reader, err := NewCompressedReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.NilError(t, err)
+	p = make([]byte, len(data)+5)
+	n, err = reader.ReadAt(p, 0)
+	assert.Equal(t, err, io.EOF)
+	assert.DeepEqual(t, p[:n], data)
+}
+
+func TestPaddingStats(t *testing.T) {
+	fileName := "/tmp/blocklistpaddingstats_test"
+	file, err := os.Create(fileName)
+	assert.NilError(t, err)
+	defer os.Remove(fileName)
+	defer file.Close()
+
+	paddedBlockSize := uint32(128)
+	blWriter, err := NewBlockListWriterV1(file, paddedBlockSize, 0)
+	assert.NilError(t, err)
+	stats := blWriter.PaddingStats()
+	assert.Equal(t, stats.Blocks, uint64(0))
+	assert.Equal(t, stats.Amplification(), float64(0))
+
+	// Small blocks and one block that fills its padded size
+	var payload uint64
+	for i := 0; i < 20; i++ {
+		data := []byte(fmt.Sprintf(`{"List":[%v]}`, i))
+		block, _, err := blWriter.AppendBlockData(&testBlockV1{List: []uint64{uint64(i)}})
+		assert.NilError(t, err)
+		assert.Equal(t, block.GetSize(), uint32(len(data)))
+		payload += uint64(len(data))
+	}
+	full := make([]byte, blWriter.GetMaxDataSize())
+	_, err = blWriter.writeBlockDataBytes(full)
+	assert.NilError(t, err)
+	payload += uint64(len(full))
+
+	stats = blWriter.PaddingStats()
+	assert.Equal(t, stats.Blocks, uint64(21))
+	assert.Equal(t, stats.PayloadBytes, payload)
+	assert.Equal(t, stats.BlockBytes, uint64(21*paddedBlockSize))
+	assert.Equal(t, stats.BytesWritten, blWriter.BytesWritten())
+	assert.Equal(t, stats.MaxPayload, blWriter.GetMaxDataSize())
+	assert.Equal(t, stats.Amplification(), float64(stats.BytesWritten)/float64(payload))
+	assert.Assert(t, stats.Amplification() > 2)
+
+	// The blocks of 12 bytes of data waste over 90% of their bytes, the
+	// blocks of 13 bytes 80-90%, and the full block less than 10%
+	assert.Equal(t, stats.WasteHistogram[9], uint64(10), "%v", stats.WasteHistogram)
+	assert.Equal(t, stats.WasteHistogram[8], uint64(10), "%v", stats.WasteHistogram)
+	assert.Equal(t, stats.WasteHistogram[0], uint64(1), "%v", stats.WasteHistogram)
+
+	assert.NilError(t, blWriter.Close())
+	stat, err := file.Stat()
+	assert.NilError(t, err)
+	assert.Equal(t, blWriter.PaddingStats().BytesWritten, uint64(stat.Size()))
+}
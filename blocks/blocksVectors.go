@@ -0,0 +1,190 @@
+package blocks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/go-errors/errors"
+	"github.com/overnest/strongsalt-common-go/tools"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// TestVectorManifest is the name of the manifest file written by
+// GenerateTestVectors
+const TestVectorManifest = "manifest.json"
+
+// TestVector describes one block list written by GenerateTestVectors. Options
+// are the names of the writer options the list was written with: "footer"
+// for WithFooter and "backPointers" for WithBackPointers.
+type TestVector struct {
+	File            string            `json:"file"`
+	Version         uint32            `json:"version"`
+	PaddedBlockSize uint32            `json:"paddedBlockSize"`
+	Options         []string          `json:"options"`
+	Blocks          []TestVectorBlock `json:"blocks"`
+}
+
+// TestVectorBlock is the data of each block in a test vector block list
+type TestVectorBlock struct {
+	Name   string   `json:"name"`
+	Values []uint64 `json:"values"`
+}
+
+var testVectorOptions = map[string]BlockListOption{
+	"footer":       WithFooter(),
+	"backPointers": WithBackPointers(),
+}
+
+// GenerateTestVectors writes padded and unpadded block lists with known
+// contents into dir, along with a manifest describing them. The files let
+// implementations in other languages, and later versions of this package,
+// check that they read the same block lists.
+func GenerateTestVectors(dir string) error {
+	var blocks []TestVectorBlock
+	for i := uint64(0); i < 5; i++ {
+		blocks = append(blocks, TestVectorBlock{fmt.Sprintf("block%v", i), []uint64{i, i * i}})
+	}
+
+	vectors := []TestVector{
+		{File: "padded_v1.bin", Version: BlockListV1, PaddedBlockSize: 64},
+		{File: "unpadded_v1.bin", Version: BlockListV1},
+		{File: "padded_v2_footer.bin", Version: BlockListV2, PaddedBlockSize: 64,
+			Options: []string{"footer"}},
+		{File: "unpadded_v2_footer_backpointers.bin", Version: BlockListV2,
+			Options: []string{"footer", "backPointers"}},
+	}
+	for i := range vectors {
+		vectors[i].Blocks = blocks
+		if err := writeTestVector(filepath.Join(dir, vectors[i].File), vectors[i]); err != nil {
+			return err
+		}
+	}
+
+	manifest, err := json.MarshalIndent(vectors, "", "  ")
+	if err != nil {
+		return errs.Wrap(err, nil)
+	}
+	return errs.Wrap(ioutil.WriteFile(filepath.Join(dir, TestVectorManifest), manifest, 0644), nil)
+}
+
+// VerifyTestVectors reads the block lists described by the manifest in dir,
+// and checks that they have the described version and blocks. The lists are
+// compared block by block rather than byte by byte, since padding is random
+// and gzip implementations do not all compress the same way.
+func VerifyTestVectors(dir string) error {
+	manifest, err := ioutil.ReadFile(filepath.Join(dir, TestVectorManifest))
+	if err != nil {
+		return errs.Wrap(err, nil)
+	}
+
+	var vectors []TestVector
+	if err = json.Unmarshal(manifest, &vectors); err != nil {
+		return errs.Wrap(err, errs.ErrCorrupt)
+	}
+
+	for _, vector := range vectors {
+		if err = verifyTestVector(filepath.Join(dir, vector.File), vector); err != nil {
+			return errs.WrapPrefix(err, nil, fmt.Sprintf("Test vector %v", vector.File))
+		}
+	}
+	return nil
+}
+
+func testVectorListOptions(vector TestVector) ([]BlockListOption, error) {
+	var opts []BlockListOption
+	for _, name := range vector.Options {
+		opt, ok := testVectorOptions[name]
+		if !ok {
+			return nil, errors.Errorf("Unknown block list option %v", name)
+		}
+		opts = append(opts, opt)
+	}
+	return opts, nil
+}
+
+// writeTestVector writes the block list described by the test vector
+func writeTestVector(fileName string, vector TestVector) error {
+	opts, err := testVectorListOptions(vector)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(fileName)
+	if err != nil {
+		return errs.Wrap(err, nil)
+	}
+	defer file.Close()
+
+	writer, err := NewBlockListWriterV1(file, vector.PaddedBlockSize, 0, opts...)
+	if err != nil {
+		return err
+	}
+	for i := range vector.Blocks {
+		if err = writer.WriteBlockData(&vector.Blocks[i]); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+func verifyTestVector(fileName string, vector TestVector) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return errs.Wrap(err, nil)
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return errs.Wrap(err, nil)
+	}
+
+	reader, err := NewBlockListReaderV1(file, 0, uint64(stat.Size()),
+		func() interface{} { return &TestVectorBlock{} })
+	if err != nil {
+		return err
+	}
+	if reader.GetVersion() != vector.Version {
+		return errs.Errorf(errs.ErrCorrupt, "Block list version(%v) is not %v",
+			reader.GetVersion(), vector.Version)
+	}
+	if reader.GetPaddedBlockSize() != vector.PaddedBlockSize {
+		return errs.Errorf(errs.ErrCorrupt, "Padded block size(%v) is not %v",
+			reader.GetPaddedBlockSize(), vector.PaddedBlockSize)
+	}
+
+	for i := 0; ; i++ {
+		blockData, _, err := reader.ReadNextBlockData()
+		if err == io.EOF {
+			if i != len(vector.Blocks) {
+				return errs.Errorf(errs.ErrCorrupt, "The block list has %v of the %v blocks",
+					i, len(vector.Blocks))
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if i >= len(vector.Blocks) {
+			return errs.Errorf(errs.ErrCorrupt, "The block list has more than %v blocks",
+				len(vector.Blocks))
+		}
+
+		actual, err := tools.Marshal(blockData)
+		if err != nil {
+			return errs.Wrap(err, nil)
+		}
+		expected, err := tools.Marshal(&vector.Blocks[i])
+		if err != nil {
+			return errs.Wrap(err, nil)
+		}
+		if !bytes.Equal(actual, expected) {
+			return errs.Errorf(errs.ErrCorrupt, "Block %v data does not match", i)
+		}
+	}
+}
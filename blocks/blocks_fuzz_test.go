@@ -0,0 +1,83 @@
+//go:build go1.18
+// +build go1.18
+
+package blocks
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func FuzzDeserializeBlockV1(f *testing.F) {
+	block := newBlock(1, 3, []byte("abc"))
+	for _, pad := range []uint32{0, 32} {
+		serial, err := block.Serialize(pad)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(pad, serial)
+	}
+
+	f.Fuzz(func(t *testing.T, paddedBlockSize uint32, data []byte) {
+		blk, err := DeserializeBlockV1(paddedBlockSize, data)
+		if err == nil && uint64(blk.GetSize()) > uint64(len(data)) {
+			t.Fatalf("Block size(%v) is bigger than the data size(%v)", blk.GetSize(), len(data))
+		}
+	})
+}
+
+func FuzzBlockListReaderV1(f *testing.F) {
+	for _, pad := range []uint32{0, 64} {
+		var buf bytes.Buffer
+		writer, err := NewBlockListWriterV1(&readerAtBuffer{&buf}, pad, 0, WithFooter())
+		if err != nil {
+			f.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			if err = writer.WriteBlockData(&testBlockV1{List: []uint64{uint64(i)}}); err != nil {
+				f.Fatal(err)
+			}
+		}
+		if err = writer.Close(); err != nil {
+			f.Fatal(err)
+		}
+		f.Add(buf.Bytes())
+	}
+
+	// A hostile block size must not cause a huge allocation
+	hostile := make([]byte, blockListHeaderLen+blockHeaderLen)
+	binary.BigEndian.PutUint32(hostile, BlockListV1)
+	binary.BigEndian.PutUint32(hostile[blockListHeaderLen+blockNumLen:], 0xFFFFFFF0)
+	f.Add(hostile)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		reader, err := NewBlockListReaderV1(bytes.NewReader(data), 0, uint64(len(data)),
+			initEmptyBlockData)
+		if err != nil {
+			return
+		}
+		for i := 0; i <= len(data); i++ {
+			if _, err = reader.readNextBlock(); err != nil {
+				break
+			}
+		}
+		for i := 0; i <= len(data); i++ {
+			if _, err = reader.readPrevBlock(); err != nil {
+				break
+			}
+		}
+		if totalBlocks, err := reader.GetTotalBlocks(); err == nil && totalBlocks > 0 {
+			_, _ = reader.readBlockAt(totalBlocks - 1)
+		}
+	})
+}
+
+// readerAtBuffer is a bytes.Buffer that also implements io.ReaderAt
+type readerAtBuffer struct {
+	*bytes.Buffer
+}
+
+func (w *readerAtBuffer) ReadAt(p []byte, off int64) (int, error) {
+	return bytes.NewReader(w.Bytes()).ReadAt(p, off)
+}
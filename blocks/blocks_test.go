@@ -2,16 +2,21 @@ package blocks
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 
 	"github.com/go-errors/errors"
 	"github.com/overnest/strongsalt-common-go/tools"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
 	"gotest.tools/assert"
 )
 
@@ -45,6 +50,7 @@ func TestBlockV1(t *testing.T) {
 		if paddedBlockSize > 0 { // Fix sized blocks are turned on
 			if paddedBlockSize < dataSize+hdrSize {
 				assert.ErrorType(t, err, &BlockPaddingError{})
+				assert.Assert(t, errors.Is(err, errs.ErrTooLarge))
 				if paderr, ok := err.(*BlockPaddingError); !ok {
 					// Should never get here
 					assert.Assert(t, ok)
@@ -358,7 +364,7 @@ func testBlockListSearchV1(t *testing.T, padded bool, initOffset uint64) {
 }
 
 func testSearchV1(t *testing.T, value uint64, shouldExist bool, blReader BlockListReaderV1) {
-	blk, err := blReader.SearchLinear(value, BlockTestComparator)
+	blk, _, err := blReader.SearchLinear(value, BlockTestComparator)
 	assert.NilError(t, err)
 	if shouldExist {
 		assert.Assert(t, blk != nil)
@@ -366,9 +372,9 @@ func testSearchV1(t *testing.T, value uint64, shouldExist bool, blReader BlockLi
 		assert.Equal(t, blk, nil)
 	}
 
-	blk, err = blReader.SearchBinary(value, BlockTestComparator)
+	blk, _, err = blReader.SearchBinary(value, BlockTestComparator)
 	if err != nil {
-		fmt.Println(value, err.(*errors.Error).ErrorStack())
+		fmt.Println(value, err.(tools.ErrorStack).Stacktrace())
 	}
 	assert.NilError(t, err)
 	if shouldExist {
@@ -412,3 +418,1247 @@ func BlockTestComparator(value interface{}, blockData interface{}) (int, error)
 
 	return 0, nil
 }
+
+// writeTestBlockList writes a block list where block i holds the value i
+func writeTestBlockList(t *testing.T, fileName string, paddedBlockSize uint32, blocks int,
+	opts ...BlockListOption) {
+	file, err := os.Create(fileName)
+	assert.NilError(t, err)
+	defer file.Close()
+
+	blWriter, err := NewBlockListWriterV1(file, paddedBlockSize, 0, opts...)
+	assert.NilError(t, err)
+	for i := 0; i < blocks; i++ {
+		err = blWriter.WriteBlockData(&testBlockV1{List: []uint64{uint64(i)}})
+		assert.NilError(t, err)
+	}
+	assert.NilError(t, blWriter.Close())
+}
+
+// openTestBlockList opens a block list file written by a test for reading
+func openTestBlockList(t *testing.T, fileName string, opts ...BlockListOption) (*os.File, BlockListReaderV1) {
+	file, err := os.Open(fileName)
+	assert.NilError(t, err)
+	stat, err := file.Stat()
+	assert.NilError(t, err)
+
+	blReader, err := NewBlockListReaderV1(file, 0, uint64(stat.Size()), initEmptyBlockData, opts...)
+	assert.NilError(t, err)
+	return file, blReader
+}
+
+func TestBlockListReverseV1(t *testing.T) {
+	testBlockListReverseV1(t, 32)
+	testBlockListReverseV1(t, 0, WithBackPointers())
+}
+
+func testBlockListReverseV1(t *testing.T, paddedBlockSize uint32, opts ...BlockListOption) {
+	fileName := "/tmp/blocklistreversev1_test"
+	totalBlocks := 20
+	writeTestBlockList(t, fileName, paddedBlockSize, totalBlocks, opts...)
+	defer os.Remove(fileName)
+
+	file, blReader := openTestBlockList(t, fileName)
+	defer file.Close()
+
+	// Nothing before the beginning of the list
+	_, _, err := blReader.ReadPrevBlockData()
+	assert.Equal(t, err, io.EOF)
+
+	// Newest first
+	assert.NilError(t, blReader.ResetToEnd())
+	for i := totalBlocks - 1; i >= 0; i-- {
+		blockData, _, err := blReader.ReadPrevBlockData()
+		assert.NilError(t, err)
+		assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(i))
+		assert.Equal(t, blReader.GetCurBlock().GetID(), uint32(i))
+	}
+	_, _, err = blReader.ReadPrevBlockData()
+	assert.Equal(t, err, io.EOF)
+
+	// Changing direction returns the same block again
+	for i := 0; i < 3; i++ {
+		blockData, _, err := blReader.ReadNextBlockData()
+		assert.NilError(t, err)
+		assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(i))
+	}
+	blockData, _, err := blReader.ReadPrevBlockData()
+	assert.NilError(t, err)
+	assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(2))
+	blockData, _, err = blReader.ReadPrevBlockData()
+	assert.NilError(t, err)
+	assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(1))
+	blockData, _, err = blReader.ReadNextBlockData()
+	assert.NilError(t, err)
+	assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(1))
+
+	// Iterate in both directions
+	for _, direction := range []IterateDirection{IterateForward, IterateBackward} {
+		var values []uint64
+		err = blReader.Iterate(direction, func(blockData interface{}, jsonSize int) (bool, error) {
+			values = append(values, blockData.(*testBlockV1).List[0])
+			return len(values) < totalBlocks/2, nil
+		})
+		assert.NilError(t, err)
+		assert.Equal(t, len(values), totalBlocks/2)
+		for i, v := range values {
+			if direction == IterateForward {
+				assert.Equal(t, v, uint64(i))
+			} else {
+				assert.Equal(t, v, uint64(totalBlocks-1-i))
+			}
+		}
+	}
+}
+
+func TestBlockListReverseUnsupportedV1(t *testing.T) {
+	fileName := "/tmp/blocklistreverseunsupportedv1_test"
+	writeTestBlockList(t, fileName, 0, 5)
+	defer os.Remove(fileName)
+
+	file, blReader := openTestBlockList(t, fileName)
+	defer file.Close()
+	assert.Equal(t, blReader.GetVersion(), BlockListV1)
+	assert.NilError(t, blReader.ResetToEnd())
+	_, _, err := blReader.ReadPrevBlockData()
+	assert.Assert(t, err != nil && err != io.EOF)
+}
+
+func TestCursorV1(t *testing.T) {
+	fileName := "/tmp/blocklistcursorv1_test"
+	totalBlocks := 10
+	writeTestBlockList(t, fileName, 0, totalBlocks)
+	defer os.Remove(fileName)
+
+	file, blReader := openTestBlockList(t, fileName)
+	defer file.Close()
+
+	// The cursor starts from the beginning of the list
+	_, _, err := blReader.ReadNextBlockData()
+	assert.NilError(t, err)
+	cursor := blReader.Cursor(IterateForward)
+	var values []uint64
+	for cursor.Next() {
+		values = append(values, cursor.Value().(*testBlockV1).List[0])
+		assert.Assert(t, cursor.JSONSize() > 0)
+	}
+	assert.NilError(t, cursor.Err())
+	assert.Equal(t, len(values), totalBlocks)
+	for i, v := range values {
+		assert.Equal(t, v, uint64(i))
+	}
+	assert.Assert(t, !cursor.Next())
+	assert.Assert(t, cursor.Value() == nil)
+
+	// The list can not be read backwards, which is an error rather than the end
+	cursor = blReader.Cursor(IterateBackward)
+	assert.Assert(t, !cursor.Next())
+	assert.Assert(t, cursor.Err() != nil)
+}
+
+func TestDeriveV1(t *testing.T) {
+	srcName := "/tmp/blocklistderivev1_src_test"
+	dstName := "/tmp/blocklistderivev1_dst_test"
+	totalBlocks := 30
+	writeTestBlockList(t, srcName, 0, totalBlocks)
+	defer os.Remove(srcName)
+
+	srcFile, src := openTestBlockList(t, srcName)
+	defer srcFile.Close()
+
+	// Keep the odd blocks and double their values into a padded list
+	dstFile, err := os.Create(dstName)
+	assert.NilError(t, err)
+	defer os.Remove(dstName)
+	defer dstFile.Close()
+	dst, err := NewBlockListWriterV1(dstFile, 64, 0)
+	assert.NilError(t, err)
+
+	written, err := Derive(src, dst, func(blockData interface{}) (interface{}, bool) {
+		v := blockData.(*testBlockV1).List[0]
+		return &testBlockV1{List: []uint64{v, v * 2}}, v%2 == 1
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, written, uint32(totalBlocks/2))
+	assert.NilError(t, dst.Close())
+	dstFile.Close()
+
+	dstFile, dstReader := openTestBlockList(t, dstName)
+	defer dstFile.Close()
+	for i := 0; i < totalBlocks/2; i++ {
+		blockData, _, err := dstReader.ReadBlockDataAt(uint32(i))
+		assert.NilError(t, err)
+		v := uint64(i*2 + 1)
+		assert.DeepEqual(t, blockData.(*testBlockV1).List, []uint64{v, v * 2})
+	}
+
+	// Blocks that do not fit the destination padding are reported
+	dstFile, err = os.Create(dstName)
+	assert.NilError(t, err)
+	defer dstFile.Close()
+	dst, err = NewBlockListWriterV1(dstFile, 32, 0)
+	assert.NilError(t, err)
+	_, err = Derive(src, dst, func(blockData interface{}) (interface{}, bool) {
+		return &testBlockV1{List: make([]uint64, 100)}, true
+	})
+	_, ok := IsBlockPaddingError(err)
+	assert.Assert(t, ok)
+}
+
+func TestBlockListBytesV1(t *testing.T) {
+	testBlockListBytesV1(t, 0)
+	testBlockListBytesV1(t, 48)
+	testBlockListBytesV1(t, 0, WithExplicitIDs(), WithBackPointers())
+	testBlockListBytesV1(t, 48, WithExplicitIDs())
+}
+
+func testBlockListBytesV1(t *testing.T, paddedBlockSize uint32, opts ...BlockListOption) {
+	fileName := "/tmp/blocklistbytesv1_test"
+	initOffset := uint64(100)
+	trailer := []byte("structure after the block list")
+
+	file, err := os.Create(fileName)
+	assert.NilError(t, err)
+	defer os.Remove(fileName)
+	defer file.Close()
+
+	_, err = file.Write(make([]byte, initOffset))
+	assert.NilError(t, err)
+	blWriter, err := NewBlockListWriterV1(file, paddedBlockSize, initOffset, opts...)
+	assert.NilError(t, err)
+	assert.Equal(t, blWriter.BytesWritten(), uint64(blWriter.(*blockListV1).listHeaderLen()))
+
+	for i := 0; i < 10; i++ {
+		assert.NilError(t, blWriter.WriteBlockData(&testBlockV1{List: []uint64{uint64(i)}}))
+		offset, err := file.Seek(0, io.SeekCurrent)
+		assert.NilError(t, err)
+		assert.Equal(t, initOffset+blWriter.BytesWritten(), uint64(offset))
+	}
+	assert.NilError(t, blWriter.Close())
+	listEndOffset := initOffset + blWriter.BytesWritten()
+	_, err = file.Write(trailer)
+	assert.NilError(t, err)
+	file.Close()
+
+	file, err = os.Open(fileName)
+	assert.NilError(t, err)
+	defer file.Close()
+	_, err = file.Seek(int64(initOffset), io.SeekStart)
+	assert.NilError(t, err)
+	blReader, err := NewBlockListReaderV1(file, initOffset, listEndOffset, initEmptyBlockData)
+	assert.NilError(t, err)
+
+	for i := 0; i < 10; i++ {
+		_, _, err = blReader.ReadNextBlockData()
+		assert.NilError(t, err)
+		offset, err := file.Seek(0, io.SeekCurrent)
+		assert.NilError(t, err)
+		assert.Equal(t, uint64(offset)+blReader.BytesRemaining(), listEndOffset)
+	}
+
+	buf := make([]byte, len(trailer))
+	_, err = file.ReadAt(buf, int64(listEndOffset))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, buf, trailer)
+}
+
+func TestBlockListRWV1(t *testing.T) {
+	testBlockListRWV1(t)
+	testBlockListRWV1(t, WithExplicitIDs())
+}
+
+func testBlockListRWV1(t *testing.T, opts ...BlockListOption) {
+	fileName := "/tmp/blocklistrw_test"
+
+	file, err := os.Create(fileName)
+	assert.NilError(t, err)
+	defer os.Remove(fileName)
+	defer file.Close()
+
+	// Unpadded lists can not be opened for read-write
+	_, err = OpenBlockListRW(file, 0, 0, 0, initEmptyBlockData, opts...)
+	assert.Assert(t, err != nil)
+
+	blRW, err := OpenBlockListRW(file, 64, 0, 0, initEmptyBlockData, opts...)
+	assert.NilError(t, err)
+
+	// Every block written can be read back through the same handle
+	for i := 0; i < 10; i++ {
+		assert.NilError(t, blRW.WriteBlockData(&testBlockV1{List: []uint64{uint64(i)}}))
+		blockData, _, err := blRW.ReadBlockDataAt(uint32(i))
+		assert.NilError(t, err)
+		assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(i))
+	}
+	blockData, _, err := blRW.SearchBinary(uint64(7), BlockTestComparator)
+	assert.NilError(t, err)
+	assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(7))
+	assert.NilError(t, blRW.Close())
+
+	stat, err := file.Stat()
+	assert.NilError(t, err)
+	assert.Equal(t, uint64(stat.Size()), blRW.BytesWritten())
+
+	// Reopen the list and append to it
+	_, err = OpenBlockListRW(file, 32, 0, uint64(stat.Size()), initEmptyBlockData)
+	assert.Assert(t, err != nil)
+	blRW, err = OpenBlockListRW(file, 0, 0, uint64(stat.Size()), initEmptyBlockData)
+	assert.NilError(t, err)
+	for i := 10; i < 20; i++ {
+		assert.NilError(t, blRW.WriteBlockData(&testBlockV1{List: []uint64{uint64(i)}}))
+	}
+	for i := 0; i < 20; i++ {
+		blockData, _, err := blRW.ReadNextBlockData()
+		assert.NilError(t, err)
+		assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(i))
+		assert.Equal(t, blRW.GetCurBlock().GetID(), uint32(i))
+	}
+	_, _, err = blRW.ReadNextBlockData()
+	assert.Equal(t, err, io.EOF)
+	assert.NilError(t, blRW.Close())
+	file.Close()
+
+	file, blReader := openTestBlockList(t, fileName)
+	defer file.Close()
+	totalBlocks, err := blReader.GetTotalBlocks()
+	assert.NilError(t, err)
+	assert.Equal(t, totalBlocks, uint32(20))
+	for i := 0; i < 20; i++ {
+		blockData, _, err := blReader.ReadNextBlockData()
+		assert.NilError(t, err)
+		assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(i))
+	}
+	_, _, err = blReader.ReadNextBlockData()
+	assert.Equal(t, err, io.EOF)
+}
+
+func TestBlockHostileSizeV1(t *testing.T) {
+	// The block size plus the header overflows 32 bits
+	serial := make([]byte, blockHeaderLen+4)
+	binary.BigEndian.PutUint32(serial[blockNumLen:], 0xFFFFFFFC)
+	_, err := DeserializeBlockV1(0, serial)
+	assert.Assert(t, errors.Is(err, errs.ErrCorrupt))
+
+	// The block size is bigger than the rest of the list
+	list := make([]byte, blockListHeaderLen+blockHeaderLen+4)
+	binary.BigEndian.PutUint32(list, BlockListV1)
+	binary.BigEndian.PutUint32(list[blockListHeaderLen+blockNumLen:], 0xFFFFFFF0)
+	blReader, err := NewBlockListReaderV1(bytes.NewReader(list), 0, uint64(len(list)), initEmptyBlockData)
+	assert.NilError(t, err)
+	_, _, err = blReader.ReadNextBlockData()
+	assert.Assert(t, errors.Is(err, errs.ErrCorrupt))
+
+	// The padded block size is bigger than the rest of the list
+	binary.BigEndian.PutUint32(list[versionLen:], DefaultMaxBlockSize+1)
+	blReader, err = NewBlockListReaderV1(bytes.NewReader(list), 0, uint64(len(list)), initEmptyBlockData)
+	assert.NilError(t, err)
+	_, _, err = blReader.ReadNextBlockData()
+	assert.Equal(t, err, io.EOF)
+}
+
+func TestMaxBlockSizeV1(t *testing.T) {
+	fileName := "/tmp/blocklistmaxsize_test"
+	defer os.Remove(fileName)
+	blockData := func(i int) *testBlockV1 {
+		return &testBlockV1{List: []uint64{uint64(i), 1 << 60}}
+	}
+
+	file, err := os.Create(fileName)
+	assert.NilError(t, err)
+	_, err = NewBlockListWriterV1(file, 1024, 0, WithMaxBlockSize(512))
+	assert.Assert(t, errors.Is(err, errs.ErrTooLarge))
+	blWriter, err := NewBlockListWriterV1(file, 0, 0, WithMaxBlockSize(32))
+	assert.NilError(t, err)
+	err = blWriter.WriteBlockData(blockData(0))
+	assert.Assert(t, errors.Is(err, errs.ErrTooLarge))
+	assert.NilError(t, file.Close())
+
+	file, err = os.Create(fileName)
+	assert.NilError(t, err)
+	blWriter, err = NewBlockListWriterV1(file, 0, 0)
+	assert.NilError(t, err)
+	for i := 0; i < 2; i++ {
+		assert.NilError(t, blWriter.WriteBlockData(blockData(i)))
+	}
+	assert.NilError(t, file.Close())
+
+	// Blocks bigger than the limit are read when they fit in the list
+	file, blReader := openTestBlockList(t, fileName, WithMaxBlockSize(32))
+	defer file.Close()
+	testReadAllBlocks(t, blReader, 2)
+
+	// Without the end of the list, the limit applies
+	_, err = file.Seek(0, io.SeekStart)
+	assert.NilError(t, err)
+	blReader, err = NewBlockListReaderV1(file, 0, 0, initEmptyBlockData, WithMaxBlockSize(32))
+	assert.NilError(t, err)
+	_, _, err = blReader.ReadNextBlockData()
+	assert.Assert(t, errors.Is(err, errs.ErrCorrupt))
+	_, err = file.Seek(0, io.SeekStart)
+	assert.NilError(t, err)
+	blReader, err = NewBlockListReaderV1(file, 0, 0, initEmptyBlockData)
+	assert.NilError(t, err)
+	_, _, err = blReader.ReadNextBlockData()
+	assert.NilError(t, err)
+}
+
+func TestDumpLoadV1(t *testing.T) {
+	testDumpLoadV1(t, 0)
+	testDumpLoadV1(t, 64)
+}
+
+func testDumpLoadV1(t *testing.T, paddedBlockSize uint32) {
+	fileName := "/tmp/blocklistdump_test"
+	defer os.Remove(fileName)
+
+	file, err := os.Create(fileName)
+	assert.NilError(t, err)
+	blWriter, err := NewBlockListWriterV1(file, paddedBlockSize, 0, WithExplicitIDs())
+	assert.NilError(t, err)
+	for i := 0; i < 5; i++ {
+		err = blWriter.WriteBlockDataWithID(uint32(i*2), &testBlockV1{List: []uint64{uint64(i)}})
+		assert.NilError(t, err)
+	}
+	// A block whose data can not be decoded
+	_, err = blWriter.writeBlockDataBytes([]byte("<not json>"))
+	assert.NilError(t, err)
+	assert.NilError(t, blWriter.Close())
+	file.Close()
+
+	file, blReader := openTestBlockList(t, fileName)
+	var dump bytes.Buffer
+	assert.NilError(t, Dump(blReader, &dump))
+	file.Close()
+
+	lines := bytes.Split(bytes.TrimSpace(dump.Bytes()), []byte("\n"))
+	assert.Equal(t, len(lines), 6)
+	assert.Equal(t, string(lines[1][:len(`{"id":2,`)]), `{"id":2,`)
+	assert.Assert(t, bytes.Contains(lines[1], []byte(`"payload":{"List":[1]}`)))
+	assert.Assert(t, bytes.Contains(lines[5], []byte(`"raw":`)))
+	assert.Assert(t, bytes.Contains(lines[5], []byte(`"error":`)))
+
+	file, err = os.Create(fileName)
+	assert.NilError(t, err)
+	blWriter, err = NewBlockListWriterV1(file, paddedBlockSize, 0, WithExplicitIDs())
+	assert.NilError(t, err)
+	written, err := Load(bytes.NewReader(dump.Bytes()), blWriter)
+	assert.NilError(t, err)
+	assert.Equal(t, written, uint32(6))
+	assert.NilError(t, blWriter.Close())
+	file.Close()
+
+	// Loading the dump gives back the same list
+	file, blReader = openTestBlockList(t, fileName)
+	defer file.Close()
+	var reloaded bytes.Buffer
+	assert.NilError(t, Dump(blReader, &reloaded))
+	assert.Equal(t, reloaded.String(), dump.String())
+
+	_, err = Load(bytes.NewReader([]byte("{not json")), blWriter)
+	assert.Assert(t, errors.Is(err, errs.ErrCorrupt))
+}
+
+func TestMinPaddedBlockSizeV1(t *testing.T) {
+	fileName := "/tmp/blocklistminpad_test"
+
+	file, err := os.Create(fileName)
+	assert.NilError(t, err)
+	defer os.Remove(fileName)
+	defer file.Close()
+
+	assert.Equal(t, MinPaddedBlockSize(), blockHeaderLen+MinBlockDataSize)
+
+	_, err = NewBlockListWriterV1(file, MinPaddedBlockSize()-1, 0)
+	paderr, ok := IsPaddedBlockSizeError(err)
+	assert.Assert(t, ok)
+	assert.Equal(t, paderr.PaddedBlockSize, MinPaddedBlockSize()-1)
+	assert.Equal(t, paderr.MinPaddedBlockSize, MinPaddedBlockSize())
+
+	_, err = NewBlockListWriterV1(file, MinPaddedBlockSize(), 0)
+	assert.NilError(t, err)
+
+	// Timestamps make the block header bigger
+	_, err = NewBlockListWriterV1(file, MinPaddedBlockSize(), 0, WithTimestamps())
+	_, ok = IsPaddedBlockSizeError(err)
+	assert.Assert(t, ok)
+
+	defer func(minSize uint32) { MinBlockDataSize = minSize }(MinBlockDataSize)
+	MinBlockDataSize = 32
+	_, err = NewBlockListWriterV1(file, 32, 0)
+	_, ok = IsPaddedBlockSizeError(err)
+	assert.Assert(t, ok)
+}
+
+// countingReader counts the reads made on a storage
+type countingReader struct {
+	*os.File
+	reads int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	r.reads++
+	return r.File.Read(p)
+}
+
+func TestBlockListReadAheadV1(t *testing.T) {
+	fileName := "/tmp/blocklistreadahead_test"
+	defer os.Remove(fileName)
+
+	for _, paddedBlockSize := range []uint32{0, 64} {
+		writeTestBlockList(t, fileName, paddedBlockSize, 100, WithBackPointers())
+		stat, err := os.Stat(fileName)
+		assert.NilError(t, err)
+
+		reads := make([]int, 0, 2)
+		for _, readAhead := range []int{0, 4096} {
+			file, err := os.Open(fileName)
+			assert.NilError(t, err)
+			store := &countingReader{File: file}
+			blReader, err := NewBlockListReaderV1(store, 0, uint64(stat.Size()),
+				initEmptyBlockData, WithReadAhead(readAhead))
+			assert.NilError(t, err)
+
+			blockData, _, err := blReader.SearchLinear(uint64(99), BlockTestComparator)
+			assert.NilError(t, err)
+			assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(99))
+
+			// Seeking drops the data read ahead
+			blockData, _, err = blReader.ReadPrevBlockData()
+			assert.NilError(t, err)
+			assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(99))
+			blockData, _, err = blReader.ReadNextBlockData()
+			assert.NilError(t, err)
+			assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(99))
+			assert.NilError(t, blReader.Reset())
+			testReadAllBlocks(t, blReader, 100)
+
+			reads = append(reads, store.reads)
+			file.Close()
+		}
+		assert.Assert(t, reads[1]*10 < reads[0], "reads without and with read ahead: %v", reads)
+	}
+}
+
+func TestWriteAtomicV1(t *testing.T) {
+	fileName := "/tmp/blocklistatomic_test"
+	defer os.Remove(fileName)
+
+	build := func(blocks int) func(writer BlockListWriterV1) error {
+		return func(writer BlockListWriterV1) error {
+			for i := 0; i < blocks; i++ {
+				if err := writer.WriteBlockData(&testBlockV1{List: []uint64{uint64(i)}}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	assert.NilError(t, WriteAtomic(fileName, 64, build(10), WithFooter()))
+	file, blReader := openTestBlockList(t, fileName)
+	testReadAllBlocks(t, blReader, 10)
+	file.Close()
+	assert.NilError(t, os.Chmod(fileName, 0600))
+
+	// A failed build leaves the old list and no temporary file
+	failure := errors.New("build failure")
+	err := WriteAtomic(fileName, 64, func(writer BlockListWriterV1) error {
+		if err := build(5)(writer); err != nil {
+			return err
+		}
+		return failure
+	})
+	assert.Equal(t, err, failure)
+	tmpFiles, err := filepath.Glob("/tmp/.blocklistatomic_test.tmp*")
+	assert.NilError(t, err)
+	assert.Equal(t, len(tmpFiles), 0)
+	file, blReader = openTestBlockList(t, fileName)
+	testReadAllBlocks(t, blReader, 10)
+	file.Close()
+
+	// Replacing the list keeps its mode
+	assert.NilError(t, WriteAtomic(fileName, 0, build(3)))
+	stat, err := os.Stat(fileName)
+	assert.NilError(t, err)
+	assert.Equal(t, stat.Mode().Perm(), os.FileMode(0600))
+	file, blReader = openTestBlockList(t, fileName)
+	defer file.Close()
+	testReadAllBlocks(t, blReader, 3)
+}
+
+func TestBlockListContextV1(t *testing.T) {
+	fileName := "/tmp/blocklistctx_test"
+	defer os.Remove(fileName)
+	writeTestBlockList(t, fileName, 64, 10)
+
+	file, err := os.Open(fileName)
+	assert.NilError(t, err)
+	defer file.Close()
+	stat, err := file.Stat()
+	assert.NilError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	blReader, err := NewBlockListReaderV1Ctx(ctx, file, 0, uint64(stat.Size()), initEmptyBlockData)
+	assert.NilError(t, err)
+
+	// Cancelling during an iteration stops it
+	visited := 0
+	err = blReader.Iterate(IterateForward, func(blockData interface{}, jsonSize int) (bool, error) {
+		visited++
+		if visited == 3 {
+			cancel()
+		}
+		return true, nil
+	})
+	assert.Assert(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, visited, 3)
+
+	_, _, err = blReader.SearchLinear(uint64(5), BlockTestComparator)
+	assert.Assert(t, errors.Is(err, context.Canceled))
+	_, _, err = blReader.SearchBinary(uint64(5), BlockTestComparator)
+	assert.Assert(t, errors.Is(err, context.Canceled))
+	// Single block reads still work
+	_, _, err = blReader.ReadBlockDataAt(5)
+	assert.NilError(t, err)
+
+	_, err = NewBlockListReaderV1Ctx(ctx, file, 0, uint64(stat.Size()), initEmptyBlockData)
+	assert.Assert(t, errors.Is(err, context.Canceled))
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Hour)
+	blWriter, err := NewBlockListWriterV1Ctx(ctx, &bytes.Buffer{}, 0, 0)
+	assert.NilError(t, err)
+	assert.NilError(t, blWriter.WriteBlockData(&testBlockV1{List: []uint64{0}}))
+	cancel()
+	err = blWriter.WriteBlockData(&testBlockV1{List: []uint64{1}})
+	assert.Assert(t, errors.Is(err, context.Canceled))
+}
+
+func TestSuggestPadSizeV1(t *testing.T) {
+	samples := make([]interface{}, 0, 20)
+	for i := 0; i < 20; i++ {
+		// {"List":[1000000000]} is 21 bytes, serialized with the block header
+		// it is 29 bytes
+		samples = append(samples, &testBlockV1{List: []uint64{1000000000 + uint64(i)}})
+	}
+
+	padSize, waste, err := SuggestPadSize(samples, nil, 50)
+	assert.NilError(t, err)
+	assert.Equal(t, padSize, uint32(32))
+	assert.Assert(t, waste > 9 && waste < 10, waste)
+
+	// Only the size of the biggest sample meets the target
+	padSize, waste, err = SuggestPadSize(samples, nil, 5)
+	assert.NilError(t, err)
+	assert.Equal(t, padSize, uint32(29))
+	assert.Equal(t, waste, float64(0))
+
+	// Timestamps make every block 8 bytes bigger
+	padSize, _, err = SuggestPadSize(samples, nil, 50, WithTimestamps())
+	assert.NilError(t, err)
+	assert.Equal(t, padSize, uint32(64))
+
+	// Every sample must fit, even if no size meets the target
+	samples = append(samples, &testBlockV1{List: make([]uint64, 20)})
+	padSize, _, err = SuggestPadSize(samples, nil, 100)
+	assert.NilError(t, err)
+	assert.Equal(t, padSize, uint32(64))
+	padSize, waste, err = SuggestPadSize(samples, nil, 1)
+	assert.Assert(t, err != nil)
+	assert.Equal(t, padSize, uint32(8+len(`{"List":[`)+20*2-1+2))
+	assert.Assert(t, waste > 1)
+
+	_, _, err = SuggestPadSize(nil, nil, 100)
+	assert.Assert(t, err != nil)
+}
+
+func TestDataReaderV1(t *testing.T) {
+	fileName := "/tmp/blocklistdatareader_test"
+	defer os.Remove(fileName)
+
+	for _, paddedBlockSize := range []uint32{0, 64} {
+		file, err := os.Create(fileName)
+		assert.NilError(t, err)
+		blWriter, err := NewBlockListWriterV1(file, paddedBlockSize, 0)
+		assert.NilError(t, err)
+
+		content := []byte(teststr)
+		for data := content; len(data) > 0; {
+			size := tools.MinUint32(uint32(len(data)), tools.MinUint32(blWriter.GetMaxDataSize(), 50))
+			_, err = blWriter.writeBlockDataBytes(data[:size])
+			assert.NilError(t, err)
+			data = data[size:]
+		}
+		assert.NilError(t, blWriter.Close())
+		file.Close()
+
+		file, err = os.Open(fileName)
+		assert.NilError(t, err)
+		stat, err := file.Stat()
+		assert.NilError(t, err)
+		blReader, err := NewBlockListReaderV1(file, 0, uint64(stat.Size()), nil)
+		assert.NilError(t, err)
+
+		read := &bytes.Buffer{}
+		_, err = io.Copy(read, NewDataReader(blReader))
+		assert.NilError(t, err)
+		assert.DeepEqual(t, read.Bytes(), content)
+		file.Close()
+	}
+}
+
+func TestDataWriterV1(t *testing.T) {
+	fileName := "/tmp/blocklistdatawriter_test"
+	defer os.Remove(fileName)
+
+	content := []byte(teststr)
+	for _, paddedBlockSize := range []uint32{0, 64} {
+		for _, chunkSize := range []uint32{0, 10, 1000} {
+			file, err := os.Create(fileName)
+			assert.NilError(t, err)
+			blWriter, err := NewBlockListWriterV1(file, paddedBlockSize, 0)
+			assert.NilError(t, err)
+
+			// Write in pieces that do not line up with the blocks
+			dataWriter := NewDataWriter(blWriter, chunkSize)
+			for data := content; len(data) > 0; {
+				n := tools.MinUint32(uint32(len(data)), 7)
+				written, err := dataWriter.Write(data[:n])
+				assert.NilError(t, err)
+				assert.Equal(t, written, int(n))
+				data = data[n:]
+			}
+			assert.NilError(t, dataWriter.Close())
+			file.Close()
+
+			file, err = os.Open(fileName)
+			assert.NilError(t, err)
+			stat, err := file.Stat()
+			assert.NilError(t, err)
+			blReader, err := NewBlockListReaderV1(file, 0, uint64(stat.Size()), nil)
+			assert.NilError(t, err)
+
+			maxSize := chunkSize
+			if maxSize == 0 || (paddedBlockSize > 0 && maxSize > blWriter.GetMaxDataSize()) {
+				maxSize = blWriter.GetMaxDataSize()
+			}
+			read := make([]byte, 0, len(content))
+			for {
+				blk, err := blReader.readNextBlock()
+				if err == io.EOF {
+					break
+				}
+				assert.NilError(t, err)
+				assert.Assert(t, blk.GetSize() <= maxSize)
+				read = append(read, blk.GetData()...)
+			}
+			assert.DeepEqual(t, read, content)
+			file.Close()
+		}
+	}
+}
+
+func TestBlockListStrictReadsV1(t *testing.T) {
+	fileName := "/tmp/blockliststrict_test"
+	defer os.Remove(fileName)
+	writeTestBlockList(t, fileName, 64, 3)
+	stat, err := os.Stat(fileName)
+	assert.NilError(t, err)
+
+	for _, strict := range []bool{false, true} {
+		file, err := os.Open(fileName)
+		assert.NilError(t, err)
+		var opts []BlockListOption
+		if strict {
+			opts = append(opts, WithStrictReads())
+		}
+		blReader, err := NewBlockListReaderV1(file, 0, uint64(stat.Size()), initEmptyBlockData, opts...)
+		assert.NilError(t, err)
+
+		testReadAllBlocks(t, blReader, 3)
+		assert.Assert(t, blReader.EOF())
+		_, _, err = blReader.ReadNextBlockData()
+		if strict {
+			assert.Assert(t, errs.Is(err, ErrReadAfterEOF))
+		} else {
+			assert.Equal(t, err, io.EOF)
+		}
+
+		// Moving the read position clears the end of the list
+		blockData, _, err := blReader.ReadPrevBlockData()
+		assert.NilError(t, err)
+		assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(2))
+		assert.Assert(t, !blReader.EOF())
+		_, _, err = blReader.ReadNextBlockData()
+		assert.NilError(t, err)
+		_, _, err = blReader.ReadNextBlockData()
+		assert.Equal(t, err, io.EOF)
+		assert.NilError(t, blReader.Reset())
+		assert.Assert(t, !blReader.EOF())
+		testReadAllBlocks(t, blReader, 3)
+		file.Close()
+	}
+}
+
+func TestConcatV1(t *testing.T) {
+	paddedName := "/tmp/blocklistconcat_padded_test"
+	unpaddedName := "/tmp/blocklistconcat_unpadded_test"
+	dstName := "/tmp/blocklistconcat_dst_test"
+	writeTestBlockList(t, paddedName, 64, 5)
+	defer os.Remove(paddedName)
+	writeTestBlockList(t, unpaddedName, 0, 7, WithTimestamps())
+	defer os.Remove(unpaddedName)
+	defer os.Remove(dstName)
+
+	paddedFile, padded := openTestBlockList(t, paddedName)
+	defer paddedFile.Close()
+	unpaddedFile, unpadded := openTestBlockList(t, unpaddedName)
+	defer unpaddedFile.Close()
+
+	for _, paddedBlockSize := range []uint32{0, 64} {
+		dstFile, err := os.Create(dstName)
+		assert.NilError(t, err)
+		dst, err := NewBlockListWriterV1(dstFile, paddedBlockSize, 0)
+		assert.NilError(t, err)
+		written, err := Concat(dst, padded, unpadded)
+		assert.NilError(t, err)
+		assert.Equal(t, written, uint32(12))
+		assert.NilError(t, dst.Close())
+		dstFile.Close()
+
+		dstFile, dstReader := openTestBlockList(t, dstName)
+		for i := 0; i < 12; i++ {
+			blockData, _, err := dstReader.ReadNextBlockData()
+			assert.NilError(t, err)
+			assert.Equal(t, dstReader.GetCurBlock().GetID(), uint32(i))
+			expected := uint64(i)
+			if i >= 5 {
+				expected = uint64(i - 5)
+			}
+			assert.Equal(t, blockData.(*testBlockV1).List[0], expected)
+		}
+		_, _, err = dstReader.ReadNextBlockData()
+		assert.Equal(t, err, io.EOF)
+		dstFile.Close()
+	}
+
+	// Blocks that do not fit the destination padding are reported
+	dstFile, err := os.Create(dstName)
+	assert.NilError(t, err)
+	defer dstFile.Close()
+	dst, err := NewBlockListWriterV1(dstFile, 16, 0)
+	assert.NilError(t, err)
+	_, err = Concat(dst, unpadded)
+	_, ok := IsBlockPaddingError(err)
+	assert.Assert(t, ok)
+}
+
+func TestSearchBinaryIndexV1(t *testing.T) {
+	fileName := "/tmp/blocklistsearchindex_test"
+	defer os.Remove(fileName)
+
+	// Block i holds the values 10*i and 10*i+2
+	file, err := os.Create(fileName)
+	assert.NilError(t, err)
+	blWriter, err := NewBlockListWriterV1(file, 64, 0)
+	assert.NilError(t, err)
+	for i := uint64(0); i < 9; i++ {
+		assert.NilError(t, blWriter.WriteBlockData(&testBlockV1{List: []uint64{10 * i, 10*i + 2}}))
+	}
+	assert.NilError(t, blWriter.Close())
+	file.Close()
+
+	file, blReader := openTestBlockList(t, fileName)
+	defer file.Close()
+	for i := uint32(0); i < 9; i++ {
+		index, found, err := blReader.SearchBinaryIndex(uint64(10*i+2), BlockTestComparator)
+		assert.NilError(t, err)
+		assert.Assert(t, found)
+		assert.Equal(t, index, i)
+
+		// In the range of the block, but not in the block
+		index, found, err = blReader.SearchBinaryIndex(uint64(10*i+1), BlockTestComparator)
+		assert.NilError(t, err)
+		assert.Assert(t, !found)
+		assert.Equal(t, index, i)
+
+		// Between two blocks
+		index, found, err = blReader.SearchBinaryIndex(uint64(10*i+5), BlockTestComparator)
+		assert.NilError(t, err)
+		assert.Assert(t, !found)
+		assert.Equal(t, index, i+1)
+	}
+
+	writeTestBlockList(t, fileName, 64, 0)
+	file, blReader = openTestBlockList(t, fileName)
+	defer file.Close()
+	index, found, err := blReader.SearchBinaryIndex(uint64(1), BlockTestComparator)
+	assert.NilError(t, err)
+	assert.Assert(t, !found)
+	assert.Equal(t, index, uint32(0))
+}
+
+func TestKeyComparatorV1(t *testing.T) {
+	fileName := "/tmp/blocklistkeycomparator_test"
+	defer os.Remove(fileName)
+
+	// Block i holds the keys "k<i>0" to "k<i>5"
+	file, err := os.Create(fileName)
+	assert.NilError(t, err)
+	blWriter, err := NewBlockListWriterV1(file, 64, 0)
+	assert.NilError(t, err)
+	for i := 0; i < 5; i++ {
+		keys := &testKeysBlock{Keys: []string{fmt.Sprintf("k%v0", i), fmt.Sprintf("k%v5", i)}}
+		assert.NilError(t, blWriter.WriteBlockData(keys))
+	}
+	assert.NilError(t, blWriter.Close())
+	file.Close()
+
+	file, err = os.Open(fileName)
+	assert.NilError(t, err)
+	defer file.Close()
+	stat, err := file.Stat()
+	assert.NilError(t, err)
+	blReader, err := NewBlockListReaderV1(file, 0, uint64(stat.Size()),
+		func() interface{} { return &testKeysBlock{} })
+	assert.NilError(t, err)
+
+	comparator := KeyComparator(func(blockData interface{}) ([]byte, []byte, error) {
+		keys := blockData.(*testKeysBlock).Keys
+		return []byte(keys[0]), []byte(keys[len(keys)-1]), nil
+	})
+
+	for _, key := range []interface{}{"k20", []byte("k23"), "k25"} {
+		index, found, err := blReader.SearchBinaryIndex(key, comparator)
+		assert.NilError(t, err)
+		assert.Assert(t, found)
+		assert.Equal(t, index, uint32(2))
+	}
+	blockData, _, err := blReader.SearchLinear("k31", comparator)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, blockData.(*testKeysBlock).Keys, []string{"k30", "k35"})
+
+	for key, expected := range map[string]uint32{"a": 0, "k26": 3, "k9": 5} {
+		index, found, err := blReader.SearchBinaryIndex(key, comparator)
+		assert.NilError(t, err)
+		assert.Assert(t, !found)
+		assert.Equal(t, index, expected)
+	}
+
+	_, _, err = blReader.SearchBinaryIndex(1, comparator)
+	assert.Assert(t, err != nil)
+}
+
+func TestSearchHandleV1(t *testing.T) {
+	fileName := "/tmp/blocklistsearchhandle_test"
+	defer os.Remove(fileName)
+
+	// Block i holds the value i. Only decode the blocks without the value.
+	decoded := 0
+	comparator := func(value interface{}, handle BlockHandle) (int, error) {
+		raw, err := handle.RawBytes()
+		if err != nil {
+			return 0, err
+		}
+		if bytes.Contains(raw, []byte(fmt.Sprintf("[%v]", value))) {
+			return 1, nil
+		}
+		blockData := &testBlockV1{}
+		decoded++
+		if err := handle.Decode(blockData); err != nil {
+			return 0, err
+		}
+		if value.(uint64) < blockData.List[0] {
+			return -1, nil
+		}
+		return 2, nil
+	}
+
+	for _, blockSize := range []uint32{0, 128} {
+		writeTestBlockList(t, fileName, blockSize, 10)
+		file, blReader := openTestBlockList(t, fileName)
+
+		handle, err := blReader.SearchLinearHandle(uint64(3), comparator)
+		assert.NilError(t, err)
+		assert.Equal(t, handle.BlockIndex(), uint32(3))
+		blockData := &testBlockV1{}
+		assert.NilError(t, handle.Decode(blockData))
+		assert.DeepEqual(t, blockData.List, []uint64{3})
+
+		handle, err = blReader.SearchLinearHandle(uint64(31), comparator)
+		assert.NilError(t, err)
+		assert.Assert(t, handle == nil)
+
+		if blockSize > 0 {
+			decoded = 0
+			handle, err = blReader.SearchBinaryHandle(uint64(6), comparator)
+			assert.NilError(t, err)
+			assert.Equal(t, handle.BlockIndex(), uint32(6))
+			assert.Assert(t, decoded < 6)
+
+			handle, err = blReader.SearchBinaryHandle(uint64(61), comparator)
+			assert.NilError(t, err)
+			assert.Assert(t, handle == nil)
+		}
+		file.Close()
+	}
+}
+
+func TestAppendBlockDataV1(t *testing.T) {
+	fileName := "/tmp/blocklistappend_test"
+	defer os.Remove(fileName)
+
+	for _, blockSize := range []uint32{0, 64} {
+		file, err := os.Create(fileName)
+		assert.NilError(t, err)
+		_, err = file.Write([]byte("prefix"))
+		assert.NilError(t, err)
+
+		blWriter, err := NewBlockListWriterV1(file, blockSize, 6)
+		assert.NilError(t, err)
+		offsets := make([]uint64, 5)
+		for i := range offsets {
+			var block Block
+			block, offsets[i], err = blWriter.AppendBlockData(&testBlockV1{List: []uint64{uint64(i)}})
+			assert.NilError(t, err)
+			assert.Equal(t, block.GetID(), uint32(i))
+		}
+		assert.NilError(t, blWriter.Close())
+
+		// Every block starts with its ID at its offset
+		for i, offset := range offsets {
+			id := make([]byte, 4)
+			_, err = file.ReadAt(id, int64(offset))
+			assert.NilError(t, err)
+			assert.Equal(t, binary.BigEndian.Uint32(id), uint32(i))
+			if blockSize > 0 {
+				assert.Equal(t, offset, offsets[0]+uint64(i)*uint64(blockSize))
+			}
+		}
+		file.Close()
+	}
+}
+
+type testKeysBlock struct {
+	Keys []string
+}
+
+func TestReadPastEndOffsetV1(t *testing.T) {
+	fileName := "/tmp/blocklistpastend_test"
+	defer os.Remove(fileName)
+
+	// A list of 5 blocks followed by the blocks of another structure
+	file, err := os.Create(fileName)
+	assert.NilError(t, err)
+	blWriter, err := NewBlockListWriterV1(file, 64, 0)
+	assert.NilError(t, err)
+	for i := uint64(0); i < 5; i++ {
+		assert.NilError(t, blWriter.WriteBlockData(&testBlockV1{List: []uint64{i}}))
+	}
+	assert.NilError(t, blWriter.Close())
+	listEnd := blWriter.BytesWritten()
+	blWriter, err = NewBlockListWriterV1(file, 64, listEnd)
+	assert.NilError(t, err)
+	for i := uint64(5); i < 10; i++ {
+		assert.NilError(t, blWriter.WriteBlockData(&testBlockV1{List: []uint64{i}}))
+	}
+	file.Close()
+
+	file, err = os.Open(fileName)
+	assert.NilError(t, err)
+	defer file.Close()
+
+	// An end offset in the middle of a block leaves the block out
+	for _, endOffset := range []uint64{listEnd, listEnd + 40} {
+		_, err = file.Seek(0, io.SeekStart)
+		assert.NilError(t, err)
+		blReader, err := NewBlockListReaderV1(file, 0, endOffset, initEmptyBlockData)
+		assert.NilError(t, err)
+		testReadAllBlocks(t, blReader, 5)
+
+		for _, index := range []uint32{5, 6, 100} {
+			_, _, err = blReader.ReadBlockDataAt(index)
+			assert.Equal(t, err, io.EOF)
+		}
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	assert.NilError(t, err)
+	blReader, err := NewBlockListReaderV1(file, 0, listEnd-10, initEmptyBlockData)
+	assert.NilError(t, err)
+	testReadAllBlocks(t, blReader, 4)
+	_, _, err = blReader.ReadBlockDataAt(4)
+	assert.Equal(t, err, io.EOF)
+}
+
+func TestBlockExtent(t *testing.T) {
+	fileName := "/tmp/blocklistextent_test"
+	defer os.Remove(fileName)
+	writeTestBlockList(t, fileName, 64, 10)
+
+	file, blReader := openTestBlockList(t, fileName)
+	defer file.Close()
+	var prevEnd uint64
+	for i := uint32(0); i < 10; i++ {
+		offset, length, err := blReader.BlockExtent(i)
+		assert.NilError(t, err)
+		assert.Equal(t, length, uint64(64))
+		if i > 0 {
+			assert.Equal(t, offset, prevEnd)
+		}
+		prevEnd = offset + length
+
+		// The caller reads the block bytes itself
+		blockBytes := make([]byte, length)
+		_, err = file.ReadAt(blockBytes, int64(offset))
+		assert.NilError(t, err)
+		block, err := DeserializeBlockV1(64, blockBytes)
+		assert.NilError(t, err)
+		assert.Equal(t, block.GetID(), i)
+		blockData, _, err := blReader.deserializeBlockData(block.GetData())
+		assert.NilError(t, err)
+		assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(i))
+	}
+
+	_, _, err := blReader.BlockExtent(10)
+	assert.Equal(t, err, io.EOF)
+
+	// The block count of a huge list, 1<<32 blocks after the 8 byte list
+	// header, does not silently wrap around
+	_, err = file.Seek(0, io.SeekStart)
+	assert.NilError(t, err)
+	hugeReader, err := NewBlockListReaderV1(file, 0, 64<<32+8, initEmptyBlockData)
+	assert.NilError(t, err)
+	_, err = hugeReader.GetTotalBlocks()
+	assert.Assert(t, errs.Is(err, errs.ErrTooLarge), "%v", err)
+
+	// Blocks of lists without padding have no fixed place
+	writeTestBlockList(t, fileName, 0, 10)
+	file2, blReader := openTestBlockList(t, fileName)
+	defer file2.Close()
+	_, _, err = blReader.BlockExtent(0)
+	assert.Assert(t, err != nil)
+
+	// Blocks with timestamps are parsed with the format of the list
+	writeTestBlockList(t, fileName, 64, 10, WithTimestamps())
+	file3, blReader := openTestBlockList(t, fileName)
+	defer file3.Close()
+	for i := uint32(0); i < 10; i++ {
+		offset, length, err := blReader.BlockExtent(i)
+		assert.NilError(t, err)
+		blockBytes := make([]byte, length)
+		_, err = file3.ReadAt(blockBytes, int64(offset))
+		assert.NilError(t, err)
+		block, err := blReader.DeserializeBlock(blockBytes)
+		assert.NilError(t, err)
+		assert.Equal(t, block.GetID(), i)
+		assert.Assert(t, !block.GetTimestamp().IsZero())
+		blockData, _, err := blReader.deserializeBlockData(block.GetData())
+		assert.NilError(t, err)
+		assert.Equal(t, blockData.(*testBlockV1).List[0], uint64(i))
+	}
+}
+
+func TestScanInto(t *testing.T) {
+	fileName := "/tmp/blocklistscaninto_test"
+	defer os.Remove(fileName)
+	writeTestBlockList(t, fileName, 64, 10)
+
+	file, blReader := openTestBlockList(t, fileName)
+	defer file.Close()
+	for _, bufSize := range []int{64, 3*64 + 10, 1024} {
+		buf := make([]byte, bufSize)
+		var ids []uint64
+		err := blReader.ScanInto(buf, func(data []byte) error {
+			blockData, _, err := blReader.deserializeBlockData(data)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, blockData.(*testBlockV1).List[0])
+			return nil
+		})
+		assert.NilError(t, err)
+		assert.DeepEqual(t, ids, []uint64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9})
+	}
+
+	// The scan does not allocate per block
+	buf := make([]byte, 64)
+	allocs := testing.AllocsPerRun(10, func() {
+		blReader.ScanInto(buf, func(data []byte) error { return nil })
+	})
+	assert.Assert(t, allocs < 10, "%v allocations", allocs)
+
+	// Errors of fn stop the scan
+	stop := errors.New("stop")
+	scanned := 0
+	err := blReader.ScanInto(buf, func(data []byte) error {
+		scanned++
+		return stop
+	})
+	assert.Equal(t, err, stop)
+	assert.Equal(t, scanned, 1)
+
+	err = blReader.ScanInto(make([]byte, 63), func(data []byte) error { return nil })
+	assert.Assert(t, err != nil)
+
+	// Lists without padding can not be scanned into a buffer
+	writeTestBlockList(t, fileName, 0, 10)
+	file2, blReader := openTestBlockList(t, fileName)
+	defer file2.Close()
+	err = blReader.ScanInto(make([]byte, 1024), func(data []byte) error { return nil })
+	assert.Assert(t, err != nil)
+}
+
+func TestCopyRaw(t *testing.T) {
+	paddedName := "/tmp/blocklistcopyraw_padded_test"
+	unpaddedName := "/tmp/blocklistcopyraw_unpadded_test"
+	dstName := "/tmp/blocklistcopyraw_dst_test"
+	writeTestBlockList(t, paddedName, 64, 10)
+	defer os.Remove(paddedName)
+	writeTestBlockList(t, unpaddedName, 0, 10)
+	defer os.Remove(unpaddedName)
+	defer os.Remove(dstName)
+
+	paddedFile, padded := openTestBlockList(t, paddedName)
+	defer paddedFile.Close()
+	unpaddedFile, unpadded := openTestBlockList(t, unpaddedName)
+	defer unpaddedFile.Close()
+
+	for _, src := range []BlockListReaderV1{padded, unpadded} {
+		for _, paddedBlockSize := range []uint32{0, 128} {
+			dstFile, err := os.Create(dstName)
+			assert.NilError(t, err)
+			dst, err := NewBlockListWriterV1(dstFile, paddedBlockSize, 0)
+			assert.NilError(t, err)
+			written, err := CopyRaw(src, dst, 3, 7)
+			assert.NilError(t, err)
+			assert.Equal(t, written, uint32(4))
+			// The range is cut at the end of the source
+			written, err = CopyRaw(src, dst, 8, 20)
+			assert.NilError(t, err)
+			assert.Equal(t, written, uint32(2))
+			assert.NilError(t, dst.Close())
+			dstFile.Close()
+
+			dstFile, dstReader := openTestBlockList(t, dstName)
+			for i, expected := range []uint64{3, 4, 5, 6, 8, 9} {
+				blockData, _, err := dstReader.ReadNextBlockData()
+				assert.NilError(t, err)
+				assert.Equal(t, dstReader.GetCurBlock().GetID(), uint32(i))
+				assert.Equal(t, blockData.(*testBlockV1).List[0], expected)
+			}
+			_, _, err = dstReader.ReadNextBlockData()
+			assert.Equal(t, err, io.EOF)
+			dstFile.Close()
+		}
+	}
+
+	dstFile, err := os.Create(dstName)
+	assert.NilError(t, err)
+	defer dstFile.Close()
+	dst, err := NewBlockListWriterV1(dstFile, 0, 0)
+	assert.NilError(t, err)
+	_, err = CopyRaw(padded, dst, 5, 4)
+	assert.Assert(t, err != nil)
+	written, err := CopyRaw(unpadded, dst, 12, 14)
+	assert.NilError(t, err)
+	assert.Equal(t, written, uint32(0))
+}
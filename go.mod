@@ -1,8 +1,9 @@
 module github.com/overnest/strongsalt-common-go
 
-go 1.13
+go 1.14
 
 require (
+	github.com/cespare/xxhash/v2 v2.1.2
 	github.com/go-errors/errors v1.1.1
 	github.com/google/go-cmp v0.5.2
 	github.com/pkg/errors v0.8.1 // indirect
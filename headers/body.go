@@ -0,0 +1,114 @@
+package headers
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/overnest/strongsalt-common-go/tools"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// bodyEnvelope wraps a JSON header body with the version of its schema
+type bodyEnvelope struct {
+	SchemaVersion uint32          `json:"schemaVersion"`
+	Body          json.RawMessage `json:"body"`
+}
+
+// MarshalBodyWithSchema marshals v into a JSON header body, along with the
+// version of the schema of v. Readers use UnmarshalBody to get the version
+// back, and decide how to read the body.
+func MarshalBodyWithSchema(v interface{}, schemaVer uint32) ([]byte, error) {
+	body, err := tools.MarshalCanonical(v)
+	if err != nil {
+		return nil, errs.WrapPrefix(err, nil, "Can not marshal the header body")
+	}
+
+	envelope, err := tools.MarshalCanonical(&bodyEnvelope{schemaVer, body})
+	if err != nil {
+		return nil, errs.Wrap(err, nil)
+	}
+	return envelope, nil
+}
+
+// UnmarshalBody unmarshals the JSON body of the header into out, and returns
+// the version of its schema. Bodies that were not marshaled with
+// MarshalBodyWithSchema are unmarshaled as they are, with schema version 0.
+func UnmarshalBody(hdr Header, out interface{}) (uint32, error) {
+	if hdrType, ok := headerType(hdr); ok && hdrType != HeaderTypeJSON && hdrType != HeaderTypeJSONGzip {
+		return 0, errs.Errorf(nil, "Header type %v does not have a JSON body", hdrType)
+	}
+
+	body, err := hdr.GetBody()
+	if err != nil {
+		return 0, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(body, &fields); err == nil && len(fields) == 2 &&
+		fields["schemaVersion"] != nil && fields["body"] != nil {
+		var envelope bodyEnvelope
+		if err = json.Unmarshal(body, &envelope); err == nil {
+			if err = tools.Unmarshal(envelope.Body, out); err != nil {
+				return 0, errs.Wrap(err, errs.ErrCorrupt)
+			}
+			return envelope.SchemaVersion, nil
+		}
+	}
+
+	if err = tools.Unmarshal(body, out); err != nil {
+		return 0, errs.Wrap(err, errs.ErrCorrupt)
+	}
+	return 0, nil
+}
+
+// UnmarshalBSON decodes BSON header bodies for DeserializePlainHdrBody. This
+// package does not depend on a BSON library, so BSON bodies can not be
+// decoded until the application sets it, for example to bson.Unmarshal.
+var UnmarshalBSON func(data []byte, v interface{}) error
+
+// DeserializePlainHdrBody deserializes the plaintext header from the stream,
+// and unmarshals its body into out, according to the header type. Gzipped
+// bodies are gunzipped first.
+func DeserializePlainHdrBody(r io.Reader, out interface{}) (Header, error) {
+	hdr, _, err := DeserializePlainHdrStream(r)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := hdr.GetBody()
+	if err != nil {
+		return nil, err
+	}
+
+	hdrType, _ := headerType(hdr)
+	switch hdrType {
+	case HeaderTypeJSON, HeaderTypeJSONGzip:
+		err = tools.Unmarshal(body, out)
+	case HeaderTypeBSON, HeaderTypeBSONGzip:
+		if UnmarshalBSON == nil {
+			return nil, errs.New(nil, "Can not unmarshal a BSON header body without UnmarshalBSON")
+		}
+		err = UnmarshalBSON(body, out)
+	default:
+		return nil, errs.Errorf(errs.ErrCorrupt, "Unknown header type %v", hdrType)
+	}
+	if err != nil {
+		return nil, errs.Wrap(err, errs.ErrCorrupt)
+	}
+	return hdr, nil
+}
+
+// headerType returns the type of the known header versions
+func headerType(hdr Header) (HeaderType, bool) {
+	switch h := hdr.(type) {
+	case *PlainHdrV1:
+		return h.HdrType, true
+	case *PlainHdrV2:
+		return h.HdrType, true
+	case *CipherHdrV1:
+		return h.HdrType, true
+	case *CipherHdrV2:
+		return h.HdrType, true
+	}
+	return 0, false
+}
@@ -0,0 +1,111 @@
+package headers
+
+import (
+	"github.com/overnest/strongsalt-common-go/tools"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// DefaultGzipThreshold is the body size in bytes above which a builder
+// gzips the header body, unless the header type is set
+var DefaultGzipThreshold = 512
+
+// HeaderBuilder builds a header with the length fields filled in, for example:
+//
+//	hdr, err := NewPlainBuilder().BodyJSON(v).Build()
+//
+// The first error of the builder methods is returned by Build.
+type HeaderBuilder struct {
+	create        func(hdrType HeaderType, hdrBody []byte, opts ...CreateOption) Header
+	hdrType       HeaderType
+	body          []byte
+	json          bool
+	gzipThreshold int
+	checksum      bool
+	err           error
+}
+
+// NewPlainBuilder creates a plaintext header builder
+func NewPlainBuilder() *HeaderBuilder {
+	return &HeaderBuilder{create: CreatePlainHdr, gzipThreshold: DefaultGzipThreshold}
+}
+
+// NewCipherBuilder creates a ciphertext header builder
+func NewCipherBuilder() *HeaderBuilder {
+	return &HeaderBuilder{create: CreateCipherHdr, gzipThreshold: DefaultGzipThreshold}
+}
+
+// Type sets the header type. Without it, the type is chosen from the body
+// size.
+func (b *HeaderBuilder) Type(hdrType HeaderType) *HeaderBuilder {
+	b.hdrType = hdrType
+	return b
+}
+
+// Body sets the serialized header body. Without a header type, the body is
+// assumed to be JSON.
+func (b *HeaderBuilder) Body(body []byte) *HeaderBuilder {
+	b.body = body
+	b.json = false
+	return b
+}
+
+// BodyJSON sets the header body to v marshaled as canonical JSON, so that
+// the same value always gives the same header
+func (b *HeaderBuilder) BodyJSON(v interface{}) *HeaderBuilder {
+	body, err := tools.MarshalCanonical(v)
+	if err != nil {
+		b.setErr(errs.WrapPrefix(err, nil, "Can not marshal the header body"))
+		return b
+	}
+	b.body = body
+	b.json = true
+	return b
+}
+
+// GzipThreshold sets the body size in bytes above which the body is gzipped,
+// when the header type is not set
+func (b *HeaderBuilder) GzipThreshold(size int) *HeaderBuilder {
+	b.gzipThreshold = size
+	return b
+}
+
+// Checksum makes the builder create a version 2 header with a checksum
+func (b *HeaderBuilder) Checksum() *HeaderBuilder {
+	b.checksum = true
+	return b
+}
+
+// Build creates the header
+func (b *HeaderBuilder) Build() (Header, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	hdrType := b.hdrType
+	var opts []CreateOption
+	switch {
+	case hdrType == 0:
+		hdrType = HeaderTypeJSON
+		opts = append(opts, WithAutoGzip(b.gzipThreshold))
+	case !hdrType.IsValid():
+		return nil, errs.Errorf(nil, "Invalid header type %v", hdrType)
+	case b.json && hdrType != HeaderTypeJSON && hdrType != HeaderTypeJSONGzip:
+		return nil, errs.Errorf(nil, "A JSON body does not match header type %v", hdrType)
+	}
+
+	if b.checksum {
+		opts = append(opts, WithChecksum())
+	}
+
+	body := b.body
+	if body == nil {
+		body = []byte{}
+	}
+	return b.create(hdrType, body, opts...), nil
+}
+
+func (b *HeaderBuilder) setErr(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
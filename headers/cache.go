@@ -0,0 +1,121 @@
+package headers
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// CacheKey identifies a header in a Cache, either by its ContentID or by the
+// file and offset it was read from
+type CacheKey struct {
+	ContentID [32]byte
+	File      string
+	Offset    uint64
+}
+
+// ContentIDKey returns the cache key of the header with the ContentID
+func ContentIDKey(id [32]byte) CacheKey {
+	return CacheKey{ContentID: id}
+}
+
+// LocationKey returns the cache key of the header at the offset of the file
+func LocationKey(file string, offset uint64) CacheKey {
+	return CacheKey{File: file, Offset: offset}
+}
+
+// Cache keeps parsed headers in memory, so that services reading the same
+// headers over and over do not parse them each time. It holds at most
+// maxEntries headers, dropping the least recently used one to make room, and
+// drops headers older than its TTL. It is safe for concurrent use. The
+// cached headers are shared, and must not be modified.
+type Cache struct {
+	lock       sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	entries    map[CacheKey]*list.Element
+	lru        *list.List
+	now        func() time.Time
+}
+
+type cacheEntry struct {
+	key    CacheKey
+	header Header
+	added  time.Time
+}
+
+// NewCache returns an empty cache of at most maxEntries headers, which are
+// dropped ttl after they are put. Headers are kept until there is no room
+// for them if ttl is 0.
+func NewCache(maxEntries int, ttl time.Duration) *Cache {
+	if maxEntries < 1 {
+		maxEntries = 1
+	}
+	return &Cache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		entries:    make(map[CacheKey]*list.Element),
+		lru:        list.New(),
+		now:        time.Now,
+	}
+}
+
+// Get returns the header under the key, and whether there is one
+func (c *Cache) Get(key CacheKey) (Header, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if c.ttl > 0 && c.now().Sub(entry.added) >= c.ttl {
+		c.remove(elem)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry.header, true
+}
+
+// Put puts the header under the key, replacing the header that was there
+func (c *Cache) Put(key CacheKey, header Header) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		entry.header = header
+		entry.added = c.now()
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	for c.lru.Len() >= c.maxEntries {
+		c.remove(c.lru.Back())
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, header: header, added: c.now()})
+}
+
+// Remove drops the header under the key, such as a header that was updated
+// in its file
+func (c *Cache) Remove(key CacheKey) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+}
+
+// Len returns the number of cached headers, expired ones included
+func (c *Cache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.lru.Len()
+}
+
+func (c *Cache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+}
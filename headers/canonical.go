@@ -0,0 +1,59 @@
+package headers
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/overnest/strongsalt-common-go/tools"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// canonicalBody returns the canonical form of a header body. JSON bodies are
+// re-encoded with sorted object keys and no insignificant white space, and
+// numbers are kept as written. Other bodies are returned as they are.
+func canonicalBody(hdrType HeaderType, body []byte) ([]byte, error) {
+	if hdrType != HeaderTypeJSON && hdrType != HeaderTypeJSONGzip {
+		return body, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, errs.WrapPrefix(err, errs.ErrCorrupt, "Can not parse the JSON header body")
+	}
+	if decoder.More() {
+		return nil, errs.New(errs.ErrCorrupt, "The JSON header body has data after its value")
+	}
+
+	canonical, err := tools.MarshalCanonical(v)
+	if err != nil {
+		return nil, errs.Wrap(err, nil)
+	}
+	return canonical, nil
+}
+
+// headersEqual compares the canonical serializations of two headers
+func headersEqual(h, other Header) bool {
+	if other == nil {
+		return false
+	}
+	a, err := equalSerialize(h)
+	if err != nil {
+		return false
+	}
+	b, err := equalSerialize(other)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(a, b)
+}
+
+// equalSerialize serializes a header for comparison. A header whose JSON
+// body can not be parsed is compared as it is serialized.
+func equalSerialize(h Header) ([]byte, error) {
+	if b, err := h.CanonicalSerialize(); err == nil {
+		return b, nil
+	}
+	return h.Serialize()
+}
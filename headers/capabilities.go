@@ -0,0 +1,82 @@
+package headers
+
+import (
+	"bufio"
+
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// MaxSupportedPlainVersion returns the newest plaintext header version this
+// package can deserialize
+func MaxSupportedPlainVersion() uint32 {
+	return PlainHeaderV2
+}
+
+// MaxSupportedCipherVersion returns the newest ciphertext header version this
+// package can deserialize
+func MaxSupportedCipherVersion() uint32 {
+	return CipherHeaderV2
+}
+
+// HeaderCapabilities describes a deserialized header, and the optional
+// features it used
+type HeaderCapabilities struct {
+	Kind    HeaderKind
+	Version uint32
+	HdrType HeaderType
+	// Len is the number of bytes the serialized header took
+	Len uint64
+	// Checksum shows whether the header had a checksum, which V2 headers do
+	Checksum bool
+	// Compressed shows whether the header body was gzipped
+	Compressed bool
+	// MAC shows whether the header was authenticated. No header version has
+	// a MAC yet.
+	MAC bool
+}
+
+// DeserializeAnyHdr deserializes the plaintext or ciphertext header at the
+// start of the buffered reader, of any supported version. It also describes
+// the header, so that callers do not need to know what each version supports.
+// Unlike DeserializePlainHdrStream and DeserializeCipherHdrStream, it reads
+// V2 headers over 4GB.
+func DeserializeAnyHdr(r *bufio.Reader) (Header, HeaderCapabilities, error) {
+	var caps HeaderCapabilities
+	kind, version, _, err := Peek(r)
+	if err != nil {
+		return nil, caps, err
+	}
+	if _, err = r.Discard(4); err != nil {
+		return nil, caps, errs.WrapPrefix(err, errs.ErrTruncated, "Can not read version number")
+	}
+
+	var hdr Header
+	var parsed uint64
+	switch {
+	case kind == HeaderKindPlain && version == PlainHeaderV1:
+		var parsed32 uint32
+		hdr, parsed32, err = DeserializePlainHdrStreamV1(r)
+		parsed = uint64(parsed32)
+	case kind == HeaderKindPlain && version == PlainHeaderV2:
+		hdr, parsed, err = DeserializePlainHdrStreamV2(r)
+	case kind == HeaderKindCipher && version == CipherHeaderV1:
+		var parsed32 uint32
+		hdr, parsed32, err = DeserializeCipherHdrStreamV1(r)
+		parsed = uint64(parsed32)
+	case kind == HeaderKindCipher && version == CipherHeaderV2:
+		hdr, parsed, err = DeserializeCipherHdrStreamV2(r)
+	default:
+		err = errs.Errorf(errs.ErrUnsupportedVersion, "Version %v is not supported", version)
+	}
+	if err != nil {
+		return nil, caps, err
+	}
+
+	caps.Kind = kind
+	caps.Version = version
+	caps.HdrType, _ = headerType(hdr)
+	caps.Len = parsed + 4
+	caps.Checksum = version == PlainHeaderV2 || version == CipherHeaderV2
+	caps.Compressed = caps.HdrType.IsGzipped()
+	return hdr, caps, nil
+}
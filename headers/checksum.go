@@ -0,0 +1,94 @@
+package headers
+
+import (
+	"bytes"
+	"encoding/binary"
+	stderrors "errors"
+	"io"
+
+	"github.com/overnest/strongsalt-common-go/tools"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// ErrHeaderChecksum means the checksum of a V2 header does not match its bytes
+var ErrHeaderChecksum = stderrors.New("header checksum mismatch")
+
+const maxInt = int(^uint(0) >> 1)
+
+// readChunkSize is the biggest buffer allocated for a header before its
+// bytes are read. Bigger headers are read into a buffer that grows as the
+// bytes arrive, so that a header whose length is bigger than the stream is
+// truncated instead of allocating its whole length.
+const readChunkSize = 1024 * 1024
+
+// putChecksum stores the checksum of a serialized header in its last 4 bytes
+func putChecksum(b []byte) {
+	end := len(b) - 4
+	binary.BigEndian.PutUint32(b[end:], tools.CRC32C(b[:end]))
+}
+
+// verifyChecksum checks the checksum in the last 4 bytes of a serialized header
+func verifyChecksum(b []byte) error {
+	end := len(b) - 4
+	return checkChecksum(b[end:], tools.CRC32C(b[:end]))
+}
+
+// checkChecksum compares a stored checksum with the one computed over the
+// header bytes
+func checkChecksum(expected []byte, actual uint32) error {
+	computed := make([]byte, 4)
+	binary.BigEndian.PutUint32(computed, actual)
+	if !tools.ConstantTimeEqual(expected, computed) {
+		return errs.Errorf(ErrHeaderChecksum, "Header checksum(%x) does not match "+
+			"the header bytes checksum(%x)", expected, computed)
+	}
+	return nil
+}
+
+// readBodyAndChecksum reads the restLen bytes of a V2 header that follow its
+// fixed fields from a stream, which are the body, the optional parent ID and
+// the checksum. It verifies the checksum over the fixed fields and the rest
+// of the header, and returns the rest of the header.
+func readBodyAndChecksum(reader io.Reader, fixed []byte, restLen uint64) ([]byte, error) {
+	if restLen > uint64(maxInt) {
+		return nil, errs.Errorf(errs.ErrTooLarge, "Header length(%v) is too large", restLen)
+	}
+	rest, err := readHeaderBytes(reader, restLen)
+	if err != nil {
+		return nil, errs.WrapPrefix(err, errs.ErrTruncated, "Can not read header body")
+	}
+
+	end := restLen - 4
+	hasher := tools.NewCRC32C()
+	hasher.Write(fixed)
+	hasher.Write(rest[:end])
+	if err := checkChecksum(rest[end:], hasher.Sum32()); err != nil {
+		return nil, err
+	}
+	return rest, nil
+}
+
+// readHeaderBytes reads n bytes of a header from a stream. It returns
+// io.ErrUnexpectedEOF if the stream ends first.
+func readHeaderBytes(reader io.Reader, n uint64) ([]byte, error) {
+	if n <= readChunkSize {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(reader, b); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		return b, nil
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(readChunkSize)
+	if _, err := io.CopyN(&buf, reader, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
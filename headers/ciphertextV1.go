@@ -1,11 +1,11 @@
 package headers
 
 import (
+	"bytes"
 	"encoding/binary"
 	"io"
 
-	"github.com/go-errors/errors"
-	"github.com/overnest/strongsalt-common-go/tools"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
 )
 
 const (
@@ -44,13 +44,23 @@ func (h *CipherHdrV1) GetVersion() uint32 {
 
 // Serialize serializes the ciphertext header
 func (h *CipherHdrV1) Serialize() ([]byte, error) {
+	b, _, err := h.serialize(nil)
+	return b, err
+}
+
+// serialize serializes the ciphertext header, gzipping the body at the
+// level. It also returns the size of the body once serialized.
+func (h *CipherHdrV1) serialize(level *int) ([]byte, int, error) {
 	body := h.HdrBody
 	if h.HdrType.IsGzipped() {
 		var err error
-		if body, err = tools.Gzip(h.HdrBody); err != nil {
-			return nil, errors.New(err)
+		if body, err = gzipBody(h.HdrBody, level); err != nil {
+			return nil, 0, errs.Wrap(err, nil)
 		}
 	}
+	if err := checkV1BodyLen(len(body)); err != nil {
+		return nil, 0, err
+	}
 
 	b := make([]byte, 4+4+4+4+len(body))
 	binary.BigEndian.PutUint32(b[0:], h.Version)
@@ -59,7 +69,7 @@ func (h *CipherHdrV1) Serialize() ([]byte, error) {
 
 	binary.BigEndian.PutUint32(b[12:], uint32(len(body)))
 	copy(b[16:], body)
-	return b, nil
+	return b, len(body), nil
 }
 
 // Our headers have variable lengths. Therefore, when deserializing, we
@@ -92,7 +102,7 @@ func (h *CipherHdrV1) deserialize(b []byte) (complete bool, parsedBytes uint32,
 	parsedBytes += 8
 
 	if h.Prime != CipherHdrV1Prime {
-		err = errors.Errorf("Parsing error. Prime number does not match. Possible corruption")
+		err = errs.Errorf(errs.ErrCorrupt, "Parsing error. Prime number does not match. Possible corruption")
 		return
 	}
 
@@ -100,7 +110,7 @@ func (h *CipherHdrV1) deserialize(b []byte) (complete bool, parsedBytes uint32,
 	h.HdrLen = binary.BigEndian.Uint32(b[12:])
 	parsedBytes += 8
 
-	if uint32(len(b)) < parsedBytes+h.HdrLen {
+	if uint64(len(b)) < uint64(parsedBytes)+uint64(h.HdrLen) {
 		return
 	}
 
@@ -108,9 +118,9 @@ func (h *CipherHdrV1) deserialize(b []byte) (complete bool, parsedBytes uint32,
 	parsedBytes += h.HdrLen
 
 	if h.HdrType.IsGzipped() {
-		body, gerr := tools.Gunzip(h.HdrBody)
+		body, gerr := gunzipBody(h.HdrBody)
 		if gerr != nil {
-			err = errors.New(gerr)
+			err = errs.Wrap(gerr, errs.ErrCorrupt)
 			return
 		}
 		h.HdrLen = uint32(len(body))
@@ -126,6 +136,37 @@ func (h *CipherHdrV1) GetBody() ([]byte, error) {
 	return h.HdrBody, nil
 }
 
+// BodyReader returns a reader of the header body, so that the body can be
+// processed incrementally, such as by a JSON decoder
+func (h *CipherHdrV1) BodyReader() io.Reader {
+	return bytes.NewReader(h.HdrBody)
+}
+
+// CanonicalSerialize serializes the ciphertext header with its body in canonical
+// form, so that equal headers serialize to the same bytes
+func (h *CipherHdrV1) CanonicalSerialize() ([]byte, error) {
+	body, err := canonicalBody(h.HdrType, h.HdrBody)
+	if err != nil {
+		return nil, err
+	}
+	c := *h
+	c.HdrLen = uint32(len(body))
+	c.HdrBody = body
+	return c.Serialize()
+}
+
+// ContentID returns the SHA-256 of the canonical serialization of the
+// header, which identifies its contents
+func (h *CipherHdrV1) ContentID() ([32]byte, error) {
+	return contentID(h)
+}
+
+// Equal shows whether the other header has the same version, type and
+// canonical body. Bodies that are not valid JSON must be identical.
+func (h *CipherHdrV1) Equal(other Header) bool {
+	return headersEqual(h, other)
+}
+
 // DeserializeCipherHdrV1 deserializes the ciphertext header
 func DeserializeCipherHdrV1(b []byte) (complete bool, parsedBytes uint32, header *CipherHdrV1, err error) {
 	complete = false
@@ -147,46 +188,46 @@ func DeserializeCipherHdrStreamV1(reader io.Reader) (header *CipherHdrV1, parsed
 	err = nil
 
 	if err = binary.Read(reader, binary.BigEndian, &header.Prime); err != nil {
-		err = errors.WrapPrefix(err, "Can not read header prime number", 1)
+		err = errs.WrapPrefix(err, nil, "Can not read header prime number")
 		return
 	}
 	parsed += 4
 
 	if header.Prime != CipherHdrV1Prime {
-		err = errors.Errorf("Parsing error. Prime number does not match. Possible corruption")
+		err = errs.Errorf(errs.ErrCorrupt, "Parsing error. Prime number does not match. Possible corruption")
 		return
 	}
 
 	var hdrType uint32
 	if err = binary.Read(reader, binary.BigEndian, &hdrType); err != nil {
-		err = errors.WrapPrefix(err, "Can not read header type", 1)
+		err = errs.WrapPrefix(err, nil, "Can not read header type")
 		return
 	}
 	parsed += 4
 
 	if err = binary.Read(reader, binary.BigEndian, &header.HdrLen); err != nil {
-		err = errors.WrapPrefix(err, "Can not read header length", 1)
+		err = errs.WrapPrefix(err, nil, "Can not read header length")
 		return
 	}
 	parsed += 4
 
 	header.HdrType = HeaderType(hdrType)
 	header.HdrBody = make([]byte, header.HdrLen)
-	n, rerr := reader.Read(header.HdrBody)
-	if rerr != nil && rerr != io.EOF {
-		err = errors.WrapPrefix(rerr, "Can not read header body", 1)
+	n, rerr := io.ReadFull(reader, header.HdrBody)
+	if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+		err = errs.Errorf(errs.ErrTruncated, "Read %v bytes for header body but expected %v", n, header.HdrLen)
 		return
 	}
-	if uint32(n) != header.HdrLen {
-		err = errors.Errorf("Read %v bytes for header body but expected %v", n, header.HdrLen)
+	if rerr != nil {
+		err = errs.WrapPrefix(rerr, nil, "Can not read header body")
 		return
 	}
 	parsed += uint32(n)
 
 	if header.HdrType.IsGzipped() {
-		body, gerr := tools.Gunzip(header.HdrBody)
+		body, gerr := gunzipBody(header.HdrBody)
 		if gerr != nil {
-			err = errors.New(gerr)
+			err = errs.Wrap(gerr, errs.ErrCorrupt)
 			return
 		}
 		header.HdrLen = uint32(len(body))
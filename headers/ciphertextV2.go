@@ -0,0 +1,323 @@
+package headers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// The ciphertext header V2 has the following format:
+// ---------------------------------------------------------------------------------------------------------
+// | version(4) | totallen(8) | prime(4) | hdrtype(4) | hdrlen(8) | header(hdrlen) | fields(...) | crc(4) |
+// ---------------------------------------------------------------------------------------------------------
+// 1. version(4 bytes): This tells us which header version to use when
+// 	  parsing.
+// 2. totallen(8 bytes): This tells us how many bytes the whole serialized
+//    header is, from the version to the crc, as in the plaintext header V2.
+// 3. prime(4 bytes): The same prime number as the V1 header, which quickly
+//    detects data that we did not encrypt.
+// 4. hdrtype(4 bytes): Format of the header that follows
+// 5. hdrlen(8 bytes): This tells us how many bytes the serialized headers
+//    are. Unlike V1, the header can be bigger than 4GB.
+// 6. header(hdrlen bytes): The serialized header information
+// 7. fields(optional): The optional fields, as in the plaintext header V2.
+//    Ciphertext headers can also hold the AAD of the payload, and the ID of
+//    the key that encrypted it.
+// 8. crc(4 bytes): CRC-32 (Castagnoli) of all the bytes before it. Unlike
+//    the prime number, it also detects small changes to the header body.
+
+const cipherHdrV2FixedLen = 28
+
+// CipherHdrV2 is the V2 ciphertext header
+type CipherHdrV2 struct {
+	Version uint32
+	Prime   uint32
+	HdrType HeaderType
+	HdrLen  uint64
+	HdrBody []byte
+	// ParentID is the ContentID of the parent header, if there is one
+	ParentID *[32]byte
+	// AAD is the additional authenticated data that binds the header to
+	// the encrypted payload, if there is one
+	AAD []byte
+	// KeyID is the ID of the key that encrypted the payload, if there is
+	// one, for services that keep a key per tenant
+	KeyID string
+	// PayloadDigest is the digest of the payload that follows the header,
+	// if there is one
+	PayloadDigest *PayloadDigest
+}
+
+// GetVersion retrieves the version number
+func (h *CipherHdrV2) GetVersion() uint32 {
+	return h.Version
+}
+
+// Serialize serializes the ciphertext header
+func (h *CipherHdrV2) Serialize() ([]byte, error) {
+	b, _, err := h.serialize(nil)
+	return b, err
+}
+
+// serialize serializes the ciphertext header, gzipping the body at the
+// level. It also returns the size of the body once serialized.
+func (h *CipherHdrV2) serialize(level *int) ([]byte, int, error) {
+	body := h.HdrBody
+	if h.HdrType.IsGzipped() {
+		var err error
+		if body, err = gzipBody(h.HdrBody, level); err != nil {
+			return nil, 0, errs.Wrap(err, nil)
+		}
+	}
+
+	fields := &v2Fields{parentID: h.ParentID, aad: h.AAD, keyID: h.KeyID,
+		digest: h.PayloadDigest}
+	fieldBytes, err := fields.serialize()
+	if err != nil {
+		return nil, 0, err
+	}
+	totalLen, err := v2TotalLen(cipherHdrV2FixedLen, len(body), len(fieldBytes))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	b := make([]byte, totalLen)
+	binary.BigEndian.PutUint32(b[0:], h.Version)
+	binary.BigEndian.PutUint64(b[4:], totalLen)
+	binary.BigEndian.PutUint32(b[12:], h.Prime)
+	binary.BigEndian.PutUint32(b[16:], uint32(h.HdrType))
+	binary.BigEndian.PutUint64(b[20:], uint64(len(body)))
+	copy(b[28:], body)
+	copy(b[28+len(body):], fieldBytes)
+	putChecksum(b)
+	return b, len(body), nil
+}
+
+// GetBody gets the header body
+func (h *CipherHdrV2) GetBody() ([]byte, error) {
+	return h.HdrBody, nil
+}
+
+// GetAAD returns the additional authenticated data that binds the header to
+// the encrypted payload, or nil if the header has none. The encryption layer
+// passes it to the AEAD that seals the payload.
+func (h *CipherHdrV2) GetAAD() []byte {
+	return h.AAD
+}
+
+// GetKeyID returns the ID of the key that encrypted the payload, or "" if
+// the header has none
+func (h *CipherHdrV2) GetKeyID() string {
+	return h.KeyID
+}
+
+// BodyReader returns a reader of the header body, so that the body can be
+// processed incrementally, such as by a JSON decoder
+func (h *CipherHdrV2) BodyReader() io.Reader {
+	return bytes.NewReader(h.HdrBody)
+}
+
+// CanonicalSerialize serializes the ciphertext header with its body in canonical
+// form, so that equal headers serialize to the same bytes
+func (h *CipherHdrV2) CanonicalSerialize() ([]byte, error) {
+	body, err := canonicalBody(h.HdrType, h.HdrBody)
+	if err != nil {
+		return nil, err
+	}
+	c := *h
+	c.HdrLen = uint64(len(body))
+	c.HdrBody = body
+	return c.Serialize()
+}
+
+// ContentID returns the SHA-256 of the canonical serialization of the
+// header, which identifies its contents
+func (h *CipherHdrV2) ContentID() ([32]byte, error) {
+	return contentID(h)
+}
+
+// Equal shows whether the other header has the same version, type, parent
+// ID and canonical body. Bodies that are not valid JSON must be identical.
+func (h *CipherHdrV2) Equal(other Header) bool {
+	return headersEqual(h, other)
+}
+
+// See CipherHdrV1.deserialize for the meaning of the return values
+func (h *CipherHdrV2) deserialize(b []byte) (complete bool, parsedBytes uint64, err error) {
+	complete = false
+	parsedBytes = 0
+	err = nil
+
+	if len(b) < cipherHdrV2FixedLen {
+		return
+	}
+
+	h.Version = binary.BigEndian.Uint32(b[0:])
+	totalLen := binary.BigEndian.Uint64(b[4:])
+	h.Prime = binary.BigEndian.Uint32(b[12:])
+	parsedBytes += 16
+
+	if h.Prime != CipherHdrV1Prime {
+		err = errs.Errorf(errs.ErrCorrupt, "Parsing error. Prime number does not match. Possible corruption")
+		return
+	}
+
+	h.HdrType = HeaderType(binary.BigEndian.Uint32(b[16:]))
+	h.HdrLen = binary.BigEndian.Uint64(b[20:])
+	parsedBytes += 12
+
+	var fieldsLen uint64
+	if fieldsLen, err = checkV2TotalLen(totalLen, cipherHdrV2FixedLen, h.HdrLen); err != nil {
+		return
+	}
+
+	if uint64(len(b)) < totalLen {
+		return
+	}
+
+	h.HdrBody = b[parsedBytes : parsedBytes+h.HdrLen]
+	parsedBytes += h.HdrLen
+	fields, ferr := parseV2Fields(b[parsedBytes : parsedBytes+fieldsLen])
+	if ferr != nil {
+		err = ferr
+		return
+	}
+	h.ParentID = fields.parentID
+	h.AAD = fields.aad
+	h.KeyID = fields.keyID
+	h.PayloadDigest = fields.digest
+	parsedBytes += fieldsLen + 4
+
+	if err = verifyChecksum(b[:parsedBytes]); err != nil {
+		return
+	}
+
+	if h.HdrType.IsGzipped() {
+		body, gerr := gunzipBody(h.HdrBody)
+		if gerr != nil {
+			err = errs.Wrap(gerr, errs.ErrCorrupt)
+			return
+		}
+		h.HdrLen = uint64(len(body))
+		h.HdrBody = body
+	}
+
+	complete = true
+	return
+}
+
+// DeserializeCipherHdrV2 deserializes the ciphertext header. Unlike
+// DeserializeCipherHdr, it reports the parsed bytes of headers over 4GB.
+func DeserializeCipherHdrV2(b []byte) (complete bool, parsedBytes uint64, header *CipherHdrV2, err error) {
+	header = &CipherHdrV2{}
+	complete, parsedBytes, err = header.deserialize(b)
+	return
+}
+
+// DeserializeCipherHdrStreamV2 deserializes the ciphertext header after the
+// version number
+func DeserializeCipherHdrStreamV2(reader io.Reader) (header *CipherHdrV2, parsed uint64, err error) {
+	header = nil
+	parsed = 0
+	err = nil
+
+	fixed := make([]byte, cipherHdrV2FixedLen)
+	binary.BigEndian.PutUint32(fixed, CipherHeaderV2)
+	if _, err = io.ReadFull(reader, fixed[4:16]); err != nil {
+		err = errs.WrapPrefix(err, errs.ErrTruncated, "Can not read header prime number")
+		return
+	}
+	parsed += 12
+
+	if binary.BigEndian.Uint32(fixed[12:]) != CipherHdrV1Prime {
+		err = errs.Errorf(errs.ErrCorrupt, "Parsing error. Prime number does not match. Possible corruption")
+		return
+	}
+
+	if _, err = io.ReadFull(reader, fixed[16:]); err != nil {
+		err = errs.WrapPrefix(err, errs.ErrTruncated, "Can not read header type and length")
+		return
+	}
+	parsed += 12
+
+	hdrLen := binary.BigEndian.Uint64(fixed[20:])
+	totalLen := binary.BigEndian.Uint64(fixed[4:])
+	if _, err = checkV2TotalLen(totalLen, cipherHdrV2FixedLen, hdrLen); err != nil {
+		return
+	}
+
+	header = &CipherHdrV2{
+		Version: CipherHeaderV2,
+		Prime:   CipherHdrV1Prime,
+		HdrType: HeaderType(binary.BigEndian.Uint32(fixed[16:])),
+		HdrLen:  hdrLen}
+
+	var rest []byte
+	if rest, err = readBodyAndChecksum(reader, fixed, totalLen-cipherHdrV2FixedLen); err != nil {
+		return
+	}
+	header.HdrBody = rest[:header.HdrLen]
+	var fields *v2Fields
+	if fields, err = parseV2Fields(rest[header.HdrLen : len(rest)-4]); err != nil {
+		return
+	}
+	header.ParentID = fields.parentID
+	header.AAD = fields.aad
+	header.KeyID = fields.keyID
+	header.PayloadDigest = fields.digest
+	parsed += totalLen - cipherHdrV2FixedLen
+
+	if header.HdrType.IsGzipped() {
+		body, gerr := gunzipBody(header.HdrBody)
+		if gerr != nil {
+			err = errs.Wrap(gerr, errs.ErrCorrupt)
+			return
+		}
+		header.HdrLen = uint64(len(body))
+		header.HdrBody = body
+	}
+
+	return
+}
+
+// KeyLookup returns the key with the key ID of a ciphertext header
+type KeyLookup func(keyID string) ([]byte, error)
+
+// DeserializeCipherHdrStreamWithKeyLookup deserializes a ciphertext header,
+// version number included, and returns the key that encrypted the payload,
+// found by lookup from the key ID of the header. The header must be a
+// version 2 header created WithKeyID, or else the error is
+// errs.ErrUnsupportedVersion or errs.ErrNotFound. The errors of lookup are
+// wrapped, so that errs.Is still tells a missing tenant key apart.
+func DeserializeCipherHdrStreamWithKeyLookup(reader io.Reader, lookup KeyLookup) (header *CipherHdrV2,
+	key []byte, parsed uint64, err error) {
+	var version uint32
+	if err = binary.Read(reader, binary.BigEndian, &version); err != nil {
+		err = errs.WrapPrefix(err, errs.ErrTruncated, "Can not read version number")
+		return
+	}
+	parsed += 4
+	if version != CipherHeaderV2 {
+		err = errs.Errorf(errs.ErrUnsupportedVersion, "Version %v ciphertext headers do not "+
+			"have a key ID", version)
+		return
+	}
+
+	var hdrParsed uint64
+	if header, hdrParsed, err = DeserializeCipherHdrStreamV2(reader); err != nil {
+		return
+	}
+	parsed += hdrParsed
+
+	if header.KeyID == "" {
+		err = errs.New(errs.ErrNotFound, "The ciphertext header does not have a key ID")
+		return
+	}
+	if key, err = lookup(header.KeyID); err != nil {
+		err = errs.WrapPrefix(err, nil, "Can not find key "+header.KeyID)
+		return
+	}
+	return
+}
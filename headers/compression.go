@@ -0,0 +1,112 @@
+package headers
+
+import (
+	"github.com/overnest/strongsalt-common-go/tools"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// MaxGunzippedBodyLen is the biggest body a gzipped header body is
+// uncompressed to. Bigger bodies are rejected with an errs.ErrTooLarge error,
+// so that a small malicious header can not exhaust the memory.
+var MaxGunzippedBodyLen = int64(64 * 1024 * 1024)
+
+// gunzipBody uncompresses a gzipped header body, up to MaxGunzippedBodyLen
+func gunzipBody(body []byte) ([]byte, error) {
+	return tools.GunzipLimit(body, MaxGunzippedBodyLen)
+}
+
+// gzipBody gzips the header body at the level, or at the default level if
+// level is nil
+func gzipBody(body []byte, level *int) ([]byte, error) {
+	if level == nil {
+		return tools.Gzip(body)
+	}
+	return tools.GzipLevel(body, *level)
+}
+
+// SerializeOption changes how a header is serialized by SerializeWithOptions
+type SerializeOption func(opts *serializeOptions)
+
+type serializeOptions struct {
+	gzipLevel *int
+}
+
+// WithGzipLevel is a serialize option that gzips the header body at the
+// given compression level, instead of tools.GzipDefaultLevel. It can be any
+// of the compress/gzip levels.
+func WithGzipLevel(level int) SerializeOption {
+	return func(opts *serializeOptions) {
+		opts.gzipLevel = &level
+	}
+}
+
+// levelSerializer is implemented by the headers of this package, which can
+// gzip their body at any level
+type levelSerializer interface {
+	serialize(level *int) ([]byte, int, error)
+}
+
+// SerializedHeader is a header serialized by SerializeWithOptions. Its
+// Serialize returns the bytes it was serialized to, and the sizes of the
+// serialization are recorded, so that they are read without gzipping the
+// body again.
+type SerializedHeader struct {
+	Header
+	serial         []byte
+	bodySize       int
+	compressedSize int
+}
+
+// Serialize returns the bytes the header was serialized to
+func (s *SerializedHeader) Serialize() ([]byte, error) {
+	return s.serial, nil
+}
+
+// SerializedSize returns the size of the serialized header
+func (s *SerializedHeader) SerializedSize() int {
+	return len(s.serial)
+}
+
+// BodySize returns the size of the header body before it is gzipped
+func (s *SerializedHeader) BodySize() int {
+	return s.bodySize
+}
+
+// CompressedBodySize returns the size of the body in the serialized header,
+// which is BodySize for headers whose body is not gzipped
+func (s *SerializedHeader) CompressedBodySize() int {
+	return s.compressedSize
+}
+
+// CompressionRatio returns BodySize divided by CompressedBodySize, or 1 if
+// the body is empty and not gzipped
+func (s *SerializedHeader) CompressionRatio() float64 {
+	if s.compressedSize == 0 {
+		return 1
+	}
+	return float64(s.bodySize) / float64(s.compressedSize)
+}
+
+// SerializeWithOptions serializes the header like Serialize, with the
+// options, such as WithGzipLevel. The header is not changed, so it is safe
+// to serialize a shared header.
+func SerializeWithOptions(hdr Header, opts ...SerializeOption) (*SerializedHeader, error) {
+	options := &serializeOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	s, ok := hdr.(levelSerializer)
+	if !ok {
+		return nil, errs.New(nil, "The header can not be serialized with options")
+	}
+	body, err := hdr.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	serial, compressedSize, err := s.serialize(options.gzipLevel)
+	if err != nil {
+		return nil, err
+	}
+	return &SerializedHeader{Header: hdr, serial: serial, bodySize: len(body),
+		compressedSize: compressedSize}, nil
+}
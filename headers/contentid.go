@@ -0,0 +1,25 @@
+package headers
+
+import (
+	"crypto/sha256"
+)
+
+// WithParentID is a create option that stores the ContentID of a parent
+// header, such as the previous version of the same metadata, in the header.
+// Only version 2 headers can hold a parent ID, so it also implies
+// WithChecksum.
+func WithParentID(id [32]byte) CreateOption {
+	return func(opts *createOptions) {
+		opts.checksum = true
+		opts.parentID = &id
+	}
+}
+
+// contentID returns the SHA-256 of the canonical serialization of a header
+func contentID(h Header) ([32]byte, error) {
+	b, err := h.CanonicalSerialize()
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return sha256.Sum256(b), nil
+}
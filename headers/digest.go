@@ -0,0 +1,106 @@
+package headers
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	stderrors "errors"
+	"hash"
+	"io"
+
+	"github.com/overnest/strongsalt-common-go/tools"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// ErrPayloadDigest means the payload that follows a header does not match
+// the payload digest in the header
+var ErrPayloadDigest = stderrors.New("payload digest mismatch")
+
+// DigestAlgorithm is the hash algorithm of a PayloadDigest
+type DigestAlgorithm uint8
+
+const (
+	_ = iota // Skip 0
+	// DigestSHA256 is SHA-256
+	DigestSHA256 = DigestAlgorithm(iota)
+	// DigestSHA512 is SHA-512
+	DigestSHA512
+)
+
+// newHash returns a new hash of the algorithm
+func (alg DigestAlgorithm) newHash() (hash.Hash, error) {
+	switch alg {
+	case DigestSHA256:
+		return sha256.New(), nil
+	case DigestSHA512:
+		return sha512.New(), nil
+	}
+	return nil, errs.Errorf(errs.ErrUnsupportedVersion, "Payload digest algorithm %v is not "+
+		"supported", alg)
+}
+
+// PayloadDigest is the digest of the payload that follows a header. It is
+// stored in the header as an optional field, with the following format:
+// -------------------------------
+// | algorithm(1) | hash(...) |
+// -------------------------------
+type PayloadDigest struct {
+	Algorithm DigestAlgorithm
+	Hash      []byte
+}
+
+// WithPayloadDigest is a create option that stores the digest of the payload
+// that follows the header in the header, so that a receiver can check the
+// payload with VerifyPayload, using only the header. The digest can be
+// computed with DigestPayload. Only version 2 headers can hold the digest,
+// so it also implies WithChecksum.
+func WithPayloadDigest(digest PayloadDigest) CreateOption {
+	return func(opts *createOptions) {
+		opts.checksum = true
+		opts.payloadDigest = &digest
+	}
+}
+
+// DigestPayload reads the payload to the end, and returns its digest
+func DigestPayload(alg DigestAlgorithm, r io.Reader) (PayloadDigest, error) {
+	h, err := alg.newHash()
+	if err != nil {
+		return PayloadDigest{}, err
+	}
+	if _, err = io.Copy(h, r); err != nil {
+		return PayloadDigest{}, errs.WrapPrefix(err, nil, "Can not read the payload")
+	}
+	return PayloadDigest{Algorithm: alg, Hash: h.Sum(nil)}, nil
+}
+
+// GetPayloadDigest returns the payload digest of a header, or nil if the
+// header has none. Only version 2 headers can have one.
+func GetPayloadDigest(hdr Header) *PayloadDigest {
+	switch h := hdr.(type) {
+	case *PlainHdrV2:
+		return h.PayloadDigest
+	case *CipherHdrV2:
+		return h.PayloadDigest
+	}
+	return nil
+}
+
+// VerifyPayload reads the payload to the end, hashing it as it goes, and
+// compares its digest with the payload digest of the header. It returns an
+// ErrPayloadDigest error if they do not match, and an errs.ErrNotFound error
+// if the header has no payload digest.
+func VerifyPayload(r io.Reader, hdr Header) error {
+	expected := GetPayloadDigest(hdr)
+	if expected == nil {
+		return errs.New(errs.ErrNotFound, "The header does not have a payload digest")
+	}
+
+	actual, err := DigestPayload(expected.Algorithm, r)
+	if err != nil {
+		return err
+	}
+	if !tools.ConstantTimeEqual(expected.Hash, actual.Hash) {
+		return errs.Errorf(ErrPayloadDigest, "Payload digest(%x) does not match the header "+
+			"payload digest(%x)", actual.Hash, expected.Hash)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package headers
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// V2 headers can have optional fields between the body and the checksum.
+// Each field has the following format:
+// ----------------------------------
+// | tag(4) | len(4) | value(len) |
+// ----------------------------------
+// Readers skip the fields whose tag they do not know, so that fields can be
+// added without a new header version.
+const (
+	// fieldParentID is the ContentID of the parent header
+	fieldParentID = uint32(1)
+	// fieldAAD is the additional authenticated data of the payload of a
+	// ciphertext header
+	fieldAAD = uint32(2)
+	// fieldKeyID is the ID of the key that encrypted the payload of a
+	// ciphertext header
+	fieldKeyID = uint32(3)
+	// fieldReserved is the zeros that pad a header to its reserved length
+	fieldReserved = uint32(4)
+	// fieldPayloadDigest is the PayloadDigest of the payload that follows
+	// the header
+	fieldPayloadDigest = uint32(5)
+	// fieldSignature is the HeaderSignature of a header appended to an
+	// AppendOnlyHeaderLog
+	fieldSignature = uint32(6)
+
+	fieldHeaderLen = 8
+)
+
+// v2Fields are the optional fields of a V2 header
+type v2Fields struct {
+	parentID *[32]byte
+	aad      []byte
+	keyID    string
+	reserved bool
+	digest   *PayloadDigest
+	sig      *HeaderSignature
+}
+
+// serialize serializes the fields that are set
+func (f *v2Fields) serialize() ([]byte, error) {
+	var b []byte
+	if f.parentID != nil {
+		b = appendField(b, fieldParentID, f.parentID[:])
+	}
+	if f.aad != nil {
+		if uint64(len(f.aad)) > math.MaxUint32 {
+			return nil, errs.Errorf(errs.ErrTooLarge, "Header AAD length(%v) is too large",
+				len(f.aad))
+		}
+		b = appendField(b, fieldAAD, f.aad)
+	}
+	if f.keyID != "" {
+		if uint64(len(f.keyID)) > math.MaxUint32 {
+			return nil, errs.Errorf(errs.ErrTooLarge, "Header key ID length(%v) is too large",
+				len(f.keyID))
+		}
+		b = appendField(b, fieldKeyID, []byte(f.keyID))
+	}
+	if f.digest != nil {
+		if uint64(len(f.digest.Hash)) >= math.MaxUint32 {
+			return nil, errs.Errorf(errs.ErrTooLarge, "Header payload digest length(%v) is "+
+				"too large", len(f.digest.Hash))
+		}
+		value := append([]byte{byte(f.digest.Algorithm)}, f.digest.Hash...)
+		b = appendField(b, fieldPayloadDigest, value)
+	}
+	if f.sig != nil {
+		if uint64(len(f.sig.SignerID))+uint64(len(f.sig.Signature)) > math.MaxUint32-4 {
+			return nil, errs.Errorf(errs.ErrTooLarge, "Header signature length(%v) is too large",
+				len(f.sig.SignerID)+len(f.sig.Signature))
+		}
+		value := make([]byte, 4, 4+len(f.sig.SignerID)+len(f.sig.Signature))
+		binary.BigEndian.PutUint32(value, uint32(len(f.sig.SignerID)))
+		value = append(append(value, f.sig.SignerID...), f.sig.Signature...)
+		b = appendField(b, fieldSignature, value)
+	}
+	return b, nil
+}
+
+func appendField(b []byte, tag uint32, value []byte) []byte {
+	var hdr [fieldHeaderLen]byte
+	binary.BigEndian.PutUint32(hdr[0:], tag)
+	binary.BigEndian.PutUint32(hdr[4:], uint32(len(value)))
+	return append(append(b, hdr[:]...), value...)
+}
+
+// parseV2Fields parses the fields between the body and the checksum
+func parseV2Fields(b []byte) (*v2Fields, error) {
+	f := &v2Fields{}
+	for len(b) > 0 {
+		if len(b) < fieldHeaderLen {
+			return nil, errs.New(errs.ErrCorrupt, "Header field is truncated")
+		}
+		tag := binary.BigEndian.Uint32(b[0:])
+		valueLen := uint64(binary.BigEndian.Uint32(b[4:]))
+		if valueLen > uint64(len(b)-fieldHeaderLen) {
+			return nil, errs.Errorf(errs.ErrCorrupt, "Header field %v length(%v) is bigger "+
+				"than the header", tag, valueLen)
+		}
+		value := b[fieldHeaderLen : fieldHeaderLen+valueLen]
+
+		switch tag {
+		case fieldParentID:
+			if len(value) != len(f.parentID) {
+				return nil, errs.Errorf(errs.ErrCorrupt, "Header parent ID length(%v) "+
+					"is not %v", len(value), len(f.parentID))
+			}
+			var id [32]byte
+			copy(id[:], value)
+			f.parentID = &id
+		case fieldAAD:
+			f.aad = append([]byte{}, value...)
+		case fieldKeyID:
+			f.keyID = string(value)
+		case fieldReserved:
+			f.reserved = true
+		case fieldPayloadDigest:
+			if len(value) == 0 {
+				return nil, errs.New(errs.ErrCorrupt, "Header payload digest has no algorithm")
+			}
+			f.digest = &PayloadDigest{Algorithm: DigestAlgorithm(value[0]),
+				Hash: append([]byte{}, value[1:]...)}
+		case fieldSignature:
+			if len(value) < 4 || uint64(binary.BigEndian.Uint32(value)) > uint64(len(value)-4) {
+				return nil, errs.New(errs.ErrCorrupt, "Header signature is truncated")
+			}
+			signerEnd := 4 + binary.BigEndian.Uint32(value)
+			f.sig = &HeaderSignature{SignerID: string(value[4:signerEnd]),
+				Signature: append([]byte{}, value[signerEnd:]...)}
+		}
+		b = b[fieldHeaderLen+valueLen:]
+	}
+	return f, nil
+}
@@ -3,10 +3,13 @@ package headers
 import (
 	"encoding/binary"
 	"encoding/json"
+	"fmt"
 	"io"
+	"math"
+	"strings"
 	"unsafe"
 
-	"github.com/go-errors/errors"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
 )
 
 //
@@ -19,6 +22,10 @@ type Header interface {
 	GetVersion() uint32
 	Serialize() ([]byte, error)
 	GetBody() ([]byte, error)
+	BodyReader() io.Reader
+	CanonicalSerialize() ([]byte, error)
+	Equal(other Header) bool
+	ContentID() ([32]byte, error)
 }
 
 // HeaderVer is structure used to parse header version
@@ -35,7 +42,7 @@ func (h *HeaderVer) GetVersion() uint32 {
 func (h *HeaderVer) Deserialize(data []byte) (*HeaderVer, error) {
 	err := json.Unmarshal(data, h)
 	if err != nil {
-		return nil, errors.New(err)
+		return nil, errs.Wrap(err, nil)
 	}
 	return h, nil
 }
@@ -66,6 +73,8 @@ const (
 	_ = iota // Skip 0
 	// PlainHeaderV1 is plaintext header version 1
 	PlainHeaderV1 = uint32(iota)
+	// PlainHeaderV2 is plaintext header version 2
+	PlainHeaderV2 = uint32(iota)
 
 	// PlainHeaderCurV is the current version of plaintext header
 	PlainHeaderCurV = PlainHeaderV1
@@ -75,6 +84,8 @@ const (
 	_ = iota // Skip 0
 	// CipherHeaderV1 is ciphertext header version 1
 	CipherHeaderV1 = uint32(iota)
+	// CipherHeaderV2 is ciphertext header version 2
+	CipherHeaderV2 = uint32(iota)
 
 	// CipherHeaderCurV is the current version of ciphertext header
 	CipherHeaderCurV = CipherHeaderV1
@@ -90,20 +101,190 @@ var (
 	HeaderTypes = []HeaderType{
 		HeaderTypeJSON, HeaderTypeJSONGzip,
 		HeaderTypeBSON, HeaderTypeBSONGzip}
+
+	headerTypeNames = map[HeaderType]string{
+		HeaderTypeJSON:     "json",
+		HeaderTypeJSONGzip: "json-gzip",
+		HeaderTypeBSON:     "bson",
+		HeaderTypeBSONGzip: "bson-gzip",
+	}
 )
 
-// CreatePlainHdr creates a plaintext header
-func CreatePlainHdr(hdrType HeaderType, hdrBody []byte) Header {
-	hdr := &PlainHdrV1{PlainHeaderV1, hdrType,
-		uint32(len(hdrBody)), hdrBody}
-	return hdr
+// IsValid shows whether the header type is one of HeaderTypes
+func (t HeaderType) IsValid() bool {
+	_, ok := headerTypeNames[t]
+	return ok
+}
+
+// String returns the name of the header type, such as "json-gzip", or
+// "HeaderType(n)" for an invalid header type
+func (t HeaderType) String() string {
+	if name, ok := headerTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("HeaderType(%d)", int(t))
+}
+
+// ParseHeaderType returns the header type with the name returned by String.
+// Names are not case sensitive.
+func ParseHeaderType(s string) (HeaderType, error) {
+	name := strings.ToLower(strings.TrimSpace(s))
+	for t, typeName := range headerTypeNames {
+		if typeName == name {
+			return t, nil
+		}
+	}
+	return 0, errs.Errorf(nil, "Unknown header type name %q", s)
+}
+
+// withGzip returns the header type with the same body format, gzipped or not
+func (t HeaderType) withGzip(gzip bool) HeaderType {
+	switch t {
+	case HeaderTypeJSON, HeaderTypeJSONGzip:
+		if gzip {
+			return HeaderTypeJSONGzip
+		}
+		return HeaderTypeJSON
+	case HeaderTypeBSON, HeaderTypeBSONGzip:
+		if gzip {
+			return HeaderTypeBSONGzip
+		}
+		return HeaderTypeBSON
+	}
+	return t
+}
+
+// CreateOption changes how a header is created
+type CreateOption func(opts *createOptions)
+
+type createOptions struct {
+	autoGzip      bool
+	gzipThreshold int
+	checksum      bool
+	parentID      *[32]byte
+	aad           []byte
+	keyID         string
+	payloadDigest *PayloadDigest
+}
+
+// WithAutoGzip is a create option that gzips the header body only when it is
+// bigger than threshold bytes. The header type records whether the body is
+// gzipped, whichever of the gzipped or plain variants of the body format
+// was passed in.
+func WithAutoGzip(threshold int) CreateOption {
+	return func(opts *createOptions) {
+		opts.autoGzip = true
+		opts.gzipThreshold = threshold
+	}
+}
+
+// WithChecksum is a create option that creates a version 2 header, which
+// ends with a checksum of the header bytes. Older readers only parse
+// version 1 headers.
+func WithChecksum() CreateOption {
+	return func(opts *createOptions) {
+		opts.checksum = true
+	}
+}
+
+// WithAAD is a create option for ciphertext headers that stores the
+// additional authenticated data of the encrypted payload, such as a document
+// ID and version, in the header. Only version 2 headers can hold the AAD, so
+// it also implies WithChecksum.
+func WithAAD(aad []byte) CreateOption {
+	return func(opts *createOptions) {
+		opts.checksum = true
+		opts.aad = aad
+	}
+}
+
+// WithKeyID is a create option for ciphertext headers that stores the ID of
+// the key that encrypted the payload in the header, so that a reader can
+// find the key of the tenant with DeserializeCipherHdrStreamWithKeyLookup.
+// Only version 2 headers can hold the key ID, so it also implies
+// WithChecksum.
+func WithKeyID(keyID string) CreateOption {
+	return func(opts *createOptions) {
+		opts.checksum = true
+		opts.keyID = keyID
+	}
+}
+
+func applyCreateOptions(hdrType HeaderType, hdrBody []byte, opts []CreateOption) (HeaderType, *createOptions) {
+	options := &createOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	if options.autoGzip {
+		hdrType = hdrType.withGzip(len(hdrBody) > options.gzipThreshold)
+	}
+	return hdrType, options
+}
+
+// maxV1BodyLen is the biggest body a V1 header can hold
+var maxV1BodyLen = uint64(math.MaxUint32)
+
+// checkV1BodyLen makes sure a body fits in a V1 header, instead of letting
+// its length be truncated to 32 bits
+func checkV1BodyLen(bodyLen int) error {
+	if uint64(bodyLen) > maxV1BodyLen {
+		return errs.Errorf(errs.ErrTooLarge, "Header body length(%v) is too large for a "+
+			"version 1 header. Use WithChecksum to create a version 2 header", bodyLen)
+	}
+	return nil
+}
+
+// CreatePlainHdr creates a plaintext header. Plaintext headers have no AAD
+// or key ID, so WithAAD and WithKeyID are ignored. A body too large for a
+// version 1 header is put in a version 2 header, as if WithChecksum was
+// passed.
+func CreatePlainHdr(hdrType HeaderType, hdrBody []byte, opts ...CreateOption) Header {
+	hdrType, options := applyCreateOptions(hdrType, hdrBody, opts)
+	if !options.checksum {
+		if hdr, err := NewPlainHdrV1(hdrType, hdrBody); err == nil {
+			return hdr
+		}
+	}
+	return &PlainHdrV2{Version: PlainHeaderV2, HdrType: hdrType,
+		HdrLen: uint64(len(hdrBody)), HdrBody: hdrBody,
+		ParentID: options.parentID, PayloadDigest: options.payloadDigest}
+}
+
+// CreateCipherHdr creates a ciphertext header. A body too large for a
+// version 1 header is put in a version 2 header, as if WithChecksum was
+// passed.
+func CreateCipherHdr(hdrType HeaderType, hdrBody []byte, opts ...CreateOption) Header {
+	hdrType, options := applyCreateOptions(hdrType, hdrBody, opts)
+	if !options.checksum {
+		if hdr, err := NewCipherHdrV1(hdrType, hdrBody); err == nil {
+			return hdr
+		}
+	}
+	return &CipherHdrV2{Version: CipherHeaderV2, Prime: CipherHdrV1Prime,
+		HdrType: hdrType, HdrLen: uint64(len(hdrBody)), HdrBody: hdrBody,
+		ParentID: options.parentID, AAD: options.aad, KeyID: options.keyID,
+		PayloadDigest: options.payloadDigest}
+}
+
+// NewPlainHdrV1 creates a version 1 plaintext header, or returns an
+// errs.ErrTooLarge error if the body is too large for its 32 bit length
+func NewPlainHdrV1(hdrType HeaderType, hdrBody []byte) (*PlainHdrV1, error) {
+	if err := checkV1BodyLen(len(hdrBody)); err != nil {
+		return nil, err
+	}
+	return &PlainHdrV1{PlainHeaderV1, hdrType,
+		uint32(len(hdrBody)), hdrBody}, nil
 }
 
-// CreateCipherHdr creates a ciphertext header
-func CreateCipherHdr(hdrType HeaderType, hdrBody []byte) Header {
-	hdr := &CipherHdrV1{CipherHeaderV1, CipherHdrV1Prime,
-		hdrType, uint32(len(hdrBody)), hdrBody}
-	return hdr
+// NewCipherHdrV1 creates a version 1 ciphertext header, or returns an
+// errs.ErrTooLarge error if the body is too large for its 32 bit length
+func NewCipherHdrV1(hdrType HeaderType, hdrBody []byte) (*CipherHdrV1, error) {
+	if err := checkV1BodyLen(len(hdrBody)); err != nil {
+		return nil, err
+	}
+	return &CipherHdrV1{CipherHeaderV1, CipherHdrV1Prime,
+		hdrType, uint32(len(hdrBody)), hdrBody}, nil
 }
 
 // Our headers have variable lengths. Therefore, when deserializing, we
@@ -113,7 +294,8 @@ func CreateCipherHdr(hdrType HeaderType, hdrBody []byte) Header {
 // for all deserialization functions:
 // 1. complete: whether the passed in byte array is enough to deserialize the
 // 			 entire header. If complete = false, then the user needs to
-// 			 retry the function with more bytes.
+// 			 retry the function with more bytes. PlainHdrBytesNeeded and
+// 			 CipherHdrBytesNeeded tell how many more at least.
 // 2. parsedBytes: if complete = true, then this field tells the caller how
 // 			 many bytes of the input array was actually used. The rest
 // 			 of the array would be part of the data that follows this
@@ -140,9 +322,16 @@ func DeserializePlainHdr(b []byte) (complete bool, parsedBytes uint32, header He
 	switch version {
 	case PlainHeaderV1:
 		return DeserializePlainHdrV1(b)
+	case PlainHeaderV2:
+		var parsed uint64
+		complete, parsed, header, err = DeserializePlainHdrV2(b)
+		if err == nil {
+			parsedBytes, err = parsedLen32(parsed)
+		}
+		return
 	}
 
-	err = errors.Errorf("Version %v is not supported", version)
+	err = errs.Errorf(errs.ErrUnsupportedVersion, "Version %v is not supported", version)
 	return
 }
 
@@ -154,7 +343,7 @@ func DeserializePlainHdrStream(reader io.Reader) (header Header, parsed uint32,
 
 	var version uint32
 	if err = binary.Read(reader, binary.BigEndian, &version); err != nil {
-		err = errors.WrapPrefix(err, "Can not read version number", 1)
+		err = errs.WrapPrefix(err, nil, "Can not read version number")
 		return
 	}
 
@@ -163,8 +352,15 @@ func DeserializePlainHdrStream(reader io.Reader) (header Header, parsed uint32,
 		header, parsed, err = DeserializePlainHdrStreamV1(reader)
 		parsed += 4
 		return
+	case PlainHeaderV2:
+		var parsed64 uint64
+		header, parsed64, err = DeserializePlainHdrStreamV2(reader)
+		if err == nil {
+			parsed, err = parsedLen32(parsed64 + 4)
+		}
+		return
 	default:
-		err = errors.Errorf("Version %v is not supported", version)
+		err = errs.Errorf(errs.ErrUnsupportedVersion, "Version %v is not supported", version)
 		return
 	}
 }
@@ -186,9 +382,16 @@ func DeserializeCipherHdr(b []byte) (complete bool, parsedBytes uint32, header H
 	switch version {
 	case CipherHeaderV1:
 		return DeserializeCipherHdrV1(b)
+	case CipherHeaderV2:
+		var parsed uint64
+		complete, parsed, header, err = DeserializeCipherHdrV2(b)
+		if err == nil {
+			parsedBytes, err = parsedLen32(parsed)
+		}
+		return
 	}
 
-	err = errors.Errorf("Version %v is not supported", version)
+	err = errs.Errorf(errs.ErrUnsupportedVersion, "Version %v is not supported", version)
 	return
 }
 
@@ -200,7 +403,7 @@ func DeserializeCipherHdrStream(reader io.Reader) (header Header, parsed uint32,
 
 	var version uint32
 	if err = binary.Read(reader, binary.BigEndian, &version); err != nil {
-		err = errors.WrapPrefix(err, "Can not read version number", 1)
+		err = errs.WrapPrefix(err, nil, "Can not read version number")
 		return
 	}
 
@@ -209,8 +412,57 @@ func DeserializeCipherHdrStream(reader io.Reader) (header Header, parsed uint32,
 		header, parsed, err = DeserializeCipherHdrStreamV1(reader)
 		parsed += 4
 		return
+	case CipherHeaderV2:
+		var parsed64 uint64
+		header, parsed64, err = DeserializeCipherHdrStreamV2(reader)
+		if err == nil {
+			parsed, err = parsedLen32(parsed64 + 4)
+		}
+		return
 	default:
-		err = errors.Errorf("Version %v is not supported", version)
+		err = errs.Errorf(errs.ErrUnsupportedVersion, "Version %v is not supported", version)
 		return
 	}
 }
+
+// parsedLen32 returns the length of a parsed header, for the deserialization
+// functions that report it in 32 bits
+func parsedLen32(parsed uint64) (uint32, error) {
+	if parsed > math.MaxUint32 {
+		return 0, errs.Errorf(errs.ErrTooLarge, "Header length(%v) is bigger than 4GB. "+
+			"Use the V2 deserialization functions", parsed)
+	}
+	return uint32(parsed), nil
+}
+
+// DeserializePlainHdrSeeker is DeserializePlainHdrStream for storage that
+// can seek. On any error, the reader is moved back to where the header
+// started, so the caller can retry with another parser.
+func DeserializePlainHdrSeeker(reader io.ReadSeeker) (header Header, parsed uint32, err error) {
+	return deserializeHdrSeeker(reader, DeserializePlainHdrStream)
+}
+
+// DeserializeCipherHdrSeeker is DeserializeCipherHdrStream for storage that
+// can seek. On any error, the reader is moved back to where the header
+// started, so the caller can retry with another parser.
+func DeserializeCipherHdrSeeker(reader io.ReadSeeker) (header Header, parsed uint32, err error) {
+	return deserializeHdrSeeker(reader, DeserializeCipherHdrStream)
+}
+
+func deserializeHdrSeeker(reader io.ReadSeeker,
+	deserialize func(io.Reader) (Header, uint32, error)) (Header, uint32, error) {
+	start, err := reader.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, 0, errs.WrapPrefix(err, nil, "Can not get the header offset")
+	}
+
+	header, parsed, err := deserialize(reader)
+	if err != nil {
+		if _, serr := reader.Seek(start, io.SeekStart); serr != nil {
+			return nil, 0, errs.WrapPrefix(serr, nil, fmt.Sprintf(
+				"Can not rewind after header error(%v)", err))
+		}
+		return nil, 0, err
+	}
+	return header, parsed, nil
+}
@@ -1,9 +1,24 @@
 package headers
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 
+	"github.com/overnest/strongsalt-common-go/tools/errs"
 	"gotest.tools/assert"
 )
 
@@ -133,3 +148,1302 @@ func TestPlaintextCiphtextHeaderStreamV1(t *testing.T) {
 		assert.DeepEqual(t, cipherHdr.HdrBody, []byte(teststr))
 	}
 }
+
+func TestHeaderBuilder(t *testing.T) {
+	small := map[string]string{"key": "value"}
+	large := map[string]string{"key": teststr}
+
+	for _, builder := range []func() *HeaderBuilder{NewPlainBuilder, NewCipherBuilder} {
+		hdr, err := builder().BodyJSON(small).Build()
+		assert.NilError(t, err)
+		s, err := hdr.Serialize()
+		assert.NilError(t, err)
+
+		var d Header
+		if _, ok := hdr.(*PlainHdrV1); ok {
+			_, _, d, err = DeserializePlainHdr(s)
+		} else {
+			_, _, d, err = DeserializeCipherHdr(s)
+		}
+		assert.NilError(t, err)
+		assert.DeepEqual(t, d, hdr)
+		body, err := d.GetBody()
+		assert.NilError(t, err)
+		assert.Equal(t, string(body), `{"key":"value"}`)
+
+		// Big bodies are gzipped
+		hdr, err = builder().BodyJSON(large).Build()
+		assert.NilError(t, err)
+		switch h := hdr.(type) {
+		case *PlainHdrV1:
+			assert.Equal(t, h.HdrType, HeaderTypeJSONGzip)
+			assert.Equal(t, h.HdrLen, uint32(len(h.HdrBody)))
+		case *CipherHdrV1:
+			assert.Equal(t, h.HdrType, HeaderTypeJSONGzip)
+			assert.Equal(t, h.HdrLen, uint32(len(h.HdrBody)))
+		}
+
+		_, err = builder().Type(HeaderTypeBSON).BodyJSON(small).Build()
+		assert.Assert(t, err != nil)
+		_, err = builder().Type(HeaderType(100)).Body([]byte{1}).Build()
+		assert.Assert(t, err != nil)
+		_, err = builder().BodyJSON(make(chan int)).Type(HeaderTypeJSON).Build()
+		assert.Assert(t, err != nil)
+
+		hdr, err = builder().Type(HeaderTypeBSON).Body([]byte{1, 2, 3}).Build()
+		assert.NilError(t, err)
+		body, err = hdr.GetBody()
+		assert.NilError(t, err)
+		assert.DeepEqual(t, body, []byte{1, 2, 3})
+	}
+}
+
+func TestCreateAutoGzip(t *testing.T) {
+	small := []byte(`{"key":"value"}`)
+	large := []byte(teststr)
+
+	tests := []struct {
+		hdrType  HeaderType
+		body     []byte
+		expected HeaderType
+	}{
+		{HeaderTypeJSONGzip, small, HeaderTypeJSON},
+		{HeaderTypeJSON, small, HeaderTypeJSON},
+		{HeaderTypeJSON, large, HeaderTypeJSONGzip},
+		{HeaderTypeJSONGzip, large, HeaderTypeJSONGzip},
+		{HeaderTypeBSONGzip, small, HeaderTypeBSON},
+		{HeaderTypeBSON, large, HeaderTypeBSONGzip},
+	}
+
+	for _, test := range tests {
+		plainHdr := CreatePlainHdr(test.hdrType, test.body, WithAutoGzip(64)).(*PlainHdrV1)
+		assert.Equal(t, plainHdr.HdrType, test.expected)
+		cipherHdr := CreateCipherHdr(test.hdrType, test.body, WithAutoGzip(64)).(*CipherHdrV1)
+		assert.Equal(t, cipherHdr.HdrType, test.expected)
+
+		s, err := plainHdr.Serialize()
+		assert.NilError(t, err)
+		_, _, d, err := DeserializePlainHdrV1(s)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, d, plainHdr)
+	}
+
+	// Without the option, the type is kept
+	plainHdr := CreatePlainHdr(HeaderTypeJSONGzip, small).(*PlainHdrV1)
+	assert.Equal(t, plainHdr.HdrType, HeaderTypeJSONGzip)
+}
+
+func TestHeaderChecksumV2(t *testing.T) {
+	for _, hdrType := range HeaderTypes {
+		headers := []Header{
+			CreatePlainHdr(hdrType, []byte(teststr), WithChecksum()),
+			CreateCipherHdr(hdrType, []byte(teststr), WithChecksum()),
+		}
+		deserializers := []func([]byte) (bool, uint32, Header, error){
+			DeserializePlainHdr, DeserializeCipherHdr}
+		streamDeserializers := []func(io.Reader) (Header, uint32, error){
+			DeserializePlainHdrStream, DeserializeCipherHdrStream}
+
+		for i, header := range headers {
+			assert.Equal(t, header.GetVersion(), uint32(2))
+			s, err := header.Serialize()
+			assert.NilError(t, err)
+
+			complete, parsedBytes, d, err := deserializers[i](s)
+			assert.NilError(t, err)
+			assert.Equal(t, complete, true)
+			assert.Equal(t, parsedBytes, uint32(len(s)))
+			assert.DeepEqual(t, d, header)
+
+			d, parsed, err := streamDeserializers[i](bytes.NewReader(s))
+			assert.NilError(t, err)
+			assert.Equal(t, parsed, uint32(len(s)))
+			assert.DeepEqual(t, d, header)
+
+			// The checksum is needed to complete the header
+			complete, _, _, err = deserializers[i](s[:len(s)-1])
+			assert.NilError(t, err)
+			assert.Equal(t, complete, false)
+
+			// A flipped bit in the body is detected
+			s[len(s)-10] ^= 1
+			_, _, _, err = deserializers[i](s)
+			assert.Assert(t, errors.Is(err, ErrHeaderChecksum))
+			_, _, err = streamDeserializers[i](bytes.NewReader(s))
+			assert.Assert(t, errors.Is(err, ErrHeaderChecksum))
+		}
+	}
+
+	hdr, err := NewPlainBuilder().BodyJSON(teststr).Checksum().Build()
+	assert.NilError(t, err)
+	assert.Equal(t, hdr.GetVersion(), PlainHeaderV2)
+
+	// Version 2 is only created WithChecksum
+	assert.Equal(t, CreatePlainHdr(HeaderTypeJSON, []byte(teststr)).GetVersion(), PlainHeaderCurV)
+	assert.Equal(t, CreateCipherHdr(HeaderTypeJSON, []byte(teststr)).GetVersion(), CipherHeaderCurV)
+}
+
+func TestPeek(t *testing.T) {
+	headers := []Header{
+		CreatePlainHdr(HeaderTypeJSON, []byte(teststr)),
+		CreatePlainHdr(HeaderTypeJSONGzip, []byte(teststr), WithChecksum()),
+		CreateCipherHdr(HeaderTypeBSON, []byte(teststr)),
+		CreateCipherHdr(HeaderTypeJSON, []byte(teststr), WithChecksum()),
+	}
+	kinds := []HeaderKind{HeaderKindPlain, HeaderKindPlain, HeaderKindCipher, HeaderKindCipher}
+
+	for i, header := range headers {
+		s, err := header.Serialize()
+		assert.NilError(t, err)
+
+		r := bufio.NewReader(bytes.NewReader(append(s, "trailing data"...)))
+		kind, version, totalLen, err := Peek(r)
+		assert.NilError(t, err)
+		assert.Equal(t, kind, kinds[i])
+		assert.Equal(t, version, header.GetVersion())
+		assert.Equal(t, totalLen, uint64(len(s)))
+
+		// Nothing was consumed
+		var d Header
+		if kind == HeaderKindPlain {
+			d, _, err = DeserializePlainHdrStream(r)
+		} else {
+			d, _, err = DeserializeCipherHdrStream(r)
+		}
+		assert.NilError(t, err)
+		assert.DeepEqual(t, d, header)
+	}
+
+	// Unsupported versions are reported without consuming the stream
+	s, err := CreateCipherHdr(HeaderTypeJSON, []byte(teststr)).Serialize()
+	assert.NilError(t, err)
+	s[3] = 100
+	r := bufio.NewReader(bytes.NewReader(s))
+	kind, version, _, err := Peek(r)
+	assert.Assert(t, errors.Is(err, errs.ErrUnsupportedVersion))
+	assert.Equal(t, kind, HeaderKindCipher)
+	assert.Equal(t, version, uint32(100))
+	assert.Equal(t, r.Buffered(), len(s))
+
+	_, _, _, err = Peek(bufio.NewReader(bytes.NewReader([]byte("not a header"))))
+	assert.Assert(t, errors.Is(err, errs.ErrCorrupt))
+	_, _, _, err = Peek(bufio.NewReader(bytes.NewReader(s[:6])))
+	assert.Assert(t, errors.Is(err, errs.ErrTruncated))
+}
+
+func TestDeserializeHdrSeeker(t *testing.T) {
+	plain, err := CreatePlainHdr(HeaderTypeJSON, []byte(teststr), WithChecksum()).Serialize()
+	assert.NilError(t, err)
+	cipher, err := CreateCipherHdr(HeaderTypeJSON, []byte(teststr)).Serialize()
+	assert.NilError(t, err)
+
+	// The cipher parser fails on a plaintext header, and the plain one is retried
+	reader := bytes.NewReader(append([]byte("prefix"), plain...))
+	_, err = reader.Seek(6, io.SeekStart)
+	assert.NilError(t, err)
+	_, _, err = DeserializeCipherHdrSeeker(reader)
+	assert.Assert(t, err != nil)
+	offset, err := reader.Seek(0, io.SeekCurrent)
+	assert.NilError(t, err)
+	assert.Equal(t, offset, int64(6))
+
+	header, parsed, err := DeserializePlainHdrSeeker(reader)
+	assert.NilError(t, err)
+	assert.Equal(t, parsed, uint32(len(plain)))
+	assert.Equal(t, header.GetVersion(), PlainHeaderV2)
+
+	// A truncated header also rewinds
+	reader = bytes.NewReader(cipher[:len(cipher)-1])
+	_, _, err = DeserializeCipherHdrSeeker(reader)
+	assert.Assert(t, errors.Is(err, errs.ErrTruncated))
+	offset, err = reader.Seek(0, io.SeekCurrent)
+	assert.NilError(t, err)
+	assert.Equal(t, offset, int64(0))
+}
+
+func TestBodySchema(t *testing.T) {
+	type bodyV1 struct {
+		Name string
+	}
+	type bodyV2 struct {
+		Name string
+		Size int
+	}
+
+	body, err := MarshalBodyWithSchema(&bodyV2{"name", 10}, 2)
+	assert.NilError(t, err)
+	for _, hdrType := range []HeaderType{HeaderTypeJSON, HeaderTypeJSONGzip} {
+		s, err := CreateCipherHdr(hdrType, body, WithChecksum()).Serialize()
+		assert.NilError(t, err)
+		_, _, hdr, err := DeserializeCipherHdr(s)
+		assert.NilError(t, err)
+
+		var v2 bodyV2
+		schemaVer, err := UnmarshalBody(hdr, &v2)
+		assert.NilError(t, err)
+		assert.Equal(t, schemaVer, uint32(2))
+		assert.DeepEqual(t, v2, bodyV2{"name", 10})
+
+		// Older readers still get the fields they know
+		var v1 bodyV1
+		_, err = UnmarshalBody(hdr, &v1)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, v1, bodyV1{"name"})
+	}
+
+	// Bodies without a schema version are version 0
+	var v1 bodyV1
+	schemaVer, err := UnmarshalBody(CreatePlainHdr(HeaderTypeJSON, []byte(`{"Name":"old"}`)), &v1)
+	assert.NilError(t, err)
+	assert.Equal(t, schemaVer, uint32(0))
+	assert.DeepEqual(t, v1, bodyV1{"old"})
+
+	_, err = UnmarshalBody(CreatePlainHdr(HeaderTypeBSON, body), &v1)
+	assert.Assert(t, err != nil)
+	_, err = UnmarshalBody(CreatePlainHdr(HeaderTypeJSON, []byte("{")), &v1)
+	assert.Assert(t, errors.Is(err, errs.ErrCorrupt))
+}
+
+func TestSkipHeader(t *testing.T) {
+	headers := []Header{
+		CreatePlainHdr(HeaderTypeJSON, []byte(teststr)),
+		CreatePlainHdr(HeaderTypeJSONGzip, []byte(teststr), WithChecksum()),
+		CreateCipherHdr(HeaderTypeBSON, []byte(teststr)),
+		CreateCipherHdr(HeaderTypeJSON, []byte(teststr), WithChecksum()),
+	}
+
+	for _, header := range headers {
+		s, err := header.Serialize()
+		assert.NilError(t, err)
+		if header.GetVersion() == 2 {
+			assert.Equal(t, binary.BigEndian.Uint64(s[4:]), uint64(len(s)))
+		}
+
+		r := bytes.NewReader(append(s, "payload"...))
+		version, skipped, err := SkipHeader(r)
+		assert.NilError(t, err)
+		assert.Equal(t, version, header.GetVersion())
+		assert.Equal(t, skipped, uint64(len(s)))
+		payload, err := ioutil.ReadAll(r)
+		assert.NilError(t, err)
+		assert.Equal(t, string(payload), "payload")
+
+		_, _, err = SkipHeader(bytes.NewReader(s[:len(s)-1]))
+		assert.Assert(t, errors.Is(err, errs.ErrTruncated))
+	}
+
+	// The total length of a V2 header must match its body
+	s, err := CreatePlainHdr(HeaderTypeJSON, []byte(teststr), WithChecksum()).Serialize()
+	assert.NilError(t, err)
+	binary.BigEndian.PutUint64(s[4:], uint64(len(s)+1))
+	_, _, _, err = DeserializePlainHdr(s)
+	assert.Assert(t, errors.Is(err, errs.ErrCorrupt))
+	_, _, err = DeserializePlainHdrStream(bytes.NewReader(s))
+	assert.Assert(t, errors.Is(err, errs.ErrCorrupt))
+	binary.BigEndian.PutUint64(s[4:], 3)
+	_, _, err = SkipHeader(bytes.NewReader(s))
+	assert.Assert(t, errors.Is(err, errs.ErrCorrupt))
+
+	// A plaintext V2 header without a body is shorter than any ciphertext one
+	s, err = CreatePlainHdr(HeaderTypeJSON, []byte{}, WithChecksum()).Serialize()
+	assert.NilError(t, err)
+	_, skipped, err := SkipHeader(bytes.NewReader(s))
+	assert.NilError(t, err)
+	assert.Equal(t, skipped, uint64(plainHdrV2FixedLen+4))
+	s, err = CreateCipherHdr(HeaderTypeJSON, []byte{}, WithChecksum()).Serialize()
+	assert.NilError(t, err)
+	binary.BigEndian.PutUint64(s[4:], plainHdrV2FixedLen+4)
+	_, _, err = SkipHeader(bytes.NewReader(s))
+	assert.Assert(t, errors.Is(err, errs.ErrCorrupt))
+}
+
+func TestHeaderTooLarge(t *testing.T) {
+	// Pretend the V1 limit is small instead of allocating 4GB
+	defer func(max uint64) { maxV1BodyLen = max }(maxV1BodyLen)
+	maxV1BodyLen = uint64(len(teststr) - 1)
+
+	_, err := NewPlainHdrV1(HeaderTypeJSON, []byte(teststr))
+	assert.Assert(t, errors.Is(err, errs.ErrTooLarge))
+	_, err = NewCipherHdrV1(HeaderTypeJSON, []byte(teststr))
+	assert.Assert(t, errors.Is(err, errs.ErrTooLarge))
+	_, err = (&PlainHdrV1{PlainHeaderV1, HeaderTypeJSON, 0, []byte(teststr)}).Serialize()
+	assert.Assert(t, errors.Is(err, errs.ErrTooLarge))
+
+	// Bodies too large for V1 are put in V2 headers
+	assert.Equal(t, CreatePlainHdr(HeaderTypeJSON, []byte(teststr)).GetVersion(), PlainHeaderV2)
+	assert.Equal(t, CreateCipherHdr(HeaderTypeJSON, []byte(teststr)).GetVersion(), CipherHeaderV2)
+	hdr, err := NewPlainHdrV1(HeaderTypeJSON, []byte(teststr[1:]))
+	assert.NilError(t, err)
+	assert.Equal(t, hdr.HdrLen, uint32(len(teststr)-1))
+
+	// V2 headers do not have the limit
+	s, err := CreatePlainHdr(HeaderTypeJSON, []byte(teststr), WithChecksum()).Serialize()
+	assert.NilError(t, err)
+	_, parsed, _, err := DeserializePlainHdrV2(s)
+	assert.NilError(t, err)
+	assert.Equal(t, parsed, uint64(len(s)))
+
+	// The generic functions report the parsed bytes in 32 bits
+	_, err = parsedLen32(math.MaxUint32 + 1)
+	assert.Assert(t, errors.Is(err, errs.ErrTooLarge))
+	n, err := parsedLen32(math.MaxUint32)
+	assert.NilError(t, err)
+	assert.Equal(t, n, uint32(math.MaxUint32))
+}
+
+func TestDeserializePlainHdrBody(t *testing.T) {
+	type testBody struct {
+		Name string
+		Size int
+	}
+	body := []byte(`{"Name":"name","Size":10}`)
+
+	for _, hdrType := range []HeaderType{HeaderTypeJSON, HeaderTypeJSONGzip} {
+		for _, opts := range [][]CreateOption{nil, {WithChecksum()}} {
+			s, err := CreatePlainHdr(hdrType, body, opts...).Serialize()
+			assert.NilError(t, err)
+
+			var out testBody
+			hdr, err := DeserializePlainHdrBody(bytes.NewReader(s), &out)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, out, testBody{"name", 10})
+			hdrBody, err := hdr.GetBody()
+			assert.NilError(t, err)
+			assert.DeepEqual(t, hdrBody, body)
+		}
+	}
+
+	// BSON bodies need a BSON decoder
+	s, err := CreatePlainHdr(HeaderTypeBSONGzip, body).Serialize()
+	assert.NilError(t, err)
+	var out testBody
+	_, err = DeserializePlainHdrBody(bytes.NewReader(s), &out)
+	assert.Assert(t, err != nil)
+
+	UnmarshalBSON = json.Unmarshal
+	defer func() { UnmarshalBSON = nil }()
+	_, err = DeserializePlainHdrBody(bytes.NewReader(s), &out)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, out, testBody{"name", 10})
+
+	s, err = CreatePlainHdr(HeaderTypeJSON, []byte("{")).Serialize()
+	assert.NilError(t, err)
+	_, err = DeserializePlainHdrBody(bytes.NewReader(s), &out)
+	assert.Assert(t, errors.Is(err, errs.ErrCorrupt))
+}
+
+func TestHeaderCanonical(t *testing.T) {
+	a := []byte(`{"b": [1, 2.50, {"y": true, "x": null}], "a": "<&>"}`)
+	b := []byte(`{"a":"<&>","b":[1,2.50,{"x":null,"y":true}]}`)
+
+	for _, hdrType := range []HeaderType{HeaderTypeJSON, HeaderTypeJSONGzip} {
+		for _, opts := range [][]CreateOption{nil, {WithChecksum()}} {
+			plainA := CreatePlainHdr(hdrType, a, opts...)
+			plainB := CreatePlainHdr(hdrType, b, opts...)
+			cipherA := CreateCipherHdr(hdrType, a, opts...)
+			cipherB := CreateCipherHdr(hdrType, b, opts...)
+
+			sa, err := plainA.CanonicalSerialize()
+			assert.NilError(t, err)
+			sb, err := plainB.CanonicalSerialize()
+			assert.NilError(t, err)
+			assert.DeepEqual(t, sa, sb)
+			_, _, d, err := DeserializePlainHdr(sa)
+			assert.NilError(t, err)
+			body, err := d.GetBody()
+			assert.NilError(t, err)
+			assert.Equal(t, string(body), string(b))
+
+			assert.Assert(t, plainA.Equal(plainB))
+			assert.Assert(t, cipherA.Equal(cipherB))
+			assert.Assert(t, !plainA.Equal(cipherA))
+			assert.Assert(t, !plainA.Equal(nil))
+			assert.Assert(t, !plainA.Equal(CreatePlainHdr(hdrType, []byte(`{"a":"<&>"}`), opts...)))
+		}
+	}
+
+	// Headers of different versions or types are not equal
+	assert.Assert(t, !CreatePlainHdr(HeaderTypeJSON, a).Equal(CreatePlainHdr(HeaderTypeJSON, a, WithChecksum())))
+	assert.Assert(t, !CreatePlainHdr(HeaderTypeJSON, a).Equal(CreatePlainHdr(HeaderTypeJSONGzip, a)))
+
+	// Other bodies are compared as they are
+	assert.Assert(t, CreatePlainHdr(HeaderTypeBSON, a).Equal(CreatePlainHdr(HeaderTypeBSON, a)))
+	assert.Assert(t, !CreatePlainHdr(HeaderTypeBSON, a).Equal(CreatePlainHdr(HeaderTypeBSON, b)))
+
+	_, err := CreatePlainHdr(HeaderTypeJSON, []byte(`{} {}`)).CanonicalSerialize()
+	assert.Assert(t, errors.Is(err, errs.ErrCorrupt))
+	invalid := CreatePlainHdr(HeaderTypeJSON, []byte(`{`))
+	assert.Assert(t, invalid.Equal(CreatePlainHdr(HeaderTypeJSON, []byte(`{`))))
+	assert.Assert(t, !invalid.Equal(CreatePlainHdr(HeaderTypeJSON, []byte(`{ `))))
+}
+
+func TestTestVectors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "headervectors")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.NilError(t, GenerateTestVectors(dir))
+	assert.NilError(t, VerifyTestVectors(dir))
+
+	manifest, err := ioutil.ReadFile(filepath.Join(dir, TestVectorManifest))
+	assert.NilError(t, err)
+	var vectors []TestVector
+	assert.NilError(t, json.Unmarshal(manifest, &vectors))
+	assert.Equal(t, len(vectors), 16)
+
+	// A changed header does not verify
+	file := filepath.Join(dir, vectors[0].File)
+	serial, err := ioutil.ReadFile(file)
+	assert.NilError(t, err)
+	serial[len(serial)-1]++
+	assert.NilError(t, ioutil.WriteFile(file, serial, 0644))
+	err = VerifyTestVectors(dir)
+	assert.Assert(t, errors.Is(err, errs.ErrCorrupt))
+}
+
+func TestDeserializeAnyHdr(t *testing.T) {
+	assert.Equal(t, MaxSupportedPlainVersion(), PlainHeaderV2)
+	assert.Equal(t, MaxSupportedCipherVersion(), CipherHeaderV2)
+
+	headers := []Header{
+		CreatePlainHdr(HeaderTypeJSON, []byte(teststr)),
+		CreatePlainHdr(HeaderTypeJSONGzip, []byte(teststr), WithChecksum()),
+		CreateCipherHdr(HeaderTypeBSONGzip, []byte(teststr)),
+		CreateCipherHdr(HeaderTypeJSON, []byte(teststr), WithChecksum()),
+	}
+	expected := []HeaderCapabilities{
+		{Kind: HeaderKindPlain, Version: 1, HdrType: HeaderTypeJSON},
+		{Kind: HeaderKindPlain, Version: 2, HdrType: HeaderTypeJSONGzip, Checksum: true, Compressed: true},
+		{Kind: HeaderKindCipher, Version: 1, HdrType: HeaderTypeBSONGzip, Compressed: true},
+		{Kind: HeaderKindCipher, Version: 2, HdrType: HeaderTypeJSON, Checksum: true},
+	}
+
+	for i, header := range headers {
+		s, err := header.Serialize()
+		assert.NilError(t, err)
+		expected[i].Len = uint64(len(s))
+
+		r := bufio.NewReader(bytes.NewReader(append(s, "payload"...)))
+		d, caps, err := DeserializeAnyHdr(r)
+		assert.NilError(t, err)
+		assert.Equal(t, caps, expected[i])
+		assert.Assert(t, d.Equal(header))
+		payload, err := ioutil.ReadAll(r)
+		assert.NilError(t, err)
+		assert.Equal(t, string(payload), "payload")
+	}
+
+	s := make([]byte, 16)
+	binary.BigEndian.PutUint32(s, 3)
+	binary.BigEndian.PutUint32(s[4:], CipherHdrV1Prime)
+	_, _, err := DeserializeAnyHdr(bufio.NewReader(bytes.NewReader(s)))
+	assert.Assert(t, errors.Is(err, errs.ErrUnsupportedVersion))
+}
+
+func TestHeaderCompressionStats(t *testing.T) {
+	body := bytes.Repeat([]byte(teststr), 10)
+	for _, checksum := range []bool{false, true} {
+		var opts []CreateOption
+		if checksum {
+			opts = append(opts, WithChecksum())
+		}
+
+		hdr := CreatePlainHdr(HeaderTypeJSON, body, opts...)
+		s, err := SerializeWithOptions(hdr)
+		assert.NilError(t, err)
+		expected, err := hdr.Serialize()
+		assert.NilError(t, err)
+		assert.Equal(t, s.SerializedSize(), len(expected))
+		assert.Equal(t, s.BodySize(), len(body))
+		assert.Equal(t, s.CompressedBodySize(), len(body))
+		assert.Equal(t, s.CompressionRatio(), float64(1))
+
+		hdr = CreateCipherHdr(HeaderTypeJSONGzip, body, opts...)
+		fast, err := SerializeWithOptions(hdr, WithGzipLevel(gzip.BestSpeed))
+		assert.NilError(t, err)
+		best, err := SerializeWithOptions(hdr, WithGzipLevel(gzip.BestCompression))
+		assert.NilError(t, err)
+		assert.Equal(t, best.BodySize(), len(body))
+		assert.Assert(t, best.CompressedBodySize() < len(body))
+		assert.Assert(t, best.CompressedBodySize() <= fast.CompressedBodySize())
+		assert.Assert(t, best.CompressionRatio() > 1)
+		assert.Equal(t, best.SerializedSize()-best.CompressedBodySize(),
+			fast.SerializedSize()-fast.CompressedBodySize())
+
+		// The sizes are those of the bytes the header was serialized to
+		serial, err := best.Serialize()
+		assert.NilError(t, err)
+		assert.Equal(t, len(serial), best.SerializedSize())
+		parsed, _, err := DeserializeAnyHdr(bufio.NewReader(bytes.NewReader(serial)))
+		assert.NilError(t, err)
+		assert.Assert(t, parsed.Equal(hdr))
+
+		_, err = SerializeWithOptions(hdr, WithGzipLevel(42))
+		assert.Assert(t, err != nil)
+	}
+}
+
+func TestSerializeConcurrent(t *testing.T) {
+	hdr := CreatePlainHdr(HeaderTypeJSONGzip, []byte(teststr), WithChecksum())
+	expected, err := hdr.Serialize()
+	assert.NilError(t, err)
+
+	// Serialize does not change the header, so a shared header can be
+	// serialized by several goroutines
+	done := make(chan []byte)
+	for i := 0; i < 4; i++ {
+		go func() {
+			s, _ := hdr.Serialize()
+			done <- s
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		assert.DeepEqual(t, <-done, expected)
+	}
+}
+
+func TestHeaderBodyReader(t *testing.T) {
+	type testBody struct {
+		Name string
+		Size int
+	}
+	body, err := json.Marshal(&testBody{"name", 42})
+	assert.NilError(t, err)
+
+	headers := []Header{
+		CreatePlainHdr(HeaderTypeJSON, body),
+		CreatePlainHdr(HeaderTypeJSONGzip, body, WithChecksum()),
+		CreateCipherHdr(HeaderTypeJSONGzip, body),
+		CreateCipherHdr(HeaderTypeJSON, body, WithChecksum()),
+	}
+	for _, header := range headers {
+		s, err := header.Serialize()
+		assert.NilError(t, err)
+
+		d, _, err := DeserializeAnyHdr(bufio.NewReader(bytes.NewReader(s)))
+		assert.NilError(t, err)
+
+		var decoded testBody
+		assert.NilError(t, json.NewDecoder(d.BodyReader()).Decode(&decoded))
+		assert.DeepEqual(t, decoded, testBody{"name", 42})
+	}
+}
+
+func TestHeaderContentID(t *testing.T) {
+	// Equal headers have the same ID
+	id, err := CreatePlainHdr(HeaderTypeJSON, []byte(`{"b": 2, "a": 1}`)).ContentID()
+	assert.NilError(t, err)
+	same, err := CreatePlainHdr(HeaderTypeJSON, []byte(`{"a":1,"b":2}`)).ContentID()
+	assert.NilError(t, err)
+	assert.Equal(t, id, same)
+	other, err := CreatePlainHdr(HeaderTypeJSON, []byte(`{"a":1,"b":3}`)).ContentID()
+	assert.NilError(t, err)
+	assert.Assert(t, id != other)
+	other, err = CreateCipherHdr(HeaderTypeJSON, []byte(`{"a":1,"b":2}`)).ContentID()
+	assert.NilError(t, err)
+	assert.Assert(t, id != other)
+
+	body := []byte(`{"a":1,"b":4}`)
+	for _, create := range []func(HeaderType, []byte, ...CreateOption) Header{CreatePlainHdr, CreateCipherHdr} {
+		// The parent ID makes a V2 header
+		child := create(HeaderTypeJSONGzip, body, WithParentID(id))
+		assert.Equal(t, child.GetVersion(), uint32(2))
+		s, err := child.Serialize()
+		assert.NilError(t, err)
+
+		plain, err := create(HeaderTypeJSONGzip, body, WithChecksum()).Serialize()
+		assert.NilError(t, err)
+		assert.Equal(t, len(s), len(plain)+8+32)
+
+		d, _, err := DeserializeAnyHdr(bufio.NewReader(bytes.NewReader(s)))
+		assert.NilError(t, err)
+		assert.Assert(t, d.Equal(child))
+		switch hdr := d.(type) {
+		case *PlainHdrV2:
+			assert.DeepEqual(t, *hdr.ParentID, id)
+			complete, parsed, _, err := DeserializePlainHdrV2(s)
+			assert.NilError(t, err)
+			assert.Assert(t, complete)
+			assert.Equal(t, parsed, uint64(len(s)))
+		case *CipherHdrV2:
+			assert.DeepEqual(t, *hdr.ParentID, id)
+			complete, parsed, hdr2, err := DeserializeCipherHdrV2(s)
+			assert.NilError(t, err)
+			assert.Assert(t, complete)
+			assert.Equal(t, parsed, uint64(len(s)))
+			assert.DeepEqual(t, *hdr2.ParentID, id)
+		default:
+			t.Fatalf("Unexpected header %T", d)
+		}
+
+		childID, err := d.ContentID()
+		assert.NilError(t, err)
+		noParentID, err := create(HeaderTypeJSONGzip, body, WithChecksum()).ContentID()
+		assert.NilError(t, err)
+		assert.Assert(t, childID != noParentID)
+
+		_, skipped, err := SkipHeader(bytes.NewReader(s))
+		assert.NilError(t, err)
+		assert.Equal(t, skipped, uint64(len(s)))
+
+		// A parent ID field of the wrong length is corrupt
+		binary.BigEndian.PutUint32(s[len(s)-4-32-4:], 31)
+		putChecksum(s)
+		_, _, err = DeserializeAnyHdr(bufio.NewReader(bytes.NewReader(s)))
+		assert.Assert(t, errs.Is(err, errs.ErrCorrupt))
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	body := []byte(`{"name":"upgrade","size":42}`)
+	payload := []byte("the payload after the header")
+	parent := [32]byte{1, 2, 3}
+
+	headers := []Header{
+		CreatePlainHdr(HeaderTypeJSON, body),
+		CreatePlainHdr(HeaderTypeJSONGzip, body),
+		CreateCipherHdr(HeaderTypeJSON, body),
+		CreateCipherHdr(HeaderTypeJSONGzip, body),
+	}
+	for _, header := range headers {
+		s, err := header.Serialize()
+		assert.NilError(t, err)
+
+		var upgraded bytes.Buffer
+		opts := make([]CreateOption, 1, 2)
+		opts[0] = WithParentID(parent)
+		n, err := Upgrade(bytes.NewReader(append(s, payload...)), &upgraded, opts...)
+		assert.NilError(t, err)
+		assert.Equal(t, n, int64(upgraded.Len()))
+		assert.Assert(t, opts[:2][1] == nil)
+
+		r := bufio.NewReader(&upgraded)
+		hdr, caps, err := DeserializeAnyHdr(r)
+		assert.NilError(t, err)
+		assert.Equal(t, hdr.GetVersion(), uint32(2))
+		assert.Assert(t, caps.Checksum)
+		hdrType, _ := headerType(header)
+		assert.Equal(t, caps.HdrType, hdrType)
+		b, err := hdr.GetBody()
+		assert.NilError(t, err)
+		assert.DeepEqual(t, b, body)
+		switch h := hdr.(type) {
+		case *PlainHdrV2:
+			assert.Equal(t, caps.Kind, HeaderKindPlain)
+			assert.DeepEqual(t, *h.ParentID, parent)
+		case *CipherHdrV2:
+			assert.Equal(t, caps.Kind, HeaderKindCipher)
+			assert.DeepEqual(t, *h.ParentID, parent)
+		}
+		rest, err := ioutil.ReadAll(r)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, rest, payload)
+
+		// V2 headers are copied as they are
+		s, err = hdr.Serialize()
+		assert.NilError(t, err)
+		var copied bytes.Buffer
+		_, err = Upgrade(bytes.NewReader(append(s, payload...)), &copied)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, copied.Bytes(), append(s, payload...))
+	}
+
+	_, err := Upgrade(bytes.NewReader([]byte("not a header at all")), ioutil.Discard)
+	assert.Assert(t, errs.Is(err, errs.ErrCorrupt))
+}
+
+func TestHeaderAAD(t *testing.T) {
+	body := []byte(`{"doc":"a"}`)
+	aad := []byte("document-42:v7")
+
+	hdr := CreateCipherHdr(HeaderTypeJSONGzip, body, WithAAD(aad))
+	assert.Equal(t, hdr.GetVersion(), uint32(2))
+	assert.DeepEqual(t, hdr.(*CipherHdrV2).GetAAD(), aad)
+	s, err := hdr.Serialize()
+	assert.NilError(t, err)
+
+	complete, parsed, d, err := DeserializeCipherHdrV2(s)
+	assert.NilError(t, err)
+	assert.Assert(t, complete)
+	assert.Equal(t, parsed, uint64(len(s)))
+	assert.DeepEqual(t, d.GetAAD(), aad)
+	assert.Assert(t, d.ParentID == nil)
+	assert.Assert(t, d.Equal(hdr))
+
+	d2, _, err := DeserializeAnyHdr(bufio.NewReader(bytes.NewReader(s)))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, d2.(*CipherHdrV2).GetAAD(), aad)
+
+	id, err := hdr.ContentID()
+	assert.NilError(t, err)
+	other, err := CreateCipherHdr(HeaderTypeJSONGzip, body, WithAAD([]byte("document-42:v8"))).ContentID()
+	assert.NilError(t, err)
+	assert.Assert(t, id != other)
+
+	// The parent ID and AAD can be used together
+	hdr = CreateCipherHdr(HeaderTypeJSON, body, WithAAD(aad), WithParentID(id))
+	s, err = hdr.Serialize()
+	assert.NilError(t, err)
+	_, _, d, err = DeserializeCipherHdrV2(s)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, d.GetAAD(), aad)
+	assert.DeepEqual(t, *d.ParentID, id)
+
+	// Fields that are not known are skipped
+	unknown := appendField(nil, 99, []byte("later"))
+	s = append(append(s[:len(s)-4:len(s)-4], unknown...), 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(s[4:], uint64(len(s)))
+	putChecksum(s)
+	complete, _, d, err = DeserializeCipherHdrV2(s)
+	assert.NilError(t, err)
+	assert.Assert(t, complete)
+	assert.DeepEqual(t, d.GetAAD(), aad)
+	d2, _, err = DeserializeAnyHdr(bufio.NewReader(bytes.NewReader(s)))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, *d2.(*CipherHdrV2).ParentID, id)
+
+	// Plaintext headers have no AAD
+	plain, err := CreatePlainHdr(HeaderTypeJSON, body, WithAAD(aad)).Serialize()
+	assert.NilError(t, err)
+	checksummed, err := CreatePlainHdr(HeaderTypeJSON, body, WithChecksum()).Serialize()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, plain, checksummed)
+}
+
+func TestHeaderTypeString(t *testing.T) {
+	for _, hdrType := range HeaderTypes {
+		assert.Assert(t, hdrType.IsValid())
+		parsed, err := ParseHeaderType(hdrType.String())
+		assert.NilError(t, err)
+		assert.Equal(t, parsed, hdrType)
+	}
+
+	assert.Equal(t, HeaderTypeJSONGzip.String(), "json-gzip")
+	parsed, err := ParseHeaderType(" BSON ")
+	assert.NilError(t, err)
+	assert.Equal(t, parsed, HeaderTypeBSON)
+
+	for _, hdrType := range []HeaderType{0, HeaderTypeBSONGzip + 1, -1} {
+		assert.Assert(t, !hdrType.IsValid())
+	}
+	assert.Equal(t, HeaderType(7).String(), "HeaderType(7)")
+	_, err = ParseHeaderType("HeaderType(7)")
+	assert.Assert(t, err != nil)
+	_, err = ParseHeaderType("")
+	assert.Assert(t, err != nil)
+}
+
+func TestHeaderKeyLookup(t *testing.T) {
+	body := []byte(`{"doc":"a"}`)
+	keys := map[string][]byte{"tenant-1/key-3": []byte("secret key 3")}
+	lookup := func(keyID string) ([]byte, error) {
+		key, ok := keys[keyID]
+		if !ok {
+			return nil, errs.Errorf(errs.ErrNotFound, "No key %v", keyID)
+		}
+		return key, nil
+	}
+
+	hdr := CreateCipherHdr(HeaderTypeJSON, body, WithKeyID("tenant-1/key-3"), WithAAD([]byte("aad")))
+	assert.Equal(t, hdr.GetVersion(), CipherHeaderV2)
+	s, err := hdr.Serialize()
+	assert.NilError(t, err)
+
+	d, key, parsed, err := DeserializeCipherHdrStreamWithKeyLookup(bytes.NewReader(s), lookup)
+	assert.NilError(t, err)
+	assert.Equal(t, parsed, uint64(len(s)))
+	assert.DeepEqual(t, key, keys["tenant-1/key-3"])
+	assert.Equal(t, d.GetKeyID(), "tenant-1/key-3")
+	assert.DeepEqual(t, d.GetAAD(), []byte("aad"))
+	assert.DeepEqual(t, d.HdrBody, body)
+
+	_, _, d, err = DeserializeCipherHdrV2(s)
+	assert.NilError(t, err)
+	assert.Equal(t, d.GetKeyID(), "tenant-1/key-3")
+
+	// The key of another tenant is not found
+	s, err = CreateCipherHdr(HeaderTypeJSON, body, WithKeyID("tenant-2/key-1")).Serialize()
+	assert.NilError(t, err)
+	_, _, _, err = DeserializeCipherHdrStreamWithKeyLookup(bytes.NewReader(s), lookup)
+	assert.Assert(t, errs.Is(err, errs.ErrNotFound))
+
+	// Headers without a key ID
+	s, err = CreateCipherHdr(HeaderTypeJSON, body, WithChecksum()).Serialize()
+	assert.NilError(t, err)
+	_, _, _, err = DeserializeCipherHdrStreamWithKeyLookup(bytes.NewReader(s), lookup)
+	assert.Assert(t, errs.Is(err, errs.ErrNotFound))
+	s, err = CreateCipherHdr(HeaderTypeJSON, body).Serialize()
+	assert.NilError(t, err)
+	_, _, _, err = DeserializeCipherHdrStreamWithKeyLookup(bytes.NewReader(s), lookup)
+	assert.Assert(t, errs.Is(err, errs.ErrUnsupportedVersion))
+
+	// Plaintext headers have no key ID
+	plain, err := CreatePlainHdr(HeaderTypeJSON, body, WithKeyID("tenant-1/key-3")).Serialize()
+	assert.NilError(t, err)
+	checksummed, err := CreatePlainHdr(HeaderTypeJSON, body, WithChecksum()).Serialize()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, plain, checksummed)
+}
+
+func TestGunzippedBodyLimit(t *testing.T) {
+	defer func(maxLen int64) { MaxGunzippedBodyLen = maxLen }(MaxGunzippedBodyLen)
+
+	for _, hdr := range []Header{
+		CreatePlainHdr(HeaderTypeJSONGzip, []byte(teststr)),
+		CreatePlainHdr(HeaderTypeJSONGzip, []byte(teststr), WithChecksum()),
+		CreateCipherHdr(HeaderTypeJSONGzip, []byte(teststr)),
+		CreateCipherHdr(HeaderTypeJSONGzip, []byte(teststr), WithChecksum()),
+	} {
+		s, err := hdr.Serialize()
+		assert.NilError(t, err)
+
+		MaxGunzippedBodyLen = int64(len(teststr))
+		d, _, err := DeserializeAnyHdr(bufio.NewReader(bytes.NewReader(s)))
+		assert.NilError(t, err)
+		body, err := d.GetBody()
+		assert.NilError(t, err)
+		assert.Equal(t, string(body), teststr)
+
+		MaxGunzippedBodyLen = int64(len(teststr)) - 1
+		_, _, err = DeserializeAnyHdr(bufio.NewReader(bytes.NewReader(s)))
+		assert.Assert(t, errs.Is(err, errs.ErrTooLarge))
+	}
+}
+
+func TestHdrBytesNeeded(t *testing.T) {
+	hdrs := []struct {
+		hdr    Header
+		needed func([]byte) (uint64, error)
+		deser  func([]byte) (bool, uint32, Header, error)
+	}{
+		{CreatePlainHdr(HeaderTypeJSON, []byte(teststr)), PlainHdrBytesNeeded, DeserializePlainHdr},
+		{CreatePlainHdr(HeaderTypeJSON, []byte(teststr), WithChecksum()), PlainHdrBytesNeeded,
+			DeserializePlainHdr},
+		{CreateCipherHdr(HeaderTypeJSON, []byte(teststr)), CipherHdrBytesNeeded, DeserializeCipherHdr},
+		{CreateCipherHdr(HeaderTypeJSON, []byte(teststr), WithKeyID("tenant")), CipherHdrBytesNeeded,
+			DeserializeCipherHdr},
+	}
+
+	for _, h := range hdrs {
+		b, err := h.hdr.Serialize()
+		assert.NilError(t, err)
+
+		// Refilling the buffer by the hint always ends with the whole header
+		n, refills := 0, 0
+		for {
+			needed, err := h.needed(b[:n])
+			assert.NilError(t, err)
+			complete, _, _, err := h.deser(b[:n])
+			assert.NilError(t, err)
+			assert.Equal(t, complete, needed == 0)
+			if needed == 0 {
+				break
+			}
+			n += int(needed)
+			assert.Assert(t, n <= len(b))
+			refills++
+		}
+		assert.Equal(t, n, len(b))
+		assert.Assert(t, refills <= 3)
+
+		// Extra bytes after the header are not needed
+		needed, err := h.needed(append(b, 1, 2, 3))
+		assert.NilError(t, err)
+		assert.Equal(t, needed, uint64(0))
+	}
+
+	_, err := PlainHdrBytesNeeded([]byte{0, 0, 0, 99})
+	assert.Assert(t, errs.Is(err, errs.ErrUnsupportedVersion))
+}
+
+func TestDeserializeHdrStreamPartialReads(t *testing.T) {
+	hdrs := []struct {
+		hdr   Header
+		deser func(io.Reader) (Header, uint32, error)
+	}{
+		{CreatePlainHdr(HeaderTypeJSON, []byte(teststr)), DeserializePlainHdrStream},
+		{CreatePlainHdr(HeaderTypeJSONGzip, []byte(teststr)), DeserializePlainHdrStream},
+		{CreatePlainHdr(HeaderTypeJSON, []byte(teststr), WithChecksum()), DeserializePlainHdrStream},
+		{CreateCipherHdr(HeaderTypeJSON, []byte(teststr)), DeserializeCipherHdrStream},
+		{CreateCipherHdr(HeaderTypeJSON, []byte(teststr), WithChecksum()), DeserializeCipherHdrStream},
+	}
+
+	for _, h := range hdrs {
+		b, err := h.hdr.Serialize()
+		assert.NilError(t, err)
+
+		// A network stream returns a byte at a time
+		header, parsed, err := h.deser(iotest.OneByteReader(bytes.NewReader(b)))
+		assert.NilError(t, err)
+		assert.Equal(t, parsed, uint32(len(b)))
+		body, err := header.GetBody()
+		assert.NilError(t, err)
+		assert.Equal(t, string(body), teststr)
+
+		// A stream that ends in the body is truncated
+		_, _, err = h.deser(iotest.OneByteReader(bytes.NewReader(b[:len(b)-10])))
+		assert.Assert(t, errs.Is(err, errs.ErrTruncated))
+	}
+}
+
+func TestHeaderCache(t *testing.T) {
+	cache := NewCache(2, time.Minute)
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+
+	hdr1 := CreatePlainHdr(HeaderTypeJSON, []byte(`{"a":1}`))
+	hdr2 := CreatePlainHdr(HeaderTypeJSON, []byte(`{"a":2}`))
+	hdr3 := CreatePlainHdr(HeaderTypeJSON, []byte(`{"a":3}`))
+	id1, err := hdr1.ContentID()
+	assert.NilError(t, err)
+
+	cache.Put(ContentIDKey(id1), hdr1)
+	cache.Put(LocationKey("file", 0), hdr2)
+	header, ok := cache.Get(ContentIDKey(id1))
+	assert.Assert(t, ok)
+	assert.Equal(t, header, hdr1)
+	_, ok = cache.Get(LocationKey("file", 10))
+	assert.Assert(t, !ok)
+
+	// The least recently used header makes room
+	cache.Put(LocationKey("file", 10), hdr3)
+	assert.Equal(t, cache.Len(), 2)
+	_, ok = cache.Get(LocationKey("file", 0))
+	assert.Assert(t, !ok)
+	_, ok = cache.Get(ContentIDKey(id1))
+	assert.Assert(t, ok)
+
+	cache.Remove(ContentIDKey(id1))
+	_, ok = cache.Get(ContentIDKey(id1))
+	assert.Assert(t, !ok)
+
+	// Headers expire after the TTL
+	now = now.Add(time.Minute)
+	_, ok = cache.Get(LocationKey("file", 10))
+	assert.Assert(t, !ok)
+	assert.Equal(t, cache.Len(), 0)
+}
+
+func TestPlainHdrReserved(t *testing.T) {
+	hdr, err := CreatePlainHdrReserved(HeaderTypeJSON, []byte(`{"version":1}`), 256)
+	assert.NilError(t, err)
+	serialized, err := hdr.Serialize()
+	assert.NilError(t, err)
+	assert.Equal(t, len(serialized), 256)
+
+	// Readers see the body, and skip the padding
+	stream := append(append([]byte{}, serialized...), []byte("data")...)
+	parsedHdr, parsed, err := DeserializePlainHdrStream(bytes.NewReader(stream))
+	assert.NilError(t, err)
+	assert.Equal(t, parsed, uint32(256))
+	body, err := parsedHdr.GetBody()
+	assert.NilError(t, err)
+	assert.Equal(t, string(body), `{"version":1}`)
+	assert.Equal(t, parsedHdr.(*PlainHdrV2).ReservedLen, uint64(256))
+
+	// The padding is not part of the contents
+	plainID, err := CreatePlainHdr(HeaderTypeJSON, []byte(`{"version":1}`), WithChecksum()).ContentID()
+	assert.NilError(t, err)
+	reservedID, err := hdr.ContentID()
+	assert.NilError(t, err)
+	assert.Equal(t, plainID, reservedID)
+
+	// Updates keep the length
+	updated, err := UpdateReserved(serialized, []byte(`{"version":2,"note":"updated"}`))
+	assert.NilError(t, err)
+	assert.Equal(t, len(updated), 256)
+	complete, _, parsedHdr, err := DeserializePlainHdr(updated)
+	assert.NilError(t, err)
+	assert.Assert(t, complete)
+	body, err = parsedHdr.GetBody()
+	assert.NilError(t, err)
+	assert.Equal(t, string(body), `{"version":2,"note":"updated"}`)
+
+	_, err = UpdateReserved(updated, bytes.Repeat([]byte("x"), 256))
+	assert.Assert(t, errs.Is(err, errs.ErrTooLarge))
+	_, err = CreatePlainHdrReserved(HeaderTypeJSON, bytes.Repeat([]byte("x"), 256), 256)
+	assert.Assert(t, errs.Is(err, errs.ErrTooLarge))
+
+	// The options of the caller are not written into
+	opts := make([]CreateOption, 1, 2)
+	opts[0] = WithParentID([32]byte{1})
+	_, err = CreatePlainHdrReserved(HeaderTypeJSON, []byte(`{}`), 256, opts...)
+	assert.NilError(t, err)
+	assert.Assert(t, opts[:2][1] == nil)
+
+	// The signature of the old body is dropped
+	hdr.(*PlainHdrV2).Signature = &HeaderSignature{SignerID: "alice", Signature: []byte("sig")}
+	signed, err := hdr.Serialize()
+	assert.NilError(t, err)
+	updated, err = UpdateReserved(signed, []byte(`{"version":3}`))
+	assert.NilError(t, err)
+	_, _, parsedHdr, err = DeserializePlainHdr(updated)
+	assert.NilError(t, err)
+	assert.Assert(t, GetSignature(parsedHdr) == nil)
+
+	// Only reserved headers can be updated
+	plain, err := CreatePlainHdr(HeaderTypeJSON, []byte(`{}`), WithChecksum()).Serialize()
+	assert.NilError(t, err)
+	_, err = UpdateReserved(plain, []byte(`{}`))
+	assert.Assert(t, err != nil)
+}
+
+func TestPayloadDigest(t *testing.T) {
+	payload := bytes.Repeat([]byte("payload "), 1000)
+	digest, err := DigestPayload(DigestSHA256, bytes.NewReader(payload))
+	assert.NilError(t, err)
+	assert.Equal(t, len(digest.Hash), 32)
+
+	for _, create := range []func(HeaderType, []byte, ...CreateOption) Header{CreatePlainHdr, CreateCipherHdr} {
+		hdr := create(HeaderTypeJSON, []byte(`{"doc":"a"}`), WithPayloadDigest(digest))
+		assert.Equal(t, hdr.GetVersion(), uint32(2))
+		s, err := hdr.Serialize()
+		assert.NilError(t, err)
+
+		// The receiver only needs the header to check the payload
+		stream := append(append([]byte{}, s...), payload...)
+		d, _, err := DeserializeAnyHdr(bufio.NewReader(bytes.NewReader(stream[:len(s)])))
+		assert.NilError(t, err)
+		assert.DeepEqual(t, *GetPayloadDigest(d), digest)
+		assert.NilError(t, VerifyPayload(iotest.OneByteReader(bytes.NewReader(stream[len(s):])), d))
+
+		tampered := append([]byte{}, payload...)
+		tampered[10] ^= 1
+		err = VerifyPayload(bytes.NewReader(tampered), d)
+		assert.Assert(t, errors.Is(err, ErrPayloadDigest))
+		err = VerifyPayload(bytes.NewReader(payload[1:]), d)
+		assert.Assert(t, errors.Is(err, ErrPayloadDigest))
+	}
+
+	sha512Digest, err := DigestPayload(DigestSHA512, bytes.NewReader(payload))
+	assert.NilError(t, err)
+	s, err := CreateCipherHdr(HeaderTypeJSON, []byte(`{}`), WithPayloadDigest(sha512Digest),
+		WithKeyID("tenant-1")).Serialize()
+	assert.NilError(t, err)
+	_, _, d, err := DeserializeCipherHdrV2(s)
+	assert.NilError(t, err)
+	assert.Equal(t, d.KeyID, "tenant-1")
+	assert.NilError(t, VerifyPayload(bytes.NewReader(payload), d))
+
+	// Headers without a digest, and unknown algorithms
+	err = VerifyPayload(bytes.NewReader(payload), CreatePlainHdr(HeaderTypeJSON, []byte(`{}`)))
+	assert.Assert(t, errs.Is(err, errs.ErrNotFound))
+	_, err = DigestPayload(DigestAlgorithm(99), bytes.NewReader(payload))
+	assert.Assert(t, errs.Is(err, errs.ErrUnsupportedVersion))
+	unknown := CreatePlainHdr(HeaderTypeJSON, []byte(`{}`),
+		WithPayloadDigest(PayloadDigest{Algorithm: 99, Hash: digest.Hash}))
+	err = VerifyPayload(bytes.NewReader(payload), unknown)
+	assert.Assert(t, errs.Is(err, errs.ErrUnsupportedVersion))
+}
+
+func TestHeaderErrorsUnwrap(t *testing.T) {
+	var hdrErrs []error
+	_, err := NewPlainBuilder().Type(HeaderType(100)).Body([]byte{1}).Build()
+	hdrErrs = append(hdrErrs, err)
+	_, err = NewPlainBuilder().Type(HeaderTypeBSON).BodyJSON(map[string]int{"a": 1}).Build()
+	hdrErrs = append(hdrErrs, err)
+	_, err = UnmarshalBody(CreatePlainHdr(HeaderTypeBSON, []byte{1}), &struct{}{})
+	hdrErrs = append(hdrErrs, err)
+	_, err = UnmarshalBody(CreatePlainHdr(HeaderTypeJSON, []byte("{")), &struct{}{})
+	hdrErrs = append(hdrErrs, err)
+	_, _, _, err = DeserializePlainHdr([]byte{0, 0, 0, 99})
+	hdrErrs = append(hdrErrs, err)
+	_, _, _, err = DeserializePlainHdr(append([]byte{0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 3}, "xyz"...))
+	hdrErrs = append(hdrErrs, err)
+
+	// The errors can be inspected with the standard library alone
+	for _, err := range hdrErrs {
+		assert.Assert(t, err != nil)
+		var e *errs.Error
+		assert.Assert(t, errors.As(err, &e), "%T: %v", err, err)
+		assert.Assert(t, errs.Stacktrace(err) != "")
+	}
+}
+
+func TestHeaderReference(t *testing.T) {
+	// A big key bundle header stored after other data in a shared file
+	bundle := CreateCipherHdr(HeaderTypeJSONGzip, []byte(`{"keys":["`+
+		strings.Repeat("k", 10000)+`"]}`), WithChecksum())
+	serialized, err := bundle.Serialize()
+	assert.NilError(t, err)
+	file := append(bytes.Repeat([]byte{7}, 100), serialized...)
+	files := map[string]io.ReaderAt{"bundle-1": bytes.NewReader(file)}
+	lookup := func(fileID string) (io.ReaderAt, error) {
+		if f, ok := files[fileID]; ok {
+			return f, nil
+		}
+		return nil, errs.Errorf(errs.ErrNotFound, "No file %v", fileID)
+	}
+
+	ref, err := NewReference("bundle-1", 100, file[100:])
+	assert.NilError(t, err)
+	assert.Equal(t, ref.Length, uint64(len(serialized)))
+	hdr, err := CreateReferenceHdr(ref, WithChecksum())
+	assert.NilError(t, err)
+	refSerialized, err := hdr.Serialize()
+	assert.NilError(t, err)
+	assert.Assert(t, len(refSerialized) < 200)
+
+	_, _, parsed, err := DeserializePlainHdr(refSerialized)
+	assert.NilError(t, err)
+	parsedRef, err := GetReference(parsed)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, parsedRef, ref)
+	resolved, err := ResolveReference(parsed, lookup)
+	assert.NilError(t, err)
+	assert.Assert(t, resolved.Equal(bundle))
+
+	// Other headers are not references
+	_, err = GetReference(CreatePlainHdr(HeaderTypeJSON, []byte(`{"type":"other"}`)))
+	assert.Assert(t, errs.Is(err, errs.ErrNotFound))
+	_, err = ResolveReference(CreatePlainHdr(HeaderTypeBSON, []byte{1}), lookup)
+	assert.Assert(t, errs.Is(err, errs.ErrNotFound))
+
+	// The referenced header must be the one found
+	check := func(ref HeaderReference, kind error) {
+		hdr, err := CreateReferenceHdr(&ref)
+		assert.NilError(t, err)
+		_, err = ResolveReference(hdr, lookup)
+		assert.Assert(t, errs.Is(err, kind), "%v", err)
+	}
+	other := *ref
+	other.FileID = "bundle-2"
+	check(other, errs.ErrNotFound)
+	other = *ref
+	other.ContentID = strings.Repeat("00", 32)
+	check(other, errs.ErrCorrupt)
+	other = *ref
+	other.Length--
+	check(other, errs.ErrTruncated)
+	other = *ref
+	other.Offset = 1000
+	check(other, errs.ErrTruncated)
+}
+
+func TestHeaderLog(t *testing.T) {
+	// Two writers sign their own entries
+	keys := make(map[string]ed25519.PrivateKey)
+	for _, signer := range []string{"alice", "bob"} {
+		_, key, err := ed25519.GenerateKey(nil)
+		assert.NilError(t, err)
+		keys[signer] = key
+	}
+	signer := func(signerID string) SignFunc {
+		return func(id [32]byte) (*HeaderSignature, error) {
+			return &HeaderSignature{SignerID: signerID,
+				Signature: ed25519.Sign(keys[signerID], id[:])}, nil
+		}
+	}
+	verify := func(id [32]byte, sig *HeaderSignature) error {
+		key, ok := keys[sig.SignerID]
+		if !ok {
+			return errs.Errorf(errs.ErrNotFound, "No key for %v", sig.SignerID)
+		}
+		if !ed25519.Verify(key.Public().(ed25519.PublicKey), id[:], sig.Signature) {
+			return errors.New("bad signature")
+		}
+		return nil
+	}
+
+	var buf bytes.Buffer
+	log, err := NewAppendOnlyHeaderLog(&buf, nil, signer("alice"))
+	assert.NilError(t, err)
+	first, err := log.Append(HeaderTypeJSON, []byte(`{"rotate":1}`))
+	assert.NilError(t, err)
+	_, err = log.Append(HeaderTypeJSON, []byte(`{"policy":"read-only"}`))
+	assert.NilError(t, err)
+	twoLen := buf.Len()
+
+	// Another writer resumes the log
+	hdrs, err := VerifyLog(bytes.NewReader(buf.Bytes()), verify)
+	assert.NilError(t, err)
+	assert.Equal(t, len(hdrs), 2)
+	log, err = NewAppendOnlyHeaderLog(&buf, hdrs[1], signer("bob"))
+	assert.NilError(t, err)
+	// Append does not write into the backing array of the options
+	opts := make([]CreateOption, 1, 2)
+	opts[0] = WithParentID([32]byte{1})
+	_, err = log.Append(HeaderTypeJSON, []byte(`{"rotate":2}`), opts...)
+	assert.NilError(t, err)
+	assert.Assert(t, opts[:2][1] == nil)
+	serial := append([]byte{}, buf.Bytes()...)
+
+	hdrs, err = VerifyLog(bytes.NewReader(serial), verify)
+	assert.NilError(t, err)
+	assert.Equal(t, len(hdrs), 3)
+	assert.Assert(t, hdrs[0].Equal(first))
+	assert.Assert(t, GetSignature(hdrs[0]) != nil)
+	assert.Equal(t, GetSignature(hdrs[2]).SignerID, "bob")
+	id, err := hdrs[1].ContentID()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, *hdrs[2].(*PlainHdrV2).ParentID, id)
+
+	// Without a verify function, only the chain is checked
+	hdrs, err = VerifyLog(bytes.NewReader(serial), nil)
+	assert.NilError(t, err)
+	assert.Equal(t, len(hdrs), 3)
+
+	// A removed entry breaks the chain
+	_, firstLen, err := SkipHeader(bytes.NewReader(serial))
+	assert.NilError(t, err)
+	_, err = VerifyLog(bytes.NewReader(serial[firstLen:]), verify)
+	assert.Assert(t, errs.Is(err, ErrHeaderLog), "%v", err)
+	removed := append(append([]byte{}, serial[:firstLen]...), serial[twoLen:]...)
+	hdrs, err = VerifyLog(bytes.NewReader(removed), verify)
+	assert.Assert(t, errs.Is(err, ErrHeaderLog), "%v", err)
+	assert.Equal(t, len(hdrs), 1)
+
+	// An entry replaced by another writer does not verify
+	keys["mallory"] = keys["alice"]
+	var forged bytes.Buffer
+	log, err = NewAppendOnlyHeaderLog(&forged, nil, signer("mallory"))
+	assert.NilError(t, err)
+	_, err = log.Append(HeaderTypeJSON, []byte(`{"rotate":1}`))
+	assert.NilError(t, err)
+	delete(keys, "mallory")
+	forged.Write(serial[firstLen:])
+	_, err = VerifyLog(bytes.NewReader(forged.Bytes()), verify)
+	assert.Assert(t, errs.Is(err, ErrHeaderLog), "%v", err)
+	assert.Assert(t, errs.Is(err, errs.ErrNotFound), "%v", err)
+
+	// Unsigned entries do not verify
+	var unsigned bytes.Buffer
+	log, err = NewAppendOnlyHeaderLog(&unsigned, nil, nil)
+	assert.NilError(t, err)
+	_, err = log.Append(HeaderTypeJSON, []byte(`{"rotate":1}`))
+	assert.NilError(t, err)
+	_, err = VerifyLog(bytes.NewReader(unsigned.Bytes()), nil)
+	assert.NilError(t, err)
+	_, err = VerifyLog(bytes.NewReader(unsigned.Bytes()), verify)
+	assert.Assert(t, errs.Is(err, ErrHeaderLog), "%v", err)
+
+	// A truncated entry can not be read
+	_, err = VerifyLog(bytes.NewReader(serial[:len(serial)-1]), verify)
+	assert.Assert(t, errs.Is(err, errs.ErrTruncated), "%v", err)
+}
+
+func TestHugeHeaderLength(t *testing.T) {
+	// A V2 header that claims to be 1TB long
+	hostile := make([]byte, 24)
+	binary.BigEndian.PutUint32(hostile[0:], PlainHeaderV2)
+	binary.BigEndian.PutUint64(hostile[4:], 1<<40)
+	binary.BigEndian.PutUint32(hostile[12:], uint32(HeaderTypeJSON))
+	binary.BigEndian.PutUint64(hostile[16:], 1<<40-28)
+
+	_, _, err := DeserializePlainHdrStream(bytes.NewReader(hostile))
+	assert.Assert(t, errs.Is(err, errs.ErrTruncated), "%v", err)
+	_, _, err = DeserializeAnyHdr(bufio.NewReader(bytes.NewReader(hostile)))
+	assert.Assert(t, errs.Is(err, errs.ErrTruncated), "%v", err)
+
+	// Headers bigger than a read chunk are still read
+	body := []byte(`"` + strings.Repeat("a", readChunkSize+10) + `"`)
+	serial, err := CreatePlainHdr(HeaderTypeJSON, body, WithChecksum()).Serialize()
+	assert.NilError(t, err)
+	hdr, _, err := DeserializeAnyHdr(bufio.NewReader(bytes.NewReader(serial)))
+	assert.NilError(t, err)
+	parsedBody, err := hdr.GetBody()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, parsedBody, body)
+	_, _, err = DeserializeAnyHdr(bufio.NewReader(bytes.NewReader(serial[:len(serial)-1])))
+	assert.Assert(t, errs.Is(err, errs.ErrTruncated), "%v", err)
+}
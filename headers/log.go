@@ -0,0 +1,152 @@
+package headers
+
+import (
+	"bufio"
+	stderrors "errors"
+	"io"
+	"strconv"
+
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// ErrHeaderLog means a header log is not the chain of headers it should be,
+// such as when a header was removed, reordered or changed, or its signature
+// does not verify
+var ErrHeaderLog = stderrors.New("header log chain is broken")
+
+// HeaderSignature is the signature of a header in a header log. It is stored
+// in the header as an optional field, with the following format:
+// --------------------------------------------------------------
+// | signerIDLen(4) | signerID(signerIDLen) | signature(...) |
+// --------------------------------------------------------------
+type HeaderSignature struct {
+	// SignerID identifies the writer that signed the header, so that the
+	// logs with several writers can be verified with the key of each
+	SignerID  string
+	Signature []byte
+}
+
+// SignFunc signs the ContentID of a header appended to a header log
+type SignFunc func(contentID [32]byte) (*HeaderSignature, error)
+
+// VerifyFunc verifies the signature of the ContentID of a header in a header
+// log
+type VerifyFunc func(contentID [32]byte, sig *HeaderSignature) error
+
+// AppendOnlyHeaderLog writes a tamper evident history of plaintext headers,
+// such as the key rotations or policy changes of a document. Each header
+// appended holds the ContentID of the header before it as its parent ID, and
+// optionally a signature of its own ContentID, so that VerifyLog finds any
+// header that was removed, reordered or changed.
+type AppendOnlyHeaderLog struct {
+	writer io.Writer
+	sign   SignFunc
+	lastID *[32]byte
+}
+
+// GetSignature returns the signature of a header, or nil if the header has
+// none. Only version 2 plaintext headers can have one.
+func GetSignature(hdr Header) *HeaderSignature {
+	if h, ok := hdr.(*PlainHdrV2); ok {
+		return h.Signature
+	}
+	return nil
+}
+
+// NewAppendOnlyHeaderLog returns a header log that appends to w. The last
+// header is the last one already in the log, such as the last header
+// returned by VerifyLog, or nil for a new log. Headers are signed with sign,
+// unless it is nil.
+func NewAppendOnlyHeaderLog(w io.Writer, last Header, sign SignFunc) (*AppendOnlyHeaderLog, error) {
+	l := &AppendOnlyHeaderLog{writer: w, sign: sign}
+	if last != nil {
+		id, err := last.ContentID()
+		if err != nil {
+			return nil, err
+		}
+		l.lastID = &id
+	}
+	return l, nil
+}
+
+// Append creates a plaintext header chained to the last header of the log,
+// signs it, and writes it to the log. The options are passed to
+// CreatePlainHdr, which always creates a version 2 header for the log.
+func (l *AppendOnlyHeaderLog) Append(hdrType HeaderType, hdrBody []byte, opts ...CreateOption) (Header, error) {
+	opts = append(append([]CreateOption{}, opts...), WithChecksum())
+	hdr := CreatePlainHdr(hdrType, hdrBody, opts...).(*PlainHdrV2)
+	// The chain sets the parent ID, whatever the options say
+	hdr.ParentID = l.lastID
+
+	id, err := hdr.ContentID()
+	if err != nil {
+		return nil, err
+	}
+	if l.sign != nil {
+		if hdr.Signature, err = l.sign(id); err != nil {
+			return nil, errs.WrapPrefix(err, nil, "Can not sign the header")
+		}
+	}
+
+	serial, err := hdr.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = l.writer.Write(serial); err != nil {
+		return nil, errs.Wrap(err, nil)
+	}
+	l.lastID = &id
+	return hdr, nil
+}
+
+// VerifyLog reads a header log to the end, and checks that each header is
+// chained to the header before it. If verify is not nil, every header must
+// be signed, and verify checks each signature. It returns the headers of
+// the log, or an ErrHeaderLog error for the first header that breaks the
+// chain. The errors of verify are wrapped, so that errs.Is still finds them.
+func VerifyLog(r io.Reader, verify VerifyFunc) ([]Header, error) {
+	br := bufio.NewReader(r)
+	var hdrs []Header
+	var lastID *[32]byte
+	for {
+		if _, err := br.Peek(1); err == io.EOF {
+			return hdrs, nil
+		}
+		index := len(hdrs)
+		hdr, _, err := DeserializeAnyHdr(br)
+		if err != nil {
+			return hdrs, errs.WrapPrefix(err, nil, "Can not read header log entry "+
+				strconv.Itoa(index))
+		}
+		plain, ok := hdr.(*PlainHdrV2)
+		if !ok {
+			return hdrs, errs.Errorf(ErrHeaderLog, "Header log entry %v is not a version 2 "+
+				"plaintext header", index)
+		}
+
+		switch {
+		case lastID == nil && plain.ParentID != nil:
+			return hdrs, errs.New(ErrHeaderLog, "The first header log entry has a parent ID")
+		case lastID != nil && (plain.ParentID == nil || *plain.ParentID != *lastID):
+			return hdrs, errs.Errorf(ErrHeaderLog, "Header log entry %v is not chained to "+
+				"the entry before it", index)
+		}
+
+		id, err := plain.ContentID()
+		if err != nil {
+			return hdrs, err
+		}
+		if verify != nil {
+			if plain.Signature == nil {
+				return hdrs, errs.Errorf(ErrHeaderLog, "Header log entry %v is not signed", index)
+			}
+			if err = verify(id, plain.Signature); err != nil {
+				return hdrs, errs.WrapPrefix(errs.Wrap(err, ErrHeaderLog), nil,
+					"Header log entry "+strconv.Itoa(index)+" signature does not verify")
+			}
+		}
+
+		hdrs = append(hdrs, hdr)
+		lastID = &id
+	}
+}
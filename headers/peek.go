@@ -0,0 +1,226 @@
+package headers
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// HeaderKind tells plaintext headers from ciphertext headers
+type HeaderKind int
+
+const (
+	_ = iota // Skip 0
+	// HeaderKindPlain is a plaintext header
+	HeaderKindPlain = HeaderKind(iota)
+	// HeaderKindCipher is a ciphertext header
+	HeaderKindCipher = HeaderKind(iota)
+)
+
+// Peek inspects the header at the start of the buffered reader without
+// consuming any bytes, so the caller can pick the parser for it. It returns
+// the kind and version of the header, and the number of bytes the serialized
+// header takes. A ciphertext header is recognized by its prime number.
+// Anything else is taken for a plaintext header with a valid header type.
+func Peek(r *bufio.Reader) (kind HeaderKind, version uint32, totalLen uint64, err error) {
+	b, err := r.Peek(8)
+	if err != nil {
+		return 0, 0, 0, errs.WrapPrefix(err, errs.ErrTruncated, "Can not peek header")
+	}
+
+	version = binary.BigEndian.Uint32(b)
+	if version == PlainHeaderV2 || version == CipherHeaderV2 {
+		return peekV2(r, version)
+	}
+
+	var fixedLen uint32
+	if binary.BigEndian.Uint32(b[4:]) == CipherHdrV1Prime {
+		kind = HeaderKindCipher
+		if version != CipherHeaderV1 {
+			err = errs.Errorf(errs.ErrUnsupportedVersion, "Version %v is not supported", version)
+			return
+		}
+		fixedLen = 16
+	} else {
+		kind = HeaderKindPlain
+		if !HeaderType(binary.BigEndian.Uint32(b[4:])).IsValid() {
+			err = errs.New(errs.ErrCorrupt, "The data does not start with a header")
+			return 0, 0, 0, err
+		}
+		if version != PlainHeaderV1 {
+			err = errs.Errorf(errs.ErrUnsupportedVersion, "Version %v is not supported", version)
+			return
+		}
+		fixedLen = 12
+	}
+
+	if b, err = r.Peek(int(fixedLen)); err != nil {
+		return kind, version, 0, errs.WrapPrefix(err, errs.ErrTruncated, "Can not peek header")
+	}
+	hdrLen := binary.BigEndian.Uint32(b[fixedLen-4:])
+	return kind, version, uint64(fixedLen) + uint64(hdrLen), nil
+}
+
+// peekV2 inspects a V2 header, whose total length follows the version
+func peekV2(r *bufio.Reader, version uint32) (kind HeaderKind, _ uint32, totalLen uint64, err error) {
+	b, err := r.Peek(16)
+	if err != nil {
+		return 0, version, 0, errs.WrapPrefix(err, errs.ErrTruncated, "Can not peek header")
+	}
+
+	totalLen = binary.BigEndian.Uint64(b[4:])
+	if binary.BigEndian.Uint32(b[12:]) == CipherHdrV1Prime {
+		kind = HeaderKindCipher
+		if totalLen < cipherHdrV2FixedLen+4 {
+			err = errs.Errorf(errs.ErrCorrupt, "Header total length(%v) is too small", totalLen)
+		}
+	} else {
+		kind = HeaderKindPlain
+		if !HeaderType(binary.BigEndian.Uint32(b[12:])).IsValid() {
+			return 0, 0, 0, errs.New(errs.ErrCorrupt, "The data does not start with a header")
+		}
+		if totalLen < plainHdrV2FixedLen+4 {
+			err = errs.Errorf(errs.ErrCorrupt, "Header total length(%v) is too small", totalLen)
+		}
+	}
+	if err != nil {
+		return kind, version, 0, err
+	}
+	return kind, version, totalLen, nil
+}
+
+// SkipHeader reads past the plaintext or ciphertext header at the start of
+// the reader, without parsing or checking its body. It returns the version
+// of the header and the number of bytes skipped. V2 headers are skipped
+// using their total length alone.
+func SkipHeader(r io.Reader) (version uint32, skipped uint64, err error) {
+	b := make([]byte, 16)
+	if _, err = io.ReadFull(r, b[:8]); err != nil {
+		return 0, 0, errs.WrapPrefix(err, errs.ErrTruncated, "Can not read header version")
+	}
+	version = binary.BigEndian.Uint32(b)
+
+	var rest uint64
+	switch {
+	case version == PlainHeaderV2 || version == CipherHeaderV2:
+		if _, err = io.ReadFull(r, b[8:16]); err != nil {
+			return version, 0, errs.WrapPrefix(err, errs.ErrTruncated, "Can not read header length")
+		}
+		// The prime follows the total length of ciphertext headers
+		totalLen := binary.BigEndian.Uint64(b[4:])
+		minLen := uint64(plainHdrV2FixedLen + 4)
+		if binary.BigEndian.Uint32(b[12:]) == CipherHdrV1Prime {
+			minLen = cipherHdrV2FixedLen + 4
+		}
+		if totalLen < minLen {
+			return version, 0, errs.Errorf(errs.ErrCorrupt, "Header total length(%v) is too small", totalLen)
+		}
+		skipped = 16
+		rest = totalLen - skipped
+	case version == CipherHeaderV1 && binary.BigEndian.Uint32(b[4:]) == CipherHdrV1Prime:
+		if _, err = io.ReadFull(r, b[8:16]); err != nil {
+			return version, 0, errs.WrapPrefix(err, errs.ErrTruncated, "Can not read header length")
+		}
+		rest = uint64(binary.BigEndian.Uint32(b[12:]))
+		skipped = 16
+	case version == PlainHeaderV1:
+		if _, err = io.ReadFull(r, b[8:12]); err != nil {
+			return version, 0, errs.WrapPrefix(err, errs.ErrTruncated, "Can not read header length")
+		}
+		rest = uint64(binary.BigEndian.Uint32(b[8:]))
+		skipped = 12
+	default:
+		return version, 0, errs.Errorf(errs.ErrUnsupportedVersion, "Version %v is not supported", version)
+	}
+
+	if rest > math.MaxInt64 {
+		return version, 0, errs.Errorf(errs.ErrTooLarge, "Header length(%v) is too large", rest)
+	}
+	if _, err = io.CopyN(ioutil.Discard, r, int64(rest)); err != nil {
+		return version, 0, errs.WrapPrefix(err, errs.ErrTruncated, "Can not skip header body")
+	}
+	return version, skipped + rest, nil
+}
+
+// v2TotalLen returns the total length of a V2 header with a body of
+// bodyLen bytes and fields of fieldsLen bytes
+func v2TotalLen(fixedLen uint64, bodyLen, fieldsLen int) (uint64, error) {
+	if uint64(bodyLen) > math.MaxInt64-fixedLen-uint64(fieldsLen)-4 {
+		return 0, errs.Errorf(errs.ErrTooLarge, "Header body length(%v) is too large", bodyLen)
+	}
+	return fixedLen + uint64(bodyLen) + uint64(fieldsLen) + 4, nil
+}
+
+// checkV2TotalLen checks the total length of a V2 header against the length
+// of its body. It returns the length of the fields between the body and the
+// checksum, which must have room for at least one field if there are any.
+func checkV2TotalLen(totalLen, fixedLen, hdrLen uint64) (fieldsLen uint64, err error) {
+	if hdrLen <= math.MaxInt64-fixedLen-4 && totalLen >= fixedLen+hdrLen+4 &&
+		totalLen <= math.MaxInt64 {
+		fieldsLen = totalLen - fixedLen - hdrLen - 4
+		if fieldsLen == 0 || fieldsLen >= fieldHeaderLen {
+			return fieldsLen, nil
+		}
+	}
+	return 0, errs.Errorf(errs.ErrCorrupt, "Header total length(%v) does not match "+
+		"the header length(%v)", totalLen, hdrLen)
+}
+
+// PlainHdrBytesNeeded returns the minimum number of bytes to add to b before
+// DeserializePlainHdr can complete, or 0 if b already holds the whole
+// plaintext header. Until the length of the header is in b, it is the number
+// of bytes needed to read the length, so callers refilling a buffer may be
+// asked for more after they add them.
+func PlainHdrBytesNeeded(b []byte) (uint64, error) {
+	return hdrBytesNeeded(b, HeaderKindPlain)
+}
+
+// CipherHdrBytesNeeded is PlainHdrBytesNeeded for DeserializeCipherHdr
+func CipherHdrBytesNeeded(b []byte) (uint64, error) {
+	return hdrBytesNeeded(b, HeaderKindCipher)
+}
+
+func hdrBytesNeeded(b []byte, kind HeaderKind) (uint64, error) {
+	if len(b) < 4 {
+		return uint64(4 - len(b)), nil
+	}
+
+	version := binary.BigEndian.Uint32(b)
+	var totalLen uint64
+	switch {
+	case kind == HeaderKindPlain && version == PlainHeaderV1,
+		kind == HeaderKindCipher && version == CipherHeaderV1:
+		fixedLen := 12
+		if kind == HeaderKindCipher {
+			fixedLen = 16
+		}
+		if len(b) < fixedLen {
+			return uint64(fixedLen - len(b)), nil
+		}
+		totalLen = uint64(fixedLen) + uint64(binary.BigEndian.Uint32(b[fixedLen-4:]))
+	case kind == HeaderKindPlain && version == PlainHeaderV2,
+		kind == HeaderKindCipher && version == CipherHeaderV2:
+		fixedLen := uint64(plainHdrV2FixedLen)
+		if kind == HeaderKindCipher {
+			fixedLen = cipherHdrV2FixedLen
+		}
+		if len(b) < 12 {
+			// The smallest header has an empty body and the checksum
+			return fixedLen + 4 - uint64(len(b)), nil
+		}
+		if totalLen = binary.BigEndian.Uint64(b[4:]); totalLen < fixedLen+4 {
+			return 0, errs.Errorf(errs.ErrCorrupt, "Header total length(%v) is too small", totalLen)
+		}
+	default:
+		return 0, errs.Errorf(errs.ErrUnsupportedVersion, "Version %v is not supported", version)
+	}
+
+	if uint64(len(b)) >= totalLen {
+		return 0, nil
+	}
+	return totalLen - uint64(len(b)), nil
+}
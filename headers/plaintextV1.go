@@ -1,11 +1,11 @@
 package headers
 
 import (
+	"bytes"
 	"encoding/binary"
 	"io"
 
-	"github.com/go-errors/errors"
-	"github.com/overnest/strongsalt-common-go/tools"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
 )
 
 // The plaintext header V1 has the following format:
@@ -34,20 +34,30 @@ func (h *PlainHdrV1) GetVersion() uint32 {
 
 // Serialize serializes the plaintext header
 func (h *PlainHdrV1) Serialize() ([]byte, error) {
+	b, _, err := h.serialize(nil)
+	return b, err
+}
+
+// serialize serializes the plaintext header, gzipping the body at the
+// level. It also returns the size of the body once serialized.
+func (h *PlainHdrV1) serialize(level *int) ([]byte, int, error) {
 	body := h.HdrBody
 	if h.HdrType.IsGzipped() {
 		var err error
-		if body, err = tools.Gzip(h.HdrBody); err != nil {
-			return nil, errors.New(err)
+		if body, err = gzipBody(h.HdrBody, level); err != nil {
+			return nil, 0, errs.Wrap(err, nil)
 		}
 	}
+	if err := checkV1BodyLen(len(body)); err != nil {
+		return nil, 0, err
+	}
 
 	b := make([]byte, 4+4+4+len(body))
 	binary.BigEndian.PutUint32(b[0:], h.Version)
 	binary.BigEndian.PutUint32(b[4:], uint32(h.HdrType))
 	binary.BigEndian.PutUint32(b[8:], uint32(len(body)))
 	copy(b[12:], body)
-	return b, nil
+	return b, len(body), nil
 }
 
 // GetBody gets the header body
@@ -55,6 +65,37 @@ func (h *PlainHdrV1) GetBody() ([]byte, error) {
 	return h.HdrBody, nil
 }
 
+// BodyReader returns a reader of the header body, so that the body can be
+// processed incrementally, such as by a JSON decoder
+func (h *PlainHdrV1) BodyReader() io.Reader {
+	return bytes.NewReader(h.HdrBody)
+}
+
+// CanonicalSerialize serializes the plaintext header with its body in canonical
+// form, so that equal headers serialize to the same bytes
+func (h *PlainHdrV1) CanonicalSerialize() ([]byte, error) {
+	body, err := canonicalBody(h.HdrType, h.HdrBody)
+	if err != nil {
+		return nil, err
+	}
+	c := *h
+	c.HdrLen = uint32(len(body))
+	c.HdrBody = body
+	return c.Serialize()
+}
+
+// ContentID returns the SHA-256 of the canonical serialization of the
+// header, which identifies its contents
+func (h *PlainHdrV1) ContentID() ([32]byte, error) {
+	return contentID(h)
+}
+
+// Equal shows whether the other header has the same version, type and
+// canonical body. Bodies that are not valid JSON must be identical.
+func (h *PlainHdrV1) Equal(other Header) bool {
+	return headersEqual(h, other)
+}
+
 // Our headers have variable lengths. Therefore, when deserializing, we
 // will not know ahead of time how many bytes to pass to the deserialization
 // function. The only way to know whether we have enough bytes for deserialization
@@ -85,7 +126,7 @@ func (h *PlainHdrV1) deserialize(b []byte) (complete bool, parsedBytes uint32, e
 	h.HdrLen = binary.BigEndian.Uint32(b[8:])
 	parsedBytes += 12
 
-	if uint32(len(b)) < parsedBytes+h.HdrLen {
+	if uint64(len(b)) < uint64(parsedBytes)+uint64(h.HdrLen) {
 		return
 	}
 
@@ -93,9 +134,9 @@ func (h *PlainHdrV1) deserialize(b []byte) (complete bool, parsedBytes uint32, e
 	parsedBytes += h.HdrLen
 
 	if h.HdrType.IsGzipped() {
-		body, gerr := tools.Gunzip(h.HdrBody)
+		body, gerr := gunzipBody(h.HdrBody)
 		if gerr != nil {
-			err = errors.New(gerr)
+			err = errs.Wrap(gerr, errs.ErrCorrupt)
 			return
 		}
 		h.HdrLen = uint32(len(body))
@@ -128,14 +169,14 @@ func DeserializePlainHdrStreamV1(reader io.Reader) (header *PlainHdrV1, parsed u
 
 	var hdrType uint32
 	if err = binary.Read(reader, binary.BigEndian, &hdrType); err != nil {
-		err = errors.WrapPrefix(err, "Can not read header type", 0)
+		err = errs.WrapPrefix(err, nil, "Can not read header type")
 		return
 	}
 	parsed += 4
 
 	var hdrLen uint32
 	if err = binary.Read(reader, binary.BigEndian, &hdrLen); err != nil {
-		err = errors.WrapPrefix(err, "Can not read header length", 0)
+		err = errs.WrapPrefix(err, nil, "Can not read header length")
 		return
 	}
 	parsed += 4
@@ -145,21 +186,21 @@ func DeserializePlainHdrStreamV1(reader io.Reader) (header *PlainHdrV1, parsed u
 		HdrType: HeaderType(hdrType),
 		HdrLen:  hdrLen}
 	header.HdrBody = make([]byte, header.HdrLen)
-	n, rerr := reader.Read(header.HdrBody)
-	if rerr != nil && rerr != io.EOF {
-		err = errors.WrapPrefix(rerr, "Can not read header body", 0)
+	n, rerr := io.ReadFull(reader, header.HdrBody)
+	if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+		err = errs.Errorf(errs.ErrTruncated, "Read %v bytes for header body but expected %v", n, header.HdrLen)
 		return
 	}
-	if uint32(n) != header.HdrLen {
-		err = errors.Errorf("Read %v bytes for header body but expected %v", n, header.HdrLen)
+	if rerr != nil {
+		err = errs.WrapPrefix(rerr, nil, "Can not read header body")
 		return
 	}
 	parsed += uint32(n)
 
 	if header.HdrType.IsGzipped() {
-		body, gerr := tools.Gunzip(header.HdrBody)
+		body, gerr := gunzipBody(header.HdrBody)
 		if gerr != nil {
-			err = errors.New(gerr)
+			err = errs.Wrap(gerr, errs.ErrCorrupt)
 			return
 		}
 		header.HdrLen = uint32(len(body))
@@ -0,0 +1,262 @@
+package headers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// The plaintext header V2 has the following format:
+// ---------------------------------------------------------------------------------------------
+// | version(4) | totallen(8) | hdrtype(4) | hdrlen(8) | header(hdrlen) | fields(...) | crc(4) |
+// ---------------------------------------------------------------------------------------------
+// 1. version(4 bytes): This tells us which header version to use when
+// 	  parsing.
+// 2. totallen(8 bytes): This tells us how many bytes the whole serialized
+//    header is, from the version to the crc. It lets SkipHeader skip the
+//    header without parsing it.
+// 3. hdrtype(4 bytes): Format of the header that follows
+// 4. hdrlen(8 bytes): This tells us how many bytes the serialized headers
+//    are. Unlike V1, the header can be bigger than 4GB.
+// 5. header(hdrlen bytes): The serialized header information
+// 6. fields(optional): The optional fields described in fields.go, such as
+//    the ContentID of the parent header. They take the rest of totallen.
+// 7. crc(4 bytes): CRC-32 (Castagnoli) of all the bytes before it. A
+//    mismatch is reported as ErrHeaderChecksum.
+
+const plainHdrV2FixedLen = 24
+
+// PlainHdrV2 is the V2 plaintext header
+type PlainHdrV2 struct {
+	Version uint32
+	HdrType HeaderType
+	HdrLen  uint64
+	HdrBody []byte
+	// ParentID is the ContentID of the parent header, if there is one
+	ParentID *[32]byte
+	// ReservedLen is the length the serialized header is padded to, if it
+	// was created with CreatePlainHdrReserved
+	ReservedLen uint64
+	// PayloadDigest is the digest of the payload that follows the header,
+	// if there is one
+	PayloadDigest *PayloadDigest
+	// Signature is the signature of the header, if it was appended to an
+	// AppendOnlyHeaderLog with a SignFunc. It is not part of the contents
+	// of the header, since it signs its ContentID.
+	Signature *HeaderSignature
+}
+
+// GetVersion retrieves the version number
+func (h *PlainHdrV2) GetVersion() uint32 {
+	return h.Version
+}
+
+// Serialize serializes the plaintext header
+func (h *PlainHdrV2) Serialize() ([]byte, error) {
+	b, _, err := h.serialize(nil)
+	return b, err
+}
+
+// serialize serializes the plaintext header, gzipping the body at the
+// level. It also returns the size of the body once serialized.
+func (h *PlainHdrV2) serialize(level *int) ([]byte, int, error) {
+	body := h.HdrBody
+	if h.HdrType.IsGzipped() {
+		var err error
+		if body, err = gzipBody(h.HdrBody, level); err != nil {
+			return nil, 0, errs.Wrap(err, nil)
+		}
+	}
+
+	fields := &v2Fields{parentID: h.ParentID, digest: h.PayloadDigest, sig: h.Signature}
+	fieldBytes, err := fields.serialize()
+	if err != nil {
+		return nil, 0, err
+	}
+	totalLen, err := v2TotalLen(plainHdrV2FixedLen, len(body), len(fieldBytes))
+	if err != nil {
+		return nil, 0, err
+	}
+	if h.ReservedLen > 0 {
+		if totalLen > h.ReservedLen || h.ReservedLen-totalLen < fieldHeaderLen {
+			return nil, 0, errs.Errorf(errs.ErrTooLarge, "Header length(%v) does not fit the "+
+				"reserved length(%v)", totalLen+fieldHeaderLen, h.ReservedLen)
+		}
+		fieldBytes = appendField(fieldBytes, fieldReserved,
+			make([]byte, h.ReservedLen-totalLen-fieldHeaderLen))
+		totalLen = h.ReservedLen
+	}
+
+	b := make([]byte, totalLen)
+	binary.BigEndian.PutUint32(b[0:], h.Version)
+	binary.BigEndian.PutUint64(b[4:], totalLen)
+	binary.BigEndian.PutUint32(b[12:], uint32(h.HdrType))
+	binary.BigEndian.PutUint64(b[16:], uint64(len(body)))
+	copy(b[24:], body)
+	copy(b[24+len(body):], fieldBytes)
+	putChecksum(b)
+	return b, len(body), nil
+}
+
+// GetBody gets the header body
+func (h *PlainHdrV2) GetBody() ([]byte, error) {
+	return h.HdrBody, nil
+}
+
+// BodyReader returns a reader of the header body, so that the body can be
+// processed incrementally, such as by a JSON decoder
+func (h *PlainHdrV2) BodyReader() io.Reader {
+	return bytes.NewReader(h.HdrBody)
+}
+
+// CanonicalSerialize serializes the plaintext header with its body in canonical
+// form, so that equal headers serialize to the same bytes
+func (h *PlainHdrV2) CanonicalSerialize() ([]byte, error) {
+	body, err := canonicalBody(h.HdrType, h.HdrBody)
+	if err != nil {
+		return nil, err
+	}
+	c := *h
+	c.HdrLen = uint64(len(body))
+	c.HdrBody = body
+	// The padding of a reserved header is not part of its contents
+	c.ReservedLen = 0
+	c.Signature = nil
+	return c.Serialize()
+}
+
+// ContentID returns the SHA-256 of the canonical serialization of the
+// header, which identifies its contents
+func (h *PlainHdrV2) ContentID() ([32]byte, error) {
+	return contentID(h)
+}
+
+// Equal shows whether the other header has the same version, type, parent
+// ID and canonical body. Bodies that are not valid JSON must be identical.
+func (h *PlainHdrV2) Equal(other Header) bool {
+	return headersEqual(h, other)
+}
+
+// See PlainHdrV1.deserialize for the meaning of the return values
+func (h *PlainHdrV2) deserialize(b []byte) (complete bool, parsedBytes uint64, err error) {
+	complete = false
+	parsedBytes = 0
+	err = nil
+
+	if len(b) < plainHdrV2FixedLen {
+		return
+	}
+
+	h.Version = binary.BigEndian.Uint32(b[0:])
+	totalLen := binary.BigEndian.Uint64(b[4:])
+	h.HdrType = HeaderType(binary.BigEndian.Uint32(b[12:]))
+	h.HdrLen = binary.BigEndian.Uint64(b[16:])
+	parsedBytes += plainHdrV2FixedLen
+
+	var fieldsLen uint64
+	if fieldsLen, err = checkV2TotalLen(totalLen, plainHdrV2FixedLen, h.HdrLen); err != nil {
+		return
+	}
+
+	if uint64(len(b)) < totalLen {
+		return
+	}
+
+	h.HdrBody = b[parsedBytes : parsedBytes+h.HdrLen]
+	parsedBytes += h.HdrLen
+	fields, ferr := parseV2Fields(b[parsedBytes : parsedBytes+fieldsLen])
+	if ferr != nil {
+		err = ferr
+		return
+	}
+	h.ParentID = fields.parentID
+	h.PayloadDigest = fields.digest
+	h.Signature = fields.sig
+	if fields.reserved {
+		h.ReservedLen = totalLen
+	}
+	parsedBytes += fieldsLen + 4
+
+	if err = verifyChecksum(b[:parsedBytes]); err != nil {
+		return
+	}
+
+	if h.HdrType.IsGzipped() {
+		body, gerr := gunzipBody(h.HdrBody)
+		if gerr != nil {
+			err = errs.Wrap(gerr, errs.ErrCorrupt)
+			return
+		}
+		h.HdrLen = uint64(len(body))
+		h.HdrBody = body
+	}
+
+	complete = true
+	return
+}
+
+// DeserializePlainHdrV2 deserializes the plaintext header. Unlike
+// DeserializePlainHdr, it reports the parsed bytes of headers over 4GB.
+func DeserializePlainHdrV2(b []byte) (complete bool, parsedBytes uint64, header *PlainHdrV2, err error) {
+	header = &PlainHdrV2{}
+	complete, parsedBytes, err = header.deserialize(b)
+	return
+}
+
+// DeserializePlainHdrStreamV2 deserializes the plaintext header after the
+// version number
+func DeserializePlainHdrStreamV2(reader io.Reader) (header *PlainHdrV2, parsed uint64, err error) {
+	header = nil
+	parsed = 0
+	err = nil
+
+	fixed := make([]byte, plainHdrV2FixedLen)
+	binary.BigEndian.PutUint32(fixed, PlainHeaderV2)
+	if _, err = io.ReadFull(reader, fixed[4:]); err != nil {
+		err = errs.WrapPrefix(err, errs.ErrTruncated, "Can not read header type and length")
+		return
+	}
+	parsed += plainHdrV2FixedLen - 4
+
+	hdrLen := binary.BigEndian.Uint64(fixed[16:])
+	totalLen := binary.BigEndian.Uint64(fixed[4:])
+	if _, err = checkV2TotalLen(totalLen, plainHdrV2FixedLen, hdrLen); err != nil {
+		return
+	}
+
+	header = &PlainHdrV2{
+		Version: PlainHeaderV2,
+		HdrType: HeaderType(binary.BigEndian.Uint32(fixed[12:])),
+		HdrLen:  hdrLen}
+
+	var rest []byte
+	if rest, err = readBodyAndChecksum(reader, fixed, totalLen-plainHdrV2FixedLen); err != nil {
+		return
+	}
+	header.HdrBody = rest[:header.HdrLen]
+	var fields *v2Fields
+	if fields, err = parseV2Fields(rest[header.HdrLen : len(rest)-4]); err != nil {
+		return
+	}
+	header.ParentID = fields.parentID
+	header.PayloadDigest = fields.digest
+	header.Signature = fields.sig
+	if fields.reserved {
+		header.ReservedLen = totalLen
+	}
+	parsed += totalLen - plainHdrV2FixedLen
+
+	if header.HdrType.IsGzipped() {
+		body, gerr := gunzipBody(header.HdrBody)
+		if gerr != nil {
+			err = errs.Wrap(gerr, errs.ErrCorrupt)
+			return
+		}
+		header.HdrLen = uint64(len(body))
+		header.HdrBody = body
+	}
+
+	return
+}
@@ -0,0 +1,126 @@
+package headers
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+
+	"github.com/overnest/strongsalt-common-go/tools"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// ReferenceType is the type of the JSON body of a reference header
+const ReferenceType = "header-reference"
+
+// HeaderReference is the body of a reference header, which points to a
+// serialized header stored in another file, such as a shared key bundle, so
+// that a small header can stand for a big one. Its JSON body looks like:
+//
+//	{"contentID":"<hex>","fileID":"bundle-1","length":4096,"offset":128,"type":"header-reference"}
+type HeaderReference struct {
+	Type string `json:"type"`
+	// FileID identifies the file that holds the referenced header
+	FileID string `json:"fileID"`
+	// Offset and Length locate the serialized header in the file
+	Offset uint64 `json:"offset"`
+	Length uint64 `json:"length"`
+	// ContentID is the hex encoded ContentID of the referenced header, which
+	// ResolveReference checks
+	ContentID string `json:"contentID"`
+}
+
+// FileLookup returns the file with the file ID of a HeaderReference
+type FileLookup func(fileID string) (io.ReaderAt, error)
+
+// NewReference returns the reference to the header serialized at the start
+// of serialized, which is stored at offset in the file with the file ID
+func NewReference(fileID string, offset uint64, serialized []byte) (*HeaderReference, error) {
+	hdr, caps, err := DeserializeAnyHdr(bufio.NewReader(bytes.NewReader(serialized)))
+	if err != nil {
+		return nil, err
+	}
+	id, err := hdr.ContentID()
+	if err != nil {
+		return nil, err
+	}
+	return &HeaderReference{Type: ReferenceType, FileID: fileID, Offset: offset,
+		Length: caps.Len, ContentID: tools.EncodeHex(id[:])}, nil
+}
+
+// CreateReferenceHdr creates a plaintext header with the reference as its
+// JSON body
+func CreateReferenceHdr(ref *HeaderReference, opts ...CreateOption) (Header, error) {
+	r := *ref
+	r.Type = ReferenceType
+	body, err := tools.MarshalCanonical(&r)
+	if err != nil {
+		return nil, errs.WrapPrefix(err, nil, "Can not marshal the header reference")
+	}
+	return CreatePlainHdr(HeaderTypeJSON, body, opts...), nil
+}
+
+// GetReference returns the reference in the body of a reference header. It
+// returns an errs.ErrNotFound error if the header is not a reference header.
+func GetReference(hdr Header) (*HeaderReference, error) {
+	hdrType, ok := headerType(hdr)
+	if !ok || (hdrType != HeaderTypeJSON && hdrType != HeaderTypeJSONGzip) {
+		return nil, errs.New(errs.ErrNotFound, "The header is not a reference header")
+	}
+	body, err := hdr.GetBody()
+	if err != nil {
+		return nil, err
+	}
+
+	ref := &HeaderReference{}
+	if err = tools.Unmarshal(body, ref); err != nil || ref.Type != ReferenceType {
+		return nil, errs.New(errs.ErrNotFound, "The header is not a reference header")
+	}
+	return ref, nil
+}
+
+// ResolveReference reads the header that the reference header points to,
+// from the file found by lookup. It returns an errs.ErrCorrupt error if the
+// header found is not the one referenced. The errors of lookup are wrapped,
+// so that errs.Is still tells a missing file apart.
+func ResolveReference(hdr Header, lookup FileLookup) (Header, error) {
+	ref, err := GetReference(hdr)
+	if err != nil {
+		return nil, err
+	}
+	if ref.Length > uint64(maxInt) {
+		return nil, errs.Errorf(errs.ErrTooLarge, "Referenced header length(%v) is too large",
+			ref.Length)
+	}
+
+	file, err := lookup(ref.FileID)
+	if err != nil {
+		return nil, errs.WrapPrefix(err, nil, "Can not find file "+ref.FileID)
+	}
+	serialized, err := readHeaderBytes(io.NewSectionReader(file, int64(ref.Offset),
+		int64(ref.Length)), ref.Length)
+	if err != nil {
+		return nil, errs.WrapPrefix(err, errs.ErrTruncated, "Can not read the referenced header")
+	}
+
+	target, caps, err := DeserializeAnyHdr(bufio.NewReader(bytes.NewReader(serialized)))
+	if err != nil {
+		return nil, err
+	}
+	if caps.Len != ref.Length {
+		return nil, errs.Errorf(errs.ErrCorrupt, "Referenced header length(%v) does not match "+
+			"the reference length(%v)", caps.Len, ref.Length)
+	}
+	id, err := target.ContentID()
+	if err != nil {
+		return nil, err
+	}
+	refID, err := tools.DecodeHex(ref.ContentID)
+	if err != nil {
+		return nil, errs.WrapPrefix(err, errs.ErrCorrupt, "Can not decode the reference content ID")
+	}
+	if !tools.ConstantTimeEqual(id[:], refID) {
+		return nil, errs.Errorf(errs.ErrCorrupt, "Referenced header content ID(%x) does not "+
+			"match the reference content ID(%v)", id, ref.ContentID)
+	}
+	return target, nil
+}
@@ -0,0 +1,46 @@
+package headers
+
+import (
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// CreatePlainHdrReserved creates a version 2 plaintext header whose
+// serialization is padded to reservedTotalLen bytes, so that the data after
+// it never moves when the header is updated in place with UpdateReserved.
+// The padding is an optional field that older version 2 readers skip. The
+// header must have room for the padding, of at least 8 bytes, or else the
+// error is errs.ErrTooLarge.
+func CreatePlainHdrReserved(hdrType HeaderType, hdrBody []byte, reservedTotalLen uint64,
+	opts ...CreateOption) (Header, error) {
+	opts = append(append([]CreateOption{}, opts...), WithChecksum())
+	hdr := CreatePlainHdr(hdrType, hdrBody, opts...).(*PlainHdrV2)
+	hdr.ReservedLen = reservedTotalLen
+	if _, err := hdr.Serialize(); err != nil {
+		return nil, err
+	}
+	return hdr, nil
+}
+
+// UpdateReserved replaces the body of the serialized header created with
+// CreatePlainHdrReserved, and returns the new serialization, which has the
+// same length, to be written over the old one. The header keeps its type,
+// so a gzipped body is gzipped again. A signature of the old header does
+// not verify the new one, so it is dropped. It returns an errs.ErrTooLarge
+// error if the new body does not fit the reserved length.
+func UpdateReserved(serialized []byte, hdrBody []byte) ([]byte, error) {
+	complete, _, hdr, err := DeserializePlainHdrV2(serialized)
+	if err != nil {
+		return nil, err
+	}
+	if !complete {
+		return nil, errs.New(errs.ErrTruncated, "The serialized header is truncated")
+	}
+	if hdr.Version != PlainHeaderV2 || hdr.ReservedLen == 0 {
+		return nil, errs.New(nil, "The header was not created with CreatePlainHdrReserved")
+	}
+
+	hdr.HdrBody = hdrBody
+	hdr.HdrLen = uint64(len(hdrBody))
+	hdr.Signature = nil
+	return hdr.Serialize()
+}
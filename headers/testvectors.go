@@ -0,0 +1,172 @@
+package headers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// TestVectorManifest is the name of the manifest file written by
+// GenerateTestVectors
+const TestVectorManifest = "manifest.json"
+
+// TestVector describes one serialized header written by GenerateTestVectors.
+// Kind is "plain" or "cipher", and Body is the header body before it is
+// gzipped.
+type TestVector struct {
+	File    string     `json:"file"`
+	Kind    string     `json:"kind"`
+	Version uint32     `json:"version"`
+	HdrType HeaderType `json:"hdrType"`
+	Body    []byte     `json:"body"`
+}
+
+const (
+	testVectorPlain  = "plain"
+	testVectorCipher = "cipher"
+)
+
+var (
+	testVectorJSONBody = []byte(`{"name":"strongsalt","size":42,"tags":["a","b"]}`)
+	// The BSON document {"a": 1}
+	testVectorBSONBody = []byte{0x0c, 0x00, 0x00, 0x00, 0x10, 'a', 0x00, 0x01, 0x00, 0x00, 0x00, 0x00}
+
+	testVectorTypeNames = map[HeaderType]string{
+		HeaderTypeJSON:     "json",
+		HeaderTypeJSONGzip: "jsongzip",
+		HeaderTypeBSON:     "bson",
+		HeaderTypeBSONGzip: "bsongzip",
+	}
+)
+
+// GenerateTestVectors writes a canonical serialized header of every kind,
+// version and header type into dir, along with a manifest describing them.
+// The files let the StrongSalt SDKs in other languages check that they read
+// and write the same headers.
+func GenerateTestVectors(dir string) error {
+	var vectors []TestVector
+	for _, kind := range []string{testVectorPlain, testVectorCipher} {
+		for _, version := range []uint32{1, 2} {
+			for _, hdrType := range HeaderTypes {
+				body := testVectorJSONBody
+				if hdrType == HeaderTypeBSON || hdrType == HeaderTypeBSONGzip {
+					body = testVectorBSONBody
+				}
+
+				vector := TestVector{
+					File: fmt.Sprintf("%v_v%v_%v.bin", kind, version,
+						testVectorTypeNames[hdrType]),
+					Kind:    kind,
+					Version: version,
+					HdrType: hdrType,
+					Body:    body,
+				}
+				serial, err := testVectorHeader(vector).CanonicalSerialize()
+				if err != nil {
+					return err
+				}
+				if err = ioutil.WriteFile(filepath.Join(dir, vector.File), serial, 0644); err != nil {
+					return errs.Wrap(err, nil)
+				}
+				vectors = append(vectors, vector)
+			}
+		}
+	}
+
+	manifest, err := json.MarshalIndent(vectors, "", "  ")
+	if err != nil {
+		return errs.Wrap(err, nil)
+	}
+	return errs.Wrap(ioutil.WriteFile(filepath.Join(dir, TestVectorManifest), manifest, 0644), nil)
+}
+
+// VerifyTestVectors reads the headers described by the manifest in dir, and
+// checks that they deserialize to the described headers. The headers that
+// are not gzipped must also serialize back to the same bytes. Gzipped bodies
+// are only compared after they are gunzipped, since gzip implementations do
+// not all compress the same way.
+func VerifyTestVectors(dir string) error {
+	manifest, err := ioutil.ReadFile(filepath.Join(dir, TestVectorManifest))
+	if err != nil {
+		return errs.Wrap(err, nil)
+	}
+
+	var vectors []TestVector
+	if err = json.Unmarshal(manifest, &vectors); err != nil {
+		return errs.Wrap(err, errs.ErrCorrupt)
+	}
+
+	for _, vector := range vectors {
+		serial, err := ioutil.ReadFile(filepath.Join(dir, vector.File))
+		if err != nil {
+			return errs.Wrap(err, nil)
+		}
+		if err = verifyTestVector(vector, serial); err != nil {
+			return errs.WrapPrefix(err, nil, fmt.Sprintf("Test vector %v", vector.File))
+		}
+	}
+	return nil
+}
+
+// testVectorHeader creates the header described by the test vector
+func testVectorHeader(vector TestVector) Header {
+	var opts []CreateOption
+	if vector.Version == 2 {
+		opts = append(opts, WithChecksum())
+	}
+	if vector.Kind == testVectorCipher {
+		return CreateCipherHdr(vector.HdrType, vector.Body, opts...)
+	}
+	return CreatePlainHdr(vector.HdrType, vector.Body, opts...)
+}
+
+func verifyTestVector(vector TestVector, serial []byte) error {
+	var complete bool
+	var parsed uint32
+	var hdr Header
+	var err error
+	switch vector.Kind {
+	case testVectorPlain:
+		complete, parsed, hdr, err = DeserializePlainHdr(serial)
+	case testVectorCipher:
+		complete, parsed, hdr, err = DeserializeCipherHdr(serial)
+	default:
+		return errs.Errorf(nil, "Unknown header kind %v", vector.Kind)
+	}
+	if err != nil {
+		return err
+	}
+	if !complete || int(parsed) != len(serial) {
+		return errs.Errorf(errs.ErrCorrupt, "Parsed %v of the %v header bytes", parsed, len(serial))
+	}
+
+	if hdr.GetVersion() != vector.Version {
+		return errs.Errorf(errs.ErrCorrupt, "Header version(%v) is not %v",
+			hdr.GetVersion(), vector.Version)
+	}
+	if hdrType, _ := headerType(hdr); hdrType != vector.HdrType {
+		return errs.Errorf(errs.ErrCorrupt, "Header type(%v) is not %v", hdrType, vector.HdrType)
+	}
+	body, err := hdr.GetBody()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(body, vector.Body) {
+		return errs.New(errs.ErrCorrupt, "Header body does not match")
+	}
+
+	if !vector.HdrType.IsGzipped() {
+		expected, err := testVectorHeader(vector).CanonicalSerialize()
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(serial, expected) {
+			return errs.New(errs.ErrCorrupt, "Serialized header does not match")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package headers
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+// Upgrade copies a header followed by its payload from r to w, converting a
+// V1 plaintext or ciphertext header to V2 on the way. The header keeps its
+// kind, type and body, and gains the V2 checksum. The payload after the
+// header is streamed unchanged. A header that is already V2 is copied as it
+// is, along with its payload. The options are passed to CreatePlainHdr or
+// CreateCipherHdr, such as WithParentID. Upgrade returns the number of bytes
+// written to w.
+func Upgrade(r io.Reader, w io.Writer, opts ...CreateOption) (int64, error) {
+	br := bufio.NewReader(r)
+	kind, version, _, err := Peek(br)
+	if err != nil {
+		return 0, err
+	}
+
+	var written int64
+	if version != PlainHeaderV2 && version != CipherHeaderV2 {
+		hdr, caps, err := DeserializeAnyHdr(br)
+		if err != nil {
+			return 0, err
+		}
+		body, err := hdr.GetBody()
+		if err != nil {
+			return 0, err
+		}
+
+		create := CreatePlainHdr
+		if kind == HeaderKindCipher {
+			create = CreateCipherHdr
+		}
+		opts = append(append([]CreateOption{}, opts...), WithChecksum())
+		upgraded, err := create(caps.HdrType, body, opts...).Serialize()
+		if err != nil {
+			return 0, err
+		}
+
+		n, err := w.Write(upgraded)
+		written += int64(n)
+		if err != nil {
+			return written, errs.Wrap(err, nil)
+		}
+	}
+
+	n, err := io.Copy(w, br)
+	written += n
+	return written, errs.Wrap(err, nil)
+}
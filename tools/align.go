@@ -0,0 +1,34 @@
+package tools
+
+// CeilDiv returns a divided by b, rounded up. b must not be 0.
+func CeilDiv(a, b uint64) uint64 {
+	q := a / b
+	if a%b != 0 {
+		q++
+	}
+	return q
+}
+
+// AlignUp rounds u up to a multiple of align. An align of 0 leaves u as it
+// is.
+func AlignUp(u, align uint64) uint64 {
+	if align == 0 {
+		return u
+	}
+	return CeilDiv(u, align) * align
+}
+
+// AlignDown rounds u down to a multiple of align. An align of 0 leaves u as
+// it is.
+func AlignDown(u, align uint64) uint64 {
+	if align == 0 {
+		return u
+	}
+	return u / align * align
+}
+
+// PadLen returns the number of bytes that pad n bytes to a multiple of
+// blockSize
+func PadLen(n, blockSize uint64) uint64 {
+	return AlignUp(n, blockSize) - n
+}
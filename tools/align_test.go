@@ -0,0 +1,33 @@
+package tools
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestAlign(t *testing.T) {
+	assert.Equal(t, CeilDiv(0, 8), uint64(0))
+	assert.Equal(t, CeilDiv(1, 8), uint64(1))
+	assert.Equal(t, CeilDiv(8, 8), uint64(1))
+	assert.Equal(t, CeilDiv(9, 8), uint64(2))
+	assert.Equal(t, CeilDiv(^uint64(0), 2), uint64(1)<<63)
+
+	assert.Equal(t, AlignUp(0, 4096), uint64(0))
+	assert.Equal(t, AlignUp(1, 4096), uint64(4096))
+	assert.Equal(t, AlignUp(4096, 4096), uint64(4096))
+	assert.Equal(t, AlignUp(4097, 4096), uint64(8192))
+	assert.Equal(t, AlignUp(10, 3), uint64(12))
+	assert.Equal(t, AlignUp(10, 0), uint64(10))
+
+	assert.Equal(t, AlignDown(4095, 4096), uint64(0))
+	assert.Equal(t, AlignDown(8191, 4096), uint64(4096))
+	assert.Equal(t, AlignDown(10, 3), uint64(9))
+	assert.Equal(t, AlignDown(10, 0), uint64(10))
+
+	assert.Equal(t, PadLen(0, 64), uint64(0))
+	assert.Equal(t, PadLen(1, 64), uint64(63))
+	assert.Equal(t, PadLen(64, 64), uint64(0))
+	assert.Equal(t, PadLen(70, 64), uint64(58))
+	assert.Equal(t, PadLen(70, 0), uint64(0))
+}
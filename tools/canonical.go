@@ -0,0 +1,38 @@
+package tools
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/go-errors/errors"
+)
+
+// MarshalCanonical marshals a value into canonical JSON, for data that is
+// hashed or signed. The same value always gives the same bytes: object keys,
+// struct fields included, are sorted, there is no insignificant white space,
+// and HTML characters are not escaped. Numbers are kept as Marshal writes
+// them.
+func MarshalCanonical(v interface{}) ([]byte, error) {
+	serial, err := Marshal(v)
+	if err != nil {
+		return nil, errors.New(err)
+	}
+
+	// Decoding into generic values turns structs into maps, whose keys the
+	// encoder sorts
+	decoder := json.NewDecoder(bytes.NewReader(serial))
+	decoder.UseNumber()
+	var generic interface{}
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, errors.New(err)
+	}
+
+	var canonical bytes.Buffer
+	encoder := json.NewEncoder(&canonical)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(generic); err != nil {
+		return nil, errors.New(err)
+	}
+	// The encoder ends the value with a newline
+	return bytes.TrimSuffix(canonical.Bytes(), []byte("\n")), nil
+}
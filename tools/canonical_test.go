@@ -0,0 +1,44 @@
+package tools
+
+import (
+	"encoding/json"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestMarshalCanonical(t *testing.T) {
+	type inner struct {
+		Zeta  string
+		Alpha []interface{}
+	}
+	type testData struct {
+		Name  string            `json:"name"`
+		Inner inner             `json:"inner"`
+		Tags  map[string]uint64 `json:"tags"`
+		Big   uint64            `json:"big"`
+	}
+
+	data := &testData{
+		Name:  "<a> & b",
+		Inner: inner{"z", []interface{}{1.5, nil, map[string]int{"y": 1, "x": 2}}},
+		Tags:  map[string]uint64{"c": 3, "a": 1, "b": 2},
+		Big:   1<<64 - 1,
+	}
+	expected := `{"big":18446744073709551615,"inner":{"Alpha":[1.5,null,{"x":2,"y":1}],` +
+		`"Zeta":"z"},"name":"<a> & b","tags":{"a":1,"b":2,"c":3}}`
+
+	for i := 0; i < 10; i++ {
+		serial, err := MarshalCanonical(data)
+		assert.NilError(t, err)
+		assert.Equal(t, string(serial), expected)
+	}
+
+	// Already marshaled JSON is made canonical too
+	serial, err := MarshalCanonical(json.RawMessage(`{ "b": [1, 2], "a": "<" }`))
+	assert.NilError(t, err)
+	assert.Equal(t, string(serial), `{"a":"<","b":[1,2]}`)
+
+	_, err = MarshalCanonical(make(chan int))
+	assert.Assert(t, err != nil)
+}
@@ -0,0 +1,86 @@
+package tools
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"strings"
+
+	"github.com/go-errors/errors"
+)
+
+// B64Variant is the base64 alphabet used to encode binary content, such as
+// block and header bytes embedded in JSON bodies
+type B64Variant int
+
+const (
+	// B64Std is the standard alphabet of RFC 4648, with padding
+	B64Std = B64Variant(iota)
+	// B64URL is the URL and file name safe alphabet of RFC 4648, without
+	// padding
+	B64URL
+)
+
+// encoding returns the encoding of the variant, which pads only B64Std
+func (v B64Variant) encoding() *base64.Encoding {
+	if v == B64URL {
+		return base64.RawURLEncoding
+	}
+	return base64.StdEncoding
+}
+
+// EncodeB64 encodes some bytes in base64
+func EncodeB64(b []byte, variant B64Variant) string {
+	return variant.encoding().EncodeToString(b)
+}
+
+// DecodeB64 decodes a base64 string. The padding is optional in both
+// variants, since encoders do not agree on it.
+func DecodeB64(s string, variant B64Variant) ([]byte, error) {
+	enc := base64.RawStdEncoding
+	if variant == B64URL {
+		enc = base64.RawURLEncoding
+	}
+	b, err := enc.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, errors.New(err)
+	}
+	return b, nil
+}
+
+// NewB64Encoder returns a writer that encodes the bytes written to it in
+// base64 into w. It must be closed to write the last partial block.
+func NewB64Encoder(w io.Writer, variant B64Variant) io.WriteCloser {
+	return base64.NewEncoder(variant.encoding(), w)
+}
+
+// NewB64Decoder returns a reader that decodes the base64 read from r, as
+// written by NewB64Encoder
+func NewB64Decoder(r io.Reader, variant B64Variant) io.Reader {
+	return base64.NewDecoder(variant.encoding(), r)
+}
+
+// EncodeHex encodes some bytes in lower case hexadecimal
+func EncodeHex(b []byte) string {
+	return hex.EncodeToString(b)
+}
+
+// DecodeHex decodes a hexadecimal string of either case
+func DecodeHex(s string) ([]byte, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, errors.New(err)
+	}
+	return b, nil
+}
+
+// NewHexEncoder returns a writer that encodes the bytes written to it in
+// lower case hexadecimal into w
+func NewHexEncoder(w io.Writer) io.Writer {
+	return hex.NewEncoder(w)
+}
+
+// NewHexDecoder returns a reader that decodes the hexadecimal read from r
+func NewHexDecoder(r io.Reader) io.Reader {
+	return hex.NewDecoder(r)
+}
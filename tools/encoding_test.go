@@ -0,0 +1,68 @@
+package tools
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestB64(t *testing.T) {
+	data := []byte{0xfb, 0xff, 0xfe, 0x00, 0x01}
+
+	assert.Equal(t, EncodeB64(data, B64Std), "+//+AAE=")
+	assert.Equal(t, EncodeB64(data, B64URL), "-__-AAE")
+
+	// Padding is optional when decoding
+	for _, s := range []string{"+//+AAE=", "+//+AAE"} {
+		b, err := DecodeB64(s, B64Std)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, b, data)
+	}
+	for _, s := range []string{"-__-AAE=", "-__-AAE"} {
+		b, err := DecodeB64(s, B64URL)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, b, data)
+	}
+	_, err := DecodeB64("-__-AAE", B64Std)
+	assert.Assert(t, err != nil)
+	_, err = DecodeB64("+//+AAE", B64URL)
+	assert.Assert(t, err != nil)
+
+	for _, variant := range []B64Variant{B64Std, B64URL} {
+		var buf bytes.Buffer
+		encoder := NewB64Encoder(&buf, variant)
+		_, err := encoder.Write(data[:2])
+		assert.NilError(t, err)
+		_, err = encoder.Write(data[2:])
+		assert.NilError(t, err)
+		assert.NilError(t, encoder.Close())
+		assert.Equal(t, buf.String(), EncodeB64(data, variant))
+
+		b, err := ioutil.ReadAll(NewB64Decoder(&buf, variant))
+		assert.NilError(t, err)
+		assert.DeepEqual(t, b, data)
+	}
+}
+
+func TestHex(t *testing.T) {
+	data := []byte{0xde, 0xad, 0xbe, 0xef}
+
+	assert.Equal(t, EncodeHex(data), "deadbeef")
+	for _, s := range []string{"deadbeef", "DEADBEEF"} {
+		b, err := DecodeHex(s)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, b, data)
+	}
+	_, err := DecodeHex("deadbee")
+	assert.Assert(t, err != nil)
+
+	var buf bytes.Buffer
+	_, err = NewHexEncoder(&buf).Write(data)
+	assert.NilError(t, err)
+	assert.Equal(t, buf.String(), "deadbeef")
+	b, err := ioutil.ReadAll(NewHexDecoder(&buf))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, b, data)
+}
@@ -0,0 +1,156 @@
+// Package errs provides the error taxonomy shared by the StrongSalt common
+// packages. Errors created here carry a stack trace (like go-errors) and a
+// sentinel classification that can be tested with the standard errors.Is and
+// errors.As functions. The headers package only returns errors of this
+// package, so its callers do not need go-errors to inspect them, and can get
+// their stack trace through the Stacktrace function.
+package errs
+
+import (
+	stderrors "errors"
+	"fmt"
+
+	"github.com/go-errors/errors"
+)
+
+var (
+	// ErrTruncated means the input ended before a complete structure was read
+	ErrTruncated = stderrors.New("truncated data")
+	// ErrCorrupt means the input is malformed or failed an integrity check
+	ErrCorrupt = stderrors.New("corrupt data")
+	// ErrUnsupportedVersion means the input uses a version this code can not parse
+	ErrUnsupportedVersion = stderrors.New("unsupported version")
+	// ErrTooLarge means a size exceeds what is allowed or representable
+	ErrTooLarge = stderrors.New("too large")
+	// ErrNotFound means the requested item does not exist
+	ErrNotFound = stderrors.New("not found")
+)
+
+// Error is a classified error with a stack trace. It unwraps to the
+// underlying cause and matches its Kind sentinel with errors.Is.
+type Error struct {
+	Kind error
+	Err  *errors.Error
+}
+
+// Errorf creates a new error of the given kind with a stack trace
+func Errorf(kind error, format string, a ...interface{}) *Error {
+	return &Error{kind, errors.Wrap(fmt.Errorf(format, a...), 1)}
+}
+
+// New creates a new error of the given kind with a stack trace. If msg is
+// empty, the message of the kind is used.
+func New(kind error, msg string) *Error {
+	if msg == "" {
+		return &Error{kind, errors.Wrap(kind.Error(), 1)}
+	}
+	return &Error{kind, errors.Wrap(msg, 1)}
+}
+
+// Wrap adds a stack trace and an optional classification to err, so that
+// err and kind can both be found with errors.Is and errors.As. An existing
+// go-errors stack trace is preserved. Wrap returns nil if err is nil.
+func Wrap(err error, kind error) error {
+	if err == nil {
+		return nil
+	}
+
+	switch e := err.(type) {
+	case *Error:
+		if kind == nil || Is(e, kind) {
+			return e
+		}
+		return &Error{kind, errors.Wrap(e, 1)}
+	case *errors.Error:
+		return &Error{kind, e}
+	}
+
+	if kind != nil && stderrors.Is(err, kind) {
+		kind = nil
+	}
+	return &Error{kind, errors.Wrap(err, 1)}
+}
+
+// WrapPrefix is like Wrap, but also prefixes the error message. The
+// original error stays reachable with errors.Is and errors.As.
+func WrapPrefix(err error, kind error, prefix string) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{kind, errors.Wrap(fmt.Errorf("%v: %w", prefix, err), 1)}
+}
+
+// StackTracer is implemented by the errors that carry a stack trace, such as
+// *Error. It matches tools.ErrorStack.
+type StackTracer interface {
+	Error() string
+	Stacktrace() string
+}
+
+// Stacktrace returns the stack trace of the first error in the chain of err
+// that has one, or "" if none does. go-errors errors are found as well.
+func Stacktrace(err error) string {
+	var tracer StackTracer
+	if As(err, &tracer) {
+		return tracer.Stacktrace()
+	}
+	var goErr *errors.Error
+	if stderrors.As(err, &goErr) {
+		return goErr.ErrorStack()
+	}
+	return ""
+}
+
+// Error shows the error message
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Stacktrace shows the stack trace
+func (e *Error) Stacktrace() string {
+	return e.Err.ErrorStack()
+}
+
+// Unwrap returns the underlying cause of the error
+func (e *Error) Unwrap() error {
+	return e.Err.Err
+}
+
+// Is reports whether the error is of the target kind
+func (e *Error) Is(target error) bool {
+	return e.Kind != nil && e.Kind == target
+}
+
+// Is is like errors.Is, but also looks inside go-errors errors, which do
+// not implement Unwrap
+func Is(err, target error) bool {
+	for err != nil {
+		if stderrors.Is(err, target) {
+			return true
+		}
+		err = unwrapGoError(err)
+	}
+	return false
+}
+
+// As is like errors.As, but also looks inside go-errors errors, which do
+// not implement Unwrap
+func As(err error, target interface{}) bool {
+	for err != nil {
+		if stderrors.As(err, target) {
+			return true
+		}
+		err = unwrapGoError(err)
+	}
+	return false
+}
+
+// unwrapGoError finds the first go-errors error in the chain of err and
+// returns the error it wraps
+func unwrapGoError(err error) error {
+	var e *errors.Error
+	if stderrors.As(err, &e) {
+		return e.Err
+	}
+	return nil
+}
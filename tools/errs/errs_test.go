@@ -0,0 +1,80 @@
+package errs
+
+import (
+	stderrors "errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/go-errors/errors"
+	"gotest.tools/assert"
+)
+
+func TestErrorKind(t *testing.T) {
+	err := Errorf(ErrCorrupt, "Block %v is bad", 1)
+	assert.Equal(t, err.Error(), "Block 1 is bad")
+	assert.Assert(t, stderrors.Is(err, ErrCorrupt))
+	assert.Assert(t, !stderrors.Is(err, ErrTruncated))
+	assert.Assert(t, len(err.Stacktrace()) > 0)
+
+	err = New(ErrTooLarge, "")
+	assert.Equal(t, err.Error(), ErrTooLarge.Error())
+	assert.Assert(t, stderrors.Is(err, ErrTooLarge))
+
+	var e *Error
+	assert.Assert(t, stderrors.As(err, &e))
+	assert.Equal(t, e.Kind, ErrTooLarge)
+}
+
+func TestWrap(t *testing.T) {
+	assert.NilError(t, Wrap(nil, ErrCorrupt))
+	assert.NilError(t, WrapPrefix(nil, ErrCorrupt, "prefix"))
+
+	// Plain errors stay reachable
+	err := Wrap(io.EOF, ErrTruncated)
+	assert.Assert(t, stderrors.Is(err, io.EOF))
+	assert.Assert(t, stderrors.Is(err, ErrTruncated))
+
+	// go-errors errors keep their stack trace and become unwrappable
+	goErr := errors.New(io.ErrUnexpectedEOF)
+	err = Wrap(goErr, nil)
+	assert.Assert(t, stderrors.Is(err, io.ErrUnexpectedEOF))
+	assert.Equal(t, err.(*Error).Stacktrace(), goErr.ErrorStack())
+
+	// Re-classifying keeps the original classification
+	err = Wrap(Errorf(ErrTruncated, "short"), ErrCorrupt)
+	assert.Assert(t, stderrors.Is(err, ErrTruncated))
+	assert.Assert(t, stderrors.Is(err, ErrCorrupt))
+
+	err = WrapPrefix(io.EOF, ErrTruncated, "Can not read header")
+	assert.Equal(t, err.Error(), "Can not read header: EOF")
+	assert.Assert(t, stderrors.Is(err, io.EOF))
+	assert.Assert(t, stderrors.Is(err, ErrTruncated))
+}
+
+func TestIsAsGoErrors(t *testing.T) {
+	// go-errors does not implement Unwrap, so the standard library
+	// can not see inside of it
+	err := errors.New(Errorf(ErrCorrupt, "bad"))
+	assert.Assert(t, !stderrors.Is(err, ErrCorrupt))
+	assert.Assert(t, Is(err, ErrCorrupt))
+
+	var e *Error
+	assert.Assert(t, As(err, &e))
+	assert.Equal(t, e.Kind, ErrCorrupt)
+	assert.Assert(t, !Is(err, ErrTooLarge))
+}
+
+func TestStacktrace(t *testing.T) {
+	err := Errorf(ErrCorrupt, "bad")
+	assert.Equal(t, Stacktrace(err), err.Stacktrace())
+	assert.Equal(t, Stacktrace(fmt.Errorf("context: %w", err)), err.Stacktrace())
+
+	goErr := errors.New(io.EOF)
+	assert.Equal(t, Stacktrace(goErr), goErr.ErrorStack())
+	assert.Equal(t, Stacktrace(io.EOF), "")
+	assert.Equal(t, Stacktrace(nil), "")
+
+	var tracer StackTracer = err
+	assert.Assert(t, len(tracer.Stacktrace()) > 0)
+}
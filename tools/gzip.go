@@ -1,43 +1,161 @@
 package tools
 
 import (
+	"bufio"
 	"bytes"
 	"compress/gzip"
+	"io"
 	"io/ioutil"
 
 	"github.com/go-errors/errors"
+	"github.com/overnest/strongsalt-common-go/tools/errs"
 )
 
-// Gzip compresses some bytes
+// GzipDefaultLevel is the compression level used by Gzip. It can be any of
+// the compress/gzip levels, from gzip.HuffmanOnly to gzip.BestCompression.
+var GzipDefaultLevel = gzip.DefaultCompression
+
+// Gzip compresses some bytes at GzipDefaultLevel
 func Gzip(b []byte) ([]byte, error) {
-	var buf bytes.Buffer
-	zw := gzip.NewWriter(&buf)
-	if _, err := zw.Write(b); err != nil {
+	return GzipLevel(b, GzipDefaultLevel)
+}
+
+// GzipLevel compresses some bytes at the given compression level
+func GzipLevel(b []byte, level int) ([]byte, error) {
+	buf := bytes.NewBuffer(DefaultBufferPool.Get(len(b)/2 + 64)[:0])
+	defer func() { DefaultBufferPool.Put(buf.Bytes()) }()
+
+	zw, err := gzip.NewWriterLevel(buf, level)
+	if err != nil {
 		return nil, errors.New(err)
 	}
-	if err := zw.Close(); err != nil {
+	if _, err := zw.Write(b); err != nil {
 		return nil, errors.New(err)
 	}
-	zb, err := ioutil.ReadAll(&buf)
-	if err != nil {
+	if err := zw.Close(); err != nil {
 		return nil, errors.New(err)
 	}
+
+	zb := make([]byte, buf.Len())
+	copy(zb, buf.Bytes())
 	return zb, nil
 }
 
 // Gunzip uncompresses some bytes
 func Gunzip(zb []byte) ([]byte, error) {
+	return gunzip(zb, -1)
+}
+
+// GunzipLimit uncompresses some bytes, and stops with an errs.ErrTooLarge
+// error once the uncompressed data gets bigger than maxSize bytes, so that
+// untrusted data can not exhaust the memory with a small gzip bomb
+func GunzipLimit(zb []byte, maxSize int64) ([]byte, error) {
+	if maxSize < 0 {
+		return nil, errors.Errorf("Invalid maximum uncompressed size(%v)", maxSize)
+	}
+	return gunzip(zb, maxSize)
+}
+
+// gunzip uncompresses some bytes, up to maxSize bytes unless it is negative
+func gunzip(zb []byte, maxSize int64) ([]byte, error) {
 	zr, err := gzip.NewReader(bytes.NewReader(zb))
 	if err != nil {
 		return nil, errors.New(err)
 	}
+	defer zr.Close()
 
-	b, err := ioutil.ReadAll(zr)
-	if err != nil {
-		zr.Close()
+	bufSize := len(zb)*4 + 64
+	var r io.Reader = zr
+	if maxSize >= 0 {
+		if int64(bufSize) > maxSize+1 {
+			bufSize = int(maxSize + 1)
+		}
+		// Read one byte more than allowed, to tell that there is more
+		r = io.LimitReader(zr, maxSize+1)
+	}
+
+	buf := bytes.NewBuffer(DefaultBufferPool.Get(bufSize)[:0])
+	defer func() { DefaultBufferPool.Put(buf.Bytes()) }()
+
+	if _, err = io.Copy(buf, r); err != nil {
 		return nil, errors.New(err)
 	}
+	if maxSize >= 0 && int64(buf.Len()) > maxSize {
+		return nil, errs.Errorf(errs.ErrTooLarge, "The uncompressed data is bigger "+
+			"than %v bytes", maxSize)
+	}
 
-	zr.Close()
+	b := make([]byte, buf.Len())
+	copy(b, buf.Bytes())
 	return b, nil
 }
+
+// GunzipMultistream uncompresses concatenated gzip members, and returns the
+// data of each member separately. Gunzip returns the data of all the members
+// joined together.
+func GunzipMultistream(zb []byte) ([][]byte, error) {
+	var members [][]byte
+	err := GunzipEachMember(bytes.NewReader(zb), func(_ int, member io.Reader) error {
+		buf := bytes.NewBuffer(DefaultBufferPool.Get(len(zb)*4 + 64)[:0])
+		defer func() { DefaultBufferPool.Put(buf.Bytes()) }()
+
+		if _, err := io.Copy(buf, member); err != nil {
+			return err
+		}
+		b := make([]byte, buf.Len())
+		copy(b, buf.Bytes())
+		members = append(members, b)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// GunzipEachMember calls fn with the uncompressed data of every gzip member
+// in r, in order. The data of a member is only valid until fn returns, and
+// whatever fn does not read is skipped. The stream must hold at least one
+// member, and nothing but gzip members.
+func GunzipEachMember(r io.Reader, fn func(index int, member io.Reader) error) error {
+	// gzip only stops at the end of a member if it can read byte by byte
+	br, ok := r.(byteScanReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return errors.New(err)
+	}
+	defer zr.Close()
+
+	for index := 0; ; index++ {
+		zr.Multistream(false)
+		if err = fn(index, zr); err != nil {
+			return errors.New(err)
+		}
+		if _, err = io.Copy(ioutil.Discard, zr); err != nil {
+			return errors.New(err)
+		}
+
+		// Stop at the end of the stream, and not on a bad member header
+		if _, err = br.ReadByte(); err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.New(err)
+		}
+		if err = br.UnreadByte(); err != nil {
+			return errors.New(err)
+		}
+		if err = zr.Reset(br); err != nil {
+			return errors.New(err)
+		}
+	}
+}
+
+type byteScanReader interface {
+	io.Reader
+	io.ByteScanner
+}
@@ -1,8 +1,13 @@
 package tools
 
 import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
 	"testing"
 
+	"github.com/overnest/strongsalt-common-go/tools/errs"
 	"gotest.tools/assert"
 )
 
@@ -28,3 +33,91 @@ func TestGzip(t *testing.T) {
 	assert.NilError(t, err)
 	assert.Equal(t, len(b), 0)
 }
+
+func TestGzipLevel(t *testing.T) {
+	levels := []int{gzip.HuffmanOnly, gzip.DefaultCompression,
+		gzip.NoCompression, gzip.BestSpeed, gzip.BestCompression}
+
+	for _, level := range levels {
+		zb, err := GzipLevel([]byte(teststr), level)
+		assert.NilError(t, err)
+		b, err := Gunzip(zb)
+		assert.NilError(t, err)
+		assert.Equal(t, teststr, string(b))
+	}
+
+	_, err := GzipLevel([]byte(teststr), gzip.BestCompression+1)
+	assert.Assert(t, err != nil)
+
+	fast, err := GzipLevel([]byte(teststr), gzip.NoCompression)
+	assert.NilError(t, err)
+	best, err := GzipLevel([]byte(teststr), gzip.BestCompression)
+	assert.NilError(t, err)
+	assert.Assert(t, len(best) < len(fast))
+}
+
+func TestGunzipLimit(t *testing.T) {
+	zb, err := Gzip([]byte(teststr))
+	assert.NilError(t, err)
+
+	for _, maxSize := range []int64{int64(len(teststr)), int64(len(teststr)) + 1, 1 << 30} {
+		b, err := GunzipLimit(zb, maxSize)
+		assert.NilError(t, err)
+		assert.Equal(t, string(b), teststr)
+	}
+
+	// A small gzip bomb stops at the limit
+	bomb, err := Gzip(make([]byte, 64<<20))
+	assert.NilError(t, err)
+	assert.Assert(t, len(bomb)*500 < 64<<20)
+	for _, zb := range [][]byte{zb, bomb} {
+		_, err = GunzipLimit(zb, int64(len(teststr))-1)
+		assert.Assert(t, errs.Is(err, errs.ErrTooLarge))
+	}
+
+	b, err := GunzipLimit(zb, 0)
+	assert.Assert(t, errs.Is(err, errs.ErrTooLarge))
+	assert.Assert(t, b == nil)
+	_, err = GunzipLimit(zb, -1)
+	assert.Assert(t, err != nil)
+}
+
+func TestGunzipMultistream(t *testing.T) {
+	parts := []string{teststr, "", "second member", teststr[:100]}
+	var concatenated []byte
+	for _, part := range parts {
+		zb, err := Gzip([]byte(part))
+		assert.NilError(t, err)
+		concatenated = append(concatenated, zb...)
+	}
+
+	members, err := GunzipMultistream(concatenated)
+	assert.NilError(t, err)
+	assert.Equal(t, len(members), len(parts))
+	for i, part := range parts {
+		assert.Equal(t, string(members[i]), part)
+	}
+
+	// Gunzip joins the members
+	b, err := Gunzip(concatenated)
+	assert.NilError(t, err)
+	assert.Equal(t, string(b), strings.Join(parts, ""))
+
+	// Members do not have to be read, and the stream does not have to be
+	// a byte reader
+	count := 0
+	err = GunzipEachMember(struct{ io.Reader }{bytes.NewReader(concatenated)},
+		func(index int, member io.Reader) error {
+			assert.Equal(t, index, count)
+			count++
+			return nil
+		})
+	assert.NilError(t, err)
+	assert.Equal(t, count, len(parts))
+
+	// Data after the members is an error
+	_, err = GunzipMultistream(append(concatenated, "trailing"...))
+	assert.Assert(t, err != nil)
+	_, err = GunzipMultistream(nil)
+	assert.Assert(t, err != nil)
+}
@@ -0,0 +1,77 @@
+package tools
+
+import (
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/go-errors/errors"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// CRC32C computes the CRC-32 checksum of some bytes using the Castagnoli
+// polynomial
+func CRC32C(b []byte) uint32 {
+	return crc32.Checksum(b, crc32cTable)
+}
+
+// NewCRC32C creates a streaming CRC-32 (Castagnoli) hash
+func NewCRC32C() hash.Hash32 {
+	return crc32.New(crc32cTable)
+}
+
+// XXHash64 computes the 64 bit xxHash of some bytes
+func XXHash64(b []byte) uint64 {
+	return xxhash.Sum64(b)
+}
+
+// NewXXHash64 creates a streaming 64 bit xxHash
+func NewXXHash64() hash.Hash64 {
+	return xxhash.New()
+}
+
+// Hasher is an io.Writer that computes a hash of all the data passing
+// through it on the way to the underlying writer
+type Hasher struct {
+	writer  io.Writer
+	hash    hash.Hash
+	written uint64
+}
+
+// NewHasher creates a Hasher that writes to w and hashes with h. If w is
+// nil, the data is only hashed.
+func NewHasher(w io.Writer, h hash.Hash) *Hasher {
+	return &Hasher{w, h, 0}
+}
+
+// Write writes to the underlying writer and hashes the bytes written
+func (h *Hasher) Write(p []byte) (int, error) {
+	n := len(p)
+	var err error
+	if h.writer != nil {
+		if n, err = h.writer.Write(p); err != nil {
+			err = errors.New(err)
+		}
+	}
+
+	h.hash.Write(p[:n])
+	h.written += uint64(n)
+	return n, err
+}
+
+// Hash returns the underlying hash
+func (h *Hasher) Hash() hash.Hash {
+	return h.hash
+}
+
+// Sum returns the hash of all the bytes written so far
+func (h *Hasher) Sum() []byte {
+	return h.hash.Sum(nil)
+}
+
+// Written returns the number of bytes written so far
+func (h *Hasher) Written() uint64 {
+	return h.written
+}
@@ -0,0 +1,40 @@
+package tools
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestHash(t *testing.T) {
+	// Known answers
+	assert.Equal(t, CRC32C([]byte("123456789")), uint32(0xe3069283))
+	assert.Equal(t, XXHash64(nil), uint64(0xef46db3751d8e999))
+
+	var buf bytes.Buffer
+	hasher := NewHasher(&buf, NewCRC32C())
+	for data := []byte(teststr); len(data) > 0; {
+		chunk := data[:MinUint32(7, uint32(len(data)))]
+		n, err := hasher.Write(chunk)
+		assert.NilError(t, err)
+		assert.Equal(t, n, len(chunk))
+		data = data[n:]
+	}
+	assert.Equal(t, buf.String(), teststr)
+	assert.Equal(t, hasher.Written(), uint64(len(teststr)))
+	assert.Equal(t, binary.BigEndian.Uint32(hasher.Sum()), CRC32C([]byte(teststr)))
+
+	hasher = NewHasher(nil, NewXXHash64())
+	_, err := hasher.Write([]byte(teststr))
+	assert.NilError(t, err)
+	assert.Equal(t, binary.BigEndian.Uint64(hasher.Sum()), XXHash64([]byte(teststr)))
+
+	hasher = NewHasher(nil, sha256.New())
+	_, err = hasher.Write([]byte(teststr))
+	assert.NilError(t, err)
+	sum := sha256.Sum256([]byte(teststr))
+	assert.DeepEqual(t, hasher.Sum(), sum[:])
+}
@@ -0,0 +1,69 @@
+package tools
+
+import (
+	"math"
+
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+)
+
+const maxInt = int(^uint(0) >> 1)
+
+// MaxUint32 returns the bigger value between two uint32 numbers
+func MaxUint32(x, y uint32) uint32 {
+	if x > y {
+		return x
+	}
+	return y
+}
+
+// MinUint32 returns the smaller value between two uint32 numbers
+func MinUint32(x, y uint32) uint32 {
+	if x < y {
+		return x
+	}
+	return y
+}
+
+// MaxUint64 returns the bigger value between two uint64 numbers
+func MaxUint64(x, y uint64) uint64 {
+	if x > y {
+		return x
+	}
+	return y
+}
+
+// MinUint64 returns the smaller value between two uint64 numbers
+func MinUint64(x, y uint64) uint64 {
+	if x < y {
+		return x
+	}
+	return y
+}
+
+// ClampUint32 limits x to the range from lo to hi
+func ClampUint32(x, lo, hi uint32) uint32 {
+	return MaxUint32(lo, MinUint32(x, hi))
+}
+
+// ClampUint64 limits x to the range from lo to hi
+func ClampUint64(x, lo, hi uint64) uint64 {
+	return MaxUint64(lo, MinUint64(x, hi))
+}
+
+// U64ToU32 converts x to a uint32, or returns an errs.ErrTooLarge error if
+// it does not fit, instead of truncating it
+func U64ToU32(x uint64) (uint32, error) {
+	if x > math.MaxUint32 {
+		return 0, errs.Errorf(errs.ErrTooLarge, "Value(%v) does not fit in 32 bits", x)
+	}
+	return uint32(x), nil
+}
+
+// U64ToInt converts x to an int, or returns an errs.ErrTooLarge error if it
+// does not fit, instead of truncating it
+func U64ToInt(x uint64) (int, error) {
+	if x > uint64(maxInt) {
+		return 0, errs.Errorf(errs.ErrTooLarge, "Value(%v) does not fit in an int", x)
+	}
+	return int(x), nil
+}
@@ -0,0 +1,35 @@
+package tools
+
+import (
+	"math"
+	"testing"
+
+	"github.com/overnest/strongsalt-common-go/tools/errs"
+	"gotest.tools/assert"
+)
+
+func TestMinMaxClamp(t *testing.T) {
+	assert.Equal(t, MaxUint32(3, 5), uint32(5))
+	assert.Equal(t, MinUint32(5, 3), uint32(3))
+	assert.Equal(t, MaxUint64(5, 3), uint64(5))
+	assert.Equal(t, MinUint64(3, 5), uint64(3))
+	assert.Equal(t, MinUint64(5, 3), uint64(3))
+	assert.Equal(t, ClampUint32(7, 2, 5), uint32(5))
+	assert.Equal(t, ClampUint32(1, 2, 5), uint32(2))
+	assert.Equal(t, ClampUint32(3, 2, 5), uint32(3))
+	assert.Equal(t, ClampUint64(math.MaxUint64, 0, 10), uint64(10))
+}
+
+func TestCheckedConversions(t *testing.T) {
+	u32, err := U64ToU32(math.MaxUint32)
+	assert.NilError(t, err)
+	assert.Equal(t, u32, uint32(math.MaxUint32))
+	_, err = U64ToU32(math.MaxUint32 + 1)
+	assert.Assert(t, errs.Is(err, errs.ErrTooLarge))
+
+	i, err := U64ToInt(42)
+	assert.NilError(t, err)
+	assert.Equal(t, i, 42)
+	_, err = U64ToInt(math.MaxUint64)
+	assert.Assert(t, errs.Is(err, errs.ErrTooLarge))
+}
@@ -0,0 +1,28 @@
+package tools
+
+import (
+	"encoding/json"
+
+	"github.com/go-errors/errors"
+)
+
+// countingWriter counts the bytes written to it, and throws them away
+type countingWriter struct {
+	count int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.count += len(p)
+	return len(p), nil
+}
+
+// EstimateJSONSize returns the length of the JSON encoding of a value, as
+// produced by Marshal, without keeping the encoded bytes in memory
+func EstimateJSONSize(v interface{}) (int, error) {
+	counter := &countingWriter{}
+	if err := json.NewEncoder(counter).Encode(v); err != nil {
+		return 0, errors.New(err)
+	}
+	// The encoder ends every value with a newline, which Marshal does not
+	return counter.count - 1, nil
+}
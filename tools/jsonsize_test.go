@@ -0,0 +1,35 @@
+package tools
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestEstimateJSONSize(t *testing.T) {
+	type testData struct {
+		Name  string
+		List  []uint64
+		Inner map[string]interface{} `json:",omitempty"`
+	}
+
+	values := []interface{}{
+		nil,
+		42,
+		"<html> & \"quotes\"",
+		[]byte(teststr),
+		&testData{Name: "name", List: []uint64{1, 2, 3}},
+		&testData{Inner: map[string]interface{}{"a": 1.5, "b": []string{"c"}}},
+	}
+
+	for _, v := range values {
+		b, err := Marshal(v)
+		assert.NilError(t, err)
+		size, err := EstimateJSONSize(v)
+		assert.NilError(t, err)
+		assert.Equal(t, size, len(b))
+	}
+
+	_, err := EstimateJSONSize(make(chan int))
+	assert.Assert(t, err != nil)
+}
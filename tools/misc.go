@@ -2,22 +2,6 @@ package tools
 
 import "encoding/json"
 
-// MaxUint32 returns the bigger value between two uint32 numbers
-func MaxUint32(x, y uint32) uint32 {
-	if x > y {
-		return x
-	}
-	return y
-}
-
-// MinUint32 returns the smaller value between two uint32 numbers
-func MinUint32(x, y uint32) uint32 {
-	if x < y {
-		return x
-	}
-	return y
-}
-
 // BinarySearchU64 finds a number in a sorted list.
 // Returns the index where the value is found.
 // Returns -1 if value is not found
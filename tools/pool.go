@@ -0,0 +1,56 @@
+package tools
+
+import (
+	"math/bits"
+	"sync"
+)
+
+const (
+	minPoolSizeClass = 6  // 64 bytes
+	maxPoolSizeClass = 24 // 16 MB
+)
+
+// BufferPool is a pool of byte slices. Slices are grouped into power of two
+// size classes, each backed by its own sync.Pool, so that a request for a
+// small buffer never holds on to a huge one.
+type BufferPool struct {
+	pools [maxPoolSizeClass + 1]sync.Pool
+}
+
+// DefaultBufferPool is the buffer pool shared by the StrongSalt packages
+var DefaultBufferPool = NewBufferPool()
+
+// NewBufferPool creates a buffer pool
+func NewBufferPool() *BufferPool {
+	return &BufferPool{}
+}
+
+// Get returns a byte slice of length size. The content of the slice is
+// undefined. Slices bigger than the largest size class are not pooled.
+func (p *BufferPool) Get(size int) []byte {
+	class := minPoolSizeClass
+	if size > 1<<minPoolSizeClass {
+		class = bits.Len(uint(size - 1))
+	}
+	if class > maxPoolSizeClass {
+		return make([]byte, size)
+	}
+
+	if buf, ok := p.pools[class].Get().(*[]byte); ok {
+		return (*buf)[:size]
+	}
+	return make([]byte, size, 1<<uint(class))
+}
+
+// Put returns a byte slice to the pool. The caller must not use the slice
+// after it has been returned.
+func (p *BufferPool) Put(buf []byte) {
+	// Round down so that every slice in a size class can hold the class size
+	class := bits.Len(uint(cap(buf))) - 1
+	if class < minPoolSizeClass || class > maxPoolSizeClass {
+		return
+	}
+
+	buf = buf[:0]
+	p.pools[class].Put(&buf)
+}
@@ -0,0 +1,30 @@
+package tools
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestBufferPool(t *testing.T) {
+	pool := NewBufferPool()
+
+	for _, size := range []int{0, 1, 63, 64, 65, 1000, 4096, 1 << 20, 1<<24 + 1} {
+		buf := pool.Get(size)
+		assert.Equal(t, len(buf), size)
+		assert.Assert(t, cap(buf) >= size)
+		pool.Put(buf)
+
+		buf = pool.Get(size)
+		assert.Equal(t, len(buf), size)
+		assert.Assert(t, cap(buf) >= size)
+	}
+
+	// Odd sized slices are rounded down to a size class that they fill
+	pool.Put(make([]byte, 100))
+	buf := pool.Get(64)
+	assert.Equal(t, len(buf), 64)
+	buf = pool.Get(100)
+	assert.Equal(t, len(buf), 100)
+	assert.Assert(t, cap(buf) >= 100)
+}
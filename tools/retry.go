@@ -0,0 +1,80 @@
+package tools
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy tells Retry how many times to call a function, and how long to
+// wait between the calls. The wait starts at InitialDelay, and is multiplied
+// by Multiplier after each failure, up to MaxDelay. Each wait is varied at
+// random by up to Jitter times itself, so that clients failing together do
+// not retry together.
+type RetryPolicy struct {
+	// MaxAttempts is the number of calls, the first one included
+	MaxAttempts  int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+	// Jitter is between 0 and 1
+	Jitter float64
+	// Retryable shows whether an error is transient. All errors are retried
+	// if it is nil.
+	Retryable func(err error) bool
+}
+
+// DefaultRetryPolicy suits transient storage errors
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:  5,
+	InitialDelay: 50 * time.Millisecond,
+	MaxDelay:     2 * time.Second,
+	Multiplier:   2,
+	Jitter:       0.2,
+}
+
+// Retry calls fn until it succeeds, returns an error that is not retryable,
+// or has been called MaxAttempts times. It stops waiting when the context is
+// done. It returns the last error of fn, or the context error if fn was
+// never called.
+func Retry(ctx context.Context, policy RetryPolicy, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	delay := policy.InitialDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= policy.MaxAttempts ||
+			(policy.Retryable != nil && !policy.Retryable(err)) {
+			return err
+		}
+
+		timer := time.NewTimer(policy.jittered(delay))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		if policy.Multiplier > 1 {
+			delay = time.Duration(float64(delay) * policy.Multiplier)
+		}
+		if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+			delay = policy.MaxDelay
+		}
+	}
+}
+
+// jittered varies the delay at random by up to Jitter times itself
+func (p RetryPolicy) jittered(delay time.Duration) time.Duration {
+	if p.Jitter <= 0 || delay <= 0 {
+		return delay
+	}
+	jitter := p.Jitter
+	if jitter > 1 {
+		jitter = 1
+	}
+	return time.Duration(float64(delay) * (1 + jitter*(2*rand.Float64()-1)))
+}
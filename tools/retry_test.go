@@ -0,0 +1,64 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func TestRetry(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 4, InitialDelay: time.Millisecond,
+		MaxDelay: 2 * time.Millisecond, Multiplier: 2, Jitter: 0.5}
+	transient := errors.New("transient")
+
+	// Succeeds on the third call
+	calls := 0
+	err := Retry(context.Background(), policy, func() error {
+		calls++
+		if calls < 3 {
+			return transient
+		}
+		return nil
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, calls, 3)
+
+	// Gives up after MaxAttempts calls
+	calls = 0
+	err = Retry(context.Background(), policy, func() error {
+		calls++
+		return transient
+	})
+	assert.Equal(t, err, transient)
+	assert.Equal(t, calls, 4)
+
+	// Errors that are not retryable are returned at once
+	permanent := errors.New("permanent")
+	policy.Retryable = func(err error) bool { return err == transient }
+	calls = 0
+	err = Retry(context.Background(), policy, func() error {
+		calls++
+		return permanent
+	})
+	assert.Equal(t, err, permanent)
+	assert.Equal(t, calls, 1)
+
+	// The context bounds the waits
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	policy = RetryPolicy{MaxAttempts: 100, InitialDelay: time.Hour}
+	calls = 0
+	err = Retry(ctx, policy, func() error {
+		calls++
+		return transient
+	})
+	assert.Equal(t, err, transient)
+	assert.Equal(t, calls, 1)
+
+	cancel()
+	err = Retry(ctx, policy, func() error { return nil })
+	assert.Assert(t, err != nil)
+}
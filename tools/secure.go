@@ -0,0 +1,19 @@
+package tools
+
+import "crypto/subtle"
+
+// ConstantTimeEqual reports whether two byte slices are equal, taking a time
+// that depends only on their lengths and not on their contents. Use it
+// instead of bytes.Equal to compare MACs, checksums and other values an
+// attacker could learn about from the time a comparison takes.
+func ConstantTimeEqual(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
+
+// Zeroize overwrites a byte slice with zeros, so that keys and other secrets
+// do not linger in memory after they are no longer needed
+func Zeroize(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,21 @@
+package tools
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestConstantTimeEqual(t *testing.T) {
+	assert.Assert(t, ConstantTimeEqual(nil, nil))
+	assert.Assert(t, ConstantTimeEqual([]byte{}, nil))
+	assert.Assert(t, ConstantTimeEqual([]byte("secret"), []byte("secret")))
+	assert.Assert(t, !ConstantTimeEqual([]byte("secret"), []byte("secreT")))
+	assert.Assert(t, !ConstantTimeEqual([]byte("secret"), []byte("secret!")))
+	assert.Assert(t, !ConstantTimeEqual([]byte("secret"), nil))
+
+	b := []byte("secret")
+	Zeroize(b)
+	assert.DeepEqual(t, b, make([]byte, 6))
+	Zeroize(nil)
+}
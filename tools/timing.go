@@ -0,0 +1,122 @@
+package tools
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Stopwatch measures elapsed time with the monotonic clock, so that changes
+// to the wall clock do not affect it
+type Stopwatch struct {
+	start time.Time
+	lap   time.Time
+}
+
+// StartStopwatch returns a running stopwatch
+func StartStopwatch() *Stopwatch {
+	now := time.Now()
+	return &Stopwatch{start: now, lap: now}
+}
+
+// Lap returns the time since the last lap, or since the start for the first
+// lap, and starts a new lap
+func (s *Stopwatch) Lap() time.Duration {
+	now := time.Now()
+	d := now.Sub(s.lap)
+	s.lap = now
+	return d
+}
+
+// Elapsed returns the time since the start
+func (s *Stopwatch) Elapsed() time.Duration {
+	return time.Since(s.start)
+}
+
+// Millis returns the duration in milliseconds, with the fraction of a
+// millisecond, as metrics usually report it
+func Millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// TimingStats are the durations recorded under a name of Timings
+type TimingStats struct {
+	Count int
+	Total time.Duration
+	Min   time.Duration
+	Max   time.Duration
+}
+
+// Mean returns the mean duration, or 0 if none were recorded
+func (s TimingStats) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Count)
+}
+
+// Timings aggregates durations by name, such as the laps of a Stopwatch
+// timing the steps of a serialization. It is safe for concurrent use.
+type Timings struct {
+	lock  sync.Mutex
+	stats map[string]*TimingStats
+}
+
+// NewTimings returns empty Timings
+func NewTimings() *Timings {
+	return &Timings{stats: make(map[string]*TimingStats)}
+}
+
+// Add records the duration under the name
+func (t *Timings) Add(name string, d time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	s := t.stats[name]
+	if s == nil {
+		s = &TimingStats{Min: d, Max: d}
+		t.stats[name] = s
+	}
+	s.Count++
+	s.Total += d
+	if d < s.Min {
+		s.Min = d
+	}
+	if d > s.Max {
+		s.Max = d
+	}
+}
+
+// Time calls fn and records how long it took under the name
+func (t *Timings) Time(name string, fn func() error) error {
+	sw := StartStopwatch()
+	err := fn()
+	t.Add(name, sw.Elapsed())
+	return err
+}
+
+// Get returns the durations recorded under the name, and whether there are
+// any
+func (t *Timings) Get(name string) (TimingStats, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	s, ok := t.stats[name]
+	if !ok {
+		return TimingStats{}, false
+	}
+	return *s, true
+}
+
+// Names returns the names with recorded durations, in sorted order
+func (t *Timings) Names() []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	names := make([]string, 0, len(t.stats))
+	for name := range t.stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
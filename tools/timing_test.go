@@ -0,0 +1,49 @@
+package tools
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func TestStopwatch(t *testing.T) {
+	sw := StartStopwatch()
+	time.Sleep(2 * time.Millisecond)
+	lap1 := sw.Lap()
+	time.Sleep(2 * time.Millisecond)
+	lap2 := sw.Lap()
+	assert.Assert(t, lap1 >= 2*time.Millisecond)
+	assert.Assert(t, lap2 >= 2*time.Millisecond)
+	assert.Assert(t, sw.Elapsed() >= lap1+lap2)
+
+	assert.Equal(t, Millis(1500*time.Microsecond), 1.5)
+}
+
+func TestTimings(t *testing.T) {
+	timings := NewTimings()
+	timings.Add("write", 3*time.Millisecond)
+	timings.Add("write", time.Millisecond)
+	timings.Add("write", 2*time.Millisecond)
+
+	stats, ok := timings.Get("write")
+	assert.Assert(t, ok)
+	assert.Equal(t, stats.Count, 3)
+	assert.Equal(t, stats.Total, 6*time.Millisecond)
+	assert.Equal(t, stats.Min, time.Millisecond)
+	assert.Equal(t, stats.Max, 3*time.Millisecond)
+	assert.Equal(t, stats.Mean(), 2*time.Millisecond)
+
+	failed := errors.New("failed")
+	err := timings.Time("gzip", func() error { return failed })
+	assert.Equal(t, err, failed)
+	stats, ok = timings.Get("gzip")
+	assert.Assert(t, ok)
+	assert.Equal(t, stats.Count, 1)
+
+	_, ok = timings.Get("none")
+	assert.Assert(t, !ok)
+	assert.Equal(t, TimingStats{}.Mean(), time.Duration(0))
+	assert.DeepEqual(t, timings.Names(), []string{"gzip", "write"})
+}
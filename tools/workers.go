@@ -0,0 +1,42 @@
+package tools
+
+import (
+	"sync"
+)
+
+// Pool runs functions on a bounded number of goroutines. Submit blocks while
+// all the workers are busy, which keeps a fast producer from queueing an
+// unbounded amount of work.
+type Pool struct {
+	slots chan struct{}
+	wg    sync.WaitGroup
+}
+
+// NewPool creates a pool that runs up to workers functions at the same
+// time. A pool has at least one worker.
+func NewPool(workers int) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Pool{slots: make(chan struct{}, workers)}
+}
+
+// Submit runs fn on a worker, and waits for a worker to be free if there is
+// none
+func (p *Pool) Submit(fn func()) {
+	p.slots <- struct{}{}
+	p.wg.Add(1)
+	go func() {
+		defer func() {
+			<-p.slots
+			p.wg.Done()
+		}()
+		fn()
+	}()
+}
+
+// Wait waits for all the submitted functions to return. The pool can be
+// used again after Wait returns.
+func (p *Pool) Wait() {
+	p.wg.Wait()
+}
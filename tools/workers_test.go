@@ -0,0 +1,43 @@
+package tools
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func TestPool(t *testing.T) {
+	for _, workers := range []int{0, 1, 4} {
+		pool := NewPool(workers)
+		maxWorkers := int32(workers)
+		if maxWorkers < 1 {
+			maxWorkers = 1
+		}
+
+		var running, peak, done int32
+		for i := 0; i < 20; i++ {
+			pool.Submit(func() {
+				n := atomic.AddInt32(&running, 1)
+				for {
+					p := atomic.LoadInt32(&peak)
+					if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+				atomic.AddInt32(&running, -1)
+				atomic.AddInt32(&done, 1)
+			})
+		}
+		pool.Wait()
+		assert.Equal(t, done, int32(20))
+		assert.Assert(t, peak <= maxWorkers, "peak %v workers %v", peak, maxWorkers)
+
+		// The pool can be reused
+		pool.Submit(func() { atomic.AddInt32(&done, 1) })
+		pool.Wait()
+		assert.Equal(t, done, int32(21))
+	}
+}